@@ -0,0 +1,284 @@
+package allowlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/state"
+	"go.uber.org/zap"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSource    = errors.New("neither allowlist file nor on-chain node list is set")
+	ErrInvalidNode = errors.New("invalid allowlist node")
+)
+
+// Chain provides the node list of the permissions system account
+type Chain interface {
+	Config() *params.ChainConfig
+	CurrentBlock() *types.Block
+	StateAt(root common.Hash) (*state.StateDB, error)
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// ChangeEvent is posted when the allowed nodes change
+type ChangeEvent struct {
+	Nodes []enode.ID
+}
+
+// Allowlist is the set of nodes allowed to be peers, merged of the file and on-chain lists.
+// Both lists are reloaded on changes, an invalid list keeps the previous one in effect
+type Allowlist struct {
+	config Config
+	chain  Chain // nil if the chain does not restrict nodes
+	logger *zap.SugaredLogger
+
+	lock       sync.RWMutex
+	fileNodes  map[enode.ID]struct{}
+	fileStat   os.FileInfo
+	chainRoot  common.Hash
+	chainNodes map[enode.ID]struct{}
+
+	feed event.Feed
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Restricted tells if nodes are restricted by the config or the chain
+func Restricted(config Config, chainConfig *params.ChainConfig) bool {
+	return len(config.File) > 0 || (chainConfig.Permissions != nil && chainConfig.Permissions.RestrictNodes)
+}
+
+// New loads the allowlist file and the on-chain list, lists are not reloaded until started
+func New(config Config, chain Chain, logger *zap.SugaredLogger) (*Allowlist, error) {
+	a := &Allowlist{
+		config: config.sanitize(),
+		logger: logger,
+		quit:   make(chan struct{}),
+	}
+	if permissions := chain.Config().Permissions; permissions != nil && permissions.RestrictNodes {
+		a.chain = chain
+	}
+	if len(a.config.File) == 0 && a.chain == nil {
+		return nil, ErrNoSource
+	}
+
+	if len(a.config.File) > 0 {
+		if _, err := a.reloadFile(); err != nil {
+			return nil, err
+		}
+	}
+	if a.chain != nil {
+		if _, err := a.reloadChain(chain.CurrentBlock().Header()); err != nil {
+			return nil, err
+		}
+	}
+	a.logger.Infow("Loaded node allowlist", "file", a.config.File, "on_chain", a.chain != nil, "nodes", len(a.Nodes()))
+	return a, nil
+}
+
+// Start reloads lists on the file and chain head changes
+func (a *Allowlist) Start() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.loop()
+	}()
+}
+
+// Stop ends lists reloading
+func (a *Allowlist) Stop() {
+	close(a.quit)
+	a.wg.Wait()
+}
+
+func (a *Allowlist) loop() {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	var (
+		heads   = make(chan core.ChainHeadEvent, 16)
+		headErr <-chan error
+	)
+	if a.chain != nil {
+		sub := a.chain.SubscribeChainHeadEvent(heads)
+		defer sub.Unsubscribe()
+		headErr = sub.Err()
+	}
+
+	for {
+		var (
+			changed bool
+			err     error
+		)
+		select {
+		case <-ticker.C:
+			if len(a.config.File) == 0 {
+				continue
+			}
+			if changed, err = a.reloadFile(); err != nil {
+				a.logger.Warnw("Unable to reload node allowlist file, previous list is kept", "file", a.config.File, "err", err)
+			}
+		case ev := <-heads:
+			if changed, err = a.reloadChain(ev.Block.Header()); err != nil {
+				a.logger.Warnw("Unable to reload on-chain node allowlist", "number", ev.Block.NumberU64(), "err", err)
+			}
+		case <-headErr:
+			return
+		case <-a.quit:
+			return
+		}
+
+		if changed {
+			nodes := a.Nodes()
+			a.logger.Infow("Node allowlist changed", "nodes", len(nodes))
+			a.feed.Send(ChangeEvent{Nodes: nodes})
+		}
+	}
+}
+
+// reloadFile reads the file if it is modified, it returns true if the allowed nodes change
+func (a *Allowlist) reloadFile() (bool, error) {
+	stat, err := os.Stat(a.config.File)
+	if err != nil {
+		return false, err
+	}
+	a.lock.RLock()
+	prev := a.fileStat
+	a.lock.RUnlock()
+	if prev != nil && prev.ModTime().Equal(stat.ModTime()) && prev.Size() == stat.Size() {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(a.config.File)
+	if err != nil {
+		return false, err
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return false, fmt.Errorf("invalid allowlist file %s: %w", a.config.File, err)
+	}
+	nodes := make(map[enode.ID]struct{}, len(list))
+	for _, node := range list {
+		id, err := ParseNode(node)
+		if err != nil {
+			return false, err
+		}
+		nodes[id] = struct{}{}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	changed := !equal(a.fileNodes, nodes)
+	a.fileNodes, a.fileStat = nodes, stat
+	return changed, nil
+}
+
+// reloadChain reads the node list of the permissions system account at the head state
+func (a *Allowlist) reloadChain(head *types.Header) (bool, error) {
+	a.lock.RLock()
+	prev := a.chainRoot
+	a.lock.RUnlock()
+	if prev == head.Root {
+		return false, nil
+	}
+
+	statedb, err := a.chain.StateAt(head.Root)
+	if err != nil {
+		return false, err
+	}
+	lists, err := permissions.Load(statedb)
+	if err != nil {
+		return false, err
+	}
+	nodes := make(map[enode.ID]struct{}, len(lists.Nodes))
+	for _, id := range lists.Nodes {
+		nodes[id] = struct{}{}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	changed := !equal(a.chainNodes, nodes)
+	a.chainNodes, a.chainRoot = nodes, head.Root
+	return changed, nil
+}
+
+// Allowed tells if the node is in the file or on-chain list
+func (a *Allowlist) Allowed(id enode.ID) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	if _, ok := a.fileNodes[id]; ok {
+		return true
+	}
+	_, ok := a.chainNodes[id]
+	return ok
+}
+
+// Nodes returns allowed node ids in ascending order
+func (a *Allowlist) Nodes() []enode.ID {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	nodes := make([]enode.ID, 0, len(a.fileNodes)+len(a.chainNodes))
+	for id := range a.fileNodes {
+		nodes = append(nodes, id)
+	}
+	for id := range a.chainNodes {
+		if _, ok := a.fileNodes[id]; !ok {
+			nodes = append(nodes, id)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i][:], nodes[j][:]) < 0
+	})
+	return nodes
+}
+
+// SubscribeChangeEvent registers a subscription of ChangeEvent
+func (a *Allowlist) SubscribeChangeEvent(ch chan<- ChangeEvent) event.Subscription {
+	return a.feed.Subscribe(ch)
+}
+
+// ParseNode returns the node id of the enode URL or the hex encoded id
+func ParseNode(node string) (enode.ID, error) {
+	if strings.HasPrefix(node, "enode://") {
+		n, err := enode.ParseV4(node)
+		if err != nil {
+			return enode.ID{}, fmt.Errorf("%w: %s", ErrInvalidNode, err)
+		}
+		return n.ID(), nil
+	}
+	id, err := enode.ParseID(node)
+	if err != nil {
+		return enode.ID{}, fmt.Errorf("%w: %s: %s", ErrInvalidNode, node, err)
+	}
+	return id, nil
+}
+
+func equal(a, b map[enode.ID]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if _, ok := b[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
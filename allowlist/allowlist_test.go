@@ -0,0 +1,140 @@
+package allowlist
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"github.com/rovergulf/chain/tests/testchain"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitChange waits for the allowlist change event
+func waitChange(t *testing.T, changes <-chan ChangeEvent) []enode.ID {
+	select {
+	case ev := <-changes:
+		return ev.Nodes
+	case <-time.After(time.Second):
+		t.Fatal("allowlist change is not posted")
+		return nil
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	node := enode.NewV4(&key.PublicKey, nil, 0, 0)
+	other := enode.ID{1}
+
+	file := filepath.Join(t.TempDir(), "allowlist.json")
+	if err := os.WriteFile(file, []byte(`["`+node.URLv4()+`"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	a, err := New(Config{File: file, Interval: 10 * time.Millisecond}, testchain.New(t, &core.Genesis{Config: params.DevChainConfig}), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Allowed(node.ID()) || a.Allowed(other) {
+		t.Fatal("unexpected allowed nodes")
+	}
+
+	changes := make(chan ChangeEvent, 1)
+	defer a.SubscribeChangeEvent(changes).Unsubscribe()
+	a.Start()
+	defer a.Stop()
+
+	// invalid list keeps the previous one
+	if err := os.WriteFile(file, []byte(`["invalid"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !a.Allowed(node.ID()) {
+		t.Fatal("invalid list is applied")
+	}
+
+	if err := os.WriteFile(file, []byte(`["`+other.String()+`"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := waitChange(t, changes); len(nodes) != 1 || nodes[0] != other || a.Allowed(node.ID()) {
+		t.Fatalf("unexpected nodes after reload: %v", nodes)
+	}
+}
+
+func TestChainReload(t *testing.T) {
+	config := *params.DevChainConfig
+	config.Permissions = &params.PermissionsConfig{
+		Admins:        []common.Address{tests.Account0},
+		RestrictNodes: true,
+		Nodes:         []enode.ID{{1}},
+	}
+	genesis := &core.Genesis{
+		Config:   &config,
+		GasLimit: core.DefaultGenesisGasLimit,
+		Alloc:    core.GenesisAlloc{tests.Account0: {Balance: big.NewInt(1e18)}},
+	}
+	bc := testchain.New(t, genesis)
+
+	logger, _ := logutils.NewLogger()
+	a, err := New(DefaultConfig, bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Allowed(enode.ID{1}) || a.Allowed(enode.ID{2}) {
+		t.Fatal("unexpected allowed nodes of the genesis")
+	}
+	changes := make(chan ChangeEvent, 1)
+	defer a.SubscribeChangeEvent(changes).Unsubscribe()
+	a.Start()
+	defer a.Stop()
+
+	// admin replaces the node
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sdb := state.NewDatabase(badgerdb.NewDatabase(db, nil))
+	genesisBlock, err := genesis.ToBlock(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSigner(config.EthConfig())
+	blocks, _, err := core.GenerateChain(&config, genesisBlock, sdb, 1, func(i int, b *core.BlockGen) {
+		for _, call := range []*permissions.Call{{Op: permissions.OpAddNode, Node: enode.ID{2}}, {Op: permissions.OpRemoveNode, Node: enode.ID{1}}} {
+			data, err := permissions.EncodeCall(call)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := types.SignTx(types.NewTransaction(b.TxNonce(tests.Account0), permissions.Address, new(big.Int), 100_000, big.NewInt(1), data), signer, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.AddTx(tx)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := waitChange(t, changes); len(nodes) != 1 || nodes[0] != (enode.ID{2}) {
+		t.Fatalf("unexpected nodes after the block: %v", nodes)
+	}
+}
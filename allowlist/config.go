@@ -0,0 +1,22 @@
+package allowlist
+
+import (
+	"time"
+)
+
+// Config are the node allowlist settings, the on-chain list is used if the chain restricts nodes
+type Config struct {
+	File     string        `json:"file" yaml:"file"`         // JSON list of allowed enode URLs or node ids, not used if empty
+	Interval time.Duration `json:"interval" yaml:"interval"` // time between checks of the file changes
+}
+
+var DefaultConfig = Config{
+	Interval: 5 * time.Second,
+}
+
+func (c Config) sanitize() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultConfig.Interval
+	}
+	return c
+}
@@ -0,0 +1,65 @@
+package carrack
+
+import (
+	"github.com/ethereum/go-ethereum/rpc"
+	"time"
+)
+
+func APIs(h *Handler) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "admin",
+			Service:   &AdminAPI{h: h},
+		},
+	}
+}
+
+// AdminAPI exposes peers reputation and bans under the 'admin' namespace
+type AdminAPI struct {
+	h *Handler
+}
+
+// PeerScore is the reputation of the connected peer
+type PeerScore struct {
+	ID    string `json:"id" yaml:"id"`
+	Name  string `json:"name" yaml:"name"`
+	Score int    `json:"score" yaml:"score"`
+}
+
+// PeerScores returns scores of the connected peers
+func (api *AdminAPI) PeerScores() []*PeerScore {
+	peers := api.h.Peers()
+	scores := make([]*PeerScore, len(peers))
+	for i, p := range peers {
+		scores[i] = &PeerScore{ID: p.ID(), Name: p.Name(), Score: api.h.reputation.Score(p.ID())}
+	}
+	return scores
+}
+
+// BannedNodes returns banned node ids with the ban expiration
+func (api *AdminAPI) BannedNodes() map[string]time.Time {
+	return api.h.reputation.BannedNodes()
+}
+
+// BanNode bans the node given by the enode URL or id for the duration in seconds, the configured one is used if not set
+func (api *AdminAPI) BanNode(node string, seconds *uint64) (bool, error) {
+	id, err := ParseNodeID(node)
+	if err != nil {
+		return false, err
+	}
+	var d time.Duration
+	if seconds != nil {
+		d = time.Duration(*seconds) * time.Second
+	}
+	api.h.BanPeer(id, d)
+	return true, nil
+}
+
+// UnbanNode lifts the ban of the node given by the enode URL or id, it returns false if the node is not banned
+func (api *AdminAPI) UnbanNode(node string) (bool, error) {
+	id, err := ParseNodeID(node)
+	if err != nil {
+		return false, err
+	}
+	return api.h.reputation.Unban(id), nil
+}
@@ -0,0 +1,97 @@
+package carrack
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math"
+)
+
+// broadcastLoop propagates new pool transactions and chain heads to peers
+func (h *Handler) broadcastLoop() {
+	for {
+		select {
+		case ev := <-h.txsCh:
+			h.broadcastTransactions(ev.Txs)
+		case ev := <-h.headCh:
+			h.broadcastBlock(ev.Block)
+		case <-h.txsSub.Err():
+			return
+		case <-h.headSub.Err():
+			return
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// broadcastTransactions sends transactions to the square root of peers, the rest of peers get their hashes announced.
+// Peers before Carrack3 can not fetch announced transactions, so they get them all
+func (h *Handler) broadcastTransactions(txs []*types.Transaction) {
+	var (
+		peers    = h.peers.all()
+		direct   = int(math.Sqrt(float64(len(peers))))
+		full     = make(map[*Peer]types.Transactions)
+		announce = make(map[*Peer][]common.Hash)
+	)
+	for _, tx := range txs {
+		hash := tx.Hash()
+		for i, p := range peers {
+			switch {
+			case p.KnownTransaction(hash):
+			case i < direct || p.Version() < Carrack3:
+				full[p] = append(full[p], tx)
+			default:
+				announce[p] = append(announce[p], hash)
+			}
+		}
+	}
+
+	for p, txs := range full {
+		p, txs := p, txs
+		h.queueSend(p, func() error {
+			return p.SendTransactions(txs)
+		})
+	}
+	for p, hashes := range announce {
+		p, hashes := p, hashes
+		h.queueSend(p, func() error {
+			return p.SendPooledTransactionHashes(hashes)
+		})
+	}
+}
+
+// broadcastBlock sends the new head block to the square root of peers behind it and announces it to the rest of them
+func (h *Handler) broadcastBlock(block *types.Block) {
+	hash, number := block.Hash(), block.NumberU64()
+	td := h.chain.GetTd(hash, number)
+	if td == nil {
+		return
+	}
+
+	var peers []*Peer
+	for _, p := range h.peers.all() {
+		// peers ahead are synchronising, they either have the block or its replacement
+		if _, head, _ := p.Head(); head < number && !p.KnownBlock(hash) {
+			peers = append(peers, p)
+		}
+	}
+	direct := int(math.Sqrt(float64(len(peers))))
+	for i, p := range peers {
+		p := p
+		if i < direct {
+			h.queueSend(p, func() error {
+				return p.SendNewBlock(block, td)
+			})
+		} else {
+			h.queueSend(p, func() error {
+				return p.SendNewBlockHashes([]BlockAnnouncement{{Hash: hash, Number: number}})
+			})
+		}
+	}
+}
+
+func (h *Handler) queueSend(p *Peer, send func() error) {
+	if !p.queueSend(send) {
+		h.logger.Debugw("Peer broadcast queue is full", "peer", p.ID())
+	}
+}
@@ -0,0 +1,200 @@
+package carrack
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"sync"
+)
+
+const (
+	maxTxFetches    = 4 * maxTxsServe // announced transactions requested from one peer at once, later announcements are dropped
+	maxBlockFetches = 16              // announced blocks requested from one peer at once
+	maxAnnouncers   = 4               // alternate announcers remembered for the hash being fetched
+)
+
+var errUnexpectedBlock = errors.New("announced block does not match")
+
+// fetch is the hash being requested from the peer, alternates are the other peers which announced it
+type fetch struct {
+	peer       *Peer
+	alternates []*Peer
+}
+
+// fetchSet tracks hashes being fetched, so announcements of several peers cause a single request.
+// Other announcers of the hash are kept to request it from them, if the fetch fails
+type fetchSet struct {
+	limit int // hashes requested from one peer at once
+
+	lock    sync.Mutex
+	hashes  map[common.Hash]*fetch
+	pending map[*Peer]int // number of hashes requested from the peer
+}
+
+func newFetchSet(limit int) *fetchSet {
+	return &fetchSet{
+		limit:   limit,
+		hashes:  make(map[common.Hash]*fetch),
+		pending: make(map[*Peer]int),
+	}
+}
+
+// reserve returns hashes not being fetched yet within the peer limit and marks them requested from the peer,
+// the peer becomes an alternate announcer of hashes being fetched from others
+func (s *fetchSet) reserve(peer *Peer, hashes []common.Hash) []common.Hash {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var reserved []common.Hash
+	for _, hash := range hashes {
+		f, ok := s.hashes[hash]
+		switch {
+		case !ok && s.pending[peer] < s.limit:
+			s.hashes[hash] = &fetch{peer: peer}
+			s.pending[peer]++
+			reserved = append(reserved, hash)
+		case ok && f.peer != peer && len(f.alternates) < maxAnnouncers && !containsPeer(f.alternates, peer):
+			f.alternates = append(f.alternates, peer)
+		}
+	}
+	return reserved
+}
+
+// release ends the request of hashes from the peer. Hashes which are not delivered are requested from
+// the next alternate announcer, they are returned grouped by it. The others may be fetched again
+func (s *fetchSet) release(peer *Peer, hashes []common.Hash, delivered func(hash common.Hash) bool) map[*Peer][]common.Hash {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var retries map[*Peer][]common.Hash
+	for _, hash := range hashes {
+		f, ok := s.hashes[hash]
+		if !ok || f.peer != peer {
+			continue
+		}
+		if s.pending[peer]--; s.pending[peer] <= 0 {
+			delete(s.pending, peer)
+		}
+		if delivered == nil || delivered(hash) || len(f.alternates) == 0 {
+			delete(s.hashes, hash)
+			continue
+		}
+
+		f.peer, f.alternates = f.alternates[0], f.alternates[1:]
+		s.pending[f.peer]++
+		if retries == nil {
+			retries = make(map[*Peer][]common.Hash)
+		}
+		retries[f.peer] = append(retries[f.peer], hash)
+	}
+	return retries
+}
+
+func containsPeer(peers []*Peer, peer *Peer) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTxAnnounces requests transactions announced by the peer which are not in the pool or being fetched
+func (h *Handler) handleTxAnnounces(peer *Peer, hashes []common.Hash) {
+	var unknown []common.Hash
+	for _, hash := range hashes {
+		peer.MarkTransaction(hash)
+		if !h.txPool.Has(hash) {
+			unknown = append(unknown, hash)
+		}
+	}
+	h.requestTransactions(peer, h.txFetches.reserve(peer, unknown))
+}
+
+// requestTransactions fetches hashes reserved for the peer, the ones it fails to deliver are requested from alternates
+func (h *Handler) requestTransactions(peer *Peer, hashes []common.Hash) {
+	for len(hashes) > 0 {
+		chunk := hashes
+		if len(chunk) > maxTxsServe {
+			chunk = chunk[:maxTxsServe]
+		}
+		hashes = hashes[len(chunk):]
+
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+
+			fetched := h.fetchTransactions(peer, chunk)
+			delivered := func(hash common.Hash) bool {
+				_, ok := fetched[hash]
+				return ok || h.txPool.Has(hash)
+			}
+			if h.ctx.Err() != nil {
+				delivered = nil
+			}
+			for alternate, retry := range h.txFetches.release(peer, chunk, delivered) {
+				h.requestTransactions(alternate, retry)
+			}
+		}()
+	}
+}
+
+// fetchTransactions requests announced transactions from the peer, adds them to the pool and returns their hashes
+func (h *Handler) fetchTransactions(peer *Peer, hashes []common.Hash) map[common.Hash]struct{} {
+	txs, err := peer.RequestPooledTransactions(h.ctx, hashes)
+	if err != nil {
+		h.logger.Debugw("Unable to fetch announced transactions", "peer", peer.ID(), "count", len(hashes), "err", err)
+		if h.ctx.Err() == nil && !errors.Is(err, ErrPeerClosed) {
+			h.adjustScore(peer, penaltyStalled, err.Error())
+		}
+		return nil
+	}
+
+	requested := make(map[common.Hash]struct{}, len(hashes))
+	for _, hash := range hashes {
+		requested[hash] = struct{}{}
+	}
+	fetched := make(map[common.Hash]struct{}, len(txs))
+	for _, tx := range txs {
+		if _, ok := requested[tx.Hash()]; !ok {
+			h.adjustScore(peer, penaltyProtocol, "unrequested transaction "+tx.Hash().String())
+			return nil
+		}
+		peer.MarkTransaction(tx.Hash())
+		fetched[tx.Hash()] = struct{}{}
+	}
+	h.txPool.AddRemotes(txs)
+	if len(txs) > 0 {
+		h.adjustScore(peer, rewardUseful, "")
+	}
+	return fetched
+}
+
+// fetchBlock requests the header and body of the block announced by the peer and imports it
+func (h *Handler) fetchBlock(peer *Peer, a BlockAnnouncement) error {
+	headers, err := peer.RequestHeadersByHash(h.ctx, a.Hash, 1, 0, false)
+	if err != nil {
+		return err
+	}
+	if len(headers) != 1 || headers[0].Hash() != a.Hash || headers[0].Number.Uint64() != a.Number {
+		return errUnexpectedBlock
+	}
+	header := headers[0]
+
+	bodies, err := peer.RequestBodies(h.ctx, []common.Hash{a.Hash})
+	if err != nil {
+		return err
+	}
+	if len(bodies) != 1 {
+		return errUnexpectedBlock
+	}
+	body := bodies[0]
+	if types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)) != header.TxHash ||
+		types.CalcUncleHash(body.Uncles) != header.UncleHash {
+		return errUnexpectedBlock
+	}
+
+	h.importBlock(peer, types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles))
+	return nil
+}
@@ -0,0 +1,872 @@
+package carrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/rovergulf/chain/allowlist"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/core/forkid"
+	"github.com/rovergulf/chain/state"
+	"go.uber.org/zap"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// knownConsensusMessages is the number of consensus message hashes remembered to stop relaying them
+const knownConsensusMessages = 4096
+
+// Chain is the local chain served to peers and extended by their blocks
+type Chain interface {
+	forkid.Blockchain
+	CurrentBlock() *types.Block
+	GetTd(hash common.Hash, number uint64) *big.Int
+	GetHeader(hash common.Hash, number uint64) *types.Header
+	GetHeaderByHash(hash common.Hash) *types.Header
+	GetHeaderByNumber(number uint64) *types.Header
+	GetCanonicalHash(number uint64) common.Hash
+	GetBlockByHash(hash common.Hash) *types.Block
+	GetReceiptsByHash(hash common.Hash) types.Receipts
+	HasBlock(hash common.Hash, number uint64) bool
+	InsertChain(blocks types.Blocks) (int, error)
+	StateDatabase() *state.Database
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// TxPool accepts transactions of peers and provides pending ones to propagate
+type TxPool interface {
+	AddRemotes(txs []*types.Transaction) []error
+	Get(hash common.Hash) *types.Transaction
+	Has(hash common.Hash) bool
+	SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription
+}
+
+// Allowlist restricts the nodes peers are connected with, connected peers are checked again on its changes
+type Allowlist interface {
+	Allowed(id enode.ID) bool
+	SubscribeChangeEvent(ch chan<- allowlist.ChangeEvent) event.Subscription
+}
+
+// StaticDialer keeps connections with static and trusted nodes, it is implemented by p2p.Server
+type StaticDialer interface {
+	AddPeer(node *enode.Node)
+	RemovePeer(node *enode.Node)
+	AddTrustedPeer(node *enode.Node)
+	RemoveTrustedPeer(node *enode.Node)
+}
+
+// Consensus handles messages of the consensus engine, bft validators exchange votes with them.
+// HandleMessage returns consensus.ErrInvalidMessage for the message the peer should not have sent
+type Consensus interface {
+	HandleMessage(payload []byte) error
+}
+
+// Handler runs the Carrack protocol with connected peers
+type Handler struct {
+	networkID uint64
+	chain     Chain
+	txPool    TxPool
+	filter    forkid.Filter
+	logger    *zap.SugaredLogger
+
+	peers *peerSet
+
+	lock      sync.RWMutex // protects consensus and static nodes
+	consensus Consensus
+	known     *lru.Cache // consensus message hashes already handled
+
+	dialer  StaticDialer  // nil until the p2p server is started
+	static  []*enode.Node // configured static nodes, including the ones missing in the allowlist
+	trusted []*enode.Node // configured trusted nodes, including the ones missing in the allowlist
+
+	reputation *Reputation
+	allowlist  Allowlist // nil if any node may be a peer
+
+	txFetches    *fetchSet // announced transactions being requested
+	blockFetches *fetchSet // announced blocks being requested
+
+	txsCh   chan core.NewTxsEvent
+	txsSub  event.Subscription
+	headCh  chan core.ChainHeadEvent
+	headSub event.Subscription
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHandler returns the protocol handler of the network chain, it propagates new transactions and blocks until stopped
+func NewHandler(networkID uint64, chain Chain, txPool TxPool, logger *zap.SugaredLogger) *Handler {
+	known, _ := lru.New(knownConsensusMessages)
+	h := &Handler{
+		networkID:    networkID,
+		chain:        chain,
+		txPool:       txPool,
+		filter:       forkid.NewFilter(chain),
+		logger:       logger,
+		peers:        newPeerSet(),
+		known:        known,
+		reputation:   newReputation(DefaultReputationConfig, logger),
+		txFetches:    newFetchSet(maxTxFetches),
+		blockFetches: newFetchSet(maxBlockFetches),
+		txsCh:        make(chan core.NewTxsEvent, 256),
+		headCh:       make(chan core.ChainHeadEvent, 16),
+	}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	h.txsSub = txPool.SubscribeNewTxsEvent(h.txsCh)
+	h.headSub = chain.SubscribeChainHeadEvent(h.headCh)
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.broadcastLoop()
+	}()
+	return h
+}
+
+// SetConsensus sets the engine consensus messages of peers are passed to
+func (h *Handler) SetConsensus(c Consensus) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.consensus = c
+}
+
+// SetReputation replaces the default peers reputation without the persistent ban list,
+// it should be set before the protocols are run
+func (h *Handler) SetReputation(r *Reputation) {
+	h.reputation = r
+}
+
+// SetAllowlist refuses nodes not on the list at dial and handshake, connected peers removed from it are disconnected.
+// It should be set before the protocols are run
+func (h *Handler) SetAllowlist(a Allowlist) {
+	h.allowlist = a
+
+	changes := make(chan allowlist.ChangeEvent, 1)
+	sub := a.SubscribeChangeEvent(changes)
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-changes:
+				h.updateStaticNodes()
+				for _, p := range h.peers.all() {
+					if !a.Allowed(p.Node().ID()) {
+						h.logger.Infow("Disconnecting peer removed from allowlist", "peer", p.ID(), "name", p.Name())
+						p.Disconnect(p2p.DiscUselessPeer)
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-h.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// allowed tells if the node may be a peer, it is neither banned nor missing in the allowlist
+func (h *Handler) allowed(id enode.ID) bool {
+	if h.reputation.Banned(id.String()) {
+		return false
+	}
+	return h.listed(id)
+}
+
+// listed tells if the node is in the allowlist, any node is listed if the allowlist is not set
+func (h *Handler) listed(id enode.ID) bool {
+	return h.allowlist == nil || h.allowlist.Allowed(id)
+}
+
+// ListedNodes returns the nodes present in the allowlist, static and trusted nodes of the p2p server are filtered with it
+func (h *Handler) ListedNodes(nodes []*enode.Node) []*enode.Node {
+	listed := make([]*enode.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if h.listed(n.ID()) {
+			listed = append(listed, n)
+		}
+	}
+	return listed
+}
+
+// SetStaticNodes sets the configured static and trusted nodes of the started p2p server.
+// Nodes missing in the allowlist are removed from the dialer and added back once they are listed
+func (h *Handler) SetStaticNodes(dialer StaticDialer, static, trusted []*enode.Node) {
+	h.lock.Lock()
+	h.dialer, h.static, h.trusted = dialer, static, trusted
+	h.lock.Unlock()
+
+	h.updateStaticNodes()
+}
+
+// updateStaticNodes adds the static and trusted nodes present in the allowlist to the dialer and removes the others
+func (h *Handler) updateStaticNodes() {
+	h.lock.RLock()
+	dialer, static, trusted := h.dialer, h.static, h.trusted
+	h.lock.RUnlock()
+	if dialer == nil {
+		return
+	}
+
+	for _, n := range static {
+		if h.listed(n.ID()) {
+			dialer.AddPeer(n)
+		} else {
+			dialer.RemovePeer(n)
+		}
+	}
+	for _, n := range trusted {
+		if h.listed(n.ID()) {
+			dialer.AddTrustedPeer(n)
+		} else {
+			dialer.RemoveTrustedPeer(n)
+		}
+	}
+}
+
+// Reputation returns scores and bans of peers
+func (h *Handler) Reputation() *Reputation {
+	return h.reputation
+}
+
+// Protocols returns the protocol versions to run by the p2p server, peers to dial are taken from the candidates
+// except banned nodes and nodes missing in the allowlist
+func (h *Handler) Protocols(candidates enode.Iterator) []p2p.Protocol {
+	if candidates != nil {
+		candidates = enode.Filter(candidates, func(n *enode.Node) bool {
+			return h.allowed(n.ID())
+		})
+	}
+	protocols := make([]p2p.Protocol, 0, len(ProtocolVersions))
+	for _, version := range ProtocolVersions {
+		version := version
+		protocols = append(protocols, p2p.Protocol{
+			Name:    ProtocolName,
+			Version: version,
+			Length:  protocolLengths[version],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				peer := NewPeer(version, p, rw)
+				defer peer.Close()
+				return h.runPeer(peer)
+			},
+			NodeInfo: func() interface{} {
+				return h.NodeInfo()
+			},
+			PeerInfo: func(id enode.ID) interface{} {
+				if p := h.peers.peer(id.String()); p != nil {
+					return p.Info()
+				}
+				return nil
+			},
+			DialCandidates: candidates,
+		})
+	}
+	return protocols
+}
+
+// PeerCount returns the number of peers passed the handshake
+func (h *Handler) PeerCount() int {
+	return h.peers.len()
+}
+
+// Peers returns the peers passed the handshake
+func (h *Handler) Peers() []*Peer {
+	return h.peers.all()
+}
+
+// BestPeer returns the peer of the heaviest chain, nil if there are no peers
+func (h *Handler) BestPeer() *Peer {
+	return h.peers.best()
+}
+
+// DropPeer disconnects the useless peer and lowers its score
+func (h *Handler) DropPeer(id string) {
+	if h.reputation.adjust(id, penaltyDropped) {
+		h.logger.Infow("Banned misbehaving peer", "peer", id, "reason", "dropped by chain synchronisation")
+	}
+	if p := h.peers.peer(id); p != nil {
+		p.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// BanPeer bans the node for the duration, the configured one is used if it is not positive
+func (h *Handler) BanPeer(id string, d time.Duration) {
+	h.reputation.Ban(id, d)
+	if p := h.peers.peer(id); p != nil {
+		p.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// adjustScore changes the peer score by its behaviour, the peer is disconnected once it is banned
+func (h *Handler) adjustScore(peer *Peer, delta int, reason string) {
+	if delta < 0 {
+		h.logger.Debugw("Peer misbehaved", "peer", peer.ID(), "reason", reason, "penalty", delta)
+	}
+	if h.reputation.adjust(peer.ID(), delta) {
+		h.logger.Infow("Banned misbehaving peer", "peer", peer.ID(), "name", peer.Name(), "reason", reason)
+		peer.Disconnect(p2p.DiscUselessPeer)
+	}
+}
+
+// Stop ends propagation and waits for fetches and blocks of peers being imported
+func (h *Handler) Stop() {
+	h.cancel()
+	h.txsSub.Unsubscribe()
+	h.headSub.Unsubscribe()
+	h.wg.Wait()
+}
+
+// runPeer handshakes the peer and handles its messages until it is disconnected
+func (h *Handler) runPeer(peer *Peer) error {
+	if !h.allowed(peer.Node().ID()) {
+		h.logger.Debugw("Banned or not allowed peer refused", "peer", peer.ID(), "name", peer.Name())
+		return p2p.DiscUselessPeer
+	}
+
+	head := h.chain.CurrentBlock()
+	status := &StatusPacket{
+		ProtocolVersion: uint32(peer.Version()),
+		NetworkID:       h.networkID,
+		TD:              h.chain.GetTd(head.Hash(), head.NumberU64()),
+		Head:            head.Hash(),
+		Number:          head.NumberU64(),
+		Genesis:         h.chain.Genesis().Hash(),
+		ForkID:          forkid.NewIDWithChain(h.chain),
+	}
+	if err := peer.Handshake(status, h.filter); err != nil {
+		h.logger.Debugw("Peer handshake failed", "peer", peer.ID(), "err", err)
+		return discReason(err)
+	}
+	if err := h.peers.register(peer); err != nil {
+		return discReason(err)
+	}
+	defer h.peers.unregister(peer.ID())
+	defer peer.Close()
+	peer.requestLimit, peer.broadcastLimit, peer.consensusLimit = h.reputation.newLimiters()
+
+	peerHead, number, td := peer.Head()
+	peer.MarkBlock(peerHead)
+	h.logger.Debugw("Peer connected", "peer", peer.ID(), "name", peer.Name(), "number", number, "td", td)
+
+	go func() {
+		if err := peer.sendLoop(); err != nil {
+			h.logger.Debugw("Unable to send broadcast to peer", "peer", peer.ID(), "err", err)
+		}
+	}()
+	for {
+		if err := h.handleMsg(peer); err != nil {
+			h.logger.Debugw("Peer message handling failed", "peer", peer.ID(), "err", err)
+			reason := discReason(err)
+			if reason == p2p.DiscProtocolError {
+				h.adjustScore(peer, penaltyProtocol, err.Error())
+			}
+			return reason
+		}
+	}
+}
+
+// handleMsg reads and handles the next message of the peer
+func (h *Handler) handleMsg(peer *Peer) error {
+	msg, err := peer.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%w: %d > %d", ErrMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	if !h.allowMsg(peer, msg.Code) {
+		h.adjustScore(peer, penaltyRateLimit, fmt.Sprintf("rate limit of message %#x exceeded", msg.Code))
+		return nil
+	}
+
+	switch msg.Code {
+	case StatusMsg:
+		return ErrExtraStatusMsg
+
+	case GetBlockHeadersMsg:
+		var req GetBlockHeadersPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, BlockHeadersMsg, &BlockHeadersPacket{
+			RequestId: req.RequestId,
+			Headers:   h.serveHeaders(req.GetBlockHeadersRequest),
+		})
+
+	case GetBlockBodiesMsg:
+		var req GetBlockBodiesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, BlockBodiesMsg, &BlockBodiesPacket{
+			RequestId: req.RequestId,
+			Bodies:    h.serveBodies(req.Hashes),
+		})
+
+	case GetReceiptsMsg:
+		var req GetReceiptsPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, ReceiptsMsg, &ReceiptsPacket{
+			RequestId: req.RequestId,
+			Receipts:  h.serveReceipts(req.Hashes),
+		})
+
+	case BlockHeadersMsg:
+		var res BlockHeadersPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, res.Headers)
+
+	case BlockBodiesMsg:
+		var res BlockBodiesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, res.Bodies)
+
+	case ReceiptsMsg:
+		var res ReceiptsPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, res.Receipts)
+
+	case GetAccountRangeMsg:
+		var req GetAccountRangePacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		keys, values, proof := h.serveRange(req.Root, req.Origin, req.Limit, req.Bytes)
+		accounts := make([]*AccountData, len(keys))
+		for i := range keys {
+			accounts[i] = &AccountData{Hash: keys[i], Body: values[i]}
+		}
+		return p2p.Send(peer.rw, AccountRangeMsg, &AccountRangePacket{RequestId: req.RequestId, Accounts: accounts, Proof: proof})
+
+	case GetStorageRangeMsg:
+		var req GetStorageRangePacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		keys, values, proof := h.serveRange(req.Root, req.Origin, req.Limit, req.Bytes)
+		slots := make([]*StorageData, len(keys))
+		for i := range keys {
+			slots[i] = &StorageData{Hash: keys[i], Body: values[i]}
+		}
+		return p2p.Send(peer.rw, StorageRangeMsg, &StorageRangePacket{RequestId: req.RequestId, Slots: slots, Proof: proof})
+
+	case GetByteCodesMsg:
+		var req GetByteCodesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, ByteCodesMsg, &ByteCodesPacket{RequestId: req.RequestId, Codes: h.serveByteCodes(req.Hashes)})
+
+	case GetTrieNodesMsg:
+		var req GetTrieNodesPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, TrieNodesMsg, &TrieNodesPacket{RequestId: req.RequestId, Nodes: h.serveTrieNodes(req.Hashes)})
+
+	case AccountRangeMsg:
+		var res AccountRangePacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, &res)
+
+	case StorageRangeMsg:
+		var res StorageRangePacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, &res)
+
+	case ByteCodesMsg:
+		var res ByteCodesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, res.Codes)
+
+	case TrieNodesMsg:
+		var res TrieNodesPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return peer.deliver(res.RequestId, res.Nodes)
+
+	case NewBlockHashesMsg:
+		var announces NewBlockHashesPacket
+		if err := msg.Decode(&announces); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		h.handleBlockAnnounces(peer, announces)
+		return nil
+
+	case NewBlockMsg:
+		var packet NewBlockPacket
+		if err := msg.Decode(&packet); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		if err := packet.sanityCheck(); err != nil {
+			return fmt.Errorf("%w: %s", ErrDecode, err)
+		}
+		h.handleNewBlock(peer, packet.Block, packet.TD)
+		return nil
+
+	case TransactionsMsg:
+		var txs TransactionsPacket
+		if err := msg.Decode(&txs); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		for i, tx := range txs {
+			if tx == nil {
+				return fmt.Errorf("%w: transaction %d is nil", ErrDecode, i)
+			}
+			peer.MarkTransaction(tx.Hash())
+		}
+		h.txPool.AddRemotes(txs)
+		return nil
+
+	case NewPooledTransactionHashesMsg:
+		if peer.Version() < Carrack3 {
+			return fmt.Errorf("%w: %#x", ErrInvalidMsgCode, msg.Code)
+		}
+		var hashes NewPooledTransactionHashesPacket
+		if err := msg.Decode(&hashes); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		h.handleTxAnnounces(peer, hashes)
+		return nil
+
+	case GetPooledTransactionsMsg:
+		if peer.Version() < Carrack3 {
+			return fmt.Errorf("%w: %#x", ErrInvalidMsgCode, msg.Code)
+		}
+		var req GetPooledTransactionsPacket
+		if err := msg.Decode(&req); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		return p2p.Send(peer.rw, PooledTransactionsMsg, &PooledTransactionsPacket{
+			RequestId:    req.RequestId,
+			Transactions: h.servePooledTransactions(req.Hashes),
+		})
+
+	case PooledTransactionsMsg:
+		if peer.Version() < Carrack3 {
+			return fmt.Errorf("%w: %#x", ErrInvalidMsgCode, msg.Code)
+		}
+		var res PooledTransactionsPacket
+		if err := msg.Decode(&res); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		for i, tx := range res.Transactions {
+			if tx == nil {
+				return fmt.Errorf("%w: transaction %d is nil", ErrDecode, i)
+			}
+		}
+		return peer.deliver(res.RequestId, res.Transactions)
+
+	case ConsensusMsg:
+		var payload ConsensusPacket
+		if err := msg.Decode(&payload); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrDecode, msg, err)
+		}
+		h.handleConsensus(peer, payload)
+		return nil
+
+	default:
+		return fmt.Errorf("%w: %#x", ErrInvalidMsgCode, msg.Code)
+	}
+}
+
+// allowMsg tells if the peer request or broadcast is within the rate limit, responses are not limited
+func (h *Handler) allowMsg(peer *Peer, code uint64) bool {
+	switch code {
+	case GetBlockHeadersMsg, GetBlockBodiesMsg, GetReceiptsMsg, GetAccountRangeMsg, GetStorageRangeMsg,
+		GetByteCodesMsg, GetTrieNodesMsg, GetPooledTransactionsMsg:
+		return peer.requestLimit.Allow()
+	case TransactionsMsg, NewPooledTransactionHashesMsg, NewBlockHashesMsg, NewBlockMsg:
+		return peer.broadcastLimit.Allow()
+	case ConsensusMsg:
+		// votes have their own budget, so transactions gossip does not stall consensus rounds
+		return peer.consensusLimit.Allow()
+	}
+	return true
+}
+
+// serveHeaders returns headers of the request, canonical chain is followed from the origin
+func (h *Handler) serveHeaders(req *GetBlockHeadersRequest) []*types.Header {
+	if req == nil {
+		return nil
+	}
+
+	var origin *types.Header
+	if req.Origin.Hash != (common.Hash{}) {
+		origin = h.chain.GetHeaderByHash(req.Origin.Hash)
+	} else {
+		origin = h.chain.GetHeaderByNumber(req.Origin.Number)
+	}
+	if origin == nil {
+		return nil
+	}
+
+	headers := []*types.Header{origin}
+	canonical := h.chain.GetCanonicalHash(origin.Number.Uint64()) == origin.Hash()
+	for uint64(len(headers)) < req.Amount && len(headers) < maxHeadersServe && len(headers)*estHeaderSize < softResponseLimit {
+		last := headers[len(headers)-1]
+		number := last.Number.Uint64()
+
+		var next *types.Header
+		switch {
+		case !canonical:
+			// side chains are only walked back by parents
+			if req.Reverse && req.Skip == 0 && number > 0 {
+				next = h.chain.GetHeader(last.ParentHash, number-1)
+			}
+		case req.Reverse:
+			if number >= req.Skip+1 {
+				next = h.chain.GetHeaderByNumber(number - req.Skip - 1)
+			}
+		default:
+			if number+req.Skip+1 > number {
+				next = h.chain.GetHeaderByNumber(number + req.Skip + 1)
+			}
+		}
+		if next == nil {
+			break
+		}
+		headers = append(headers, next)
+	}
+	return headers
+}
+
+// serveBodies returns bodies of the known blocks
+func (h *Handler) serveBodies(hashes []common.Hash) []*BlockBody {
+	var (
+		bodies []*BlockBody
+		size   common.StorageSize
+	)
+	for _, hash := range hashes {
+		if len(bodies) >= maxBodiesServe || size >= softResponseLimit {
+			break
+		}
+		block := h.chain.GetBlockByHash(hash)
+		if block == nil {
+			continue
+		}
+		bodies = append(bodies, &BlockBody{Transactions: block.Transactions(), Uncles: block.Uncles()})
+		size += block.Size()
+	}
+	return bodies
+}
+
+// serveReceipts returns receipts of the known blocks
+func (h *Handler) serveReceipts(hashes []common.Hash) [][]*types.Receipt {
+	var (
+		receipts [][]*types.Receipt
+		size     int
+	)
+	for _, hash := range hashes {
+		if len(receipts) >= maxReceiptsServe || size >= softResponseLimit {
+			break
+		}
+		results := h.chain.GetReceiptsByHash(hash)
+		if results == nil {
+			if header := h.chain.GetHeaderByHash(hash); header == nil || header.ReceiptHash != types.EmptyRootHash {
+				continue
+			}
+		}
+		receipts = append(receipts, results)
+		for _, r := range results {
+			size += types.BloomByteLength
+			for _, l := range r.Logs {
+				size += common.AddressLength + len(l.Topics)*common.HashLength + len(l.Data)
+			}
+		}
+	}
+	return receipts
+}
+
+// handleBlockAnnounces updates the peer head by the announced blocks, the block next to the local head is fetched,
+// blocks further ahead are left to chain synchronisation
+func (h *Handler) handleBlockAnnounces(peer *Peer, announces NewBlockHashesPacket) {
+	for _, a := range announces {
+		peer.MarkBlock(a.Hash)
+		if hash, number, td := peer.Head(); a.Number > number || hash == (common.Hash{}) {
+			// total difficulty is not announced, the peer is known to be at least as heavy
+			peer.SetHead(a.Hash, a.Number, td)
+		}
+
+		if a.Number != h.chain.CurrentBlock().NumberU64()+1 || h.chain.HasBlock(a.Hash, a.Number) {
+			continue
+		}
+		if len(h.blockFetches.reserve(peer, []common.Hash{a.Hash})) == 0 {
+			continue
+		}
+		a := a
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			defer h.blockFetches.release(peer, []common.Hash{a.Hash}, nil)
+			if err := h.fetchBlock(peer, a); errors.Is(err, errUnexpectedBlock) {
+				h.adjustScore(peer, penaltyProtocol, err.Error())
+			} else if err != nil && h.ctx.Err() == nil {
+				h.logger.Debugw("Unable to fetch announced block", "peer", peer.ID(), "number", a.Number, "hash", a.Hash, "err", err)
+				h.adjustScore(peer, penaltyStalled, err.Error())
+			}
+		}()
+	}
+}
+
+// handleNewBlock updates the peer head and imports the block on top of the known parent
+func (h *Handler) handleNewBlock(peer *Peer, block *types.Block, td *big.Int) {
+	// the peer head is the parent of the propagated block
+	parentTd := new(big.Int).Sub(td, block.Difficulty())
+	peer.SetHead(block.ParentHash(), block.NumberU64()-1, parentTd)
+	peer.MarkBlock(block.Hash())
+
+	if h.chain.HasBlock(block.Hash(), block.NumberU64()) {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.importBlock(peer, block)
+	}()
+}
+
+// importBlock inserts the block of the peer on top of the known parent, the peer head is updated once it is imported
+func (h *Handler) importBlock(peer *Peer, block *types.Block) {
+	if h.chain.GetHeader(block.ParentHash(), block.NumberU64()-1) == nil {
+		h.logger.Debugw("Propagated block parent is unknown", "peer", peer.ID(), "number", block.NumberU64(), "hash", block.Hash())
+		return
+	}
+	if _, err := h.chain.InsertChain(types.Blocks{block}); err != nil {
+		h.logger.Debugw("Unable to import propagated block", "peer", peer.ID(), "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+		if !errors.Is(err, core.ErrKnownBlock) && !errors.Is(err, core.ErrUnknownAncestor) && !errors.Is(err, core.ErrPrunedAncestor) &&
+			!errors.Is(err, core.ErrFutureBlock) && !errors.Is(err, core.ErrChainStopped) {
+			h.adjustScore(peer, penaltyBadBlock, err.Error())
+		}
+		return
+	}
+	h.adjustScore(peer, rewardUseful, "")
+	if td := h.chain.GetTd(block.Hash(), block.NumberU64()); td != nil {
+		peer.SetHead(block.Hash(), block.NumberU64(), td)
+	}
+}
+
+// servePooledTransactions returns the known pool transactions
+func (h *Handler) servePooledTransactions(hashes []common.Hash) []*types.Transaction {
+	var (
+		txs  []*types.Transaction
+		size common.StorageSize
+	)
+	for _, hash := range hashes {
+		if len(txs) >= maxTxsServe || size >= softResponseLimit {
+			break
+		}
+		if tx := h.txPool.Get(hash); tx != nil {
+			txs = append(txs, tx)
+			size += common.StorageSize(tx.Size())
+		}
+	}
+	return txs
+}
+
+// handleConsensus passes the message to the engine and relays it to other peers, if the engine accepted it
+func (h *Handler) handleConsensus(peer *Peer, payload []byte) {
+	hash := crypto.Keccak256Hash(payload)
+	if ok, _ := h.known.ContainsOrAdd(hash, struct{}{}); ok {
+		return
+	}
+
+	h.lock.RLock()
+	c := h.consensus
+	h.lock.RUnlock()
+	if c == nil {
+		return
+	}
+	if err := c.HandleMessage(payload); err != nil {
+		if errors.Is(err, consensus.ErrInvalidMessage) {
+			h.adjustScore(peer, penaltyProtocol, err.Error())
+		} else {
+			h.logger.Debugw("Consensus message rejected", "peer", peer.ID(), "hash", hash, "err", err)
+		}
+		return
+	}
+
+	for _, p := range h.peers.all() {
+		if p.ID() != peer.ID() {
+			h.sendConsensus(p, payload)
+		}
+	}
+}
+
+// BroadcastConsensus sends the local consensus engine message to all peers, it implements bft.Broadcaster
+func (h *Handler) BroadcastConsensus(payload []byte) {
+	h.known.Add(crypto.Keccak256Hash(payload), struct{}{})
+	for _, p := range h.peers.all() {
+		h.sendConsensus(p, payload)
+	}
+}
+
+func (h *Handler) sendConsensus(p *Peer, payload []byte) {
+	if err := p.SendConsensus(payload); err != nil {
+		h.logger.Debugw("Unable to send consensus message", "peer", p.ID(), "err", err)
+	}
+}
+
+// NodeInfo is the local chain advertised by the protocol
+type NodeInfo struct {
+	Network uint64      `json:"network" yaml:"network"`
+	TD      *big.Int    `json:"td" yaml:"td"`
+	Genesis common.Hash `json:"genesis" yaml:"genesis"`
+	Head    common.Hash `json:"head" yaml:"head"`
+	Number  uint64      `json:"number" yaml:"number"`
+}
+
+// NodeInfo returns the local chain head
+func (h *Handler) NodeInfo() *NodeInfo {
+	head := h.chain.CurrentBlock()
+	return &NodeInfo{
+		Network: h.networkID,
+		TD:      h.chain.GetTd(head.Hash(), head.NumberU64()),
+		Genesis: h.chain.Genesis().Hash(),
+		Head:    head.Hash(),
+		Number:  head.NumberU64(),
+	}
+}
+
+// PeerInfo is the peer protocol state
+type PeerInfo struct {
+	Version uint        `json:"version" yaml:"version"`
+	TD      *big.Int    `json:"td" yaml:"td"`
+	Head    common.Hash `json:"head" yaml:"head"`
+	Number  uint64      `json:"number" yaml:"number"`
+}
+
+// Info returns the peer protocol version and head
+func (p *Peer) Info() *PeerInfo {
+	head, number, td := p.Head()
+	return &PeerInfo{Version: p.version, TD: td, Head: head, Number: number}
+}
@@ -0,0 +1,430 @@
+package carrack
+
+import (
+	"context"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"github.com/rovergulf/chain/tests/testchain"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testTxPool struct {
+	lock sync.Mutex
+	txs  map[common.Hash]*types.Transaction
+	feed event.Feed
+}
+
+func newTestTxPool() *testTxPool {
+	return &testTxPool{txs: make(map[common.Hash]*types.Transaction)}
+}
+
+// AddRemotes adds transactions and posts the new ones as the pool does
+func (p *testTxPool) AddRemotes(txs []*types.Transaction) []error {
+	p.lock.Lock()
+	var added []*types.Transaction
+	for _, tx := range txs {
+		if _, ok := p.txs[tx.Hash()]; !ok {
+			p.txs[tx.Hash()] = tx
+			added = append(added, tx)
+		}
+	}
+	p.lock.Unlock()
+
+	if len(added) > 0 {
+		p.feed.Send(core.NewTxsEvent{Txs: added})
+	}
+	return make([]error, len(txs))
+}
+
+func (p *testTxPool) Get(hash common.Hash) *types.Transaction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.txs[hash]
+}
+
+func (p *testTxPool) Has(hash common.Hash) bool {
+	return p.Get(hash) != nil
+}
+
+func (p *testTxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return p.feed.Subscribe(ch)
+}
+
+// newTestBlocks generates blocks with a transfer each on top of the genesis
+func newTestBlocks(t *testing.T, genesis *core.Genesis, n int) []*types.Block {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	sdb := state.NewDatabase(badgerdb.NewDatabase(db, nil))
+	genesisBlock, err := genesis.ToBlock(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSigner(genesis.Config.EthConfig())
+	blocks, _, err := core.GenerateChain(genesis.Config, genesisBlock, sdb, n, func(i int, b *core.BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(tests.Account0), tests.Account1, big.NewInt(1000), 21_000, big.NewInt(1), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddTx(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func newTestHandler(t *testing.T, networkID uint64, genesis *core.Genesis, blocks []*types.Block) *Handler {
+	bc := testchain.New(t, genesis)
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %s", n, err)
+	}
+	logger, _ := logutils.NewLogger()
+	h := NewHandler(networkID, bc, newTestTxPool(), logger)
+	t.Cleanup(h.Stop)
+	return h
+}
+
+// connect runs the protocol between handlers over the message pipe,
+// it returns the remote peer of the local handler and errors both sides exit with
+func connect(local, remote *Handler) (*Peer, <-chan error) {
+	return connectVersion(local, remote, Carrack1, 2)
+}
+
+// connectVersion connects handlers with the protocol version, the remote has the id at the local handler
+func connectVersion(local, remote *Handler, version uint, id byte) (*Peer, <-chan error) {
+	localRw, remoteRw := p2p.MsgPipe()
+	localPeer := NewPeer(version, p2p.NewPeer(enode.ID{id}, "remote", nil), localRw)
+	remotePeer := NewPeer(version, p2p.NewPeer(enode.ID{1}, "local", nil), remoteRw)
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- local.runPeer(localPeer)
+		localRw.Close()
+	}()
+	go func() {
+		errc <- remote.runPeer(remotePeer)
+		remoteRw.Close()
+	}()
+	return localPeer, errc
+}
+
+// waitPeer waits for the peer to pass the handshake
+func waitPeer(t *testing.T, h *Handler, id enode.ID) *Peer {
+	for i := 0; i < 100; i++ {
+		if p := h.peers.peer(id.String()); p != nil {
+			return p
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("peer is not registered")
+	return nil
+}
+
+func TestHandshake(t *testing.T) {
+	genesis := testchain.Genesis()
+	otherGenesis := testchain.Genesis()
+	otherGenesis.ExtraData = []byte("other")
+
+	for _, tt := range []struct {
+		name    string
+		network uint64
+		genesis *core.Genesis
+		want    error
+	}{
+		{name: "network", network: params.TestNetworkId, genesis: genesis, want: p2p.DiscUselessPeer},
+		{name: "genesis", network: params.DevNetworkId, genesis: otherGenesis, want: p2p.DiscUselessPeer},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+			remote := newTestHandler(t, tt.network, tt.genesis, nil)
+
+			_, errc := connect(local, remote)
+			if err := <-errc; err != tt.want {
+				t.Fatalf("unexpected handshake error: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequests(t *testing.T) {
+	genesis := testchain.Genesis()
+	blocks := newTestBlocks(t, genesis, 10)
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remote := newTestHandler(t, params.DevNetworkId, genesis, blocks)
+
+	peer, _ := connect(local, remote)
+	waitPeer(t, local, peer.Node().ID())
+	if head, number, _ := peer.Head(); head != blocks[9].Hash() || number != 10 {
+		t.Fatalf("unexpected peer head #%d %s", number, head)
+	}
+
+	ctx := context.Background()
+	headers, err := peer.RequestHeadersByNumber(ctx, 1, 5, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 5 {
+		t.Fatalf("unexpected number of headers: %d", len(headers))
+	}
+	for i, header := range headers {
+		if want := blocks[i*2].Hash(); header.Hash() != want {
+			t.Fatalf("header %d is #%d %s, want %s", i, header.Number, header.Hash(), want)
+		}
+	}
+
+	headers, err = peer.RequestHeadersByHash(ctx, blocks[9].Hash(), 3, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 3 || headers[2].Hash() != blocks[7].Hash() {
+		t.Fatalf("unexpected reverse headers: %d", len(headers))
+	}
+
+	bodies, err := peer.RequestBodies(ctx, []common.Hash{blocks[0].Hash(), {1}, blocks[5].Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 2 || bodies[1].Transactions[0].Hash() != blocks[5].Transactions()[0].Hash() {
+		t.Fatalf("unexpected bodies: %d", len(bodies))
+	}
+
+	receipts, err := peer.RequestReceipts(ctx, []common.Hash{blocks[3].Hash()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 || types.DeriveSha(types.Receipts(receipts[0]), trie.NewStackTrie(nil)) != blocks[3].ReceiptHash() {
+		t.Fatal("unexpected receipts")
+	}
+}
+
+func TestNewBlock(t *testing.T) {
+	genesis := testchain.Genesis()
+	blocks := newTestBlocks(t, genesis, 2)
+	local := newTestHandler(t, params.DevNetworkId, genesis, blocks[:1])
+	remote := newTestHandler(t, params.DevNetworkId, genesis, blocks)
+
+	connect(local, remote)
+	remotePeer := waitPeer(t, remote, enode.ID{1})
+
+	td := remote.chain.GetTd(blocks[1].Hash(), 2)
+	if err := remotePeer.SendNewBlock(blocks[1], td); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && local.chain.CurrentBlock().NumberU64() != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if head := local.chain.CurrentBlock(); head.Hash() != blocks[1].Hash() {
+		t.Fatalf("propagated block is not imported, head #%d", head.NumberU64())
+	}
+}
+
+// newTestNetwork connects remote handlers to the local one over Carrack3
+func newTestNetwork(t *testing.T, local *Handler, remotes []*Handler) {
+	for i, remote := range remotes {
+		peer, _ := connectVersion(local, remote, Carrack3, byte(i+2))
+		waitPeer(t, local, peer.Node().ID())
+		waitPeer(t, remote, enode.ID{1})
+	}
+}
+
+func TestBroadcastTransactions(t *testing.T) {
+	genesis := testchain.Genesis()
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remotes := make([]*Handler, 4)
+	for i := range remotes {
+		remotes[i] = newTestHandler(t, params.DevNetworkId, genesis, nil)
+	}
+	newTestNetwork(t, local, remotes)
+
+	// two of the peers get the transaction, the others fetch it by the announced hash
+	tx := newTestBlocks(t, genesis, 1)[0].Transactions()[0]
+	local.txPool.AddRemotes([]*types.Transaction{tx})
+	for i, remote := range remotes {
+		for j := 0; j < 100 && !remote.txPool.Has(tx.Hash()); j++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !remote.txPool.Has(tx.Hash()) {
+			t.Fatalf("transaction is not propagated to peer %d", i)
+		}
+	}
+	for _, p := range local.Peers() {
+		if !p.KnownTransaction(tx.Hash()) {
+			t.Fatalf("transaction is not known to peer %s", p.ID())
+		}
+	}
+}
+
+func TestBroadcastBlock(t *testing.T) {
+	genesis := testchain.Genesis()
+	blocks := newTestBlocks(t, genesis, 2)
+	local := newTestHandler(t, params.DevNetworkId, genesis, blocks[:1])
+	remotes := make([]*Handler, 4)
+	for i := range remotes {
+		remotes[i] = newTestHandler(t, params.DevNetworkId, genesis, blocks[:1])
+	}
+	newTestNetwork(t, local, remotes)
+
+	// two of the peers get the block, the others fetch the announced one
+	if _, err := local.chain.InsertChain(blocks[1:]); err != nil {
+		t.Fatal(err)
+	}
+	for i, remote := range remotes {
+		for j := 0; j < 100 && remote.chain.CurrentBlock().NumberU64() != 2; j++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if head := remote.chain.CurrentBlock(); head.Hash() != blocks[1].Hash() {
+			t.Fatalf("block is not propagated to peer %d, head #%d", i, head.NumberU64())
+		}
+	}
+}
+
+// testConsensus records accepted messages, messages starting with zero byte are invalid
+type testConsensus struct {
+	lock     sync.Mutex
+	messages [][]byte
+}
+
+func (c *testConsensus) HandleMessage(payload []byte) error {
+	if len(payload) > 0 && payload[0] == 0 {
+		return consensus.ErrInvalidMessage
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = append(c.messages, payload)
+	return nil
+}
+
+func (c *testConsensus) received() [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.messages
+}
+
+func TestRelayConsensus(t *testing.T) {
+	genesis := testchain.Genesis()
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remotes := []*Handler{
+		newTestHandler(t, params.DevNetworkId, genesis, nil),
+		newTestHandler(t, params.DevNetworkId, genesis, nil),
+	}
+	newTestNetwork(t, local, remotes)
+	engine, relayed := new(testConsensus), new(testConsensus)
+	local.SetConsensus(engine)
+	remotes[1].SetConsensus(relayed)
+
+	// invalid message is not relayed and its sender is penalised
+	sender := waitPeer(t, remotes[0], enode.ID{1})
+	for _, payload := range [][]byte{{0, 1}, {1}} {
+		if err := sender.SendConsensus(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100 && len(relayed.received()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if messages := relayed.received(); len(messages) != 1 || messages[0][0] != 1 {
+		t.Fatalf("unexpected relayed messages: %x", messages)
+	}
+	if len(engine.received()) != 1 {
+		t.Fatalf("unexpected accepted messages: %x", engine.received())
+	}
+	if score := local.reputation.Score(enode.ID{2}.String()); score != penaltyProtocol {
+		t.Fatalf("unexpected score of the invalid message sender: %d", score)
+	}
+}
+
+func TestFetchSetAlternates(t *testing.T) {
+	s := newFetchSet(2)
+	first, second, third := &Peer{id: "first"}, &Peer{id: "second"}, &Peer{id: "third"}
+	hashes := []common.Hash{{1}, {2}, {3}}
+
+	// announcements above the peer limit are dropped, later announcers become alternates
+	if reserved := s.reserve(first, hashes); len(reserved) != 2 {
+		t.Fatalf("unexpected reserved hashes: %v", reserved)
+	}
+	if reserved := s.reserve(second, hashes); len(reserved) != 1 || reserved[0] != hashes[2] {
+		t.Fatalf("unexpected reserved hashes of the alternate: %v", reserved)
+	}
+	s.reserve(third, hashes[:1])
+
+	// undelivered hash is requested from the next announcer, then from the last one
+	retries := s.release(first, hashes[:2], func(hash common.Hash) bool { return hash == hashes[1] })
+	if len(retries) != 1 || len(retries[second]) != 1 || retries[second][0] != hashes[0] {
+		t.Fatalf("unexpected retries: %v", retries)
+	}
+	if retries = s.release(second, hashes[:1], func(common.Hash) bool { return false }); len(retries[third]) != 1 {
+		t.Fatalf("unexpected retries of the alternate: %v", retries)
+	}
+	if retries = s.release(third, hashes[:1], func(common.Hash) bool { return false }); len(retries) != 0 {
+		t.Fatalf("hash without announcers is retried: %v", retries)
+	}
+	if reserved := s.reserve(first, hashes[:2]); len(reserved) != 2 {
+		t.Fatalf("released hashes are not reserved again: %v", reserved)
+	}
+}
+
+func TestConsensusRateLimit(t *testing.T) {
+	genesis := testchain.Genesis()
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remote := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	logger, _ := logutils.NewLogger()
+	config := DefaultReputationConfig
+	config.BroadcastRate, config.BroadcastBurst = 0.001, 1
+	local.SetReputation(newReputation(config, logger))
+	engine := new(testConsensus)
+	local.SetConsensus(engine)
+
+	connect(local, remote)
+	sender := waitPeer(t, remote, enode.ID{1})
+
+	// transactions flood exhausts the broadcast budget, votes are still accepted
+	tx := newTestBlocks(t, genesis, 1)[0].Transactions()[0]
+	for i := 0; i < 10; i++ {
+		if err := sender.SendTransactions(types.Transactions{tx}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := byte(1); i <= 10; i++ {
+		if err := sender.SendConsensus([]byte{i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100 && len(engine.received()) < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if messages := engine.received(); len(messages) != 10 {
+		t.Fatalf("consensus messages are dropped: %d received", len(messages))
+	}
+	if score := local.reputation.Score(enode.ID{2}.String()); score >= 0 {
+		t.Fatalf("transactions flood is not limited, score %d", score)
+	}
+}
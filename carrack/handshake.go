@@ -0,0 +1,74 @@
+package carrack
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/rovergulf/chain/core/forkid"
+	"time"
+)
+
+// Handshake exchanges status messages with the peer, peers of other networks, genesis or incompatible forks are rejected
+func (p *Peer) Handshake(status *StatusPacket, filter forkid.Filter) error {
+	errc := make(chan error, 2)
+
+	var remote StatusPacket // safe to read after both goroutines are done
+	go func() {
+		errc <- p2p.Send(p.rw, StatusMsg, status)
+	}()
+	go func() {
+		errc <- p.readStatus(status.NetworkID, status.Genesis, filter, &remote)
+	}()
+
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+
+	// total difficulty of a chain is way below 100 bits
+	if tdlen := remote.TD.BitLen(); tdlen > 100 {
+		return fmt.Errorf("%w: too large total difficulty: bitlen %d", ErrDecode, tdlen)
+	}
+	p.SetHead(remote.Head, remote.Number, remote.TD)
+	return nil
+}
+
+// readStatus reads and validates the remote status message
+func (p *Peer) readStatus(network uint64, genesis common.Hash, filter forkid.Filter, status *StatusPacket) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Code != StatusMsg {
+		return fmt.Errorf("%w: first message has code %#x", ErrNoStatusMsg, msg.Code)
+	}
+	if msg.Size > maxMessageSize {
+		return fmt.Errorf("%w: %d > %d", ErrMsgTooLarge, msg.Size, maxMessageSize)
+	}
+	if err := msg.Decode(status); err != nil {
+		return fmt.Errorf("%w: status: %s", ErrDecode, err)
+	}
+	if status.NetworkID != network {
+		return fmt.Errorf("%w: %d, local %d", ErrNetworkIDMismatch, status.NetworkID, network)
+	}
+	if uint(status.ProtocolVersion) != p.version {
+		return fmt.Errorf("%w: %d, local %d", ErrProtocolVersionMismatch, status.ProtocolVersion, p.version)
+	}
+	if status.Genesis != genesis {
+		return fmt.Errorf("%w: %s, local %s", ErrGenesisMismatch, status.Genesis, genesis)
+	}
+	if err := filter(status.ForkID); err != nil {
+		return fmt.Errorf("%w: %s", ErrForkIDRejected, err)
+	}
+	return nil
+}
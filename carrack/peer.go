@@ -0,0 +1,366 @@
+package carrack
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+	"math/big"
+	"math/rand"
+	"sync"
+)
+
+const (
+	maxKnownTxs    = 32768 // transaction hashes remembered per peer
+	maxKnownBlocks = 1024  // block hashes remembered per peer
+	maxQueuedSends = 256   // broadcasts waiting to be sent to the peer, later ones are dropped
+)
+
+// Peer is the remote node speaking Carrack protocol
+type Peer struct {
+	*p2p.Peer
+	rw      p2p.MsgReadWriter
+	id      string
+	version uint
+
+	lock   sync.RWMutex // protects head, number and td
+	head   common.Hash
+	number uint64
+	td     *big.Int
+
+	knownTxs    *lru.Cache // transactions the peer has, they are not sent to it again
+	knownBlocks *lru.Cache // blocks the peer has
+	queue       chan func() error
+
+	requestLimit   *rate.Limiter // requests served to the peer, set by the handler
+	broadcastLimit *rate.Limiter // transactions and blocks messages accepted from the peer
+	consensusLimit *rate.Limiter // consensus messages accepted from the peer
+
+	reqLock sync.Mutex // protects nextId and pending
+	nextId  uint64
+	pending map[uint64]chan interface{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPeer returns the peer speaking the protocol version over the message stream
+func NewPeer(version uint, p *p2p.Peer, rw p2p.MsgReadWriter) *Peer {
+	knownTxs, _ := lru.New(maxKnownTxs)
+	knownBlocks, _ := lru.New(maxKnownBlocks)
+	return &Peer{
+		Peer:        p,
+		rw:          rw,
+		id:          p.ID().String(),
+		version:     version,
+		td:          new(big.Int),
+		knownTxs:    knownTxs,
+		knownBlocks: knownBlocks,
+		queue:       make(chan func() error, maxQueuedSends),
+		nextId:      rand.Uint64(),
+		pending:     make(map[uint64]chan interface{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// ID returns the peer node id
+func (p *Peer) ID() string {
+	return p.id
+}
+
+// Version returns the negotiated protocol version
+func (p *Peer) Version() uint {
+	return p.version
+}
+
+// Head returns the peer head block hash, number and chain total difficulty
+func (p *Peer) Head() (common.Hash, uint64, *big.Int) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.head, p.number, new(big.Int).Set(p.td)
+}
+
+// SetHead updates the peer head if the chain is heavier
+func (p *Peer) SetHead(hash common.Hash, number uint64, td *big.Int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if td.Cmp(p.td) < 0 {
+		return
+	}
+	p.head, p.number, p.td = hash, number, new(big.Int).Set(td)
+}
+
+// MarkTransaction remembers the transaction is known to the peer
+func (p *Peer) MarkTransaction(hash common.Hash) {
+	p.knownTxs.Add(hash, struct{}{})
+}
+
+// KnownTransaction tells if the peer has the transaction
+func (p *Peer) KnownTransaction(hash common.Hash) bool {
+	return p.knownTxs.Contains(hash)
+}
+
+// MarkBlock remembers the block is known to the peer
+func (p *Peer) MarkBlock(hash common.Hash) {
+	p.knownBlocks.Add(hash, struct{}{})
+}
+
+// KnownBlock tells if the peer has the block
+func (p *Peer) KnownBlock(hash common.Hash) bool {
+	return p.knownBlocks.Contains(hash)
+}
+
+// Close fails pending requests of the disconnected peer
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+}
+
+// SendTransactions broadcasts transactions to the peer
+func (p *Peer) SendTransactions(txs types.Transactions) error {
+	for _, tx := range txs {
+		p.MarkTransaction(tx.Hash())
+	}
+	return p2p.Send(p.rw, TransactionsMsg, TransactionsPacket(txs))
+}
+
+// SendPooledTransactionHashes announces transactions available in the local pool, it requires Carrack3
+func (p *Peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, NewPooledTransactionHashesPacket(hashes))
+}
+
+// SendNewBlockHashes announces blocks available at the local node
+func (p *Peer) SendNewBlockHashes(announces []BlockAnnouncement) error {
+	for _, a := range announces {
+		p.MarkBlock(a.Hash)
+	}
+	return p2p.Send(p.rw, NewBlockHashesMsg, NewBlockHashesPacket(announces))
+}
+
+// SendNewBlock propagates the block with the chain total difficulty
+func (p *Peer) SendNewBlock(block *types.Block, td *big.Int) error {
+	p.MarkBlock(block.Hash())
+	return p2p.Send(p.rw, NewBlockMsg, &NewBlockPacket{Block: block, TD: td})
+}
+
+// queueSend schedules the broadcast without waiting for the peer to read it, it is dropped if the queue is full
+func (p *Peer) queueSend(send func() error) bool {
+	select {
+	case p.queue <- send:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendLoop sends queued broadcasts until the peer is closed
+func (p *Peer) sendLoop() error {
+	for {
+		select {
+		case send := <-p.queue:
+			if err := send(); err != nil {
+				return err
+			}
+		case <-p.closed:
+			return nil
+		}
+	}
+}
+
+// SendConsensus sends the consensus engine message
+func (p *Peer) SendConsensus(payload []byte) error {
+	return p2p.Send(p.rw, ConsensusMsg, ConsensusPacket(payload))
+}
+
+// RequestHeadersByHash fetches headers starting at the block hash
+func (p *Peer) RequestHeadersByHash(ctx context.Context, origin common.Hash, amount, skip uint64, reverse bool) ([]*types.Header, error) {
+	return p.requestHeaders(ctx, &GetBlockHeadersRequest{
+		Origin:  HashOrNumber{Hash: origin},
+		Amount:  amount,
+		Skip:    skip,
+		Reverse: reverse,
+	})
+}
+
+// RequestHeadersByNumber fetches headers starting at the block number
+func (p *Peer) RequestHeadersByNumber(ctx context.Context, origin uint64, amount, skip uint64, reverse bool) ([]*types.Header, error) {
+	return p.requestHeaders(ctx, &GetBlockHeadersRequest{
+		Origin:  HashOrNumber{Number: origin},
+		Amount:  amount,
+		Skip:    skip,
+		Reverse: reverse,
+	})
+}
+
+func (p *Peer) requestHeaders(ctx context.Context, req *GetBlockHeadersRequest) ([]*types.Header, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetBlockHeadersMsg, &GetBlockHeadersPacket{RequestId: id, GetBlockHeadersRequest: req})
+	})
+	if err != nil {
+		return nil, err
+	}
+	headers, ok := res.([]*types.Header)
+	if !ok || uint64(len(headers)) > req.Amount {
+		return nil, ErrResponseMismatch
+	}
+	return headers, nil
+}
+
+// RequestBodies fetches bodies of the blocks, unknown blocks are skipped by the peer
+func (p *Peer) RequestBodies(ctx context.Context, hashes []common.Hash) ([]*BlockBody, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetBlockBodiesMsg, &GetBlockBodiesPacket{RequestId: id, Hashes: hashes})
+	})
+	if err != nil {
+		return nil, err
+	}
+	bodies, ok := res.([]*BlockBody)
+	if !ok || len(bodies) > len(hashes) {
+		return nil, ErrResponseMismatch
+	}
+	return bodies, nil
+}
+
+// RequestReceipts fetches receipts of the blocks, unknown blocks are skipped by the peer
+func (p *Peer) RequestReceipts(ctx context.Context, hashes []common.Hash) ([][]*types.Receipt, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetReceiptsMsg, &GetReceiptsPacket{RequestId: id, Hashes: hashes})
+	})
+	if err != nil {
+		return nil, err
+	}
+	receipts, ok := res.([][]*types.Receipt)
+	if !ok || len(receipts) > len(hashes) {
+		return nil, ErrResponseMismatch
+	}
+	return receipts, nil
+}
+
+// RequestAccountRange fetches accounts of the state root between the origin and limit hashes with their range proof
+func (p *Peer) RequestAccountRange(ctx context.Context, root, origin, limit common.Hash, bytes uint64) ([]*AccountData, [][]byte, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetAccountRangeMsg, &GetAccountRangePacket{RequestId: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	packet, ok := res.(*AccountRangePacket)
+	if !ok {
+		return nil, nil, ErrResponseMismatch
+	}
+	return packet.Accounts, packet.Proof, nil
+}
+
+// RequestStorageRange fetches slots of the storage root between the origin and limit hashes with their range proof
+func (p *Peer) RequestStorageRange(ctx context.Context, root, origin, limit common.Hash, bytes uint64) ([]*StorageData, [][]byte, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetStorageRangeMsg, &GetStorageRangePacket{RequestId: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	packet, ok := res.(*StorageRangePacket)
+	if !ok {
+		return nil, nil, ErrResponseMismatch
+	}
+	return packet.Slots, packet.Proof, nil
+}
+
+// RequestByteCodes fetches contract codes, unknown codes are empty and the response may be truncated
+func (p *Peer) RequestByteCodes(ctx context.Context, hashes []common.Hash) ([][]byte, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetByteCodesMsg, &GetByteCodesPacket{RequestId: id, Hashes: hashes})
+	})
+	if err != nil {
+		return nil, err
+	}
+	codes, ok := res.([][]byte)
+	if !ok || len(codes) > len(hashes) {
+		return nil, ErrResponseMismatch
+	}
+	return codes, nil
+}
+
+// RequestTrieNodes fetches state trie nodes, unknown nodes are empty and the response may be truncated
+func (p *Peer) RequestTrieNodes(ctx context.Context, hashes []common.Hash) ([][]byte, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetTrieNodesMsg, &GetTrieNodesPacket{RequestId: id, Hashes: hashes})
+	})
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := res.([][]byte)
+	if !ok || len(nodes) > len(hashes) {
+		return nil, ErrResponseMismatch
+	}
+	return nodes, nil
+}
+
+// RequestPooledTransactions fetches announced transactions, unknown transactions are skipped by the peer
+func (p *Peer) RequestPooledTransactions(ctx context.Context, hashes []common.Hash) ([]*types.Transaction, error) {
+	res, err := p.request(ctx, func(id uint64) error {
+		return p2p.Send(p.rw, GetPooledTransactionsMsg, &GetPooledTransactionsPacket{RequestId: id, Hashes: hashes})
+	})
+	if err != nil {
+		return nil, err
+	}
+	txs, ok := res.([]*types.Transaction)
+	if !ok || len(txs) > len(hashes) {
+		return nil, ErrResponseMismatch
+	}
+	return txs, nil
+}
+
+// request sends the request with a new id and waits for the response delivered by the handler
+func (p *Peer) request(ctx context.Context, send func(id uint64) error) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	p.reqLock.Lock()
+	id := p.nextId
+	p.nextId++
+	res := make(chan interface{}, 1)
+	p.pending[id] = res
+	p.reqLock.Unlock()
+
+	defer func() {
+		p.reqLock.Lock()
+		delete(p.pending, id)
+		p.reqLock.Unlock()
+	}()
+
+	if err := send(id); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-res:
+		return data, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("request %d: %w", id, ctx.Err())
+	case <-p.closed:
+		return nil, ErrPeerClosed
+	}
+}
+
+// deliver passes the response to the pending request
+func (p *Peer) deliver(id uint64, data interface{}) error {
+	p.reqLock.Lock()
+	res, ok := p.pending[id]
+	delete(p.pending, id)
+	p.reqLock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: id %d", ErrUnrequestedResponse, id)
+	}
+	res <- data
+	return nil
+}
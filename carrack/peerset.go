@@ -0,0 +1,77 @@
+package carrack
+
+import (
+	"math/big"
+	"sync"
+)
+
+// peerSet is the registry of peers passed the handshake
+type peerSet struct {
+	lock  sync.RWMutex
+	peers map[string]*Peer
+}
+
+func newPeerSet() *peerSet {
+	return &peerSet{peers: make(map[string]*Peer)}
+}
+
+func (ps *peerSet) register(p *Peer) error {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	if _, ok := ps.peers[p.ID()]; ok {
+		return ErrAlreadyRegistered
+	}
+	ps.peers[p.ID()] = p
+	return nil
+}
+
+func (ps *peerSet) unregister(id string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	delete(ps.peers, id)
+}
+
+func (ps *peerSet) peer(id string) *Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return ps.peers[id]
+}
+
+func (ps *peerSet) len() int {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	return len(ps.peers)
+}
+
+// all returns registered peers
+func (ps *peerSet) all() []*Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	list := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		list = append(list, p)
+	}
+	return list
+}
+
+// best returns the peer of the heaviest chain
+func (ps *peerSet) best() *Peer {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	var (
+		best   *Peer
+		bestTd *big.Int
+	)
+	for _, p := range ps.peers {
+		if _, _, td := p.Head(); bestTd == nil || td.Cmp(bestTd) > 0 {
+			best, bestTd = p, td
+		}
+	}
+	return best
+}
@@ -0,0 +1,333 @@
+package carrack
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/core/forkid"
+	"io"
+	"math/big"
+	"time"
+)
+
+const (
+	// ProtocolName is the devp2p capability name of the Carrack protocol
+	ProtocolName = "carrack"
+
+	// Carrack1 is the first version of the protocol
+	Carrack1 = 1
+
+	// Carrack2 adds state ranges and trie nodes requests of snap sync
+	Carrack2 = 2
+
+	// Carrack3 adds transaction hash announcements and pooled transactions requests
+	Carrack3 = 3
+)
+
+// ProtocolVersions are the supported versions, the first one is preferred
+var ProtocolVersions = []uint{Carrack3, Carrack2, Carrack1}
+
+// protocolLengths are the numbers of message codes of protocol versions
+var protocolLengths = map[uint]uint64{Carrack1: 11, Carrack2: 19, Carrack3: 22}
+
+const (
+	maxMessageSize    = 10 * 1024 * 1024 // message size limit of the protocol
+	softResponseLimit = 2 * 1024 * 1024  // served response size target
+	estHeaderSize     = 500              // approximate size of the RLP encoded header
+
+	maxHeadersServe  = 1024 // headers served by one request
+	maxBodiesServe   = 1024 // block bodies served by one request
+	maxReceiptsServe = 1024 // block receipts served by one request
+	maxCodesServe    = 1024 // contract codes served by one request
+	maxNodesServe    = 1024 // trie nodes served by one request
+	maxTxsServe      = 256  // pooled transactions served by one request
+
+	handshakeTimeout = 5 * time.Second
+	requestTimeout   = 10 * time.Second
+)
+
+// Carrack1 message codes
+const (
+	StatusMsg          = 0x00
+	NewBlockHashesMsg  = 0x01
+	TransactionsMsg    = 0x02
+	GetBlockHeadersMsg = 0x03
+	BlockHeadersMsg    = 0x04
+	GetBlockBodiesMsg  = 0x05
+	BlockBodiesMsg     = 0x06
+	NewBlockMsg        = 0x07
+	GetReceiptsMsg     = 0x08
+	ReceiptsMsg        = 0x09
+	ConsensusMsg       = 0x0a
+)
+
+// Carrack2 message codes
+const (
+	GetAccountRangeMsg = 0x0b
+	AccountRangeMsg    = 0x0c
+	GetStorageRangeMsg = 0x0d
+	StorageRangeMsg    = 0x0e
+	GetByteCodesMsg    = 0x0f
+	ByteCodesMsg       = 0x10
+	GetTrieNodesMsg    = 0x11
+	TrieNodesMsg       = 0x12
+)
+
+// Carrack3 message codes
+const (
+	NewPooledTransactionHashesMsg = 0x13
+	GetPooledTransactionsMsg      = 0x14
+	PooledTransactionsMsg         = 0x15
+)
+
+var (
+	ErrMsgTooLarge             = errors.New("message too long")
+	ErrDecode                  = errors.New("invalid message")
+	ErrInvalidMsgCode          = errors.New("invalid message code")
+	ErrNoStatusMsg             = errors.New("no status message")
+	ErrExtraStatusMsg          = errors.New("extra status message")
+	ErrProtocolVersionMismatch = errors.New("protocol version mismatch")
+	ErrNetworkIDMismatch       = errors.New("network id mismatch")
+	ErrGenesisMismatch         = errors.New("genesis mismatch")
+	ErrForkIDRejected          = errors.New("fork id rejected")
+	ErrUnrequestedResponse     = errors.New("unrequested response")
+	ErrResponseMismatch        = errors.New("response does not match the request")
+	ErrPeerClosed              = errors.New("peer closed")
+	ErrAlreadyRegistered       = errors.New("peer is already registered")
+)
+
+// discReason returns the disconnect reason of the protocol error, other errors are returned as is
+func discReason(err error) error {
+	switch {
+	case errors.Is(err, ErrProtocolVersionMismatch), errors.Is(err, ErrNetworkIDMismatch),
+		errors.Is(err, ErrGenesisMismatch), errors.Is(err, ErrForkIDRejected):
+		return p2p.DiscUselessPeer
+	case errors.Is(err, ErrMsgTooLarge), errors.Is(err, ErrDecode), errors.Is(err, ErrInvalidMsgCode),
+		errors.Is(err, ErrNoStatusMsg), errors.Is(err, ErrExtraStatusMsg),
+		errors.Is(err, ErrUnrequestedResponse), errors.Is(err, ErrResponseMismatch):
+		return p2p.DiscProtocolError
+	case errors.Is(err, ErrAlreadyRegistered):
+		return p2p.DiscAlreadyConnected
+	}
+	return err
+}
+
+// StatusPacket is the handshake message, peers of other chains are disconnected
+type StatusPacket struct {
+	ProtocolVersion uint32
+	NetworkID       uint64
+	TD              *big.Int
+	Head            common.Hash
+	Number          uint64
+	Genesis         common.Hash
+	ForkID          forkid.ID
+}
+
+// BlockAnnouncement is the hash and number of a new block
+type BlockAnnouncement struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// NewBlockHashesPacket announces new blocks available at the peer
+type NewBlockHashesPacket []BlockAnnouncement
+
+// TransactionsPacket broadcasts transactions
+type TransactionsPacket []*types.Transaction
+
+// HashOrNumber is the origin of headers request, either a hash or a number
+type HashOrNumber struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// EncodeRLP implements rlp.Encoder, only one of the fields is encoded
+func (hn *HashOrNumber) EncodeRLP(w io.Writer) error {
+	if hn.Hash == (common.Hash{}) {
+		return rlp.Encode(w, hn.Number)
+	}
+	if hn.Number != 0 {
+		return fmt.Errorf("both origin hash %s and number %d provided", hn.Hash, hn.Number)
+	}
+	return rlp.Encode(w, hn.Hash)
+}
+
+// DecodeRLP implements rlp.Decoder, the field is told by the value size
+func (hn *HashOrNumber) DecodeRLP(s *rlp.Stream) error {
+	_, size, err := s.Kind()
+	switch {
+	case err != nil:
+		return err
+	case size == common.HashLength:
+		hn.Number = 0
+		return s.Decode(&hn.Hash)
+	case size <= 8:
+		hn.Hash = common.Hash{}
+		return s.Decode(&hn.Number)
+	default:
+		return fmt.Errorf("invalid input size %d for origin", size)
+	}
+}
+
+// GetBlockHeadersRequest queries amount of headers starting at origin, skipping blocks in between
+type GetBlockHeadersRequest struct {
+	Origin  HashOrNumber
+	Amount  uint64
+	Skip    uint64
+	Reverse bool
+}
+
+// GetBlockHeadersPacket is the headers request
+type GetBlockHeadersPacket struct {
+	RequestId uint64
+	*GetBlockHeadersRequest
+}
+
+// BlockHeadersPacket is the headers response
+type BlockHeadersPacket struct {
+	RequestId uint64
+	Headers   []*types.Header
+}
+
+// GetBlockBodiesPacket is the block bodies request
+type GetBlockBodiesPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// BlockBody is the block transactions and uncles
+type BlockBody struct {
+	Transactions []*types.Transaction
+	Uncles       []*types.Header
+}
+
+// BlockBodiesPacket is the block bodies response
+type BlockBodiesPacket struct {
+	RequestId uint64
+	Bodies    []*BlockBody
+}
+
+// NewBlockPacket propagates the whole block with the chain total difficulty
+type NewBlockPacket struct {
+	Block *types.Block
+	TD    *big.Int
+}
+
+// sanityCheck rejects blocks the chain may not have
+func (p *NewBlockPacket) sanityCheck() error {
+	if err := p.Block.SanityCheck(); err != nil {
+		return err
+	}
+	if p.Block.NumberU64() == 0 {
+		return errors.New("genesis block propagated")
+	}
+	if p.TD.Cmp(p.Block.Difficulty()) < 0 {
+		return errors.New("total difficulty is below the block difficulty")
+	}
+	// total difficulty of a chain is way below 100 bits
+	if tdlen := p.TD.BitLen(); tdlen > 100 {
+		return fmt.Errorf("too large block total difficulty: bitlen %d", tdlen)
+	}
+	return nil
+}
+
+// GetReceiptsPacket is the block receipts request
+type GetReceiptsPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// ReceiptsPacket is the block receipts response
+type ReceiptsPacket struct {
+	RequestId uint64
+	Receipts  [][]*types.Receipt
+}
+
+// ConsensusPacket carries the consensus engine message
+type ConsensusPacket []byte
+
+// GetAccountRangePacket requests accounts of the state trie starting at the origin hash up to the limit one
+type GetAccountRangePacket struct {
+	RequestId uint64
+	Root      common.Hash
+	Origin    common.Hash
+	Limit     common.Hash
+	Bytes     uint64 // soft limit of the response size
+}
+
+// AccountData is the state trie leaf, the account RLP keyed by the address hash
+type AccountData struct {
+	Hash common.Hash
+	Body rlp.RawValue
+}
+
+// AccountRangePacket is the account range response, proofs of the origin and the last account make it verifiable
+type AccountRangePacket struct {
+	RequestId uint64
+	Accounts  []*AccountData
+	Proof     [][]byte
+}
+
+// GetStorageRangePacket requests slots of the storage trie starting at the origin hash up to the limit one
+type GetStorageRangePacket struct {
+	RequestId uint64
+	Root      common.Hash // storage trie root
+	Origin    common.Hash
+	Limit     common.Hash
+	Bytes     uint64
+}
+
+// StorageData is the storage trie leaf, the slot value RLP keyed by the slot hash
+type StorageData struct {
+	Hash common.Hash
+	Body []byte
+}
+
+// StorageRangePacket is the storage range response
+type StorageRangePacket struct {
+	RequestId uint64
+	Slots     []*StorageData
+	Proof     [][]byte
+}
+
+// GetByteCodesPacket requests contract codes by hashes
+type GetByteCodesPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// ByteCodesPacket is the contract codes response, unknown codes are empty
+type ByteCodesPacket struct {
+	RequestId uint64
+	Codes     [][]byte
+}
+
+// GetTrieNodesPacket requests state trie nodes by hashes
+type GetTrieNodesPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// TrieNodesPacket is the trie nodes response, unknown nodes are empty
+type TrieNodesPacket struct {
+	RequestId uint64
+	Nodes     [][]byte
+}
+
+// NewPooledTransactionHashesPacket announces transactions available in the peer pool
+type NewPooledTransactionHashesPacket []common.Hash
+
+// GetPooledTransactionsPacket requests announced transactions by hashes
+type GetPooledTransactionsPacket struct {
+	RequestId uint64
+	Hashes    []common.Hash
+}
+
+// PooledTransactionsPacket is the pooled transactions response, unknown transactions are skipped
+type PooledTransactionsPacket struct {
+	RequestId    uint64
+	Transactions []*types.Transaction
+}
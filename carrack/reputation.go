@@ -0,0 +1,255 @@
+package carrack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/rovergulf/chain/allowlist"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	maxScore         = 100  // score of well behaving peers does not grow above it
+	maxPeerScores    = 4096 // scores of nodes remembered, including disconnected ones
+	rewardUseful     = 1    // requested data is delivered or the propagated block is imported
+	penaltyRateLimit = -5   // the request or broadcast rate is exceeded, the message is ignored
+	penaltyStalled   = -10  // the request failed or timed out
+	penaltyDropped   = -40  // chain synchronisation dropped the peer for invalid data or stalling
+	penaltyProtocol  = -50  // malformed, unexpected or mismatching message
+	penaltyBadBlock  = -100 // propagated block failed validation
+)
+
+// ReputationConfig are the peer scoring, rate limits and banning settings
+type ReputationConfig struct {
+	BanThreshold   int           `json:"ban_threshold" yaml:"ban_threshold"`     // score peers are disconnected and banned at
+	BanDuration    time.Duration `json:"ban_duration" yaml:"ban_duration"`       // time peers are banned for
+	RequestRate    float64       `json:"request_rate" yaml:"request_rate"`       // requests per second served to a peer
+	RequestBurst   int           `json:"request_burst" yaml:"request_burst"`     // requests served at once
+	BroadcastRate  float64       `json:"broadcast_rate" yaml:"broadcast_rate"`   // transactions and blocks messages per second accepted from a peer
+	BroadcastBurst int           `json:"broadcast_burst" yaml:"broadcast_burst"` // transactions and blocks messages accepted at once
+	ConsensusRate  float64       `json:"consensus_rate" yaml:"consensus_rate"`   // consensus messages per second accepted from a peer
+	ConsensusBurst int           `json:"consensus_burst" yaml:"consensus_burst"` // consensus messages accepted at once
+	BanList        string        `json:"ban_list" yaml:"ban_list"`               // file bans are kept in, they are lost on restart if empty
+}
+
+var DefaultReputationConfig = ReputationConfig{
+	BanThreshold:   -100,
+	BanDuration:    24 * time.Hour,
+	RequestRate:    100,
+	RequestBurst:   500,
+	BroadcastRate:  50,
+	BroadcastBurst: 200,
+	ConsensusRate:  100,
+	ConsensusBurst: 400,
+}
+
+func (c ReputationConfig) sanitize() ReputationConfig {
+	if c.BanThreshold >= 0 {
+		c.BanThreshold = DefaultReputationConfig.BanThreshold
+	}
+	if c.BanDuration <= 0 {
+		c.BanDuration = DefaultReputationConfig.BanDuration
+	}
+	if c.RequestRate <= 0 {
+		c.RequestRate = DefaultReputationConfig.RequestRate
+	}
+	if c.RequestBurst < 1 {
+		c.RequestBurst = DefaultReputationConfig.RequestBurst
+	}
+	if c.BroadcastRate <= 0 {
+		c.BroadcastRate = DefaultReputationConfig.BroadcastRate
+	}
+	if c.BroadcastBurst < 1 {
+		c.BroadcastBurst = DefaultReputationConfig.BroadcastBurst
+	}
+	if c.ConsensusRate <= 0 {
+		c.ConsensusRate = DefaultReputationConfig.ConsensusRate
+	}
+	if c.ConsensusBurst < 1 {
+		c.ConsensusBurst = DefaultReputationConfig.ConsensusBurst
+	}
+	return c
+}
+
+// Reputation scores peers by their behaviour and bans nodes dropping to the threshold
+type Reputation struct {
+	config ReputationConfig
+	logger *zap.SugaredLogger
+
+	lock   sync.Mutex
+	scores *lru.Cache           // node id to score
+	banned map[string]time.Time // node id to the ban expiration
+}
+
+// NewReputation returns peers reputation with bans loaded from the ban list file
+func NewReputation(config ReputationConfig, logger *zap.SugaredLogger) (*Reputation, error) {
+	r := newReputation(config, logger)
+	if len(r.config.BanList) == 0 {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(r.config.BanList)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &r.banned); err != nil {
+		return nil, fmt.Errorf("invalid ban list %s: %w", r.config.BanList, err)
+	}
+	r.pruneBans()
+	return r, nil
+}
+
+func newReputation(config ReputationConfig, logger *zap.SugaredLogger) *Reputation {
+	scores, _ := lru.New(maxPeerScores)
+	return &Reputation{
+		config: config.sanitize(),
+		logger: logger,
+		scores: scores,
+		banned: make(map[string]time.Time),
+	}
+}
+
+// Score returns the node score, unknown nodes have zero
+func (r *Reputation) Score(id string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.score(id)
+}
+
+func (r *Reputation) score(id string) int {
+	if score, ok := r.scores.Get(id); ok {
+		return score.(int)
+	}
+	return 0
+}
+
+// adjust changes the node score, it returns true if the node is banned by it
+func (r *Reputation) adjust(id string, delta int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	score := r.score(id) + delta
+	if score > maxScore {
+		score = maxScore
+	}
+	if score > r.config.BanThreshold {
+		r.scores.Add(id, score)
+		return false
+	}
+
+	// the node starts over once the ban expires
+	r.scores.Remove(id)
+	r.banned[id] = time.Now().Add(r.config.BanDuration)
+	r.saveBans()
+	return true
+}
+
+// Ban bans the node for the duration, the configured one is used if it is not positive
+func (r *Reputation) Ban(id string, d time.Duration) {
+	if d <= 0 {
+		d = r.config.BanDuration
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.scores.Remove(id)
+	r.banned[id] = time.Now().Add(d)
+	r.saveBans()
+}
+
+// Unban lifts the node ban and resets its score, it returns false if the node is not banned
+func (r *Reputation) Unban(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.scores.Remove(id)
+	if _, ok := r.banned[id]; !ok {
+		return false
+	}
+	delete(r.banned, id)
+	r.saveBans()
+	return true
+}
+
+// Banned tells if the node is banned
+func (r *Reputation) Banned(id string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	until, ok := r.banned[id]
+	return ok && time.Now().Before(until)
+}
+
+// BannedNodes returns banned nodes with their ban expiration
+func (r *Reputation) BannedNodes() map[string]time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.pruneBans()
+	nodes := make(map[string]time.Time, len(r.banned))
+	for id, until := range r.banned {
+		nodes[id] = until
+	}
+	return nodes
+}
+
+// newLimiters returns the request, broadcast and consensus rate limiters of a peer
+func (r *Reputation) newLimiters() (*rate.Limiter, *rate.Limiter, *rate.Limiter) {
+	return rate.NewLimiter(rate.Limit(r.config.RequestRate), r.config.RequestBurst),
+		rate.NewLimiter(rate.Limit(r.config.BroadcastRate), r.config.BroadcastBurst),
+		rate.NewLimiter(rate.Limit(r.config.ConsensusRate), r.config.ConsensusBurst)
+}
+
+func (r *Reputation) pruneBans() {
+	now := time.Now()
+	for id, until := range r.banned {
+		if now.After(until) {
+			delete(r.banned, id)
+		}
+	}
+}
+
+// saveBans writes the ban list file, the previous one is replaced once the new one is written
+func (r *Reputation) saveBans() {
+	if len(r.config.BanList) == 0 {
+		return
+	}
+	r.pruneBans()
+
+	data, err := json.MarshalIndent(r.banned, "", "  ")
+	if err != nil {
+		r.logger.Errorw("Unable to encode ban list", "err", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.config.BanList), 0700); err != nil {
+		r.logger.Errorw("Unable to save ban list", "path", r.config.BanList, "err", err)
+		return
+	}
+	tmp := r.config.BanList + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		r.logger.Errorw("Unable to save ban list", "path", tmp, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, r.config.BanList); err != nil {
+		r.logger.Errorw("Unable to save ban list", "path", r.config.BanList, "err", err)
+	}
+}
+
+// ParseNodeID returns the node id of the enode URL or the hex encoded id
+func ParseNodeID(node string) (string, error) {
+	id, err := allowlist.ParseNode(node)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,175 @@
+package carrack
+
+import (
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/rovergulf/chain/allowlist"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/tests/testchain"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReputationBanList(t *testing.T) {
+	logger, _ := logutils.NewLogger()
+	config := ReputationConfig{BanList: filepath.Join(t.TempDir(), "banned.json")}
+	r, err := NewReputation(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad, good := enode.ID{1}.String(), enode.ID{2}.String()
+	if r.adjust(bad, penaltyProtocol) || r.Score(bad) != penaltyProtocol {
+		t.Fatalf("unexpected score %d", r.Score(bad))
+	}
+	if !r.adjust(bad, penaltyProtocol) || !r.Banned(bad) {
+		t.Fatal("peer is not banned at the threshold")
+	}
+	r.Ban(good, time.Millisecond)
+
+	// bans are loaded on restart, expired ones are dropped
+	time.Sleep(5 * time.Millisecond)
+	if r, err = NewReputation(config, logger); err != nil {
+		t.Fatal(err)
+	}
+	if banned := r.BannedNodes(); len(banned) != 1 || !r.Banned(bad) || r.Banned(good) {
+		t.Fatalf("unexpected banned nodes %v", banned)
+	}
+	if !r.Unban(bad) || r.Banned(bad) || r.Unban(bad) {
+		t.Fatal("node is not unbanned")
+	}
+}
+
+func TestBannedPeer(t *testing.T) {
+	genesis := testchain.Genesis()
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remote := newTestHandler(t, params.DevNetworkId, genesis, nil)
+
+	local.BanPeer(enode.ID{2}.String(), 0)
+	if _, errc := connect(local, remote); <-errc != p2p.DiscUselessPeer {
+		t.Fatal("banned peer is not refused")
+	}
+	if _, err := ParseNodeID("enode://invalid"); err == nil {
+		t.Fatal("invalid node is parsed")
+	}
+}
+
+type testAllowlist struct {
+	lock    sync.Mutex
+	allowed map[enode.ID]bool
+	feed    event.Feed
+}
+
+func (a *testAllowlist) Allowed(id enode.ID) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.allowed[id]
+}
+
+func (a *testAllowlist) set(id enode.ID, allowed bool) {
+	a.lock.Lock()
+	a.allowed[id] = allowed
+	a.lock.Unlock()
+
+	a.feed.Send(allowlist.ChangeEvent{})
+}
+
+func (a *testAllowlist) SubscribeChangeEvent(ch chan<- allowlist.ChangeEvent) event.Subscription {
+	return a.feed.Subscribe(ch)
+}
+
+func TestAllowlistPeer(t *testing.T) {
+	genesis := testchain.Genesis()
+	local := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	remote := newTestHandler(t, params.DevNetworkId, genesis, nil)
+	nodes := &testAllowlist{allowed: make(map[enode.ID]bool)}
+	local.SetAllowlist(nodes)
+
+	if _, errc := connect(local, remote); <-errc != p2p.DiscUselessPeer {
+		t.Fatal("peer missing in the allowlist is not refused")
+	}
+
+	nodes.set(enode.ID{2}, true)
+	peer, _ := connect(local, remote)
+	waitPeer(t, local, peer.Node().ID())
+
+	nodes.set(enode.ID{2}, false)
+	if _, errc := connect(local, remote); <-errc != p2p.DiscUselessPeer {
+		t.Fatal("peer removed from the allowlist is not refused")
+	}
+}
+
+// testDialer records static and trusted nodes of the p2p server
+type testDialer struct {
+	lock    sync.Mutex
+	static  map[enode.ID]bool
+	trusted map[enode.ID]bool
+}
+
+func (d *testDialer) set(nodes map[enode.ID]bool, n *enode.Node, add bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	nodes[n.ID()] = add
+}
+
+func (d *testDialer) AddPeer(n *enode.Node) {
+	d.set(d.static, n, true)
+}
+
+func (d *testDialer) RemovePeer(n *enode.Node) {
+	d.set(d.static, n, false)
+}
+
+func (d *testDialer) AddTrustedPeer(n *enode.Node) {
+	d.set(d.trusted, n, true)
+}
+
+func (d *testDialer) RemoveTrustedPeer(n *enode.Node) {
+	d.set(d.trusted, n, false)
+}
+
+func (d *testDialer) dialed(id enode.ID) (bool, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.static[id], d.trusted[id]
+}
+
+func TestAllowlistStaticNodes(t *testing.T) {
+	local := newTestHandler(t, params.DevNetworkId, testchain.Genesis(), nil)
+	nodes := &testAllowlist{allowed: map[enode.ID]bool{{2}: true}}
+	local.SetAllowlist(nodes)
+
+	static, trusted := enode.SignNull(new(enr.Record), enode.ID{2}), enode.SignNull(new(enr.Record), enode.ID{3})
+	if listed := local.ListedNodes([]*enode.Node{static, trusted}); len(listed) != 1 || listed[0] != static {
+		t.Fatalf("unexpected listed nodes: %v", listed)
+	}
+
+	dialer := &testDialer{static: make(map[enode.ID]bool), trusted: make(map[enode.ID]bool)}
+	local.SetStaticNodes(dialer, []*enode.Node{static}, []*enode.Node{trusted})
+	if s, tr := dialer.dialed(static.ID()); !s || tr {
+		t.Fatalf("unexpected static node state: static %v, trusted %v", s, tr)
+	}
+	if _, tr := dialer.dialed(trusted.ID()); tr {
+		t.Fatal("trusted node missing in the allowlist is trusted")
+	}
+
+	nodes.set(static.ID(), false)
+	nodes.set(trusted.ID(), true)
+	for i := 0; i < 100; i++ {
+		s, _ := dialer.dialed(static.ID())
+		_, tr := dialer.dialed(trusted.ID())
+		if !s && tr {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("static nodes are not updated on the allowlist change")
+}
@@ -0,0 +1,92 @@
+package carrack
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// serveRange returns leaves of the trie starting at the origin hash with proofs of the origin and the last leaf.
+// The first leaf above the limit is included, so the range is proven to end at the limit
+func (h *Handler) serveRange(root, origin, limit common.Hash, size uint64) ([]common.Hash, [][]byte, [][]byte) {
+	if size == 0 || size > softResponseLimit {
+		size = softResponseLimit
+	}
+	tr, err := trie.New(common.Hash{}, root, h.chain.StateDatabase().Underlying().TrieDB())
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	var (
+		keys   []common.Hash
+		values [][]byte
+		total  uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	for it.Next() {
+		keys = append(keys, common.BytesToHash(it.Key))
+		values = append(values, common.CopyBytes(it.Value))
+		total += uint64(common.HashLength + len(it.Value))
+		if bytes.Compare(it.Key, limit[:]) >= 0 || total >= size {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil, nil
+	}
+
+	proofDb := memorydb.New()
+	if err := tr.Prove(origin[:], 0, proofDb); err != nil {
+		return nil, nil, nil
+	}
+	if len(keys) > 0 {
+		if err := tr.Prove(keys[len(keys)-1][:], 0, proofDb); err != nil {
+			return nil, nil, nil
+		}
+	}
+	var proof [][]byte
+	pit := proofDb.NewIterator(nil, nil)
+	defer pit.Release()
+	for pit.Next() {
+		proof = append(proof, common.CopyBytes(pit.Value()))
+	}
+	return keys, values, proof
+}
+
+// serveByteCodes returns contract codes, unknown ones are empty
+func (h *Handler) serveByteCodes(hashes []common.Hash) [][]byte {
+	var (
+		codes [][]byte
+		size  int
+	)
+	db := h.chain.StateDatabase().Underlying().TrieDB().DiskDB()
+	for _, hash := range hashes {
+		if len(codes) >= maxCodesServe || size >= softResponseLimit {
+			break
+		}
+		code := rawdb.ReadCode(db, hash)
+		codes = append(codes, code)
+		size += len(code)
+	}
+	return codes
+}
+
+// serveTrieNodes returns state trie nodes, unknown ones are empty
+func (h *Handler) serveTrieNodes(hashes []common.Hash) [][]byte {
+	var (
+		nodes [][]byte
+		size  int
+	)
+	db := h.chain.StateDatabase().Underlying().TrieDB()
+	for _, hash := range hashes {
+		if len(nodes) >= maxNodesServe || size >= softResponseLimit {
+			break
+		}
+		node, _ := db.Node(hash)
+		nodes = append(nodes, node)
+		size += len(node)
+	}
+	return nodes
+}
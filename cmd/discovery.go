@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/rovergulf/chain/discovery"
+	"github.com/rovergulf/chain/pkg/sigutils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// discoveryCmd runs the bootnode
+func discoveryCmd() *cobra.Command {
+	var discoveryCmd = &cobra.Command{
+		Use:          "discovery",
+		Short:        "Runs discovery bootnode, which helps peer nodes to find each other",
+		Long:         ``,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			service, err := discovery.New(discovery.NewConfig(viper.GetViper()), logger)
+			if err != nil {
+				return err
+			}
+			if err := service.Start(); err != nil {
+				service.Stop()
+				return err
+			}
+			fmt.Println(service.Self().URLv4())
+
+			done := make(chan os.Signal, 1)
+			sigutils.ListenExit(func(sig os.Signal) {
+				done <- sig
+			})
+			sig := <-done
+			logger.Warnw("Discovery shutdown signal received", "sig", sig.String())
+			service.Stop()
+			return nil
+		},
+		TraverseChildren: true,
+	}
+
+	discoveryCmd.Flags().String("addr", discovery.DefaultConfig.Addr, "UDP listen address")
+	discoveryCmd.Flags().Int("port", discovery.DefaultConfig.Port, "UDP listen port")
+	discoveryCmd.Flags().Bool("v5", true, "Run discv5 on the same port besides discv4")
+	discoveryCmd.Flags().StringSlice("bootnodes", nil, "Comma separated enode URLs of other bootnodes")
+	discoveryCmd.Flags().String("net_restrict", "", "Comma separated CIDR masks of allowed peer IPs")
+	bindViperFlag(discoveryCmd, "discovery.addr", "addr")
+	bindViperFlag(discoveryCmd, "discovery.port", "port")
+	bindViperFlag(discoveryCmd, "discovery.v5", "v5")
+	bindViperFlag(discoveryCmd, "discovery.bootnodes", "bootnodes")
+	bindViperFlag(discoveryCmd, "discovery.net_restrict", "net_restrict")
+
+	return discoveryCmd
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/rovergulf/chain/discovery"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"os"
+	"strings"
+	"time"
+)
+
+// dnsDiscCmd crawls the discovery network and prints the signed EIP-1459 node list
+func dnsDiscCmd() *cobra.Command {
+	var dnsDiscCmd = &cobra.Command{
+		Use:          "dns-disc",
+		Short:        "Crawls the discovery network and prints signed EIP-1459 DNS node list TXT records in zone file format",
+		Long:         ``,
+		SilenceUsage: true,
+		PreRunE:      prepareWalletsManager,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defer accountManager.Shutdown()
+
+			domain, _ := cmd.Flags().GetString("domain")
+			if len(domain) == 0 {
+				return fmt.Errorf("domain is required")
+			}
+			address := viper.GetString("address")
+			if !common.IsHexAddress(address) {
+				return fmt.Errorf("bad address format")
+			}
+
+			var auth string
+			if passwordFile, _ := cmd.Flags().GetString("password"); len(passwordFile) > 0 {
+				data, err := os.ReadFile(passwordFile)
+				if err != nil {
+					return err
+				}
+				auth = strings.TrimRight(string(data), "\r\n")
+			} else {
+				input, err := getPassPhrase("Enter passphrase do decrypt wallet:", false)
+				if err != nil {
+					return err
+				}
+				auth = input
+			}
+			wallet, err := accountManager.GetWallet(common.HexToAddress(address), auth)
+			if err != nil {
+				return err
+			}
+
+			nodes, err := crawlNodes(cmd)
+			if err != nil {
+				return err
+			}
+
+			seq, _ := cmd.Flags().GetUint("seq")
+			if seq == 0 {
+				seq = uint(time.Now().Unix())
+			}
+			links, _ := cmd.Flags().GetStringSlice("links")
+			tree, err := dnsdisc.MakeTree(seq, nodes, links)
+			if err != nil {
+				return err
+			}
+			url, err := tree.Sign(wallet.GetKey().PrivateKey, domain)
+			if err != nil {
+				return err
+			}
+
+			ttl, _ := cmd.Flags().GetUint("ttl")
+			fmt.Printf("; %s, %d nodes, seq %d\n", url, len(nodes), seq)
+			fmt.Print(discovery.ZoneFile(tree, domain, ttl))
+			return nil
+		},
+		TraverseChildren: true,
+	}
+
+	dnsDiscCmd.Flags().String("domain", "", "Domain name the node list is published at")
+	dnsDiscCmd.Flags().String("password", "", "File containing the wallet passphrase, prompted if not set")
+	dnsDiscCmd.Flags().StringSlice("bootnodes", nil, "Comma separated enode URLs crawling starts from")
+	dnsDiscCmd.Flags().Bool("v5", true, "Crawl discv5 besides discv4")
+	dnsDiscCmd.Flags().Duration("timeout", 30*time.Second, "Crawling duration")
+	dnsDiscCmd.Flags().Uint("seq", 0, "Tree sequence number, current unix time if not set")
+	dnsDiscCmd.Flags().StringSlice("links", nil, "Comma separated enrtree:// URLs of linked node lists")
+	dnsDiscCmd.Flags().Uint("ttl", 86400, "TXT records time to live in seconds")
+	addAddressFlag(dnsDiscCmd)
+	bindViperFlag(dnsDiscCmd, "node.bootnodes", "bootnodes")
+
+	return dnsDiscCmd
+}
+
+// crawlNodes returns nodes of the 'network.id' network found by the crawler identified by a temporary key
+func crawlNodes(cmd *cobra.Command) ([]*enode.Node, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	v5, _ := cmd.Flags().GetBool("v5")
+	service, err := discovery.NewWithKey(discovery.Config{
+		V5:        v5,
+		Bootnodes: viper.GetStringSlice("node.bootnodes"),
+	}, key, logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.Start(); err != nil {
+		service.Stop()
+		return nil, err
+	}
+	defer service.Stop()
+
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	networkId := viper.GetUint64("network.id")
+	logger.Infow("Crawling discovery network", "network", networkId, "timeout", timeout)
+	return service.Crawl(ctx, func(n *enode.Node) bool {
+		var entry discovery.Entry
+		return n.Load(&entry) == nil && entry.NetworkID == networkId
+	})
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/core/forkid"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// forksCmd prints the fork schedule of the chain and the fork of the local head
+func forksCmd() *cobra.Command {
+	var forksCmd = &cobra.Command{
+		Use:          "forks",
+		Short:        "Prints the fork schedule and the fork the local head is on",
+		Long:         ``,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, genesis, head, err := loadChainHead(viper.GetViper())
+			if err != nil {
+				return err
+			}
+
+			id := forkid.NewID(config, genesis, head.Number.Uint64(), head.Time)
+			forks := config.Forks()
+			schedule := make([]string, 0, len(forks))
+			for _, f := range forks {
+				schedule = append(schedule, f.String())
+			}
+			return writeOutput(cmd, map[string]interface{}{
+				"genesis":   genesis.Hash(),
+				"head":      head.Number,
+				"head_time": head.Time,
+				"fork":      config.LatestFork(head.Number, head.Time).Name,
+				"fork_id": map[string]interface{}{
+					"hash": hexutil.Encode(id.Hash[:]),
+					"next": id.Next,
+				},
+				"forks": schedule,
+			})
+		},
+		TraverseChildren: true,
+	}
+
+	forksCmd.Flags().String("genesis", "", "Genesis json file path")
+	bindViperFlag(forksCmd, "network.genesis", "genesis")
+	addOutputFormatFlag(forksCmd)
+
+	return forksCmd
+}
+
+// loadChainHead returns the chain config, genesis and head stored in the local database,
+// or the configured genesis if the chain is not initialized yet
+func loadChainHead(cfg *viper.Viper) (*params.ChainConfig, *types.Block, *types.Header, error) {
+	genesis := core.DefaultGenesisBlock(cfg.GetUint64("network.id"))
+	if genesisPath := cfg.GetString("network.genesis"); len(genesisPath) > 0 {
+		g, err := core.ReadGenesis(genesisPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		genesis = g
+	}
+
+	store, err := storage.Open(context.Background(), cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer store.Close()
+
+	kv, err := storage.KeyValueStore(store)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("chain storage: %w", err)
+	}
+	db := rawdb.NewDatabase(kv)
+
+	if stored := rawdb.ReadCanonicalHash(db, 0); stored != (common.Hash{}) {
+		block := rawdb.ReadBlock(db, stored, 0)
+		config, err := core.ReadChainConfig(db, stored)
+		if block != nil && err == nil {
+			// genesis passed explicitly upgrades the stored config, as it does on node start
+			if len(cfg.GetString("network.genesis")) > 0 {
+				config = genesis.Config
+			}
+			head := rawdb.ReadHeadHeader(db)
+			if head == nil {
+				head = block.Header()
+			}
+			return config, block, head, nil
+		}
+	}
+
+	block, err := genesis.ToBlock(state.NewDatabase(rawdb.NewMemoryDatabase()))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return genesis.Config, block, block.Header(), nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+	"github.com/rovergulf/chain/node"
+	"github.com/rovergulf/chain/pkg/sigutils"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// nodeCmd represents the node command
+func nodeCmd() *cobra.Command {
+	var nodeCmd = &cobra.Command{
+		Use:          "node",
+		Short:        "Runs chain peer node",
+		Long:         ``,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := node.New()
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			sigutils.ListenExit(func(sig os.Signal) {
+				n.GracefulShutdown(ctx, sig.String())
+			})
+
+			return n.Run(ctx)
+		},
+		TraverseChildren: true,
+	}
+
+	nodeCmd.Flags().String("genesis", "", "Genesis json file path")
+	nodeCmd.Flags().Bool("mine", false, "Enable block production")
+	nodeCmd.Flags().String("etherbase", "", "Block rewards and fees receiver address")
+	nodeCmd.Flags().String("unlock", "", "Wallet address to unlock and seal blocks with")
+	nodeCmd.Flags().String("password", "", "File containing the unlocked wallet passphrase")
+	bindViperFlag(nodeCmd, "network.genesis", "genesis")
+	bindViperFlag(nodeCmd, "miner.enabled", "mine")
+	bindViperFlag(nodeCmd, "miner.etherbase", "etherbase")
+	bindViperFlag(nodeCmd, "node.account", "unlock")
+	bindViperFlag(nodeCmd, "node.password_file", "password")
+
+	return nodeCmd
+}
@@ -67,6 +67,10 @@ func init() {
 	bindViperPersistentFlag(rootCmd, "log_stacktrace", "log_stacktrace")
 	bindViperPersistentFlag(rootCmd, "data_dir", "data_dir")
 
+	rootCmd.AddCommand(nodeCmd())
+	rootCmd.AddCommand(discoveryCmd())
+	rootCmd.AddCommand(dnsDiscCmd())
+	rootCmd.AddCommand(forksCmd())
 	rootCmd.AddCommand(walletsCmd())
 }
 
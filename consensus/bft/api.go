@@ -0,0 +1,45 @@
+package bft
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/consensus"
+)
+
+// API exposes validators and their set changes under the 'bft' namespace
+type API struct {
+	chain  consensus.ChainHeaderReader
+	engine *Engine
+}
+
+// GetValidators returns validators of the block following the given one, latest if number is not set
+func (api *API) GetValidators(number *rpc.BlockNumber) ([]common.Address, error) {
+	header := api.chain.CurrentHeader()
+	if number != nil && *number >= 0 {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	return extra.Validators, nil
+}
+
+// Proposals returns validator set changes proposed by this node
+func (api *API) Proposals() map[common.Address]bool {
+	return api.engine.Proposals()
+}
+
+// Propose adds a validator set change, applied at the next epoch boundary proposed by this node
+func (api *API) Propose(address common.Address, add bool) {
+	api.engine.Propose(address, add)
+}
+
+// Discard drops the proposal
+func (api *API) Discard(address common.Address) {
+	api.engine.Discard(address)
+}
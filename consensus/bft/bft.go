@@ -0,0 +1,692 @@
+package bft
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/staking"
+	"github.com/rovergulf/chain/state"
+	"go.uber.org/zap"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultEpoch is the number of blocks after which validator set can be changed, used if not set in config
+	DefaultEpoch uint64 = 30000
+	// DefaultTimeout is the base round step timeout in milliseconds, used if not set in config
+	DefaultTimeout uint64 = 2000
+)
+
+var (
+	ErrUnknownBlock         = errors.New("unknown block")
+	ErrInvalidSeal          = errors.New("invalid proposer seal")
+	ErrInvalidProposer      = errors.New("block is not sealed by the round proposer")
+	ErrInvalidCommit        = errors.New("invalid commit seals")
+	ErrInsufficientCommit   = errors.New("not enough commit seals")
+	ErrInvalidValidators    = errors.New("invalid validator set change")
+	ErrInvalidDifficulty    = errors.New("invalid difficulty")
+	ErrInvalidNonce         = errors.New("non-zero nonce")
+	ErrInvalidMixDigest     = errors.New("non-zero mix digest")
+	ErrInvalidUncleHash     = errors.New("non empty uncle hash")
+	ErrInvalidTimestamp     = errors.New("invalid timestamp")
+	ErrInvalidMessage       = consensus.ErrInvalidMessage
+	ErrUnauthorizedProposer = errors.New("node account is not a validator")
+	ErrEngineStopped        = errors.New("bft engine is not running")
+	ErrInvalidEvidence      = errors.New("invalid double signing evidence")
+	ErrStateUnavailable     = errors.New("chain does not provide state for validator election")
+)
+
+// Chain is the blockchain validators commit blocks to
+type Chain interface {
+	consensus.ChainHeaderReader
+	CurrentBlock() *types.Block
+	ValidateBlock(block *types.Block) error
+	InsertChain(blocks types.Blocks) (int, error)
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// Broadcaster delivers consensus messages to the other validators
+type Broadcaster interface {
+	BroadcastConsensus(payload []byte)
+}
+
+// Engine is the byzantine fault tolerant engine. Fixed set of validators runs propose, prevote and precommit
+// rounds for each block, which is committed once more than two thirds of validators precommit it.
+// Committed blocks are final, validator set may only change at epoch boundaries
+type Engine struct {
+	config *params.BFTConfig
+	logger *zap.SugaredLogger
+
+	lock        sync.RWMutex // protects key, broadcaster, proposals and evidence
+	key         *keystore.Key
+	broadcaster Broadcaster
+	proposals   map[common.Address]bool
+	evidence    map[common.Hash]*Evidence // double signing evidence by offence, to be included into proposals
+
+	machine *machine
+}
+
+// New returns byzantine fault tolerant engine, it verifies headers but does not take part
+// in consensus until started
+func New(config *params.BFTConfig, logger *zap.SugaredLogger) *Engine {
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = DefaultEpoch
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = DefaultTimeout
+	}
+	if conf.Period == 0 {
+		// block timestamps must increase
+		conf.Period = 1
+	}
+
+	return &Engine{
+		config:    &conf,
+		logger:    logger,
+		proposals: make(map[common.Address]bool),
+		evidence:  make(map[common.Hash]*Evidence),
+	}
+}
+
+// Authorize sets the validator key used to sign proposals and votes
+func (e *Engine) Authorize(key *keystore.Key) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.key = key
+}
+
+// SetBroadcaster sets the transport consensus messages are sent with
+func (e *Engine) SetBroadcaster(b Broadcaster) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.broadcaster = b
+}
+
+// Start begins consensus rounds on top of the chain head
+func (e *Engine) Start(chain Chain) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.machine != nil {
+		return nil
+	}
+	if e.key == nil {
+		return ErrUnauthorizedProposer
+	}
+	e.machine = newMachine(e, chain)
+	return nil
+}
+
+// HandleMessage passes consensus message received from the network to the running engine
+func (e *Engine) HandleMessage(payload []byte) error {
+	e.lock.RLock()
+	c := e.machine
+	e.lock.RUnlock()
+	if c == nil {
+		return ErrEngineStopped
+	}
+
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		return err
+	}
+	c.post(msg)
+	return nil
+}
+
+func (e *Engine) broadcast(payload []byte) {
+	e.lock.RLock()
+	b := e.broadcaster
+	e.lock.RUnlock()
+	if b != nil {
+		b.BroadcastConsensus(payload)
+	}
+}
+
+func (e *Engine) Propose(address common.Address, add bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.proposals[address] = add
+}
+
+func (e *Engine) Discard(address common.Address) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	delete(e.proposals, address)
+}
+
+func (e *Engine) Proposals() map[common.Address]bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	proposals := make(map[common.Address]bool, len(e.proposals))
+	for address, add := range e.proposals {
+		proposals[address] = add
+	}
+	return proposals
+}
+
+// reportEvidence keeps double signing evidence until it is included into the chain
+func (e *Engine) reportEvidence(ev *Evidence) {
+	o, err := ev.verify()
+	if err != nil {
+		e.logger.Warnw("Invalid double signing evidence", "err", err)
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if _, ok := e.evidence[o.hash()]; !ok {
+		e.logger.Warnw("Validator signed conflicting votes", "validator", o.Offender, "height", o.Height, "round", o.Round)
+		e.evidence[o.hash()] = ev
+	}
+}
+
+// pendingEvidence returns evidence of offences, which are not punished at the state yet and are not expired at the block
+func (e *Engine) pendingEvidence(registry *staking.Registry, number, unbondingPeriod uint64) []Evidence {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	hashes := make([]common.Hash, 0, len(e.evidence))
+	for hash, ev := range e.evidence {
+		if registry.IsSlashed(hash) {
+			delete(e.evidence, hash)
+			continue
+		}
+		if o, err := ev.verify(); err != nil || staking.EvidenceExpired(o.Height, number, unbondingPeriod) {
+			delete(e.evidence, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	evidence := make([]Evidence, len(hashes))
+	for i, hash := range hashes {
+		evidence[i] = *e.evidence[hash]
+	}
+	return evidence
+}
+
+// ProposalHash returns hash of the header without commit seals, committed blocks are irreversible
+func (e *Engine) ProposalHash(header *types.Header) common.Hash {
+	return ProposalHash(header)
+}
+
+// Author returns the fees receiver chosen by the proposer, the proposer itself is verified by its seal
+func (e *Engine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader checks the block proposal and its commit by the quorum of validators
+func (e *Engine) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header) error {
+	if err := e.verifyProposal(chain, header); err != nil {
+		return err
+	}
+	if header.Number.Uint64() == 0 {
+		return nil
+	}
+	return e.verifyCommit(chain, header)
+}
+
+// verifyProposal checks the header is proposed by the round proposer on top of its parent, ignoring commit seals
+func (e *Engine) verifyProposal(chain consensus.ChainHeaderReader, header *types.Header) error {
+	if header.Number == nil {
+		return ErrUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	if header.Nonce != (types.BlockNonce{}) {
+		return ErrInvalidNonce
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return ErrInvalidMixDigest
+	}
+	if header.UncleHash != types.EmptyUncleHash {
+		return ErrInvalidUncleHash
+	}
+	if number == 0 {
+		if len(extra.Validators) == 0 {
+			return ErrInvalidValidators
+		}
+		return nil
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(common.Big1) != 0 {
+		return ErrInvalidDifficulty
+	}
+
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+e.config.Period > header.Time {
+		return ErrInvalidTimestamp
+	}
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	validators := parentExtra.Validators
+
+	switch {
+	case chain.Config().Staking != nil && number%e.config.Epoch == 0:
+		// validator set is elected by stake at epoch boundaries
+		elected, err := e.elect(chain, parent, validators)
+		if err != nil {
+			return err
+		}
+		if !equalValidators(elected, extra.Validators) {
+			return ErrInvalidValidators
+		}
+	default:
+		// validator set is carried over, except epoch boundaries, where one validator may be added or removed
+		changed, valid := validatorsChange(validators, extra.Validators)
+		if !valid || (changed && number%e.config.Epoch != 0) {
+			return ErrInvalidValidators
+		}
+	}
+
+	proposer, err := recoverProposer(header)
+	if err != nil {
+		return err
+	}
+	if proposer != proposerOf(validators, number, extra.Round) {
+		return ErrInvalidProposer
+	}
+
+	return e.verifyEvidence(chain, header, extra.Evidence)
+}
+
+// verifyEvidence checks included evidence proves double signing of validators at the past heights
+func (e *Engine) verifyEvidence(chain consensus.ChainHeaderReader, header *types.Header, evidence []Evidence) error {
+	if len(evidence) == 0 {
+		return nil
+	}
+	if chain.Config().Staking == nil {
+		return fmt.Errorf("%w: staking is disabled", ErrInvalidEvidence)
+	}
+
+	seen := make(map[common.Hash]struct{}, len(evidence))
+	for i := range evidence {
+		o, err := evidence[i].verify()
+		if err != nil {
+			return err
+		}
+		if o.Height == 0 || o.Height >= header.Number.Uint64() {
+			return fmt.Errorf("%w: offence at future height %d", ErrInvalidEvidence, o.Height)
+		}
+		if staking.EvidenceExpired(o.Height, header.Number.Uint64(), chain.Config().Staking.UnbondingPeriod) {
+			return fmt.Errorf("%w: offence at height %d is expired", ErrInvalidEvidence, o.Height)
+		}
+		if _, ok := seen[o.hash()]; ok {
+			return fmt.Errorf("%w: duplicate offence", ErrInvalidEvidence)
+		}
+		seen[o.hash()] = struct{}{}
+
+		parent := chain.GetHeaderByNumber(o.Height - 1)
+		if parent == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		extra, err := ExtractExtra(parent)
+		if err != nil {
+			return err
+		}
+		if !contains(extra.Validators, o.Offender) {
+			return fmt.Errorf("%w: %s is not a validator at height %d", ErrInvalidEvidence, o.Offender, o.Height)
+		}
+	}
+	return nil
+}
+
+// elect returns validators with the highest stake at the parent state, validator set is kept if nobody is elected
+func (e *Engine) elect(chain consensus.ChainHeaderReader, parent *types.Header, validators []common.Address) ([]common.Address, error) {
+	registry, err := e.registryAt(chain, parent)
+	if err != nil {
+		return nil, err
+	}
+	config := chain.Config().Staking
+	if elected := registry.Elect(config.MinValidatorStake, config.MaxValidators); len(elected) > 0 {
+		return elected, nil
+	}
+	return validators, nil
+}
+
+// registryAt returns staking registry at the state of the header
+func (e *Engine) registryAt(chain consensus.ChainHeaderReader, header *types.Header) (*staking.Registry, error) {
+	reader, ok := chain.(staking.StateReader)
+	if !ok {
+		return nil, ErrStateUnavailable
+	}
+	statedb, err := reader.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	return staking.Load(statedb)
+}
+
+// verifyCommit checks the block is committed by the quorum of its validators
+func (e *Engine) verifyCommit(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	commit := extra.Commit
+	if commit.Round < extra.Round {
+		return fmt.Errorf("%w: round %d precedes proposal round %d", ErrInvalidCommit, commit.Round, extra.Round)
+	}
+
+	hash := ProposalHash(header)
+	signers := make(map[common.Address]struct{})
+	for _, seal := range commit.Seals {
+		signer, err := recoverCommitSeal(number, commit.Round, hash, seal)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCommit, err)
+		}
+		if !contains(parentExtra.Validators, signer) {
+			return fmt.Errorf("%w: %s is not a validator", ErrInvalidCommit, signer)
+		}
+		if _, ok := signers[signer]; ok {
+			return fmt.Errorf("%w: duplicate seal of %s", ErrInvalidCommit, signer)
+		}
+		signers[signer] = struct{}{}
+	}
+	if len(signers) < quorum(len(parentExtra.Validators)) {
+		return ErrInsufficientCommit
+	}
+	return nil
+}
+
+// Prepare sets the validator set of the next block, applying local proposals or stake election at epoch boundaries,
+// and includes known double signing evidence if staking is enabled
+func (e *Engine) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+
+	validators := append([]common.Address{}, parentExtra.Validators...)
+	var evidence []Evidence
+	if config := chain.Config().Staking; config != nil {
+		registry, err := e.registryAt(chain, parent)
+		if err != nil {
+			return err
+		}
+		if number%e.config.Epoch == 0 {
+			if elected := registry.Elect(config.MinValidatorStake, config.MaxValidators); len(elected) > 0 {
+				validators = elected
+			}
+		}
+		evidence = e.pendingEvidence(registry, number, config.UnbondingPeriod)
+	} else if number%e.config.Epoch == 0 {
+		validators = e.applyProposal(validators)
+	}
+
+	header.Difficulty = big.NewInt(1)
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+	header.Time = parent.Time + e.config.Period
+	if now := uint64(time.Now().Unix()); header.Time < now {
+		header.Time = now
+	}
+
+	return writeExtra(header, &Extra{Validators: validators, Evidence: evidence})
+}
+
+// applyProposal applies one of the proposals, which change the validator set
+func (e *Engine) applyProposal(validators []common.Address) []common.Address {
+	proposals := e.Proposals()
+	addresses := make([]common.Address, 0, len(proposals))
+	for address := range proposals {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i][:], addresses[j][:]) < 0
+	})
+
+	for _, address := range addresses {
+		switch add := proposals[address]; {
+		case add && !contains(validators, address):
+			return append(validators, address)
+		case !add && contains(validators, address) && len(validators) > 1:
+			next := make([]common.Address, 0, len(validators)-1)
+			for _, v := range validators {
+				if v != address {
+					next = append(next, v)
+				}
+			}
+			return next
+		}
+	}
+	return validators
+}
+
+// Finalize applies staking rewards, slashing and unbonding if staking is enabled, issues the block reward, sets the state root and assembles the block
+func (e *Engine) Finalize(chain consensus.ChainHeaderReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	if config := chain.Config().Staking; config != nil {
+		if err := e.finalizeStaking(chain, config, header, statedb); err != nil {
+			return nil, err
+		}
+	}
+	if config := chain.Config().Rewards; config != nil {
+		rewards.Accumulate(config, statedb, header, header.Coinbase)
+	}
+	header.Root = statedb.IntermediateRoot(chain.Config().IsEIP158(header.Number, header.Time))
+	header.UncleHash = types.EmptyUncleHash
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+func (e *Engine) finalizeStaking(chain consensus.ChainHeaderReader, config *params.StakingConfig, header *types.Header, statedb *state.StateDB) error {
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+
+	registry, err := staking.Load(statedb)
+	if err != nil {
+		return err
+	}
+	stake := new(big.Int).Set(statedb.GetBalance(staking.Address))
+	for i := range extra.Evidence {
+		o, err := extra.Evidence[i].verify()
+		if err != nil {
+			return err
+		}
+		if registry.Slash(statedb, o.hash(), o.Height, o.Offender, config.SlashPercent, number+config.JailPeriod) {
+			e.logger.Warnw("Validator slashed for double signing", "validator", o.Offender, "height", o.Height, "number", number)
+		}
+	}
+	// delegators rewards are kept in the system account, so burned stake is counted before they are issued
+	burned := new(big.Int).Sub(stake, statedb.GetBalance(staking.Address))
+	issued := registry.Reward(statedb, parentExtra.Validators, config.BlockReward)
+	if chain.Config().Rewards != nil {
+		rewards.AddBurned(statedb, burned)
+		rewards.AddIssued(statedb, issued)
+	}
+	registry.Release(statedb, number)
+	registry.PruneSlashed(number, config.UnbondingPeriod)
+	return registry.Store(statedb)
+}
+
+// Seal passes the block to the consensus rounds as the local proposal candidate.
+// Committed block is pushed to results if it is the proposal of this node
+func (e *Engine) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	if block.NumberU64() == 0 {
+		return ErrUnknownBlock
+	}
+
+	e.lock.RLock()
+	c, key := e.machine, e.key
+	e.lock.RUnlock()
+	if c == nil {
+		return ErrEngineStopped
+	}
+
+	parent := chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	extra, err := ExtractExtra(parent)
+	if err != nil {
+		return err
+	}
+	if !contains(extra.Validators, key.Address) {
+		return ErrUnauthorizedProposer
+	}
+
+	go func() {
+		// proposal is not sent before the block time
+		select {
+		case <-time.After(time.Until(time.Unix(int64(block.Time()), 0))):
+		case <-stop:
+			return
+		}
+		c.postCandidate(&sealRequest{block: block, results: results, stop: stop})
+	}()
+	return nil
+}
+
+func (e *Engine) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "bft",
+			Service:   &API{chain: chain, engine: e},
+		},
+	}
+}
+
+// Close stops consensus rounds
+func (e *Engine) Close() error {
+	e.lock.Lock()
+	c := e.machine
+	e.machine = nil
+	e.lock.Unlock()
+
+	if c != nil {
+		c.stop()
+	}
+	return nil
+}
+
+// sealProposal sets the round and proposer signature to the candidate block
+func (e *Engine) sealProposal(block *types.Block, round uint64) (*types.Block, error) {
+	e.lock.RLock()
+	key := e.key
+	e.lock.RUnlock()
+
+	header := block.Header()
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	extra.Round = round
+	extra.Seal = nil
+	extra.Commit = Commit{}
+	if err := writeExtra(header, extra); err != nil {
+		return nil, err
+	}
+
+	if extra.Seal, err = crypto.Sign(SealHash(header).Bytes(), key.PrivateKey); err != nil {
+		return nil, err
+	}
+	if err := writeExtra(header, extra); err != nil {
+		return nil, err
+	}
+	return block.WithSeal(header), nil
+}
+
+// commitBlock sets commit seals of the quorum to the proposed block
+func commitBlock(block *types.Block, commit Commit) (*types.Block, error) {
+	header := block.Header()
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	extra.Commit = commit
+	if err := writeExtra(header, extra); err != nil {
+		return nil, err
+	}
+	return block.WithSeal(header), nil
+}
+
+// quorum returns number of votes required to commit the block, more than two thirds of validators
+func quorum(validators int) int {
+	return validators - (validators-1)/3
+}
+
+// proposerOf returns validator proposing the block in the round, proposers are rotated every height and round
+func proposerOf(validators []common.Address, number, round uint64) common.Address {
+	if len(validators) == 0 {
+		return common.Address{}
+	}
+	return validators[(number+round)%uint64(len(validators))]
+}
+
+func equalValidators(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(set []common.Address, addr common.Address) bool {
+	for _, a := range set {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
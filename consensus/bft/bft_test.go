@@ -0,0 +1,244 @@
+package bft
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/miner"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"github.com/rovergulf/chain/txpool"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testNetwork delivers consensus messages between validators of the test
+type testNetwork struct {
+	mu      sync.RWMutex
+	engines map[common.Address]*Engine
+}
+
+type testBroadcaster struct {
+	network *testNetwork
+	self    common.Address
+}
+
+func (b *testBroadcaster) BroadcastConsensus(payload []byte) {
+	b.network.mu.RLock()
+	defer b.network.mu.RUnlock()
+
+	for addr, engine := range b.network.engines {
+		if addr != b.self {
+			go engine.HandleMessage(payload)
+		}
+	}
+}
+
+type testValidator struct {
+	engine *Engine
+	chain  *core.BlockChain
+}
+
+func newTestValidator(t *testing.T, network *testNetwork, genesis *core.Genesis, hexKey string) *testValidator {
+	privateKey, err := crypto.HexToECDSA(hexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &keystore.Key{Address: crypto.PubkeyToAddress(privateKey.PublicKey), PrivateKey: privateKey}
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	store := badgerdb.NewDriver(db, logger)
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	engine := New(genesis.Config.BFT, logger)
+	engine.Authorize(key)
+	engine.SetBroadcaster(&testBroadcaster{network: network, self: key.Address})
+
+	bc, err := core.NewBlockChain(store, genesis, engine, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bc.Stop)
+
+	pool, err := txpool.NewTxPool(txpool.DefaultConfig, bc.Config(), bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Stop)
+
+	network.mu.Lock()
+	network.engines[key.Address] = engine
+	network.mu.Unlock()
+
+	if err := engine.Start(bc); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		engine.Close()
+	})
+
+	config := miner.DefaultConfig
+	config.Etherbase = key.Address
+	config.Interval = 100 * time.Millisecond
+	m := miner.New(config, bc, pool, engine, logger)
+	t.Cleanup(m.Close)
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testValidator{engine: engine, chain: bc}
+}
+
+func newTestGenesis(t *testing.T, validators []common.Address) *core.Genesis {
+	extra, err := GenesisExtra(validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := *params.DevChainConfig
+	config.BFT = &params.BFTConfig{Period: 1, Epoch: 30000, Timeout: 500}
+	return &core.Genesis{
+		Config:     &config,
+		ExtraData:  extra,
+		GasLimit:   core.DefaultGenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Alloc: core.GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+}
+
+func waitForHeight(t *testing.T, validators []*testValidator, number uint64) {
+	deadline := time.Now().Add(20 * time.Second)
+	for _, v := range validators {
+		for v.chain.CurrentBlock().NumberU64() < number {
+			if time.Now().After(deadline) {
+				t.Fatalf("validator %s is stuck at block %d", v.engine.key.Address, v.chain.CurrentBlock().NumberU64())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestBFTCommit(t *testing.T) {
+	keys := []string{tests.PrivateKey0, tests.PrivateKey1, tests.PrivateKey2, tests.PrivateKey3}
+	addresses := []common.Address{tests.Account0, tests.Account1, tests.Account2, tests.Account3}
+	genesis := newTestGenesis(t, addresses)
+	network := &testNetwork{engines: make(map[common.Address]*Engine)}
+
+	// one of four validators is offline, remaining three still make the quorum
+	validators := make([]*testValidator, 3)
+	for i := range validators {
+		validators[i] = newTestValidator(t, network, genesis, keys[i])
+	}
+	waitForHeight(t, validators, 3)
+
+	for number := uint64(1); number <= 3; number++ {
+		header := validators[0].chain.GetHeaderByNumber(number)
+		for _, v := range validators[1:] {
+			if ProposalHash(v.chain.GetHeaderByNumber(number)) != ProposalHash(header) {
+				t.Fatalf("validators committed different blocks #%d", number)
+			}
+		}
+
+		extra, err := ExtractExtra(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proposer, err := recoverProposer(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proposer != proposerOf(addresses, number, extra.Round) {
+			t.Fatalf("block #%d is not sealed by the round proposer", number)
+		}
+		if len(extra.Commit.Seals) < quorum(len(addresses)) {
+			t.Fatalf("block #%d has %d commit seals", number, len(extra.Commit.Seals))
+		}
+	}
+
+	// commit seals can not be forged
+	header := types.CopyHeader(validators[0].chain.GetHeaderByNumber(3))
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra.Commit.Seals = extra.Commit.Seals[:1]
+	if err := writeExtra(header, extra); err != nil {
+		t.Fatal(err)
+	}
+	if err := validators[0].engine.VerifyHeader(validators[0].chain, header); !errors.Is(err, ErrInsufficientCommit) {
+		t.Fatalf("unexpected insufficient commit error: %v", err)
+	}
+}
+
+func TestValidatorsChange(t *testing.T) {
+	current := []common.Address{tests.Account0, tests.Account1, tests.Account2}
+	for i, tt := range []struct {
+		next           []common.Address
+		changed, valid bool
+	}{
+		{current, false, true},
+		{[]common.Address{tests.Account0, tests.Account1, tests.Account2, tests.Account3}, true, true},
+		{[]common.Address{tests.Account0, tests.Account2}, true, true},
+		{[]common.Address{tests.Account0, tests.Account3}, true, false},
+		{[]common.Address{tests.Account1, tests.Account0, tests.Account2}, true, false},
+		{[]common.Address{tests.Account0, tests.Account0, tests.Account1, tests.Account2}, false, false},
+		{nil, false, false},
+	} {
+		if changed, valid := validatorsChange(current, tt.next); changed != tt.changed || valid != tt.valid {
+			t.Errorf("case %d: unexpected result %v %v", i, changed, valid)
+		}
+	}
+}
+
+func TestEvidence(t *testing.T) {
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote := func(code uint64, round uint64, digest common.Hash) *message {
+		msg := &message{Code: code, Height: 5, Round: round, Digest: digest}
+		if err := msg.sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	for i, tt := range []struct {
+		first, second *message
+		valid         bool
+	}{
+		{vote(msgPrevote, 0, common.HexToHash("0x01")), vote(msgPrevote, 0, common.HexToHash("0x02")), true},
+		{vote(msgPrecommit, 1, common.HexToHash("0x01")), vote(msgPrecommit, 1, common.Hash{}), true},
+		{vote(msgPrevote, 0, common.HexToHash("0x01")), vote(msgPrevote, 0, common.HexToHash("0x01")), false},
+		{vote(msgPrevote, 0, common.HexToHash("0x01")), vote(msgPrecommit, 0, common.HexToHash("0x02")), false},
+		{vote(msgPrevote, 0, common.HexToHash("0x01")), vote(msgPrevote, 1, common.HexToHash("0x02")), false},
+	} {
+		ev, err := newEvidence(tt.first, tt.second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := ev.verify()
+		if tt.valid && (err != nil || o.Offender != tests.Account0 || o.Height != 5) {
+			t.Errorf("case %d: unexpected offence %v, %v", i, o, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidEvidence) {
+			t.Errorf("case %d: unexpected invalid evidence error: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package bft
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Evidence is a pair of conflicting votes, signed by the same validator at the same height and round.
+// Evidence is included into headers by proposers and slashes the offender, if staking is enabled
+type Evidence struct {
+	First  []byte // rlp encoded vote
+	Second []byte // rlp encoded vote
+}
+
+// newEvidence returns evidence of the conflicting votes
+func newEvidence(first, second *message) (*Evidence, error) {
+	a, err := rlp.EncodeToBytes(first)
+	if err != nil {
+		return nil, err
+	}
+	b, err := rlp.EncodeToBytes(second)
+	if err != nil {
+		return nil, err
+	}
+	return &Evidence{First: a, Second: b}, nil
+}
+
+// offence is the double signing fault proven by the evidence
+type offence struct {
+	Offender common.Address
+	Code     uint64
+	Height   uint64
+	Round    uint64
+}
+
+// hash identifies the offence, so it is punished once whatever votes prove it
+func (o *offence) hash() common.Hash {
+	data, _ := rlp.EncodeToBytes(o)
+	return crypto.Keccak256Hash(data)
+}
+
+// verify checks the votes conflict and returns the offence they prove
+func (ev *Evidence) verify() (*offence, error) {
+	first, err := decodeMessage(ev.First)
+	if err != nil {
+		return nil, err
+	}
+	second, err := decodeMessage(ev.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case first.Code != msgPrevote && first.Code != msgPrecommit:
+		return nil, fmt.Errorf("%w: %s is not a vote", ErrInvalidEvidence, first)
+	case first.sender != second.sender:
+		return nil, fmt.Errorf("%w: votes of different validators", ErrInvalidEvidence)
+	case first.Code != second.Code || first.Height != second.Height || first.Round != second.Round:
+		return nil, fmt.Errorf("%w: votes of different steps", ErrInvalidEvidence)
+	case first.Digest == second.Digest:
+		return nil, fmt.Errorf("%w: votes do not conflict", ErrInvalidEvidence)
+	}
+
+	return &offence{
+		Offender: first.sender,
+		Code:     first.Code,
+		Height:   first.Height,
+		Round:    first.Round,
+	}, nil
+}
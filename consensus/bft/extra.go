@@ -0,0 +1,149 @@
+package bft
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// extraVanity is the number of extra-data prefix bytes reserved for proposer vanity
+const extraVanity = 32
+
+var (
+	ErrMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+	ErrInvalidExtra  = errors.New("invalid extra-data")
+)
+
+// Commit is the set of precommit signatures, which made the block final
+type Commit struct {
+	Round uint64   `json:"round" yaml:"round"`
+	Seals [][]byte `json:"seals" yaml:"seals"`
+}
+
+// Extra is the engine data, encoded in the header extra-data after the vanity prefix.
+// Commit seals are collected after the block is proposed, so they are excluded
+// from the proposal hash validators vote for
+type Extra struct {
+	Validators []common.Address // validators of the next block
+	Round      uint64           // round the block was proposed in
+	Seal       []byte           // proposer signature
+	Commit     Commit           // commit seals of the block
+	Evidence   []Evidence       `rlp:"optional"` // double signing evidence, slashing offenders
+}
+
+// GenesisExtra returns genesis extra-data with the initial validator set
+func GenesisExtra(validators []common.Address) ([]byte, error) {
+	header := &types.Header{}
+	if err := writeExtra(header, &Extra{Validators: validators}); err != nil {
+		return nil, err
+	}
+	return header.Extra, nil
+}
+
+// ExtractExtra decodes the engine data of the header
+func ExtractExtra(header *types.Header) (*Extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, ErrMissingVanity
+	}
+	extra := new(Extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, ErrInvalidExtra
+	}
+	return extra, nil
+}
+
+// writeExtra replaces the engine data of the header, keeping the vanity prefix
+func writeExtra(header *types.Header, extra *Extra) error {
+	data, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+
+	vanity := make([]byte, extraVanity)
+	copy(vanity, header.Extra)
+	header.Extra = append(vanity, data...)
+	return nil
+}
+
+// SealHash returns hash of the header without the proposer and commit seals, which is signed by the proposer
+func SealHash(header *types.Header) common.Hash {
+	return filteredHash(header, true)
+}
+
+// ProposalHash returns hash of the header without commit seals, which validators vote for.
+// It is the block hash until the block is committed
+func ProposalHash(header *types.Header) common.Hash {
+	return filteredHash(header, false)
+}
+
+// filteredHash returns hash of the header with commit seals and optionally the proposer seal removed
+func filteredHash(header *types.Header, withoutSeal bool) (hash common.Hash) {
+	cpy := types.CopyHeader(header)
+	if extra, err := ExtractExtra(cpy); err == nil {
+		if withoutSeal {
+			extra.Seal = nil
+		}
+		extra.Commit = Commit{}
+		_ = writeExtra(cpy, extra)
+	}
+
+	hasher := sha3.NewLegacyKeccak256()
+	_ = rlp.Encode(hasher, cpy)
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// recoverProposer extracts the proposer address from the sealed header
+func recoverProposer(header *types.Header) (common.Address, error) {
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(extra.Seal) != crypto.SignatureLength {
+		return common.Address{}, ErrInvalidSeal
+	}
+	pubkey, err := crypto.SigToPub(SealHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// validatorsChange returns whether the next set differs from the current one by at most one validator
+func validatorsChange(current, next []common.Address) (changed bool, valid bool) {
+	if len(next) == 0 {
+		return false, false
+	}
+	for i, addr := range next {
+		for _, other := range next[i+1:] {
+			if addr == other {
+				return false, false
+			}
+		}
+	}
+
+	var added, removed int
+	for _, addr := range next {
+		if !contains(current, addr) {
+			added++
+		}
+	}
+	for _, addr := range current {
+		if !contains(next, addr) {
+			removed++
+		}
+	}
+	if added+removed == 0 {
+		// order must be kept, as it determines proposers
+		for i := range current {
+			if current[i] != next[i] {
+				return true, false
+			}
+		}
+		return false, true
+	}
+	return true, added+removed == 1
+}
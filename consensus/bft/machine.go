@@ -0,0 +1,563 @@
+package bft
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/core"
+	"time"
+)
+
+type step uint8
+
+const (
+	stepPropose step = iota
+	stepPrevote
+	stepPrecommit
+	stepCommitted // waiting for the committed block to become the chain head
+)
+
+// maxRoundTimeoutShift limits exponential growth of round step timeouts
+const maxRoundTimeoutShift = 6
+
+// sealRequest is the local proposal candidate passed by the block producer
+type sealRequest struct {
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{}
+}
+
+type timeoutEvent struct {
+	height uint64
+	round  uint64
+	step   step
+}
+
+// roundVotes are the proposal and votes received in the round, keyed by the sender
+type roundVotes struct {
+	proposal   *message
+	prevotes   map[common.Address]*message
+	precommits map[common.Address]*message
+}
+
+func newRoundVotes() *roundVotes {
+	return &roundVotes{
+		prevotes:   make(map[common.Address]*message),
+		precommits: make(map[common.Address]*message),
+	}
+}
+
+// count returns number of votes for the digest
+func count(votes map[common.Address]*message, digest common.Hash) int {
+	var n int
+	for _, vote := range votes {
+		if vote.Digest == digest {
+			n++
+		}
+	}
+	return n
+}
+
+// machine runs consensus rounds of the current height, following the Tendermint algorithm:
+// the round proposer broadcasts the block, validators prevote it, precommit it once the quorum prevoted
+// and commit it once the quorum precommitted. Steps without progress time out, moving to the next round
+type machine struct {
+	engine *Engine
+	chain  Chain
+
+	height     uint64
+	round      uint64
+	step       step
+	validators []common.Address
+	parent     *types.Header
+
+	lockedRound int64 // -1 if not locked
+	lockedBlock *types.Block
+	validRound  int64 // -1 if not set
+	validBlock  *types.Block
+
+	rounds            map[uint64]*roundVotes
+	future            []*message // messages of the next height
+	valid             map[common.Hash]bool
+	proposed          bool // proposal of this node is sent in the current round
+	prevoteTimeout    bool // prevote timeout is scheduled in the current round
+	precommitTimeout  bool // precommit timeout is scheduled in the current round
+	precommitAdvanced bool // quorum prevotes for the proposal were applied in the current round
+
+	backlog []*message // own messages to handle
+
+	candidate *sealRequest
+	sealed    map[common.Hash]*sealRequest // proposals of this node by sealed block hash
+
+	msgCh       chan *message
+	candidateCh chan *sealRequest
+	timeoutCh   chan timeoutEvent
+	quit        chan struct{}
+	done        chan struct{}
+}
+
+func newMachine(e *Engine, chain Chain) *machine {
+	m := &machine{
+		engine:      e,
+		chain:       chain,
+		msgCh:       make(chan *message, 256),
+		candidateCh: make(chan *sealRequest, 1),
+		timeoutCh:   make(chan timeoutEvent, 16),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go m.loop()
+	return m
+}
+
+func (m *machine) post(msg *message) {
+	select {
+	case m.msgCh <- msg:
+	case <-m.quit:
+	}
+}
+
+func (m *machine) postCandidate(req *sealRequest) {
+	select {
+	case m.candidateCh <- req:
+	case <-m.quit:
+	}
+}
+
+func (m *machine) stop() {
+	close(m.quit)
+	<-m.done
+}
+
+func (m *machine) loop() {
+	defer close(m.done)
+
+	headCh := make(chan core.ChainHeadEvent, 16)
+	headSub := m.chain.SubscribeChainHeadEvent(headCh)
+	defer headSub.Unsubscribe()
+
+	m.startHeight(m.chain.CurrentBlock().Header())
+
+	for {
+		select {
+		case ev := <-headCh:
+			if number := ev.Block.NumberU64(); number >= m.height {
+				m.startHeight(ev.Block.Header())
+			}
+
+		case msg := <-m.msgCh:
+			m.handleMessage(msg)
+
+		case req := <-m.candidateCh:
+			// candidate of the next height may come before the chain head event
+			if req.block.NumberU64() < m.height {
+				continue
+			}
+			m.candidate = req
+			if m.step == stepPropose && !m.proposed && m.isProposer(m.round) {
+				m.propose()
+			}
+
+		case ev := <-m.timeoutCh:
+			m.handleTimeout(ev)
+
+		case <-headSub.Err():
+			return
+		case <-m.quit:
+			return
+		}
+
+		for len(m.backlog) > 0 {
+			msg := m.backlog[0]
+			m.backlog = m.backlog[1:]
+			m.handleMessage(msg)
+		}
+	}
+}
+
+// startHeight resets the state to run rounds of the block on top of the head
+func (m *machine) startHeight(head *types.Header) {
+	extra, err := ExtractExtra(head)
+	if err != nil {
+		m.engine.logger.Errorw("Unable to read validator set", "number", head.Number, "err", err)
+		return
+	}
+
+	m.height = head.Number.Uint64() + 1
+	m.parent = head
+	m.validators = extra.Validators
+	m.lockedRound, m.lockedBlock = -1, nil
+	m.validRound, m.validBlock = -1, nil
+	m.rounds = make(map[uint64]*roundVotes)
+	m.valid = make(map[common.Hash]bool)
+	m.sealed = make(map[common.Hash]*sealRequest)
+	if m.candidate != nil && !m.extendsParent(m.candidate.block) {
+		m.candidate = nil
+	}
+
+	m.startRound(0)
+
+	future := m.future
+	m.future = nil
+	for _, msg := range future {
+		m.handleMessage(msg)
+	}
+}
+
+func (m *machine) startRound(round uint64) {
+	m.round = round
+	m.step = stepPropose
+	m.proposed = false
+	m.prevoteTimeout = false
+	m.precommitTimeout = false
+	m.precommitAdvanced = false
+
+	if m.isProposer(round) {
+		m.propose()
+	}
+	m.schedule(stepPropose)
+	m.engine.logger.Debugw("Started consensus round", "height", m.height, "round", round, "proposer", proposerOf(m.validators, m.height, round))
+
+	m.process()
+}
+
+// propose broadcasts the valid block of previous rounds, or the local candidate
+func (m *machine) propose() {
+	msg := &message{
+		Code:   msgPropose,
+		Height: m.height,
+		Round:  m.round,
+	}
+	block := m.validBlock
+	if block != nil {
+		msg.HasValidRound = true
+		msg.ValidRound = uint64(m.validRound)
+	} else {
+		if m.candidate == nil || !m.extendsParent(m.candidate.block) {
+			return
+		}
+		var err error
+		if block, err = m.engine.sealProposal(m.candidate.block, m.round); err != nil {
+			m.engine.logger.Errorw("Unable to seal proposal", "height", m.height, "err", err)
+			return
+		}
+		m.sealed[block.Hash()] = m.candidate
+	}
+
+	data, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		m.engine.logger.Errorw("Unable to encode proposal", "height", m.height, "err", err)
+		return
+	}
+	msg.Digest = block.Hash()
+	msg.Block = data
+	msg.block = block
+
+	m.proposed = true
+	m.send(msg)
+}
+
+// send signs and broadcasts the message, and handles it locally
+func (m *machine) send(msg *message) {
+	m.engine.lock.RLock()
+	key := m.engine.key
+	m.engine.lock.RUnlock()
+
+	if err := msg.sign(key.PrivateKey); err != nil {
+		m.engine.logger.Errorw("Unable to sign consensus message", "msg", msg, "err", err)
+		return
+	}
+	data, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		m.engine.logger.Errorw("Unable to encode consensus message", "msg", msg, "err", err)
+		return
+	}
+
+	m.engine.broadcast(data)
+	// own messages are handled once the current transition is done
+	m.backlog = append(m.backlog, msg)
+}
+
+func (m *machine) vote(code uint64, digest common.Hash) {
+	if !m.isValidator() {
+		return
+	}
+	m.send(&message{
+		Code:   code,
+		Height: m.height,
+		Round:  m.round,
+		Digest: digest,
+	})
+}
+
+func (m *machine) handleMessage(msg *message) {
+	switch {
+	case msg.Height == m.height+1:
+		m.future = append(m.future, msg)
+		return
+	case msg.Height != m.height:
+		return
+	}
+	if !contains(m.validators, msg.sender) {
+		m.engine.logger.Debugw("Consensus message of unknown validator", "msg", msg, "sender", msg.sender)
+		return
+	}
+
+	votes, ok := m.rounds[msg.Round]
+	if !ok {
+		votes = newRoundVotes()
+		m.rounds[msg.Round] = votes
+	}
+
+	// only the first message of the sender counts
+	switch msg.Code {
+	case msgPropose:
+		if votes.proposal != nil || msg.sender != proposerOf(m.validators, m.height, msg.Round) {
+			return
+		}
+		votes.proposal = msg
+	case msgPrevote:
+		if vote, ok := votes.prevotes[msg.sender]; ok {
+			m.checkConflict(vote, msg)
+			return
+		}
+		votes.prevotes[msg.sender] = msg
+	case msgPrecommit:
+		if vote, ok := votes.precommits[msg.sender]; ok {
+			m.checkConflict(vote, msg)
+			return
+		}
+		votes.precommits[msg.sender] = msg
+	}
+
+	m.process()
+}
+
+// checkConflict reports the validator, which voted for different blocks in the same round
+func (m *machine) checkConflict(vote, msg *message) {
+	if vote.Digest == msg.Digest {
+		return
+	}
+	ev, err := newEvidence(vote, msg)
+	if err != nil {
+		m.engine.logger.Errorw("Unable to encode double signing evidence", "err", err)
+		return
+	}
+	m.engine.reportEvidence(ev)
+}
+
+func (m *machine) handleTimeout(ev timeoutEvent) {
+	if ev.height != m.height || ev.round != m.round {
+		return
+	}
+	switch {
+	case ev.step == stepPropose && m.step == stepPropose:
+		m.engine.logger.Debugw("Proposal timed out", "height", m.height, "round", m.round)
+		m.step = stepPrevote
+		m.vote(msgPrevote, common.Hash{})
+	case ev.step == stepPrevote && m.step == stepPrevote:
+		m.step = stepPrecommit
+		m.vote(msgPrecommit, common.Hash{})
+	case ev.step == stepPrecommit && m.step != stepCommitted:
+		m.startRound(m.round + 1)
+		return
+	}
+	m.process()
+}
+
+// process applies the state transitions, which conditions are met by the received messages
+func (m *machine) process() {
+	if m.step == stepCommitted {
+		return
+	}
+
+	// commit the block precommitted by the quorum in any round
+	for round, votes := range m.rounds {
+		for _, precommit := range votes.precommits {
+			if precommit.Digest == (common.Hash{}) || count(votes.precommits, precommit.Digest) < quorum(len(m.validators)) {
+				continue
+			}
+			if block := m.findBlock(precommit.Digest); block != nil {
+				m.commit(block, round, votes)
+				return
+			}
+		}
+	}
+
+	// skip to the round, which more than one third of validators already take part in
+	f := (len(m.validators) - 1) / 3
+	for round, votes := range m.rounds {
+		if round <= m.round {
+			continue
+		}
+		senders := make(map[common.Address]struct{})
+		if votes.proposal != nil {
+			senders[votes.proposal.sender] = struct{}{}
+		}
+		for sender := range votes.prevotes {
+			senders[sender] = struct{}{}
+		}
+		for sender := range votes.precommits {
+			senders[sender] = struct{}{}
+		}
+		if len(senders) > f {
+			m.startRound(round)
+			return
+		}
+	}
+
+	votes, ok := m.rounds[m.round]
+	if !ok {
+		return
+	}
+	n := quorum(len(m.validators))
+
+	if m.step == stepPropose && votes.proposal != nil {
+		proposal := votes.proposal
+		prevote := common.Hash{}
+		if !proposal.HasValidRound {
+			if m.isValid(proposal.block) && (m.lockedRound == -1 || m.lockedBlock.Hash() == proposal.Digest) {
+				prevote = proposal.Digest
+			}
+			m.step = stepPrevote
+			m.vote(msgPrevote, prevote)
+		} else if vr, ok := m.rounds[proposal.ValidRound]; ok && proposal.ValidRound < m.round && count(vr.prevotes, proposal.Digest) >= n {
+			if m.isValid(proposal.block) && (m.lockedRound <= int64(proposal.ValidRound) || m.lockedBlock.Hash() == proposal.Digest) {
+				prevote = proposal.Digest
+			}
+			m.step = stepPrevote
+			m.vote(msgPrevote, prevote)
+		}
+	}
+
+	if m.step == stepPrevote && len(votes.prevotes) >= n && !m.prevoteTimeout {
+		m.prevoteTimeout = true
+		m.schedule(stepPrevote)
+	}
+
+	if m.step >= stepPrevote && votes.proposal != nil && !m.precommitAdvanced &&
+		count(votes.prevotes, votes.proposal.Digest) >= n && m.isValid(votes.proposal.block) {
+		m.precommitAdvanced = true
+		if m.step == stepPrevote {
+			m.lockedRound, m.lockedBlock = int64(m.round), votes.proposal.block
+			m.step = stepPrecommit
+			m.vote(msgPrecommit, votes.proposal.Digest)
+		}
+		m.validRound, m.validBlock = int64(m.round), votes.proposal.block
+	}
+
+	if m.step == stepPrevote && count(votes.prevotes, common.Hash{}) >= n {
+		m.step = stepPrecommit
+		m.vote(msgPrecommit, common.Hash{})
+	}
+
+	if len(votes.precommits) >= n && !m.precommitTimeout {
+		m.precommitTimeout = true
+		m.schedule(stepPrecommit)
+	}
+}
+
+// commit includes commit seals into the block and passes it to the chain
+func (m *machine) commit(proposal *types.Block, round uint64, votes *roundVotes) {
+	m.step = stepCommitted
+
+	// seals are ordered by validators
+	commit := Commit{Round: round}
+	for _, validator := range m.validators {
+		if precommit, ok := votes.precommits[validator]; ok && precommit.Digest == proposal.Hash() {
+			commit.Seals = append(commit.Seals, precommit.Signature)
+		}
+	}
+	block, err := commitBlock(proposal, commit)
+	if err != nil {
+		m.engine.logger.Errorw("Unable to include commit seals", "number", proposal.NumberU64(), "err", err)
+		return
+	}
+	m.engine.logger.Infow("Committed block", "number", block.NumberU64(), "hash", block.Hash(), "round", round, "seals", len(commit.Seals))
+
+	// the proposal of this node is written by the block producer with its state
+	if req, ok := m.sealed[proposal.Hash()]; ok {
+		select {
+		case req.results <- block:
+			return
+		case <-req.stop:
+		}
+	}
+	go func() {
+		if _, err := m.chain.InsertChain(types.Blocks{block}); err != nil {
+			m.engine.logger.Errorw("Unable to insert committed block", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+		}
+	}()
+}
+
+// findBlock returns proposed block by hash of any round
+func (m *machine) findBlock(hash common.Hash) *types.Block {
+	for _, votes := range m.rounds {
+		if votes.proposal != nil && votes.proposal.Digest == hash {
+			return votes.proposal.block
+		}
+	}
+	return nil
+}
+
+// isValid checks the proposed block could be inserted into the chain
+func (m *machine) isValid(block *types.Block) bool {
+	hash := block.Hash()
+	if valid, ok := m.valid[hash]; ok {
+		return valid
+	}
+	if !m.extendsParent(block) {
+		// parent committed with other seals may not be imported yet
+		return false
+	}
+
+	err := m.engine.verifyProposal(m.chain, block.Header())
+	if err == nil {
+		err = m.chain.ValidateBlock(block)
+	}
+	if err != nil {
+		m.engine.logger.Warnw("Invalid block proposal", "number", block.NumberU64(), "hash", hash, "err", err)
+	}
+	m.valid[hash] = err == nil
+	return err == nil
+}
+
+// extendsParent checks the block is built on top of the parent, which may be committed with other seals
+func (m *machine) extendsParent(block *types.Block) bool {
+	if block.ParentHash() == m.parent.Hash() {
+		return true
+	}
+	parent := m.chain.GetHeader(block.ParentHash(), m.parent.Number.Uint64())
+	return parent != nil && ProposalHash(parent) == ProposalHash(m.parent)
+}
+
+func (m *machine) isProposer(round uint64) bool {
+	m.engine.lock.RLock()
+	defer m.engine.lock.RUnlock()
+
+	return proposerOf(m.validators, m.height, round) == m.engine.key.Address
+}
+
+func (m *machine) isValidator() bool {
+	m.engine.lock.RLock()
+	defer m.engine.lock.RUnlock()
+
+	return contains(m.validators, m.engine.key.Address)
+}
+
+// schedule posts the step timeout of the current round, timeouts double every round
+func (m *machine) schedule(s step) {
+	shift := m.round
+	if shift > maxRoundTimeoutShift {
+		shift = maxRoundTimeoutShift
+	}
+	timeout := time.Duration(m.engine.config.Timeout) * time.Millisecond << shift
+
+	ev := timeoutEvent{height: m.height, round: m.round, step: s}
+	time.AfterFunc(timeout, func() {
+		select {
+		case m.timeoutCh <- ev:
+		case <-m.quit:
+		}
+	})
+}
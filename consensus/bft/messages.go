@@ -0,0 +1,100 @@
+package bft
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	msgPropose uint64 = iota
+	msgPrevote
+	msgPrecommit
+)
+
+var msgNames = map[uint64]string{
+	msgPropose:   "propose",
+	msgPrevote:   "prevote",
+	msgPrecommit: "precommit",
+}
+
+// message is a signed consensus message, votes with empty digest are nil votes
+type message struct {
+	Code          uint64
+	Height        uint64
+	Round         uint64
+	Digest        common.Hash
+	HasValidRound bool   // proposal was accepted by the majority in the valid round
+	ValidRound    uint64 // valid round of the re-proposed block
+	Block         []byte // rlp encoded proposed block
+	Signature     []byte
+
+	sender common.Address
+	block  *types.Block
+}
+
+// voteHash returns the hash signed by the message sender.
+// Precommit signatures are used as commit seals, so they can be verified from header fields only
+func voteHash(code, height, round uint64, digest common.Hash, hasValidRound bool, validRound uint64) common.Hash {
+	data, _ := rlp.EncodeToBytes([]interface{}{code, height, round, digest, hasValidRound, validRound})
+	return crypto.Keccak256Hash(data)
+}
+
+func (m *message) hash() common.Hash {
+	return voteHash(m.Code, m.Height, m.Round, m.Digest, m.HasValidRound, m.ValidRound)
+}
+
+func (m *message) sign(key *ecdsa.PrivateKey) error {
+	sig, err := crypto.Sign(m.hash().Bytes(), key)
+	if err != nil {
+		return err
+	}
+	m.Signature = sig
+	m.sender = crypto.PubkeyToAddress(key.PublicKey)
+	return nil
+}
+
+func (m *message) String() string {
+	return fmt.Sprintf("%s #%d/%d %s", msgNames[m.Code], m.Height, m.Round, m.Digest.TerminalString())
+}
+
+// decodeMessage decodes message and recovers its sender
+func decodeMessage(data []byte) (*message, error) {
+	msg := new(message)
+	if err := rlp.DecodeBytes(data, msg); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	if _, ok := msgNames[msg.Code]; !ok {
+		return nil, fmt.Errorf("%w: unknown code %d", ErrInvalidMessage, msg.Code)
+	}
+
+	pubkey, err := crypto.SigToPub(msg.hash().Bytes(), msg.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+	}
+	msg.sender = crypto.PubkeyToAddress(*pubkey)
+
+	if msg.Code == msgPropose {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(msg.Block, block); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMessage, err)
+		}
+		if block.Hash() != msg.Digest || block.NumberU64() != msg.Height {
+			return nil, fmt.Errorf("%w: proposed block does not match", ErrInvalidMessage)
+		}
+		msg.block = block
+	}
+	return msg, nil
+}
+
+// recoverCommitSeal returns the validator, which signed the precommit for the block
+func recoverCommitSeal(height, round uint64, hash common.Hash, seal []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(voteHash(msgPrecommit, height, round, hash, false, 0).Bytes(), seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
@@ -0,0 +1,70 @@
+package clique
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/consensus"
+)
+
+// API exposes signers and voting of the proof-of-authority engine under the 'clique' namespace
+type API struct {
+	chain  consensus.ChainHeaderReader
+	clique *Clique
+}
+
+// GetSnapshot returns voting snapshot at the block, latest if number is not set
+func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
+	header := api.headerByNumber(number)
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+	return api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash())
+}
+
+func (api *API) GetSnapshotAtHash(hash common.Hash) (*Snapshot, error) {
+	header := api.chain.GetHeaderByHash(hash)
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+	return api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash())
+}
+
+// GetSigners returns authorized signers at the block, latest if number is not set
+func (api *API) GetSigners(number *rpc.BlockNumber) ([]common.Address, error) {
+	snap, err := api.GetSnapshot(number)
+	if err != nil {
+		return nil, err
+	}
+	return snap.signers(), nil
+}
+
+func (api *API) GetSignersAtHash(hash common.Hash) ([]common.Address, error) {
+	snap, err := api.GetSnapshotAtHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	return snap.signers(), nil
+}
+
+// Proposals returns current proposals the node votes for
+func (api *API) Proposals() map[common.Address]bool {
+	return api.clique.Proposals()
+}
+
+// Propose adds a new proposal to authorize or deauthorize the signer, which the node votes for in sealed blocks
+func (api *API) Propose(address common.Address, authorize bool) {
+	api.clique.Propose(address, authorize)
+}
+
+// Discard drops the proposal
+func (api *API) Discard(address common.Address) {
+	api.clique.Discard(address)
+}
+
+func (api *API) headerByNumber(number *rpc.BlockNumber) *types.Header {
+	if number == nil || *number < 0 {
+		return api.chain.CurrentHeader()
+	}
+	return api.chain.GetHeaderByNumber(uint64(number.Int64()))
+}
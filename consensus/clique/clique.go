@@ -0,0 +1,543 @@
+package clique
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/state"
+	"golang.org/x/crypto/sha3"
+	"io"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	checkpointInterval = 1024 // number of blocks after which voting snapshot is saved into the database
+	inmemorySnapshots  = 128  // number of recent snapshots to keep in memory
+	inmemorySignatures = 4096 // number of recent block signatures to keep in memory
+
+	wiggleTime = 500 * time.Millisecond // random delay per signer to allow concurrent out-of-turn signers
+)
+
+var (
+	// DefaultEpoch is the number of blocks after which votes are reset, used if not set in config
+	DefaultEpoch uint64 = 30000
+
+	extraVanity = 32                     // fixed number of extra-data prefix bytes reserved for signer vanity
+	extraSeal   = crypto.SignatureLength // fixed number of extra-data suffix bytes reserved for signer seal
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // magic nonce to vote on adding a new signer
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // magic nonce to vote on removing a signer
+
+	diffInTurn = big.NewInt(2) // block difficulty for in-turn signatures
+	diffNoTurn = big.NewInt(1) // block difficulty for out-of-turn signatures
+)
+
+var (
+	ErrUnknownBlock                 = errors.New("unknown block")
+	ErrInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+	ErrInvalidVote                  = errors.New("vote nonce not 0x00..0 or 0xff..f")
+	ErrInvalidCheckpointVote        = errors.New("vote nonce in checkpoint block non-zero")
+	ErrMissingVanity                = errors.New("extra-data 32 byte vanity prefix missing")
+	ErrMissingSignature             = errors.New("extra-data 65 byte signature suffix missing")
+	ErrExtraSigners                 = errors.New("non-checkpoint block contains extra signer list")
+	ErrInvalidCheckpointSigners     = errors.New("invalid signer list on checkpoint block")
+	ErrMismatchingCheckpointSigners = errors.New("mismatching signer list on checkpoint block")
+	ErrInvalidMixDigest             = errors.New("non-zero mix digest")
+	ErrInvalidUncleHash             = errors.New("non empty uncle hash")
+	ErrInvalidDifficulty            = errors.New("invalid difficulty")
+	ErrWrongDifficulty              = errors.New("wrong difficulty")
+	ErrInvalidTimestamp             = errors.New("invalid timestamp")
+	ErrInvalidVotingChain           = errors.New("invalid voting chain")
+	ErrUnauthorizedSigner           = errors.New("unauthorized signer")
+	ErrRecentlySigned               = errors.New("recently signed")
+)
+
+// Clique is the proof-of-authority engine. Authorized signers take turns sealing blocks
+// and vote to add or remove signers, the signer set is recorded in extra-data of epoch checkpoint blocks
+type Clique struct {
+	config *params.CliqueConfig
+	db     ethdb.KeyValueStore
+
+	recents    *lru.ARCCache // snapshots of recent blocks to speed up reorgs
+	signatures *lru.ARCCache // signatures of recent blocks to speed up sealer recovering
+
+	lock      sync.RWMutex // protects signer, key and proposals
+	proposals map[common.Address]bool
+	signer    common.Address
+	key       *ecdsa.PrivateKey
+}
+
+// New returns proof-of-authority engine, keeping voting snapshots in the database
+func New(config *params.CliqueConfig, db ethdb.KeyValueStore) *Clique {
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = DefaultEpoch
+	}
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	return &Clique{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		proposals:  make(map[common.Address]bool),
+	}
+}
+
+// Authorize sets the key used to seal blocks
+func (c *Clique) Authorize(key *keystore.Key) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.signer = key.Address
+	c.key = key.PrivateKey
+}
+
+// Propose adds a proposal to authorize or deauthorize the signer, which is voted in produced blocks
+func (c *Clique) Propose(address common.Address, authorize bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.proposals[address] = authorize
+}
+
+func (c *Clique) Discard(address common.Address) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.proposals, address)
+}
+
+func (c *Clique) Proposals() map[common.Address]bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	proposals := make(map[common.Address]bool, len(c.proposals))
+	for address, authorize := range c.proposals {
+		proposals[address] = authorize
+	}
+	return proposals
+}
+
+// Author returns address of the account, which sealed the block
+func (c *Clique) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, c.signatures)
+}
+
+// SealerAddress returns address of the local signer, which receives fees of produced blocks
+func (c *Clique) SealerAddress() common.Address {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.signer
+}
+
+func (c *Clique) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header) error {
+	if header.Number == nil {
+		return ErrUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	checkpoint := number%c.config.Epoch == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return ErrInvalidCheckpointBeneficiary
+	}
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return ErrInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return ErrInvalidCheckpointVote
+	}
+	if len(header.Extra) < extraVanity {
+		return ErrMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return ErrMissingSignature
+	}
+	signersBytes := len(header.Extra) - extraVanity - extraSeal
+	if !checkpoint && signersBytes != 0 {
+		return ErrExtraSigners
+	}
+	if checkpoint && signersBytes%common.AddressLength != 0 {
+		return ErrInvalidCheckpointSigners
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return ErrInvalidMixDigest
+	}
+	if header.UncleHash != types.EmptyUncleHash {
+		return ErrInvalidUncleHash
+	}
+	if number > 0 && (header.Difficulty == nil || (header.Difficulty.Cmp(diffInTurn) != 0 && header.Difficulty.Cmp(diffNoTurn) != 0)) {
+		return ErrInvalidDifficulty
+	}
+
+	return c.verifyCascadingFields(chain, header)
+}
+
+// verifyCascadingFields checks header fields, which depend on the parent and voting snapshot
+func (c *Clique) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+c.config.Period > header.Time {
+		return ErrInvalidTimestamp
+	}
+
+	snap, err := c.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+
+	// checkpoint blocks must contain the current signers list
+	if number%c.config.Epoch == 0 {
+		signers := make([]byte, len(snap.Signers)*common.AddressLength)
+		for i, signer := range snap.signers() {
+			copy(signers[i*common.AddressLength:], signer[:])
+		}
+		extraSuffix := len(header.Extra) - extraSeal
+		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signers) {
+			return ErrMismatchingCheckpointSigners
+		}
+	}
+
+	return c.verifySeal(snap, header)
+}
+
+// verifySeal checks the header is signed by the authorized signer, which has not signed recently
+func (c *Clique) verifySeal(snap *Snapshot, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return ErrUnknownBlock
+	}
+
+	signer, err := ecrecover(header, c.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Signers[signer]; !ok {
+		return ErrUnauthorizedSigner
+	}
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			// signer is among recents, only fail if the current block doesn't shift it out
+			if limit := uint64(len(snap.Signers)/2 + 1); seen > number-limit {
+				return ErrRecentlySigned
+			}
+		}
+	}
+
+	inturn := snap.inturn(number, signer)
+	if inturn && header.Difficulty.Cmp(diffInTurn) != 0 {
+		return ErrWrongDifficulty
+	}
+	if !inturn && header.Difficulty.Cmp(diffNoTurn) != 0 {
+		return ErrWrongDifficulty
+	}
+	return nil
+}
+
+// snapshot returns voting snapshot at the given block, replaying headers since the last stored one
+func (c *Clique) snapshot(chain consensus.ChainHeaderReader, number uint64, hash common.Hash) (*Snapshot, error) {
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		if s, ok := c.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(c.config, c.signatures, c.db, hash); err == nil {
+				snap = s
+				break
+			}
+		}
+		if number == 0 {
+			genesis := chain.GetHeaderByNumber(0)
+			if genesis == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			if err := c.VerifyHeader(chain, genesis); err != nil {
+				return nil, err
+			}
+			signers := make([]common.Address, (len(genesis.Extra)-extraVanity-extraSeal)/common.AddressLength)
+			for i := 0; i < len(signers); i++ {
+				copy(signers[i][:], genesis.Extra[extraVanity+i*common.AddressLength:])
+			}
+			snap = newSnapshot(c.config, c.signatures, 0, genesis.Hash(), signers)
+			if err := snap.store(c.db); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		header := chain.GetHeader(hash, number)
+		if header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+
+	// headers are collected backwards, while snapshot is applied in order
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	c.recents.Add(snap.Hash, snap)
+
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err := snap.store(c.db); err != nil {
+			return nil, err
+		}
+	}
+	return snap, nil
+}
+
+// Prepare sets vote, difficulty and extra-data of the header, and delays its timestamp by the block period
+func (c *Clique) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	snap, err := c.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+
+	c.lock.RLock()
+	if number%c.config.Epoch != 0 {
+		// cast random vote of the valid proposals
+		addresses := make([]common.Address, 0, len(c.proposals))
+		for address, authorize := range c.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if c.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+	}
+	header.Difficulty = calcDifficulty(snap, c.signer)
+	c.lock.RUnlock()
+
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = header.Extra[:extraVanity]
+	if number%c.config.Epoch == 0 {
+		for _, signer := range snap.signers() {
+			header.Extra = append(header.Extra, signer[:]...)
+		}
+	}
+	header.Extra = append(header.Extra, make([]byte, extraSeal)...)
+	header.MixDigest = common.Hash{}
+
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = parent.Time + c.config.Period
+	if now := uint64(time.Now().Unix()); header.Time < now {
+		header.Time = now
+	}
+	return nil
+}
+
+// Finalize issues the block reward to the signer, sets the state root and assembles the block.
+// Coinbase is the voting proposal in clique, so it does not receive the reward
+func (c *Clique) Finalize(chain consensus.ChainHeaderReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	if config := chain.Config().Rewards; config != nil {
+		signer, err := c.producer(header)
+		if err != nil {
+			return nil, err
+		}
+		rewards.Accumulate(config, statedb, header, signer)
+	}
+	header.Root = statedb.IntermediateRoot(chain.Config().IsEIP158(header.Number, header.Time))
+	header.UncleHash = types.EmptyUncleHash
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Seal signs the block with the authorized key once its timestamp is reached.
+// Out-of-turn signers wait for a random extra delay to let the in-turn signer go first
+func (c *Clique) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+	number := header.Number.Uint64()
+	if number == 0 {
+		return ErrUnknownBlock
+	}
+	// sealing empty blocks is pointless without period, so wait for transactions
+	if c.config.Period == 0 && len(block.Transactions()) == 0 {
+		return nil
+	}
+
+	c.lock.RLock()
+	signer, key := c.signer, c.key
+	c.lock.RUnlock()
+	if key == nil {
+		return ErrUnauthorizedSigner
+	}
+
+	snap, err := c.snapshot(chain, number-1, header.ParentHash)
+	if err != nil {
+		return err
+	}
+	if _, authorized := snap.Signers[signer]; !authorized {
+		return ErrUnauthorizedSigner
+	}
+	for seen, recent := range snap.Recents {
+		if recent == signer {
+			// signer is among recents, wait for the others to produce the next block
+			if limit := uint64(len(snap.Signers)/2 + 1); number < limit || seen > number-limit {
+				return nil
+			}
+		}
+	}
+
+	delay := time.Until(time.Unix(int64(header.Time), 0))
+	if header.Difficulty.Cmp(diffNoTurn) == 0 {
+		wiggle := time.Duration(len(snap.Signers)/2+1) * wiggleTime
+		delay += time.Duration(rand.Int63n(int64(wiggle)))
+	}
+
+	sighash, err := crypto.Sign(SealHash(header).Bytes(), key)
+	if err != nil {
+		return err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		select {
+		case results <- block.WithSeal(header):
+		case <-stop:
+		}
+	}()
+	return nil
+}
+
+func (c *Clique) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "clique",
+			Service:   &API{chain: chain, clique: c},
+		},
+	}
+}
+
+func (c *Clique) Close() error {
+	return nil
+}
+
+// calcDifficulty returns in-turn difficulty if the signer is expected to seal the next block
+func calcDifficulty(snap *Snapshot, signer common.Address) *big.Int {
+	if snap.inturn(snap.Number+1, signer) {
+		return new(big.Int).Set(diffInTurn)
+	}
+	return new(big.Int).Set(diffNoTurn)
+}
+
+// SealHash returns hash of the header without the seal signature
+func SealHash(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewLegacyKeccak256()
+	encodeSigHeader(hasher, header)
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+func encodeSigHeader(w io.Writer, header *types.Header) {
+	enc := []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra[:len(header.Extra)-extraSeal],
+		header.MixDigest,
+		header.Nonce,
+	}
+	if header.BaseFee != nil {
+		enc = append(enc, header.BaseFee)
+	}
+	if err := rlp.Encode(w, enc); err != nil {
+		panic("can't encode: " + err.Error())
+	}
+}
+
+// producer returns the signer of the sealed header, or the local signer if the header is being produced and is not sealed yet
+func (c *Clique) producer(header *types.Header) (common.Address, error) {
+	if len(header.Extra) >= extraSeal && bytes.Equal(header.Extra[len(header.Extra)-extraSeal:], make([]byte, extraSeal)) {
+		return c.SealerAddress(), nil
+	}
+	return c.Author(header)
+}
+
+// ecrecover extracts the signer address from the signed header
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, ErrMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
@@ -0,0 +1,250 @@
+package clique
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testChain is a header chain, which keeps all the inserted headers canonical
+type testChain struct {
+	config  *params.ChainConfig
+	headers []*types.Header
+}
+
+func (c *testChain) Config() *params.ChainConfig {
+	return c.config
+}
+
+func (c *testChain) CurrentHeader() *types.Header {
+	return c.headers[len(c.headers)-1]
+}
+
+func (c *testChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.GetHeaderByNumber(number); header != nil && header.Hash() == hash {
+		return header
+	}
+	return nil
+}
+
+func (c *testChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number >= uint64(len(c.headers)) {
+		return nil
+	}
+	return c.headers[number]
+}
+
+func (c *testChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	for _, header := range c.headers {
+		if header.Hash() == hash {
+			return header
+		}
+	}
+	return nil
+}
+
+// testSigners returns keys of the test accounts sorted by address, the order signers take turns in
+func testSigners(t *testing.T, n int) []*ecdsa.PrivateKey {
+	hexKeys := []string{tests.PrivateKey0, tests.PrivateKey1, tests.PrivateKey2, tests.PrivateKey3}
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		key, err := crypto.HexToECDSA(hexKeys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(crypto.PubkeyToAddress(keys[i].PublicKey).Bytes(), crypto.PubkeyToAddress(keys[j].PublicKey).Bytes()) < 0
+	})
+	return keys
+}
+
+func newTestChain(signers []*ecdsa.PrivateKey, period uint64) (*testChain, *Clique) {
+	config := *params.DevChainConfig
+	config.Clique = &params.CliqueConfig{Period: period, Epoch: 30000}
+
+	extra := make([]byte, extraVanity)
+	for _, key := range signers {
+		extra = append(extra, crypto.PubkeyToAddress(key.PublicKey).Bytes()...)
+	}
+	genesis := &types.Header{
+		Number:     new(big.Int),
+		Time:       uint64(time.Now().Add(-time.Hour).Unix()),
+		Extra:      append(extra, make([]byte, extraSeal)...),
+		GasLimit:   30_000_000,
+		Difficulty: big.NewInt(1),
+		UncleHash:  types.EmptyUncleHash,
+	}
+
+	return &testChain{config: &config, headers: []*types.Header{genesis}}, New(config.Clique, rawdb.NewMemoryDatabase())
+}
+
+// nextHeader returns header on top of the chain head, voting for the address if it is not empty
+func (c *testChain) nextHeader(vote common.Address, authorize bool, difficulty int64) *types.Header {
+	parent := c.CurrentHeader()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number, common.Big1),
+		Time:       parent.Time + 1,
+		GasLimit:   parent.GasLimit,
+		Difficulty: big.NewInt(difficulty),
+		Extra:      make([]byte, extraVanity+extraSeal),
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   vote,
+	}
+	if authorize {
+		copy(header.Nonce[:], nonceAuthVote)
+	}
+	return header
+}
+
+func signHeader(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) *types.Header {
+	sig, err := crypto.Sign(SealHash(header).Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	return header
+}
+
+func TestCliqueVoting(t *testing.T) {
+	keys := testSigners(t, 3)
+	chain, engine := newTestChain(keys, 1)
+	candidate := tests.Account3
+
+	// in-turn signers of blocks #1 and #2 vote for the candidate, which makes the majority of three
+	for number := 1; number <= 2; number++ {
+		header := signHeader(t, chain.nextHeader(candidate, true, 2), keys[number%len(keys)])
+		if err := engine.VerifyHeader(chain, header); err != nil {
+			t.Fatalf("block #%d verification failed: %s", number, err)
+		}
+		chain.headers = append(chain.headers, header)
+	}
+
+	snap, err := engine.snapshot(chain, 2, chain.CurrentHeader().Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snap.Signers[candidate]; !ok || len(snap.Signers) != 4 {
+		t.Fatalf("candidate is not authorized: %v", snap.signers())
+	}
+	if len(snap.Votes) != 0 || len(snap.Tally) != 0 {
+		t.Fatalf("votes are not cleared after the signer is authorized: %d votes, %d tallies", len(snap.Votes), len(snap.Tally))
+	}
+
+	// single vote of four signers does not kick the signer
+	header := signHeader(t, chain.nextHeader(crypto.PubkeyToAddress(keys[0].PublicKey), false, 1), keys[0])
+	if err := engine.VerifyHeader(chain, header); err != nil {
+		t.Fatal(err)
+	}
+	chain.headers = append(chain.headers, header)
+	if snap, err = engine.snapshot(chain, 3, header.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Signers) != 4 || snap.Tally[crypto.PubkeyToAddress(keys[0].PublicKey)].Votes != 1 {
+		t.Fatalf("unexpected snapshot after drop vote: %v, %v", snap.signers(), snap.Tally)
+	}
+}
+
+func TestCliqueVerifySeal(t *testing.T) {
+	keys := testSigners(t, 3)
+	chain, engine := newTestChain(keys, 1)
+
+	outsider, err := crypto.HexToECDSA(tests.PrivateKey4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.VerifyHeader(chain, signHeader(t, chain.nextHeader(common.Address{}, false, 1), outsider)); !errors.Is(err, ErrUnauthorizedSigner) {
+		t.Fatalf("unexpected unauthorized signer error: %v", err)
+	}
+	// keys[1] is in-turn signer of block #1
+	if err := engine.VerifyHeader(chain, signHeader(t, chain.nextHeader(common.Address{}, false, 1), keys[1])); !errors.Is(err, ErrWrongDifficulty) {
+		t.Fatalf("unexpected in-turn difficulty error: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, signHeader(t, chain.nextHeader(common.Address{}, false, 2), keys[0])); !errors.Is(err, ErrWrongDifficulty) {
+		t.Fatalf("unexpected out-of-turn difficulty error: %v", err)
+	}
+
+	header := signHeader(t, chain.nextHeader(common.Address{}, false, 1), keys[0])
+	if err := engine.VerifyHeader(chain, header); err != nil {
+		t.Fatal(err)
+	}
+	chain.headers = append(chain.headers, header)
+
+	// signer can not seal again until the others signed, one of two following blocks for three signers
+	if err := engine.VerifyHeader(chain, signHeader(t, chain.nextHeader(common.Address{}, false, 1), keys[0])); !errors.Is(err, ErrRecentlySigned) {
+		t.Fatalf("unexpected recently signed error: %v", err)
+	}
+	if err := engine.VerifyHeader(chain, signHeader(t, chain.nextHeader(common.Address{}, false, 2), keys[2])); err != nil {
+		t.Fatal(err)
+	}
+
+	// only checkpoint blocks carry signers list
+	extra := chain.nextHeader(common.Address{}, false, 2)
+	extra.Extra = append(make([]byte, extraVanity+common.AddressLength), make([]byte, extraSeal)...)
+	if err := engine.VerifyHeader(chain, signHeader(t, extra, keys[2])); !errors.Is(err, ErrExtraSigners) {
+		t.Fatalf("unexpected extra signers error: %v", err)
+	}
+}
+
+func TestCliqueSeal(t *testing.T) {
+	keys := testSigners(t, 2)
+	chain, engine := newTestChain(keys, 1)
+
+	address := crypto.PubkeyToAddress(keys[1].PublicKey)
+	engine.Authorize(&keystore.Key{Address: address, PrivateKey: keys[1]})
+	engine.Propose(tests.Account5, true)
+
+	header := &types.Header{
+		ParentHash: chain.CurrentHeader().Hash(),
+		Number:     big.NewInt(1),
+		GasLimit:   chain.CurrentHeader().GasLimit,
+		UncleHash:  types.EmptyUncleHash,
+	}
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Difficulty.Cmp(diffInTurn) != 0 {
+		t.Fatalf("unexpected difficulty of in-turn signer: %s", header.Difficulty)
+	}
+	if header.Coinbase != tests.Account5 || !bytes.Equal(header.Nonce[:], nonceAuthVote) {
+		t.Fatalf("proposal is not voted: %s %x", header.Coinbase, header.Nonce)
+	}
+	if author, err := engine.Author(header); err == nil && author == address {
+		t.Fatal("unsealed header is authored by the local signer")
+	}
+	if sealer := engine.SealerAddress(); sealer != address {
+		t.Fatalf("unexpected sealer address: %s", sealer)
+	}
+
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	if err := engine.Seal(chain, types.NewBlockWithHeader(header), results, stop); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case block := <-results:
+		if author, err := engine.Author(block.Header()); err != nil || author != address {
+			t.Fatalf("unexpected block author: %s, %v", author, err)
+		}
+		if err := engine.VerifyHeader(chain, block.Header()); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("block is not sealed")
+	}
+}
@@ -0,0 +1,267 @@
+package clique
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/rovergulf/chain/params"
+	"sort"
+)
+
+// snapshotPrefix + block hash -> json encoded snapshot
+var snapshotPrefix = []byte("clique-")
+
+// Vote is a single vote of the authorized signer to add or remove the account
+type Vote struct {
+	Signer    common.Address `json:"signer" yaml:"signer"`       // authorized signer, who casted the vote
+	Block     uint64         `json:"block" yaml:"block"`         // block number the vote was cast in, expire old votes
+	Address   common.Address `json:"address" yaml:"address"`     // account being voted to change its authorization
+	Authorize bool           `json:"authorize" yaml:"authorize"` // whether to authorize or deauthorize the account
+}
+
+// Tally is a simple vote tally to keep the current score of votes
+type Tally struct {
+	Authorize bool `json:"authorize" yaml:"authorize"` // whether the vote is about authorizing or kicking someone
+	Votes     int  `json:"votes" yaml:"votes"`         // number of votes until now wanting to pass the proposal
+}
+
+// Snapshot is the state of the authorization voting at a given block
+type Snapshot struct {
+	config   *params.CliqueConfig
+	sigcache *lru.ARCCache
+
+	Number  uint64                      `json:"number" yaml:"number"`   // block number where the snapshot was created
+	Hash    common.Hash                 `json:"hash" yaml:"hash"`       // block hash where the snapshot was created
+	Signers map[common.Address]struct{} `json:"signers" yaml:"signers"` // set of authorized signers at this moment
+	Recents map[uint64]common.Address   `json:"recents" yaml:"recents"` // set of recent signers for spam protections
+	Votes   []*Vote                     `json:"votes" yaml:"votes"`     // list of votes cast in chronological order
+	Tally   map[common.Address]Tally    `json:"tally" yaml:"tally"`     // current vote tally to avoid recalculating
+}
+
+// newSnapshot creates snapshot with the initial set of signers, used for the genesis block
+func newSnapshot(config *params.CliqueConfig, sigcache *lru.ARCCache, number uint64, hash common.Hash, signers []common.Address) *Snapshot {
+	snap := &Snapshot{
+		config:   config,
+		sigcache: sigcache,
+		Number:   number,
+		Hash:     hash,
+		Signers:  make(map[common.Address]struct{}),
+		Recents:  make(map[uint64]common.Address),
+		Tally:    make(map[common.Address]Tally),
+	}
+	for _, signer := range signers {
+		snap.Signers[signer] = struct{}{}
+	}
+	return snap
+}
+
+func loadSnapshot(config *params.CliqueConfig, sigcache *lru.ARCCache, db ethdb.KeyValueReader, hash common.Hash) (*Snapshot, error) {
+	data, err := db.Get(append(snapshotPrefix, hash[:]...))
+	if err != nil {
+		return nil, err
+	}
+	snap := new(Snapshot)
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, err
+	}
+	snap.config = config
+	snap.sigcache = sigcache
+
+	return snap, nil
+}
+
+func (s *Snapshot) store(db ethdb.KeyValueWriter) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return db.Put(append(snapshotPrefix, s.Hash[:]...), data)
+}
+
+func (s *Snapshot) copy() *Snapshot {
+	cpy := &Snapshot{
+		config:   s.config,
+		sigcache: s.sigcache,
+		Number:   s.Number,
+		Hash:     s.Hash,
+		Signers:  make(map[common.Address]struct{}),
+		Recents:  make(map[uint64]common.Address),
+		Votes:    make([]*Vote, len(s.Votes)),
+		Tally:    make(map[common.Address]Tally),
+	}
+	for signer := range s.Signers {
+		cpy.Signers[signer] = struct{}{}
+	}
+	for block, signer := range s.Recents {
+		cpy.Recents[block] = signer
+	}
+	for address, tally := range s.Tally {
+		cpy.Tally[address] = tally
+	}
+	copy(cpy.Votes, s.Votes)
+
+	return cpy
+}
+
+// validVote returns whether it makes sense to cast the vote, e.g. not to add already authorized signer
+func (s *Snapshot) validVote(address common.Address, authorize bool) bool {
+	_, signer := s.Signers[address]
+	return (signer && !authorize) || (!signer && authorize)
+}
+
+func (s *Snapshot) cast(address common.Address, authorize bool) bool {
+	if !s.validVote(address, authorize) {
+		return false
+	}
+	if old, ok := s.Tally[address]; ok {
+		old.Votes++
+		s.Tally[address] = old
+	} else {
+		s.Tally[address] = Tally{Authorize: authorize, Votes: 1}
+	}
+	return true
+}
+
+func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
+	tally, ok := s.Tally[address]
+	if !ok {
+		return false
+	}
+	// ensure we only revert counted votes
+	if tally.Authorize != authorize {
+		return false
+	}
+	if tally.Votes > 1 {
+		tally.Votes--
+		s.Tally[address] = tally
+	} else {
+		delete(s.Tally, address)
+	}
+	return true
+}
+
+// apply creates a new snapshot by applying the headers to the current one.
+// Headers must be contiguous and follow the snapshot block
+func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
+	if len(headers) == 0 {
+		return s, nil
+	}
+	for i := 0; i < len(headers)-1; i++ {
+		if headers[i+1].Number.Uint64() != headers[i].Number.Uint64()+1 {
+			return nil, ErrInvalidVotingChain
+		}
+	}
+	if headers[0].Number.Uint64() != s.Number+1 {
+		return nil, ErrInvalidVotingChain
+	}
+
+	snap := s.copy()
+	for _, header := range headers {
+		number := header.Number.Uint64()
+		// remove any votes on checkpoint blocks
+		if number%s.config.Epoch == 0 {
+			snap.Votes = nil
+			snap.Tally = make(map[common.Address]Tally)
+		}
+		// delete the oldest signer from the recent list to allow it signing again
+		if limit := uint64(len(snap.Signers)/2 + 1); number >= limit {
+			delete(snap.Recents, number-limit)
+		}
+
+		signer, err := ecrecover(header, s.sigcache)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := snap.Signers[signer]; !ok {
+			return nil, ErrUnauthorizedSigner
+		}
+		for _, recent := range snap.Recents {
+			if recent == signer {
+				return nil, ErrRecentlySigned
+			}
+		}
+		snap.Recents[number] = signer
+
+		// discard any previous votes of the signer on the same account
+		for i, vote := range snap.Votes {
+			if vote.Signer == signer && vote.Address == header.Coinbase {
+				snap.uncast(vote.Address, vote.Authorize)
+				snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+				break
+			}
+		}
+
+		var authorize bool
+		switch {
+		case bytes.Equal(header.Nonce[:], nonceAuthVote):
+			authorize = true
+		case bytes.Equal(header.Nonce[:], nonceDropVote):
+			authorize = false
+		default:
+			return nil, ErrInvalidVote
+		}
+		if snap.cast(header.Coinbase, authorize) {
+			snap.Votes = append(snap.Votes, &Vote{
+				Signer:    signer,
+				Block:     number,
+				Address:   header.Coinbase,
+				Authorize: authorize,
+			})
+		}
+
+		// signers list is changed once the majority agrees
+		if tally := snap.Tally[header.Coinbase]; tally.Votes > len(snap.Signers)/2 {
+			if tally.Authorize {
+				snap.Signers[header.Coinbase] = struct{}{}
+			} else {
+				delete(snap.Signers, header.Coinbase)
+
+				// signers list shrinks, so the oldest recent signer is allowed to sign again
+				if limit := uint64(len(snap.Signers)/2 + 1); number >= limit {
+					delete(snap.Recents, number-limit)
+				}
+				// discard any votes of the deauthorized signer
+				for i := 0; i < len(snap.Votes); i++ {
+					if snap.Votes[i].Signer == header.Coinbase {
+						snap.uncast(snap.Votes[i].Address, snap.Votes[i].Authorize)
+						snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+						i--
+					}
+				}
+			}
+			// discard any previous votes around the just changed account
+			for i := 0; i < len(snap.Votes); i++ {
+				if snap.Votes[i].Address == header.Coinbase {
+					snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+					i--
+				}
+			}
+			delete(snap.Tally, header.Coinbase)
+		}
+	}
+	snap.Number += uint64(len(headers))
+	snap.Hash = headers[len(headers)-1].Hash()
+
+	return snap, nil
+}
+
+// signers returns authorized signers in ascending order
+func (s *Snapshot) signers() []common.Address {
+	signers := make([]common.Address, 0, len(s.Signers))
+	for signer := range s.Signers {
+		signers = append(signers, signer)
+	}
+	sort.Slice(signers, func(i, j int) bool {
+		return bytes.Compare(signers[i][:], signers[j][:]) < 0
+	})
+	return signers
+}
+
+// inturn returns whether the signer is the one expected to sign the block
+func (s *Snapshot) inturn(number uint64, signer common.Address) bool {
+	signers := s.signers()
+	return len(signers) > 0 && signers[number%uint64(len(signers))] == signer
+}
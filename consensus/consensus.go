@@ -0,0 +1,71 @@
+package consensus
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+)
+
+var (
+	ErrUnknownAncestor = errors.New("unknown ancestor")
+	ErrFutureBlock     = errors.New("block in the future")
+	// ErrInvalidMessage is returned for malformed or wrongly signed consensus messages of peers
+	ErrInvalidMessage = errors.New("invalid consensus message")
+)
+
+// ChainHeaderReader gives engines access to the local chain headers
+type ChainHeaderReader interface {
+	Config() *params.ChainConfig
+	CurrentHeader() *types.Header
+	GetHeader(hash common.Hash, number uint64) *types.Header
+	GetHeaderByNumber(number uint64) *types.Header
+	GetHeaderByHash(hash common.Hash) *types.Header
+}
+
+// Engine is an algorithm agnostic consensus engine
+type Engine interface {
+	// Author returns address of the account, which produced the block
+	Author(header *types.Header) (common.Address, error)
+
+	// VerifyHeader checks header conforms the consensus rules, including its seal.
+	// Parent header must be known to the chain
+	VerifyHeader(chain ChainHeaderReader, header *types.Header) error
+
+	// Prepare initializes consensus fields of the header, e.g. difficulty
+	Prepare(chain ChainHeaderReader, header *types.Header) error
+
+	// Finalize applies post-transaction state modifications, e.g. block rewards,
+	// sets the state root and assembles the block ready to be sealed
+	Finalize(chain ChainHeaderReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error)
+
+	// Seal generates a sealing request for the block and pushes the result into the channel.
+	// It returns immediately, sealing is done asynchronously until stop channel is closed
+	Seal(chain ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error
+
+	// APIs returns RPC services provided by the engine
+	APIs(chain ChainHeaderReader) []rpc.API
+
+	// Close terminates background threads of the engine
+	Close() error
+}
+
+// Sealer is implemented by engines, which pay fees of the block to the account sealing it
+// instead of the header coinbase, e.g. clique uses the coinbase for signer voting
+type Sealer interface {
+	Engine
+	// SealerAddress returns address of the local account, which seals produced blocks
+	SealerAddress() common.Address
+}
+
+// InstantFinality is implemented by engines, which blocks are irreversible once committed.
+// Every imported block carries its own commit seals, so the chain rejects blocks forking off
+// the committed ones. The same proposal may be committed with another set of seals, so such
+// a block is accepted in place of the committed one
+type InstantFinality interface {
+	Engine
+	// ProposalHash returns hash of the header without its commit seals
+	ProposalHash(header *types.Header) common.Hash
+}
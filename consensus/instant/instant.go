@@ -0,0 +1,62 @@
+package instant
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// Engine seals blocks instantly without any proof, it is meant for development networks only
+type Engine struct{}
+
+func New() *Engine {
+	return &Engine{}
+}
+
+// Author returns the block coinbase
+func (e *Engine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader accepts any header, as blocks are not sealed
+func (e *Engine) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header) error {
+	return nil
+}
+
+func (e *Engine) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Difficulty = big.NewInt(1)
+	return nil
+}
+
+// Finalize issues the block reward to the coinbase, sets the state root and assembles the block
+func (e *Engine) Finalize(chain consensus.ChainHeaderReader, header *types.Header, statedb *state.StateDB, txs []*types.Transaction, receipts []*types.Receipt) (*types.Block, error) {
+	if config := chain.Config().Rewards; config != nil {
+		rewards.Accumulate(config, statedb, header, header.Coinbase)
+	}
+	header.Root = statedb.IntermediateRoot(chain.Config().IsEIP158(header.Number, header.Time))
+	header.UncleHash = types.EmptyUncleHash
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+func (e *Engine) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	go func() {
+		select {
+		case results <- block:
+		case <-stop:
+		}
+	}()
+	return nil
+}
+
+func (e *Engine) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
+}
+
+func (e *Engine) Close() error {
+	return nil
+}
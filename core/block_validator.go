@@ -0,0 +1,112 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"time"
+)
+
+// allowedFutureBlockTime is the max time from current time allowed for blocks, before they're considered future blocks
+const allowedFutureBlockTime = 15 * time.Second
+
+var (
+	ErrKnownBlock       = errors.New("block already known")
+	ErrUnknownAncestor  = consensus.ErrUnknownAncestor
+	ErrPrunedAncestor   = errors.New("pruned ancestor")
+	ErrFutureBlock      = consensus.ErrFutureBlock
+	ErrInvalidNumber    = errors.New("invalid block number")
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+	ErrInvalidUncles    = errors.New("uncles are not allowed")
+)
+
+// BlockValidator checks headers, bodies and resulting state of imported blocks
+type BlockValidator struct {
+	config *params.ChainConfig
+}
+
+func NewBlockValidator(config *params.ChainConfig) *BlockValidator {
+	return &BlockValidator{
+		config: config,
+	}
+}
+
+// ValidateHeader checks header fields derived from the parent block
+func (v *BlockValidator) ValidateHeader(header, parent *types.Header) error {
+	if header.Number.Uint64() != parent.Number.Uint64()+1 {
+		return fmt.Errorf("%w: have %d, parent %d", ErrInvalidNumber, header.Number, parent.Number)
+	}
+	if header.Time <= parent.Time {
+		return fmt.Errorf("%w: have %d, parent %d", ErrInvalidTimestamp, header.Time, parent.Time)
+	}
+	if header.Time > uint64(time.Now().Add(allowedFutureBlockTime).Unix()) {
+		return ErrFutureBlock
+	}
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gas used: have %d, gas limit %d", header.GasUsed, header.GasLimit)
+	}
+	if header.UncleHash != types.EmptyUncleHash {
+		return ErrInvalidUncles
+	}
+	if header.Difficulty == nil || header.Difficulty.Sign() <= 0 {
+		return fmt.Errorf("invalid difficulty: %v", header.Difficulty)
+	}
+
+	if !v.config.IsLondon(header.Number, header.Time) {
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid base fee before London: %d", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := VerifyEIP1559Header(v.config, parent, header); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateBody checks block transactions and uncles match the header
+func (v *BlockValidator) ValidateBody(block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return ErrInvalidUncles
+	}
+	if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != block.TxHash() {
+		return fmt.Errorf("transaction root hash mismatch: have %s, want %s", hash, block.TxHash())
+	}
+	return nil
+}
+
+// ValidateReceipts checks receipts of the block, which is not executed, match the header
+func (v *BlockValidator) ValidateReceipts(block *types.Block, receipts types.Receipts) error {
+	if bloom := types.CreateBloom(receipts); bloom != block.Bloom() {
+		return fmt.Errorf("invalid bloom: remote %x, local %x", block.Bloom(), bloom)
+	}
+	if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != block.ReceiptHash() {
+		return fmt.Errorf("invalid receipt root hash: remote %s, local %s", block.ReceiptHash(), hash)
+	}
+	return nil
+}
+
+// ValidateState checks the result of block execution matches the header
+func (v *BlockValidator) ValidateState(block *types.Block, statedb *state.StateDB, receipts types.Receipts, usedGas uint64) error {
+	header := block.Header()
+	if block.GasUsed() != usedGas {
+		return fmt.Errorf("invalid gas used: remote %d, local %d", block.GasUsed(), usedGas)
+	}
+	if bloom := types.CreateBloom(receipts); bloom != header.Bloom {
+		return fmt.Errorf("invalid bloom: remote %x, local %x", header.Bloom, bloom)
+	}
+	if hash := types.DeriveSha(receipts, trie.NewStackTrie(nil)); hash != header.ReceiptHash {
+		return fmt.Errorf("invalid receipt root hash: remote %s, local %s", header.ReceiptHash, hash)
+	}
+	if root := statedb.IntermediateRoot(true); header.Root != root {
+		return fmt.Errorf("invalid merkle root: remote %s, local %s", header.Root, root)
+	}
+	return nil
+}
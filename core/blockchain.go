@@ -0,0 +1,650 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage"
+	"go.uber.org/zap"
+	"math/big"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrChainStopped  = errors.New("blockchain is stopped")
+	ErrCommittedFork = errors.New("block forks committed chain")
+
+	errReorgAncestor = errors.New("invalid reorg: common ancestor not found")
+)
+
+// BlockChain validates, executes and stores blocks, tracking the canonical chain by total difficulty.
+// Blocks of side chains are stored too, so the canonical chain is switched as soon as
+// a side chain becomes heavier
+type BlockChain struct {
+	config *params.ChainConfig
+	logger *zap.SugaredLogger
+
+	db      ethdb.Database
+	store   storage.Storage
+	stateDb *state.Database
+
+	genesis      *types.Block
+	currentBlock atomic.Value // *types.Block
+
+	engine    consensus.Engine
+	validator *BlockValidator
+	processor *StateProcessor
+	vmConfig  vm.Config
+
+	chainFeed     event.Feed
+	chainSideFeed event.Feed
+	chainHeadFeed event.Feed
+	logsFeed      event.Feed
+	rmLogsFeed    event.Feed
+	scope         event.SubscriptionScope
+
+	lock    sync.Mutex // protects chain insertion
+	stopped int32
+}
+
+// NewBlockChain returns chain stored in the storage, writing genesis into empty storage.
+// Storage driver must support raw key value access to keep chain indexes and state
+func NewBlockChain(store storage.Storage, genesis *Genesis, engine consensus.Engine, logger *zap.SugaredLogger) (*BlockChain, error) {
+	kv, err := storage.KeyValueStore(store)
+	if err != nil {
+		return nil, fmt.Errorf("chain storage: %w", err)
+	}
+
+	db := rawdb.NewDatabase(kv)
+	stateDb := state.NewDatabase(kv)
+	config, genesisBlock, err := SetupGenesisBlock(db, stateDb, genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := &BlockChain{
+		config:    config,
+		logger:    logger,
+		db:        db,
+		store:     store,
+		stateDb:   stateDb,
+		genesis:   genesisBlock,
+		engine:    engine,
+		validator: NewBlockValidator(config),
+	}
+	bc.processor = NewStateProcessor(config, bc)
+
+	head := rawdb.ReadHeadBlock(db)
+	if head == nil {
+		head = genesisBlock
+	}
+	bc.currentBlock.Store(head)
+
+	logger.Infow("Loaded blockchain", "genesis", genesisBlock.Hash(),
+		"number", head.NumberU64(), "hash", head.Hash(), "chain_id", config.ChainID)
+
+	return bc, nil
+}
+
+func (bc *BlockChain) Config() *params.ChainConfig {
+	return bc.config
+}
+
+func (bc *BlockChain) Genesis() *types.Block {
+	return bc.genesis
+}
+
+func (bc *BlockChain) Engine() consensus.Engine {
+	return bc.engine
+}
+
+func (bc *BlockChain) Validator() *BlockValidator {
+	return bc.validator
+}
+
+func (bc *BlockChain) Processor() *StateProcessor {
+	return bc.processor
+}
+
+// StateDatabase returns database used to open states of the chain blocks
+func (bc *BlockChain) StateDatabase() *state.Database {
+	return bc.stateDb
+}
+
+// CurrentBlock returns the head of the canonical chain
+func (bc *BlockChain) CurrentBlock() *types.Block {
+	return bc.currentBlock.Load().(*types.Block)
+}
+
+func (bc *BlockChain) CurrentHeader() *types.Header {
+	return bc.CurrentBlock().Header()
+}
+
+func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
+	return rawdb.HasBody(bc.db, hash, number) && rawdb.HasHeader(bc.db, hash, number)
+}
+
+func (bc *BlockChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(bc.db, hash, number)
+}
+
+func (bc *BlockChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	number := rawdb.ReadHeaderNumber(bc.db, hash)
+	if number == nil {
+		return nil
+	}
+	return bc.GetHeader(hash, *number)
+}
+
+func (bc *BlockChain) GetHeaderByNumber(number uint64) *types.Header {
+	hash := rawdb.ReadCanonicalHash(bc.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetHeader(hash, number)
+}
+
+func (bc *BlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return rawdb.ReadBlock(bc.db, hash, number)
+}
+
+func (bc *BlockChain) GetBlockByHash(hash common.Hash) *types.Block {
+	number := rawdb.ReadHeaderNumber(bc.db, hash)
+	if number == nil {
+		return nil
+	}
+	return bc.GetBlock(hash, *number)
+}
+
+func (bc *BlockChain) GetBlockByNumber(number uint64) *types.Block {
+	hash := rawdb.ReadCanonicalHash(bc.db, number)
+	if hash == (common.Hash{}) {
+		return nil
+	}
+	return bc.GetBlock(hash, number)
+}
+
+// GetCanonicalHash returns the hash of canonical block by number
+func (bc *BlockChain) GetCanonicalHash(number uint64) common.Hash {
+	return rawdb.ReadCanonicalHash(bc.db, number)
+}
+
+// GetTd returns total difficulty of the chain up to the specified block
+func (bc *BlockChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	return rawdb.ReadTd(bc.db, hash, number)
+}
+
+func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
+	number := rawdb.ReadHeaderNumber(bc.db, hash)
+	if number == nil {
+		return nil
+	}
+	return rawdb.ReadReceipts(bc.db, hash, *number, bc.config.EthConfig())
+}
+
+// GetTransaction returns canonical transaction with its block hash, number and index
+func (bc *BlockChain) GetTransaction(hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64) {
+	return rawdb.ReadTransaction(bc.db, hash)
+}
+
+// State returns state of the canonical head
+func (bc *BlockChain) State() (*state.StateDB, error) {
+	return bc.StateAt(bc.CurrentBlock().Root())
+}
+
+// StateAt returns state at the specified root
+func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
+	return bc.stateDb.Open(root)
+}
+
+// InsertChain validates, executes and writes blocks in order, returning index of failed block with error.
+// Blocks are either appended to the canonical chain, or stored as a side chain, which
+// becomes canonical once it has higher total difficulty
+func (bc *BlockChain) InsertChain(blocks types.Blocks) (int, error) {
+	if atomic.LoadInt32(&bc.stopped) == 1 {
+		return 0, ErrChainStopped
+	}
+
+	if i, err := checkContiguous(blocks); err != nil {
+		return i, err
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for i, block := range blocks {
+		if err := bc.insertBlock(block); err != nil {
+			if errors.Is(err, ErrKnownBlock) {
+				continue
+			}
+			bc.logger.Warnw("Unable to import block", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+			return i, err
+		}
+	}
+
+	return len(blocks), nil
+}
+
+// InsertReceiptChain writes blocks with their downloaded receipts without executing them, as snap sync does below
+// the pivot block. Blocks extend the canonical chain, the one with its state present becomes the head
+func (bc *BlockChain) InsertReceiptChain(blocks types.Blocks, receipts []types.Receipts) (int, error) {
+	if atomic.LoadInt32(&bc.stopped) == 1 {
+		return 0, ErrChainStopped
+	}
+	if len(blocks) != len(receipts) {
+		return 0, fmt.Errorf("%d blocks with %d receipt lists", len(blocks), len(receipts))
+	}
+	if i, err := checkContiguous(blocks); err != nil {
+		return i, err
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	for i, block := range blocks {
+		if bc.HasBlock(block.Hash(), block.NumberU64()) {
+			continue
+		}
+		if err := bc.writeReceiptBlock(block, receipts[i]); err != nil {
+			bc.logger.Warnw("Unable to write block", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+			return i, err
+		}
+	}
+
+	return len(blocks), nil
+}
+
+func (bc *BlockChain) writeReceiptBlock(block *types.Block, receipts types.Receipts) error {
+	number := block.NumberU64()
+	parent := bc.GetHeader(block.ParentHash(), number-1)
+	if parent == nil || bc.GetCanonicalHash(number-1) != parent.Hash() {
+		return fmt.Errorf("%w: canonical %s", ErrUnknownAncestor, block.ParentHash())
+	}
+	if err := bc.validator.ValidateHeader(block.Header(), parent); err != nil {
+		return err
+	}
+	if err := bc.engine.VerifyHeader(bc, block.Header()); err != nil {
+		return err
+	}
+	if err := bc.validator.ValidateBody(block); err != nil {
+		return err
+	}
+	if err := bc.validator.ValidateReceipts(block, receipts); err != nil {
+		return err
+	}
+
+	td := new(big.Int).Add(block.Difficulty(), bc.GetTd(parent.Hash(), number-1))
+	batch := bc.db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), number, td)
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), number, receipts)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), number)
+	rawdb.WriteTxLookupEntriesByBlock(batch, block)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	logs := bc.collectLogs(block, false)
+	bc.indexBlock(block, logs)
+	if !bc.stateDb.HasState(block.Root()) {
+		return nil
+	}
+
+	if err := bc.writeHeadBlock(block); err != nil {
+		return err
+	}
+	bc.logger.Infow("Inserted block with downloaded state", "number", number, "hash", block.Hash(), "root", block.Root())
+
+	bc.chainFeed.Send(ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
+	bc.chainHeadFeed.Send(ChainHeadEvent{Block: block})
+	return nil
+}
+
+func (bc *BlockChain) insertBlock(block *types.Block) error {
+	if bc.HasBlock(block.Hash(), block.NumberU64()) {
+		return ErrKnownBlock
+	}
+
+	receipts, logs, statedb, err := bc.processBlock(block, true)
+	if err != nil {
+		return err
+	}
+	return bc.writeBlockWithState(block, receipts, logs, statedb)
+}
+
+// ValidateBlock checks the block could be inserted on top of its parent, without writing it.
+// Engine seals are not verified, as proposals are validated before they are sealed
+func (bc *BlockChain) ValidateBlock(block *types.Block) error {
+	_, _, _, err := bc.processBlock(block, false)
+	return err
+}
+
+// processBlock validates and executes the block on top of its parent state
+func (bc *BlockChain) processBlock(block *types.Block, verifySeal bool) (types.Receipts, []*types.Log, *state.StateDB, error) {
+	parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrUnknownAncestor, block.ParentHash())
+	}
+	if err := bc.validator.ValidateHeader(block.Header(), parent.Header()); err != nil {
+		return nil, nil, nil, err
+	}
+	if verifySeal {
+		if err := bc.engine.VerifyHeader(bc, block.Header()); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if err := bc.validator.ValidateBody(block); err != nil {
+		return nil, nil, nil, err
+	}
+
+	statedb, err := bc.StateAt(parent.Root())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrPrunedAncestor, err)
+	}
+
+	receipts, logs, usedGas, err := bc.processor.Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// engine specific state modifications, e.g. block rewards
+	if _, err := bc.engine.Finalize(bc, block.Header(), statedb, block.Transactions(), receipts); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := bc.validator.ValidateState(block, statedb, receipts, usedGas); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return receipts, logs, statedb, nil
+}
+
+// WriteBlockWithState writes already executed block, e.g. produced locally, and updates the head
+func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts types.Receipts, logs []*types.Log, statedb *state.StateDB) error {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	return bc.writeBlockWithState(block, receipts, logs, statedb)
+}
+
+func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts types.Receipts, logs []*types.Log, statedb *state.StateDB) error {
+	if bc.forksCommitted(block) {
+		return fmt.Errorf("%w: %d %s", ErrCommittedFork, block.NumberU64(), block.Hash())
+	}
+
+	parentTd := bc.GetTd(block.ParentHash(), block.NumberU64()-1)
+	if parentTd == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAncestor, block.ParentHash())
+	}
+	externTd := new(big.Int).Add(block.Difficulty(), parentTd)
+
+	if _, err := statedb.CommitBlock(true); err != nil {
+		return err
+	}
+
+	batch := bc.db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), block.NumberU64(), externTd)
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	current := bc.CurrentBlock()
+	localTd := bc.GetTd(current.Hash(), current.NumberU64())
+
+	// fork choice: heaviest chain wins, shorter one is preferred on equal difficulty
+	reorg := externTd.Cmp(localTd) > 0
+	if !reorg && externTd.Cmp(localTd) == 0 {
+		reorg = block.NumberU64() < current.NumberU64()
+	}
+	if !reorg {
+		bc.logger.Debugw("Inserted side chain block", "number", block.NumberU64(), "hash", block.Hash(), "td", externTd)
+		bc.chainSideFeed.Send(ChainSideEvent{Block: block})
+		return nil
+	}
+
+	if block.ParentHash() != current.Hash() {
+		if err := bc.reorg(current, block); err != nil {
+			return err
+		}
+	}
+	if err := bc.writeHeadBlock(block); err != nil {
+		return err
+	}
+
+	bc.indexBlock(block, logs)
+	bc.logger.Debugw("Inserted new block", "number", block.NumberU64(), "hash", block.Hash(),
+		"txs", len(block.Transactions()), "gas", block.GasUsed())
+
+	bc.chainFeed.Send(ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
+	if len(logs) > 0 {
+		bc.logsFeed.Send(logs)
+	}
+	bc.chainHeadFeed.Send(ChainHeadEvent{Block: block})
+
+	return nil
+}
+
+// forksCommitted tells if the block replaces a canonical block of the instant finality engine
+// with another proposal. The same proposal committed with other seals is not a fork
+func (bc *BlockChain) forksCommitted(block *types.Block) bool {
+	engine, final := bc.engine.(consensus.InstantFinality)
+	if !final || block.NumberU64() > bc.CurrentBlock().NumberU64() {
+		return false
+	}
+	committed := bc.GetHeaderByNumber(block.NumberU64())
+	return committed != nil && engine.ProposalHash(committed) != engine.ProposalHash(block.Header())
+}
+
+// writeHeadBlock makes block the canonical head
+func (bc *BlockChain) writeHeadBlock(block *types.Block) error {
+	batch := bc.db.NewBatch()
+	rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+	rawdb.WriteTxLookupEntriesByBlock(batch, block)
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	bc.currentBlock.Store(block)
+	return nil
+}
+
+// indexBlock saves canonical block data through the storage driver for search and analytics
+func (bc *BlockChain) indexBlock(block *types.Block, logs []*types.Log) {
+	ctx := context.Background()
+	if err := bc.store.SaveBlock(ctx, block); err != nil {
+		bc.logger.Warnw("Unable to index block", "number", block.NumberU64(), "err", err)
+	}
+	for _, tx := range block.Transactions() {
+		if err := bc.store.SaveTx(ctx, tx); err != nil {
+			bc.logger.Warnw("Unable to index transaction", "hash", tx.Hash(), "err", err)
+		}
+	}
+	for _, log := range logs {
+		if err := bc.store.SaveEvent(ctx, log); err != nil {
+			bc.logger.Warnw("Unable to index event", "tx", log.TxHash, "index", log.Index, "err", err)
+		}
+	}
+}
+
+// unindexBlock removes block data of the no longer canonical block from the storage driver
+func (bc *BlockChain) unindexBlock(block *types.Block) {
+	ctx := context.Background()
+	for _, log := range bc.collectLogs(block, true) {
+		if err := bc.store.DeleteEvent(ctx, log.TxHash, log.Index); err != nil {
+			bc.logger.Warnw("Unable to remove event from index", "tx", log.TxHash, "index", log.Index, "err", err)
+		}
+	}
+	for _, tx := range block.Transactions() {
+		if err := bc.store.DeleteTx(ctx, tx.Hash()); err != nil {
+			bc.logger.Warnw("Unable to remove transaction from index", "hash", tx.Hash(), "err", err)
+		}
+	}
+	if err := bc.store.DeleteBlock(ctx, block.Hash()); err != nil {
+		bc.logger.Warnw("Unable to remove block from index", "number", block.NumberU64(), "err", err)
+	}
+}
+
+// checkContiguous returns the index of the first block not following the previous one
+func checkContiguous(blocks types.Blocks) (int, error) {
+	for i := 1; i < len(blocks); i++ {
+		if blocks[i].NumberU64() != blocks[i-1].NumberU64()+1 || blocks[i].ParentHash() != blocks[i-1].Hash() {
+			return i, fmt.Errorf("non contiguous insert: item %d is #%d [%s], item %d is #%d [%s] (parent [%s])",
+				i-1, blocks[i-1].NumberU64(), blocks[i-1].Hash(), i, blocks[i].NumberU64(), blocks[i].Hash(), blocks[i].ParentHash())
+		}
+	}
+	return 0, nil
+}
+
+// reorg rolls canonical index back from the old head to the common ancestor
+// and forward to the parent of the new head, which is written by the caller
+func (bc *BlockChain) reorg(oldHead, newHead *types.Block) error {
+	var (
+		oldChain    types.Blocks
+		newChain    types.Blocks
+		oldBlock    = oldHead
+		newBlock    = bc.GetBlock(newHead.ParentHash(), newHead.NumberU64()-1)
+		deletedLogs []*types.Log
+	)
+	if newBlock == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAncestor, newHead.ParentHash())
+	}
+
+	for oldBlock.NumberU64() > newBlock.NumberU64() {
+		oldChain = append(oldChain, oldBlock)
+		if oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1); oldBlock == nil {
+			return errReorgAncestor
+		}
+	}
+	for newBlock.NumberU64() > oldBlock.NumberU64() {
+		newChain = append(newChain, newBlock)
+		if newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1); newBlock == nil {
+			return errReorgAncestor
+		}
+	}
+	for oldBlock.Hash() != newBlock.Hash() {
+		oldChain = append(oldChain, oldBlock)
+		newChain = append(newChain, newBlock)
+
+		oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1)
+		newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1)
+		if oldBlock == nil || newBlock == nil {
+			return errReorgAncestor
+		}
+	}
+	ancestor := oldBlock
+
+	bc.logger.Infow("Chain reorg detected", "ancestor", ancestor.NumberU64(), "hash", ancestor.Hash(),
+		"drop", len(oldChain), "add", len(newChain)+1, "from", oldHead.Hash(), "to", newHead.Hash())
+
+	for _, block := range oldChain {
+		deletedLogs = append(deletedLogs, bc.collectLogs(block, true)...)
+	}
+
+	batch := bc.db.NewBatch()
+	// roll back: drop canonical hashes and tx lookups of the old chain
+	for _, block := range oldChain {
+		for _, tx := range block.Transactions() {
+			rawdb.DeleteTxLookupEntry(batch, tx.Hash())
+		}
+	}
+	for number := ancestor.NumberU64() + 1; number <= oldHead.NumberU64(); number++ {
+		rawdb.DeleteCanonicalHash(batch, number)
+	}
+	// roll forward: new chain blocks become canonical in ascending order
+	var rebirthLogs []*types.Log
+	newLogs := make([][]*types.Log, len(newChain))
+	for i := len(newChain) - 1; i >= 0; i-- {
+		block := newChain[i]
+		rawdb.WriteCanonicalHash(batch, block.Hash(), block.NumberU64())
+		rawdb.WriteTxLookupEntriesByBlock(batch, block)
+		newLogs[i] = bc.collectLogs(block, false)
+		rebirthLogs = append(rebirthLogs, newLogs[i]...)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	// storage index follows the canonical chain, transactions included by both chains are indexed again
+	for _, block := range oldChain {
+		bc.unindexBlock(block)
+	}
+	for i := len(newChain) - 1; i >= 0; i-- {
+		bc.indexBlock(newChain[i], newLogs[i])
+	}
+
+	if len(deletedLogs) > 0 {
+		bc.rmLogsFeed.Send(RemovedLogsEvent{Logs: deletedLogs})
+	}
+	if len(rebirthLogs) > 0 {
+		bc.logsFeed.Send(rebirthLogs)
+	}
+	for _, block := range oldChain {
+		bc.chainSideFeed.Send(ChainSideEvent{Block: block})
+	}
+
+	return nil
+}
+
+// collectLogs returns logs of the stored block receipts, marked as removed if required
+func (bc *BlockChain) collectLogs(block *types.Block, removed bool) []*types.Log {
+	var logs []*types.Log
+	receipts := rawdb.ReadReceipts(bc.db, block.Hash(), block.NumberU64(), bc.config.EthConfig())
+	for _, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			l := *log
+			l.Removed = removed
+			logs = append(logs, &l)
+		}
+	}
+	return logs
+}
+
+// SubscribeChainEvent registers a subscription of ChainEvent
+func (bc *BlockChain) SubscribeChainEvent(ch chan<- ChainEvent) event.Subscription {
+	return bc.scope.Track(bc.chainFeed.Subscribe(ch))
+}
+
+// SubscribeChainHeadEvent registers a subscription of ChainHeadEvent
+func (bc *BlockChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return bc.scope.Track(bc.chainHeadFeed.Subscribe(ch))
+}
+
+// SubscribeChainSideEvent registers a subscription of ChainSideEvent
+func (bc *BlockChain) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Subscription {
+	return bc.scope.Track(bc.chainSideFeed.Subscribe(ch))
+}
+
+// SubscribeLogsEvent registers a subscription of new canonical logs
+func (bc *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
+	return bc.scope.Track(bc.logsFeed.Subscribe(ch))
+}
+
+// SubscribeRemovedLogsEvent registers a subscription of RemovedLogsEvent
+func (bc *BlockChain) SubscribeRemovedLogsEvent(ch chan<- RemovedLogsEvent) event.Subscription {
+	return bc.scope.Track(bc.rmLogsFeed.Subscribe(ch))
+}
+
+// Stop stops accepting new blocks and closes all subscriptions
+func (bc *BlockChain) Stop() {
+	if !atomic.CompareAndSwapInt32(&bc.stopped, 0, 1) {
+		return
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.scope.Close()
+	bc.logger.Info("Blockchain stopped")
+}
@@ -0,0 +1,477 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/consensus/instant"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+func newTestGenesis() *Genesis {
+	return &Genesis{
+		Config:   params.DevChainConfig,
+		GasLimit: DefaultGenesisGasLimit,
+		Alloc: GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+}
+
+func newTestBlockChain(t *testing.T, genesis *Genesis) *BlockChain {
+	return newTestBlockChainWithEngine(t, genesis, instant.New())
+}
+
+func newTestBlockChainWithEngine(t *testing.T, genesis *Genesis, engine consensus.Engine) *BlockChain {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, _ := logutils.NewLogger()
+	store := badgerdb.NewDriver(db, logger)
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	bc, err := NewBlockChain(store, genesis, engine, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bc.Stop)
+
+	return bc
+}
+
+// newTestGenerator returns separate state database containing the genesis state to generate chains on
+func newTestGenerator(t *testing.T, genesis *Genesis) (*state.Database, *types.Block) {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	sdb := state.NewDatabase(badgerdb.NewDatabase(db, nil))
+	block, err := genesis.ToBlock(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sdb, block
+}
+
+func TestBlockChainInsert(t *testing.T) {
+	genesis := newTestGenesis()
+	bc := newTestBlockChain(t, genesis)
+	sdb, genesisBlock := newTestGenerator(t, genesis)
+	if genesisBlock.Hash() != bc.Genesis().Hash() {
+		t.Fatalf("genesis mismatch: have %s, want %s", bc.Genesis().Hash(), genesisBlock.Hash())
+	}
+
+	blocks, _, err := GenerateChain(bc.Config(), genesisBlock, sdb, 3, func(i int, b *BlockGen) {
+		tx := types.NewTransaction(b.TxNonce(tests.Account0), tests.Account1, big.NewInt(1000), 21_000, big.NewInt(1), nil)
+		b.AddTx(signTestTx(t, bc.Config(), tx))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heads := make(chan ChainHeadEvent, 3)
+	sub := bc.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %s", n, err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != blocks[2].Hash() {
+		t.Fatalf("unexpected head: have #%d, want #%d", head.NumberU64(), blocks[2].NumberU64())
+	}
+	if len(heads) != 3 {
+		t.Fatalf("unexpected number of head events: %d", len(heads))
+	}
+
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := statedb.GetBalance(tests.Account1); balance.Int64() != 3000 {
+		t.Fatalf("unexpected balance: %s", balance)
+	}
+	tx := blocks[1].Transactions()[0]
+	if _, blockHash, _, _ := bc.GetTransaction(tx.Hash()); blockHash != blocks[1].Hash() {
+		t.Fatalf("unexpected tx lookup block: %s", blockHash)
+	}
+
+	// already known blocks are skipped
+	if _, err := bc.InsertChain(blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered state root fails validation
+	bad, _, err := GenerateChain(bc.Config(), blocks[2], sdb, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := bad[0].Header()
+	header.Root = common.Hash{1}
+	if _, err := bc.InsertChain(types.Blocks{bad[0].WithSeal(header)}); err == nil {
+		t.Fatal("expected invalid state root error")
+	}
+}
+
+func TestBlockChainReorg(t *testing.T) {
+	genesis := newTestGenesis()
+	bc := newTestBlockChain(t, genesis)
+	sdb, genesisBlock := newTestGenerator(t, genesis)
+
+	contract := crypto.CreateAddress(tests.Account0, 0)
+	deploy, _, err := GenerateChain(bc.Config(), genesisBlock, sdb, 1, func(i int, b *BlockGen) {
+		b.AddTx(signTestTx(t, bc.Config(), types.NewContractCreation(0, nil, 100_000, big.NewInt(1), storeAndLogCode)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// canonical chain emits the contract log in block #2
+	short, _, err := GenerateChain(bc.Config(), deploy[0], sdb, 2, func(i int, b *BlockGen) {
+		if i == 0 {
+			tx := types.NewTransaction(1, contract, big.NewInt(0), 100_000, big.NewInt(1), common.HexToHash("0x2a").Bytes())
+			b.AddTx(signTestTx(t, bc.Config(), tx))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// side chain of the same length, which becomes heavier with its last block
+	heavy, _, err := GenerateChain(bc.Config(), deploy[0], sdb, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(tests.Account2)
+		if i == 1 {
+			b.SetDifficulty(big.NewInt(2))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.InsertChain(append(deploy, short...)); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := make(chan RemovedLogsEvent, 1)
+	rmSub := bc.SubscribeRemovedLogsEvent(removed)
+	defer rmSub.Unsubscribe()
+	sides := make(chan ChainSideEvent, 4)
+	sideSub := bc.SubscribeChainSideEvent(sides)
+	defer sideSub.Unsubscribe()
+
+	// first block of the side chain is lighter, so it stays on the side
+	if _, err := bc.InsertChain(heavy[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != short[1].Hash() {
+		t.Fatalf("unexpected head before reorg: %s", head.Hash())
+	}
+	if len(removed) != 0 {
+		t.Fatal("unexpected removed logs before reorg")
+	}
+
+	if _, err := bc.InsertChain(heavy[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != heavy[1].Hash() {
+		t.Fatalf("unexpected head after reorg: have %s, want %s", head.Hash(), heavy[1].Hash())
+	}
+	for i, block := range heavy {
+		if hash := bc.GetCanonicalHash(block.NumberU64()); hash != block.Hash() {
+			t.Fatalf("block %d is not canonical", i)
+		}
+	}
+	if tx, _, _, _ := bc.GetTransaction(short[0].Transactions()[0].Hash()); tx != nil {
+		t.Fatal("reorged transaction is still canonical")
+	}
+
+	select {
+	case ev := <-removed:
+		if len(ev.Logs) != 1 || !ev.Logs[0].Removed || ev.Logs[0].Address != contract {
+			t.Fatalf("unexpected removed logs: %+v", ev.Logs)
+		}
+	default:
+		t.Fatal("removed logs event is not sent")
+	}
+	// first heavy block, then two reorged blocks
+	if len(sides) != 3 {
+		t.Fatalf("unexpected number of side events: %d", len(sides))
+	}
+
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := statedb.GetState(contract, common.Hash{}); value != (common.Hash{}) {
+		t.Fatalf("reorged storage value is still set: %s", value)
+	}
+
+	// storage index follows the canonical chain
+	ctx := context.Background()
+	indexed, err := bc.store.SearchBlocks(ctx, storage.BlockFilter{FromNumber: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical := append(types.Blocks{deploy[0]}, heavy...)
+	if len(indexed) != len(canonical) {
+		t.Fatalf("unexpected number of indexed blocks: have %d, want %d", len(indexed), len(canonical))
+	}
+	for i, block := range indexed {
+		if block.Hash() != canonical[i].Hash() {
+			t.Fatalf("indexed block %d mismatch: have %s, want %s", i, block.Hash(), canonical[i].Hash())
+		}
+	}
+	for _, block := range short {
+		if _, err := bc.store.FindBlock(ctx, block.Hash()); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("reorged block %d is still indexed: %v", block.NumberU64(), err)
+		}
+	}
+	if _, err := bc.store.FindTx(ctx, short[0].Transactions()[0].Hash()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("reorged transaction is still indexed: %v", err)
+	}
+	if events, err := bc.store.SearchEvents(ctx, storage.EventFilter{}); err != nil || len(events) != 0 {
+		t.Fatalf("reorged events are still indexed: %v, %v", events, err)
+	}
+}
+
+// finalEngine is the development engine with instant finality, commit seals are kept in the extra-data
+type finalEngine struct {
+	*instant.Engine
+}
+
+func (finalEngine) ProposalHash(header *types.Header) common.Hash {
+	cpy := types.CopyHeader(header)
+	cpy.Extra = nil
+	return cpy.Hash()
+}
+
+func TestBlockChainInstantFinality(t *testing.T) {
+	genesis := newTestGenesis()
+	bc := newTestBlockChainWithEngine(t, genesis, finalEngine{instant.New()})
+	sdb, genesisBlock := newTestGenerator(t, genesis)
+
+	committed, _, err := GenerateChain(bc.Config(), genesisBlock, sdb, 2, func(i int, b *BlockGen) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// block #2 committed with other seals, and its child
+	resealed, _, err := GenerateChain(bc.Config(), committed[0], sdb, 2, func(i int, b *BlockGen) {
+		if i == 0 {
+			b.SetExtra([]byte("seals"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// heavier chain proposing another block #2
+	forked, _, err := GenerateChain(bc.Config(), committed[0], sdb, 3, func(i int, b *BlockGen) {
+		b.SetCoinbase(tests.Account2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bc.InsertChain(committed); err != nil {
+		t.Fatal(err)
+	}
+	// the same proposal with other seals is accepted
+	if _, err := bc.InsertChain(resealed); err != nil {
+		t.Fatal(err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != resealed[1].Hash() {
+		t.Fatalf("unexpected head: have #%d %s, want %s", head.NumberU64(), head.Hash(), resealed[1].Hash())
+	}
+
+	if _, err := bc.InsertChain(forked); !errors.Is(err, ErrCommittedFork) {
+		t.Fatalf("unexpected committed fork error: %v", err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != resealed[1].Hash() {
+		t.Fatalf("committed block is reorganized: head #%d %s", head.NumberU64(), head.Hash())
+	}
+	if hash := bc.GetCanonicalHash(2); hash != resealed[0].Hash() {
+		t.Fatalf("unexpected canonical block #2: %s", hash)
+	}
+}
+
+func TestBlockChainFeeMarket(t *testing.T) {
+	treasury := tests.Account9
+	config := *params.DevChainConfig
+	config.LondonBlock = big.NewInt(0)
+	config.FeeMarket = &params.FeeMarketConfig{
+		ElasticityMultiplier:     2,
+		BaseFeeChangeDenominator: 8,
+		InitialBaseFee:           big.NewInt(1000),
+		Treasury:                 &treasury,
+	}
+	genesis := newTestGenesis()
+	genesis.Config = &config
+	genesis.GasLimit = 100_000
+
+	bc := newTestBlockChain(t, genesis)
+	sdb, genesisBlock := newTestGenerator(t, genesis)
+	if baseFee := bc.Genesis().BaseFee(); baseFee == nil || baseFee.Int64() != 1000 {
+		t.Fatalf("unexpected genesis base fee: %v", baseFee)
+	}
+
+	// first block uses more gas than the target of 50000, second one is empty
+	var tips uint64
+	blocks, _, err := GenerateChain(bc.Config(), genesisBlock, sdb, 2, func(i int, b *BlockGen) {
+		b.SetCoinbase(tests.Account10)
+		for j := 0; i == 0 && j < 3; j++ {
+			tx := types.NewTx(&types.DynamicFeeTx{
+				ChainID:   config.ChainID,
+				Nonce:     b.TxNonce(tests.Account0),
+				GasTipCap: big.NewInt(5),
+				GasFeeCap: big.NewInt(2000),
+				Gas:       21_000,
+				To:        &tests.Account1,
+				Value:     big.NewInt(1),
+			})
+			b.AddTx(signTestTx(t, bc.Config(), tx))
+			tips += 5 * 21_000
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %s", n, err)
+	}
+
+	// base fee decreases by 1/8 after empty genesis, grows by 13000/50000/8 after block #1, then decreases again
+	if baseFee := blocks[0].BaseFee(); baseFee.Int64() != 875 {
+		t.Fatalf("unexpected base fee of block #1: %s", baseFee)
+	}
+	if baseFee := blocks[1].BaseFee(); baseFee.Int64() != 903 {
+		t.Fatalf("unexpected base fee of block #2: %s", baseFee)
+	}
+	if baseFee := CalcBaseFee(&config, blocks[1].Header()); baseFee.Int64() != 791 {
+		t.Fatalf("unexpected base fee of block #3: %s", baseFee)
+	}
+
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := statedb.GetBalance(treasury); balance.Int64() != 875*63_000 {
+		t.Fatalf("unexpected treasury balance: %s", balance)
+	}
+	if balance := statedb.GetBalance(tests.Account10); balance.Uint64() != tips {
+		t.Fatalf("unexpected producer tips: %s", balance)
+	}
+
+	bad, _, err := GenerateChain(bc.Config(), blocks[1], sdb, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := bad[0].Header()
+	header.BaseFee = big.NewInt(1)
+	if _, err := bc.InsertChain(types.Blocks{bad[0].WithSeal(header)}); !errors.Is(err, ErrInvalidBaseFee) {
+		t.Fatalf("unexpected invalid base fee error: %v", err)
+	}
+}
+
+func TestBlockChainRewards(t *testing.T) {
+	config := *params.DevChainConfig
+	config.LondonBlock = big.NewInt(0)
+	config.FeeMarket = &params.FeeMarketConfig{InitialBaseFee: big.NewInt(1000)}
+	config.Rewards = &params.RewardsConfig{
+		BlockReward:       big.NewInt(1000),
+		ReductionInterval: 2,
+		ReductionPercent:  50,
+		MinReward:         big.NewInt(300),
+		TreasuryPercent:   10,
+		Treasury:          tests.Account8,
+		CommunityPercent:  20,
+		CommunityPool:     tests.Account9,
+	}
+	genesis := newTestGenesis()
+	genesis.Config = &config
+
+	bc := newTestBlockChain(t, genesis)
+	sdb, genesisBlock := newTestGenerator(t, genesis)
+
+	// rewards are halved every two blocks down to the tail issuance: 1000, 500, 500, 300
+	blocks, _, err := GenerateChain(bc.Config(), genesisBlock, sdb, 4, func(i int, b *BlockGen) {
+		b.SetCoinbase(tests.Account10)
+		if i == 0 {
+			tx := types.NewTx(&types.DynamicFeeTx{
+				ChainID:   config.ChainID,
+				Nonce:     b.TxNonce(tests.Account0),
+				GasFeeCap: big.NewInt(1000),
+				Gas:       21_000,
+				To:        &tests.Account1,
+			})
+			b.AddTx(signTestTx(t, bc.Config(), tx))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %s", n, err)
+	}
+
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, expected := range map[common.Address]int64{
+		tests.Account8:  100 + 50 + 50 + 30,
+		tests.Account9:  200 + 100 + 100 + 60,
+		tests.Account10: 700 + 350 + 350 + 210,
+	} {
+		if balance := statedb.GetBalance(addr); balance.Int64() != expected {
+			t.Errorf("unexpected reward of %s: %s, expected %d", addr, balance, expected)
+		}
+	}
+
+	supply := rewards.GetSupply(statedb)
+	burned := blocks[0].BaseFee().Int64() * 21_000
+	if supply.Genesis.Int64() != 1e18 || supply.Issued.Int64() != 2300 || supply.Burned.Int64() != burned {
+		t.Fatalf("unexpected supply: genesis %s, issued %s, burned %s", supply.Genesis, supply.Issued, supply.Burned)
+	}
+	if supply.Total.Int64() != 1e18+2300-burned {
+		t.Fatalf("unexpected total supply: %s", supply.Total)
+	}
+}
+
+func TestGenesisInvalidRewards(t *testing.T) {
+	config := *params.DevChainConfig
+	config.Rewards = &params.RewardsConfig{
+		BlockReward:      big.NewInt(1000),
+		TreasuryPercent:  60,
+		CommunityPercent: 50,
+	}
+	genesis := newTestGenesis()
+	genesis.Config = &config
+
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	store := badgerdb.NewDriver(db, logger)
+	defer store.Close()
+
+	if _, err := NewBlockChain(store, genesis, instant.New(), logger); !errors.Is(err, params.ErrInvalidRewards) {
+		t.Fatalf("unexpected invalid rewards error: %v", err)
+	}
+}
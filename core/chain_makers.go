@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// BlockGen creates blocks for GenerateChain, it is used in tests and tooling
+type BlockGen struct {
+	i       int
+	parent  *types.Block
+	chain   []*types.Block
+	header  *types.Header
+	statedb *state.StateDB
+
+	gasPool  *gethcore.GasPool
+	txs      []*types.Transaction
+	receipts []*types.Receipt
+
+	config *params.ChainConfig
+}
+
+// SetCoinbase sets the coinbase of the generated block, it can be called at most once
+func (b *BlockGen) SetCoinbase(addr common.Address) {
+	if b.gasPool != nil {
+		if len(b.txs) > 0 {
+			panic("coinbase must be set before adding transactions")
+		}
+		panic("coinbase can only be set once")
+	}
+	b.header.Coinbase = addr
+	b.gasPool = new(gethcore.GasPool).AddGas(b.header.GasLimit)
+}
+
+func (b *BlockGen) SetExtra(data []byte) {
+	b.header.Extra = data
+}
+
+func (b *BlockGen) SetDifficulty(diff *big.Int) {
+	b.header.Difficulty = diff
+}
+
+// AddTx executes transaction and adds it to the generated block, panicking if it can not be applied
+func (b *BlockGen) AddTx(tx *types.Transaction) {
+	if b.gasPool == nil {
+		b.SetCoinbase(common.Address{})
+	}
+	b.statedb.Prepare(tx.Hash(), len(b.txs))
+	receipt, err := ApplyTransaction(b.config, nil, b.header.Coinbase, b.gasPool, b.statedb, b.header, tx, &b.header.GasUsed, vm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	b.txs = append(b.txs, tx)
+	b.receipts = append(b.receipts, receipt)
+}
+
+// BaseFee returns base fee of the generated block, nil before London
+func (b *BlockGen) BaseFee() *big.Int {
+	if b.header.BaseFee == nil {
+		return nil
+	}
+	return new(big.Int).Set(b.header.BaseFee)
+}
+
+func (b *BlockGen) Number() *big.Int {
+	return new(big.Int).Set(b.header.Number)
+}
+
+func (b *BlockGen) PrevBlock(index int) *types.Block {
+	if index >= b.i {
+		panic(fmt.Errorf("block index %d out of range (%d,%d)", index, -1, b.i))
+	}
+	if index == -1 {
+		return b.parent
+	}
+	return b.chain[index]
+}
+
+func (b *BlockGen) TxNonce(addr common.Address) uint64 {
+	return b.statedb.GetNonce(addr)
+}
+
+// GenerateChain creates a chain of n blocks on top of parent, calling gen to fill each one.
+// Block states are committed to sdb, which must contain the parent state
+func GenerateChain(config *params.ChainConfig, parent *types.Block, sdb *state.Database, n int, gen func(int, *BlockGen)) ([]*types.Block, []types.Receipts, error) {
+	blocks, receipts := make(types.Blocks, n), make([]types.Receipts, n)
+	for i := 0; i < n; i++ {
+		statedb, err := sdb.Open(parent.Root())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		b := &BlockGen{
+			i:       i,
+			parent:  parent,
+			chain:   blocks,
+			statedb: statedb,
+			config:  config,
+			header: &types.Header{
+				ParentHash: parent.Hash(),
+				Number:     new(big.Int).Add(parent.Number(), common.Big1),
+				Time:       parent.Time() + 10,
+				GasLimit:   parent.GasLimit(),
+				Difficulty: big.NewInt(1),
+			},
+		}
+		if config.IsLondon(b.header.Number, b.header.Time) {
+			b.header.BaseFee = CalcBaseFee(config, parent.Header())
+		}
+		if gen != nil {
+			gen(i, b)
+		}
+		// block reward is issued to the coinbase, as engines producing unsigned blocks do in Finalize
+		if config.Rewards != nil {
+			rewards.Accumulate(config.Rewards, statedb, b.header, b.header.Coinbase)
+		}
+
+		root, err := statedb.CommitBlock(true)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.header.Root = root
+
+		block := types.NewBlock(b.header, b.txs, nil, b.receipts, trie.NewStackTrie(nil))
+		blocks[i], receipts[i] = block, b.receipts
+		parent = block
+	}
+
+	return blocks, receipts, nil
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/params"
+	"math/big"
+)
+
+var ErrInvalidBaseFee = errors.New("invalid base fee")
+
+// VerifyEIP1559Header checks the gas limit and base fee of the London header,
+// elasticity and base fee change bound are taken from the chain config
+func VerifyEIP1559Header(config *params.ChainConfig, parent, header *types.Header) error {
+	parentGasLimit := parent.GasLimit
+	if !config.IsLondon(parent.Number, parent.Time) {
+		parentGasLimit = parent.GasLimit * config.ElasticityMultiplier()
+	}
+	if err := misc.VerifyGaslimit(parentGasLimit, header.GasLimit); err != nil {
+		return err
+	}
+
+	if header.BaseFee == nil {
+		return fmt.Errorf("%w: header is missing base fee", ErrInvalidBaseFee)
+	}
+	if expected := CalcBaseFee(config, parent); header.BaseFee.Cmp(expected) != 0 {
+		return fmt.Errorf("%w: have %s, want %s, parent base fee %s, parent gas used %d",
+			ErrInvalidBaseFee, header.BaseFee, expected, parent.BaseFee, parent.GasUsed)
+	}
+	return nil
+}
+
+// CalcBaseFee returns base fee of the block following the parent. Base fee grows if parent used more gas
+// than the target, which is gas limit divided by elasticity, and decreases otherwise
+func CalcBaseFee(config *params.ChainConfig, parent *types.Header) *big.Int {
+	if !config.IsLondon(parent.Number, parent.Time) || parent.BaseFee == nil {
+		return config.InitialBaseFee()
+	}
+
+	parentGasTarget := parent.GasLimit / config.ElasticityMultiplier()
+	if parentGasTarget == 0 || parent.GasUsed == parentGasTarget {
+		return new(big.Int).Set(parent.BaseFee)
+	}
+
+	var (
+		delta       = new(big.Int)
+		target      = new(big.Int).SetUint64(parentGasTarget)
+		denominator = new(big.Int).SetUint64(config.BaseFeeChangeDenominator())
+	)
+	if parent.GasUsed > parentGasTarget {
+		delta.SetUint64(parent.GasUsed - parentGasTarget)
+		delta.Mul(delta, parent.BaseFee)
+		delta.Div(delta, target)
+		delta.Div(delta, denominator)
+		return delta.Add(parent.BaseFee, math.BigMax(delta, common.Big1))
+	}
+
+	delta.SetUint64(parentGasTarget - parent.GasUsed)
+	delta.Mul(delta, parent.BaseFee)
+	delta.Div(delta, target)
+	delta.Div(delta, denominator)
+	return math.BigMax(delta.Sub(parent.BaseFee, delta), common.Big0)
+}
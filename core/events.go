@@ -0,0 +1,34 @@
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ChainEvent is posted when a block is inserted into the canonical chain
+type ChainEvent struct {
+	Block *types.Block
+	Hash  common.Hash
+	Logs  []*types.Log
+}
+
+// ChainSideEvent is posted when a block is inserted into a side chain,
+// or a canonical block is moved to a side chain by reorg
+type ChainSideEvent struct {
+	Block *types.Block
+}
+
+// ChainHeadEvent is posted when the canonical head changes
+type ChainHeadEvent struct {
+	Block *types.Block
+}
+
+// RemovedLogsEvent is posted when logs are removed from the canonical chain by reorg
+type RemovedLogsEvent struct {
+	Logs []*types.Log
+}
+
+// NewTxsEvent is posted when transactions enter the transaction pool as executable
+type NewTxsEvent struct {
+	Txs []*types.Transaction
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/rovergulf/chain/consensus"
+	"math/big"
+	"time"
+)
+
+// ChainContext provides access to the chain headers and consensus engine during transaction execution
+type ChainContext interface {
+	// Engine returns the consensus engine used to determine the block author
+	Engine() consensus.Engine
+
+	// GetHeader returns the header corresponding to the hash and number
+	GetHeader(hash common.Hash, number uint64) *types.Header
+}
+
+// NewEVMBlockContext creates EVM context of the block produced by author
+func NewEVMBlockContext(header *types.Header, chain ChainContext, author common.Address) vm.BlockContext {
+	var baseFee *big.Int
+	if header.BaseFee != nil {
+		baseFee = new(big.Int).Set(header.BaseFee)
+	}
+
+	return vm.BlockContext{
+		CanTransfer: gethcore.CanTransfer,
+		Transfer:    gethcore.Transfer,
+		GetHash:     GetHashFn(header, chain),
+		Coinbase:    author,
+		BlockNumber: new(big.Int).Set(header.Number),
+		Time:        new(big.Int).SetUint64(header.Time),
+		Difficulty:  new(big.Int).Set(header.Difficulty),
+		BaseFee:     baseFee,
+		GasLimit:    header.GasLimit,
+	}
+}
+
+// GetHashFn returns BLOCKHASH opcode handler walking back from the header parent
+func GetHashFn(ref *types.Header, chain ChainContext) vm.GetHashFunc {
+	// cache is initialized on the first call
+	var cache []common.Hash
+
+	return func(n uint64) common.Hash {
+		if len(cache) == 0 {
+			cache = append(cache, ref.ParentHash)
+		}
+		if idx := ref.Number.Uint64() - n - 1; idx < uint64(len(cache)) {
+			return cache[idx]
+		}
+
+		lastKnownHash := cache[len(cache)-1]
+		lastKnownNumber := ref.Number.Uint64() - uint64(len(cache))
+		for {
+			header := chain.GetHeader(lastKnownHash, lastKnownNumber)
+			if header == nil {
+				break
+			}
+			cache = append(cache, header.ParentHash)
+			lastKnownHash = header.ParentHash
+			lastKnownNumber = header.Number.Uint64() - 1
+			if n == lastKnownNumber {
+				return lastKnownHash
+			}
+		}
+		return common.Hash{}
+	}
+}
+
+// deployGuard records the first contract created by a nested call of the transaction,
+// which sender may not create contracts. Events are passed to the configured tracer
+type deployGuard struct {
+	vm.EVMLogger
+	created *common.Address
+}
+
+func newDeployGuard(cfg vm.Config) *deployGuard {
+	if cfg.Debug && cfg.Tracer != nil {
+		return &deployGuard{EVMLogger: cfg.Tracer}
+	}
+	return &deployGuard{EVMLogger: noopLogger{}}
+}
+
+func (g *deployGuard) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if (typ == vm.CREATE || typ == vm.CREATE2) && g.created == nil {
+		g.created = &to
+	}
+	g.EVMLogger.CaptureEnter(typ, from, to, input, gas, value)
+}
+
+// noopLogger is the EVM tracer doing nothing
+type noopLogger struct{}
+
+func (noopLogger) CaptureTxStart(uint64) {}
+
+func (noopLogger) CaptureTxEnd(uint64) {}
+
+func (noopLogger) CaptureStart(*vm.EVM, common.Address, common.Address, bool, []byte, uint64, *big.Int) {
+}
+
+func (noopLogger) CaptureEnd([]byte, uint64, time.Duration, error) {}
+
+func (noopLogger) CaptureEnter(vm.OpCode, common.Address, common.Address, []byte, uint64, *big.Int) {}
+
+func (noopLogger) CaptureExit([]byte, uint64, error) {}
+
+func (noopLogger) CaptureState(uint64, vm.OpCode, uint64, uint64, *vm.ScopeContext, []byte, int, error) {
+}
+
+func (noopLogger) CaptureFault(uint64, vm.OpCode, uint64, uint64, *vm.ScopeContext, int, error) {}
@@ -0,0 +1,182 @@
+package forkid
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/params"
+	"hash/crc32"
+	"math"
+	"sort"
+)
+
+// timestampThreshold separates block numbers from timestamps in the next fork field of remote IDs
+const timestampThreshold = 1438269973
+
+var (
+	// ErrRemoteStale is returned if the remote fork checksum is a subset of local forks,
+	// but the remote node does not know about the next local fork
+	ErrRemoteStale = errors.New("remote needs update")
+	// ErrLocalIncompatibleOrStale is returned if the remote fork checksum is not compatible with the local chain,
+	// or the remote node announces the fork already passed locally without it
+	ErrLocalIncompatibleOrStale = errors.New("local incompatible or needs update")
+)
+
+// ID is the EIP-2124 fork identifier advertised in the handshake, timestamp forks are included as in EIP-6122
+type ID struct {
+	Hash [4]byte // CRC32 checksum of the genesis hash and passed fork blocks and timestamps
+	Next uint64  // block number or timestamp of the next fork, 0 if no forks are scheduled
+}
+
+// Filter validates the remote fork ID against the local chain
+type Filter func(id ID) error
+
+// Blockchain is the local chain the fork ID is calculated of
+type Blockchain interface {
+	Config() *params.ChainConfig
+	Genesis() *types.Block
+	CurrentHeader() *types.Header
+}
+
+// NewID returns the fork ID of the chain at the head block number and timestamp
+func NewID(config *params.ChainConfig, genesis *types.Block, head, time uint64) ID {
+	hash := crc32.ChecksumIEEE(genesis.Hash().Bytes())
+
+	forksByBlock, forksByTime := gatherForks(config, genesis.Time())
+	for _, fork := range forksByBlock {
+		if fork <= head {
+			hash = checksumUpdate(hash, fork)
+			continue
+		}
+		return ID{Hash: checksumToBytes(hash), Next: fork}
+	}
+	for _, fork := range forksByTime {
+		if fork <= time {
+			hash = checksumUpdate(hash, fork)
+			continue
+		}
+		return ID{Hash: checksumToBytes(hash), Next: fork}
+	}
+	return ID{Hash: checksumToBytes(hash)}
+}
+
+// NewIDWithChain returns the fork ID of the chain current head
+func NewIDWithChain(chain Blockchain) ID {
+	head := chain.CurrentHeader()
+	return NewID(chain.Config(), chain.Genesis(), head.Number.Uint64(), head.Time)
+}
+
+// NewFilter returns the filter of remote fork IDs validated against the chain current head
+func NewFilter(chain Blockchain) Filter {
+	return newFilter(chain.Config(), chain.Genesis(), func() (uint64, uint64) {
+		head := chain.CurrentHeader()
+		return head.Number.Uint64(), head.Time
+	})
+}
+
+// NewStaticFilter returns the filter of remote fork IDs validated against the genesis
+func NewStaticFilter(config *params.ChainConfig, genesis *types.Block) Filter {
+	return newFilter(config, genesis, func() (uint64, uint64) {
+		return 0, 0
+	})
+}
+
+func newFilter(config *params.ChainConfig, genesis *types.Block, headfn func() (uint64, uint64)) Filter {
+	forksByBlock, forksByTime := gatherForks(config, genesis.Time())
+	forks := append(append([]uint64{}, forksByBlock...), forksByTime...)
+
+	// sums[i] is the checksum of the genesis and first i forks
+	sums := make([][4]byte, len(forks)+1)
+	hash := crc32.ChecksumIEEE(genesis.Hash().Bytes())
+	sums[0] = checksumToBytes(hash)
+	for i, fork := range forks {
+		hash = checksumUpdate(hash, fork)
+		sums[i+1] = checksumToBytes(hash)
+	}
+	// sentry fork is never passed, so the loop below always stops at some fork
+	forks = append(forks, math.MaxUint64)
+	if len(forksByTime) == 0 {
+		forksByBlock = append(forksByBlock, math.MaxUint64)
+	}
+
+	return func(id ID) error {
+		block, time := headfn()
+		for i, fork := range forks {
+			head := block
+			if i >= len(forksByBlock) {
+				head = time
+			}
+			if head >= fork {
+				continue
+			}
+
+			// local and remote nodes are on the same fork, remote next fork must not be passed locally
+			if sums[i] == id.Hash {
+				if id.Next > 0 && (head >= id.Next || (id.Next > timestampThreshold && time >= id.Next)) {
+					return ErrLocalIncompatibleOrStale
+				}
+				return nil
+			}
+			// remote node is behind, it must know the next fork following its checksum
+			for j := 0; j < i; j++ {
+				if sums[j] == id.Hash {
+					if forks[j] != id.Next {
+						return ErrRemoteStale
+					}
+					return nil
+				}
+			}
+			// local node is behind, remote checksum must include forks known locally
+			for j := i + 1; j < len(sums); j++ {
+				if sums[j] == id.Hash {
+					return nil
+				}
+			}
+			return ErrLocalIncompatibleOrStale
+		}
+		return nil
+	}
+}
+
+// gatherForks returns sorted unique fork block numbers and timestamps, skipping forks active since genesis
+func gatherForks(config *params.ChainConfig, genesisTime uint64) ([]uint64, []uint64) {
+	var forksByBlock, forksByTime []uint64
+	for _, fork := range config.Forks() {
+		switch {
+		case fork.Block != nil:
+			if fork.Block.Sign() > 0 {
+				forksByBlock = append(forksByBlock, fork.Block.Uint64())
+			}
+		case fork.Time != nil:
+			if *fork.Time > genesisTime {
+				forksByTime = append(forksByTime, *fork.Time)
+			}
+		}
+	}
+	return unique(forksByBlock), unique(forksByTime)
+}
+
+func unique(forks []uint64) []uint64 {
+	sort.Slice(forks, func(i, j int) bool {
+		return forks[i] < forks[j]
+	})
+	for i := 1; i < len(forks); i++ {
+		if forks[i] == forks[i-1] {
+			forks = append(forks[:i], forks[i+1:]...)
+			i--
+		}
+	}
+	return forks
+}
+
+func checksumUpdate(hash uint32, fork uint64) uint32 {
+	var blob [8]byte
+	binary.BigEndian.PutUint64(blob[:], fork)
+	return crc32.Update(hash, crc32.IEEETable, blob[:])
+}
+
+func checksumToBytes(hash uint32) [4]byte {
+	var blob [4]byte
+	binary.BigEndian.PutUint32(blob[:], hash)
+	return blob
+}
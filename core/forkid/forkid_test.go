@@ -0,0 +1,65 @@
+package forkid
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/params"
+	"hash/crc32"
+	"math/big"
+	"testing"
+)
+
+// newTestConfig returns config with Berlin at block 10 and London at timestamp 2000
+func newTestConfig() (*params.ChainConfig, *types.Block) {
+	config := *params.DevChainConfig
+	config.BerlinBlock = big.NewInt(10)
+	londonTime := uint64(2000)
+	config.LondonTime = &londonTime
+	return &config, types.NewBlockWithHeader(&types.Header{Number: new(big.Int)})
+}
+
+func TestNewID(t *testing.T) {
+	config, genesis := newTestConfig()
+	genesisSum := crc32.ChecksumIEEE(genesis.Hash().Bytes())
+	berlinSum := checksumUpdate(genesisSum, 10)
+	londonSum := checksumUpdate(berlinSum, 2000)
+
+	for _, tt := range []struct {
+		head, time uint64
+		want       ID
+	}{
+		{0, 0, ID{Hash: checksumToBytes(genesisSum), Next: 10}},
+		{9, 1999, ID{Hash: checksumToBytes(genesisSum), Next: 10}},
+		{10, 1000, ID{Hash: checksumToBytes(berlinSum), Next: 2000}},
+		{20, 2000, ID{Hash: checksumToBytes(londonSum)}},
+	} {
+		if id := NewID(config, genesis, tt.head, tt.time); id != tt.want {
+			t.Errorf("unexpected fork id at %d/%d: have %x/%d, want %x/%d", tt.head, tt.time, id.Hash, id.Next, tt.want.Hash, tt.want.Next)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	config, genesis := newTestConfig()
+	filter := newFilter(config, genesis, func() (uint64, uint64) {
+		return 15, 1500
+	})
+
+	for _, tt := range []struct {
+		name string
+		id   ID
+		err  error
+	}{
+		{"same fork", NewID(config, genesis, 15, 1500), nil},
+		{"remote syncing", NewID(config, genesis, 5, 500), nil},
+		{"local syncing", NewID(config, genesis, 30, 3000), nil},
+		{"remote stale", ID{Hash: NewID(config, genesis, 0, 0).Hash}, ErrRemoteStale},
+		{"remote fork passed locally", ID{Hash: NewID(config, genesis, 15, 1500).Hash, Next: 12}, ErrLocalIncompatibleOrStale},
+		{"remote timestamp fork ahead", ID{Hash: NewID(config, genesis, 15, 1500).Hash, Next: 1438269974}, nil},
+		{"other chain", ID{Hash: [4]byte{1, 2, 3, 4}}, ErrLocalIncompatibleOrStale},
+	} {
+		if err := filter(tt.id); !errors.Is(err, tt.err) {
+			t.Errorf("%s: unexpected filter error: have %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
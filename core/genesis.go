@@ -0,0 +1,257 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+	"os"
+)
+
+const DefaultGenesisGasLimit uint64 = 30_000_000
+
+var (
+	ErrGenesisNoConfig = errors.New("genesis has no chain configuration")
+	ErrGenesisMismatch = errors.New("database contains incompatible genesis")
+
+	chainConfigPrefix = []byte("carrack-config-") // chainConfigPrefix + genesis hash -> json encoded chain config
+)
+
+// GenesisAccount is an account in the state of the genesis block
+type GenesisAccount struct {
+	Balance *big.Int                    `json:"balance" yaml:"balance"`
+	Nonce   uint64                      `json:"nonce,omitempty" yaml:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty" yaml:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty" yaml:"storage,omitempty"`
+}
+
+// GenesisAlloc specifies the initial state of the genesis block
+type GenesisAlloc map[common.Address]GenesisAccount
+
+// Genesis specifies the header fields and state of the genesis block, as well as chain config
+type Genesis struct {
+	Config     *params.ChainConfig `json:"config" yaml:"config"`
+	Timestamp  uint64              `json:"timestamp" yaml:"timestamp"`
+	ExtraData  hexutil.Bytes       `json:"extra_data" yaml:"extra_data"`
+	GasLimit   uint64              `json:"gas_limit" yaml:"gas_limit"`
+	Difficulty *big.Int            `json:"difficulty" yaml:"difficulty"`
+	Coinbase   common.Address      `json:"coinbase" yaml:"coinbase"`
+	Alloc      GenesisAlloc        `json:"alloc" yaml:"alloc"`
+}
+
+// DefaultGenesisBlock returns the genesis of the network by id, unknown ids use development config
+func DefaultGenesisBlock(networkId uint64) *Genesis {
+	config := params.DevChainConfig
+	switch networkId {
+	case params.MainNetworkId:
+		config = params.MainnetChainConfig
+	case params.TestNetworkId:
+		config = params.TestnetChainConfig
+	}
+
+	return &Genesis{
+		Config:     config,
+		GasLimit:   DefaultGenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Alloc:      GenesisAlloc{},
+	}
+}
+
+// ReadGenesis reads json encoded genesis from file
+func ReadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := new(Genesis)
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, fmt.Errorf("invalid genesis file: %w", err)
+	}
+	if err := genesis.validate(); err != nil {
+		return nil, err
+	}
+
+	return genesis, nil
+}
+
+// validate checks the chain config of the genesis
+func (g *Genesis) validate() error {
+	if g.Config == nil {
+		return ErrGenesisNoConfig
+	}
+	if g.Config.Rewards != nil {
+		if err := g.Config.Rewards.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToBlock applies genesis alloc to the state, commits it and returns the genesis block
+func (g *Genesis) ToBlock(sdb *state.Database) (*types.Block, error) {
+	statedb, err := sdb.Open(types.EmptyRootHash)
+	if err != nil {
+		return nil, err
+	}
+
+	supply := new(big.Int)
+	for addr, account := range g.Alloc {
+		if account.Balance != nil {
+			statedb.AddBalance(addr, account.Balance)
+			supply.Add(supply, account.Balance)
+		}
+		statedb.SetCode(addr, account.Code)
+		statedb.SetNonce(addr, account.Nonce)
+		for key, value := range account.Storage {
+			statedb.SetState(addr, key, value)
+		}
+	}
+	if g.Config != nil && g.Config.Rewards != nil {
+		rewards.SetGenesisSupply(statedb, supply)
+	}
+	if g.Config != nil && g.Config.Permissions != nil {
+		if err := permissions.Setup(g.Config.Permissions, statedb); err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := statedb.CommitBlock(false)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &types.Header{
+		Number:     new(big.Int),
+		Time:       g.Timestamp,
+		Extra:      g.ExtraData,
+		GasLimit:   g.GasLimit,
+		Difficulty: g.Difficulty,
+		Coinbase:   g.Coinbase,
+		Root:       root,
+	}
+	if header.GasLimit == 0 {
+		header.GasLimit = DefaultGenesisGasLimit
+	}
+	if header.Difficulty == nil {
+		header.Difficulty = big.NewInt(1)
+	}
+	if g.Config != nil && g.Config.IsLondon(header.Number, header.Time) {
+		header.BaseFee = g.Config.InitialBaseFee()
+	}
+
+	return types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil)), nil
+}
+
+// Commit writes genesis block, its state and chain config into database as the canonical head
+func (g *Genesis) Commit(db ethdb.Database, sdb *state.Database) (*types.Block, error) {
+	if err := g.validate(); err != nil {
+		return nil, err
+	}
+
+	block, err := g.ToBlock(sdb)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := db.NewBatch()
+	rawdb.WriteTd(batch, block.Hash(), 0, block.Difficulty())
+	rawdb.WriteBlock(batch, block)
+	rawdb.WriteReceipts(batch, block.Hash(), 0, nil)
+	rawdb.WriteCanonicalHash(batch, block.Hash(), 0)
+	rawdb.WriteHeadBlockHash(batch, block.Hash())
+	rawdb.WriteHeadHeaderHash(batch, block.Hash())
+	if err := WriteChainConfig(batch, block.Hash(), g.Config); err != nil {
+		return nil, err
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// SetupGenesisBlock writes genesis into empty database, or checks it matches the stored one.
+// Stored chain config is returned, unless genesis is provided, so config can be upgraded with forks not passed yet
+func SetupGenesisBlock(db ethdb.Database, sdb *state.Database, genesis *Genesis) (*params.ChainConfig, *types.Block, error) {
+	stored := rawdb.ReadCanonicalHash(db, 0)
+	if stored == (common.Hash{}) {
+		if genesis == nil {
+			return nil, nil, ErrGenesisNoConfig
+		}
+		block, err := genesis.Commit(db, sdb)
+		if err != nil {
+			return nil, nil, err
+		}
+		return genesis.Config, block, nil
+	}
+
+	block := rawdb.ReadBlock(db, stored, 0)
+	if block == nil {
+		return nil, nil, fmt.Errorf("stored genesis block %s is missing", stored)
+	}
+
+	if genesis != nil {
+		if err := genesis.validate(); err != nil {
+			return nil, nil, err
+		}
+		expected, err := genesis.ToBlock(sdb)
+		if err != nil {
+			return nil, nil, err
+		}
+		if expected.Hash() != stored {
+			return nil, nil, fmt.Errorf("%w: have %s, new %s", ErrGenesisMismatch, stored, expected.Hash())
+		}
+		if config, err := ReadChainConfig(db, stored); err == nil {
+			head := rawdb.ReadHeadHeader(db)
+			if head == nil {
+				head = block.Header()
+			}
+			if err := config.CheckCompatible(genesis.Config, head.Number, head.Time); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err := WriteChainConfig(db, stored, genesis.Config); err != nil {
+			return nil, nil, err
+		}
+		return genesis.Config, block, nil
+	}
+
+	config, err := ReadChainConfig(db, stored)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, block, nil
+}
+
+func WriteChainConfig(db ethdb.KeyValueWriter, hash common.Hash, config *params.ChainConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return db.Put(append(append([]byte{}, chainConfigPrefix...), hash.Bytes()...), data)
+}
+
+func ReadChainConfig(db ethdb.KeyValueReader, hash common.Hash) (*params.ChainConfig, error) {
+	data, _ := db.Get(append(append([]byte{}, chainConfigPrefix...), hash.Bytes()...))
+	if len(data) == 0 {
+		return nil, ErrGenesisNoConfig
+	}
+
+	config := new(params.ChainConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
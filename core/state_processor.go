@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/staking"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// StateProcessor applies block transactions to the state, moving it from one point to another
+type StateProcessor struct {
+	config *params.ChainConfig
+	chain  ChainContext
+}
+
+func NewStateProcessor(config *params.ChainConfig, chain ChainContext) *StateProcessor {
+	return &StateProcessor{
+		config: config,
+		chain:  chain,
+	}
+}
+
+// Process executes all block transactions and returns their receipts, logs and used gas.
+// Any transaction which could not be applied makes the whole block invalid
+func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	var (
+		receipts types.Receipts
+		allLogs  []*types.Log
+		usedGas  = new(uint64)
+		header   = block.Header()
+		gp       = new(gethcore.GasPool).AddGas(block.GasLimit())
+	)
+
+	// fees are paid to the block author, which is not always the coinbase, e.g. for proof-of-authority
+	author, err := p.chain.Engine().Author(header)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), i)
+		receipt, err := ApplyTransaction(p.config, p.chain, author, gp, statedb, header, tx, usedGas, cfg)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("could not apply tx %d [%s]: %w", i, tx.Hash().Hex(), err)
+		}
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+
+	return receipts, allLogs, *usedGas, nil
+}
+
+// ApplyTransaction executes transaction on top of the state and returns its receipt.
+// Failed execution (e.g. reverted call) still produces a receipt with failed status,
+// while an error means the transaction can not be included into the block at all
+func ApplyTransaction(config *params.ChainConfig, chain ChainContext, author common.Address, gp *gethcore.GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, error) {
+	ethConfig := config.EthConfigAt(header.Number, header.Time)
+	msg, err := tx.AsMessage(types.MakeSigner(ethConfig, header.Number), header.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+
+	var guard *deployGuard
+	if config.Permissions != nil {
+		if err := permissions.Check(config.Permissions, statedb, msg.From(), msg.To()); err != nil {
+			return nil, err
+		}
+		// contracts created by factories are caught during the execution
+		allowed, err := permissions.CanDeploy(config.Permissions, statedb, msg.From())
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			guard = newDeployGuard(cfg)
+			cfg.Debug, cfg.Tracer = true, guard
+		}
+	}
+
+	blockContext := NewEVMBlockContext(header, chain, author)
+	evm := vm.NewEVM(blockContext, gethcore.NewEVMTxContext(msg), statedb, ethConfig, cfg)
+
+	// intrinsic gas, nonce and balance checks, value transfer, refunds and fees are handled here
+	result, err := gethcore.ApplyMessage(evm, msg, gp)
+	if err != nil {
+		return nil, err
+	}
+	if guard != nil && guard.created != nil {
+		// the transaction is not included, so its gas is returned to the block
+		gp.AddGas(result.UsedGas)
+		return nil, fmt.Errorf("%w: %s created contract %s", permissions.ErrDeployerNotAllowed, msg.From(), *guard.created)
+	}
+	failed := result.Failed()
+	if !failed && config.Staking != nil && msg.To() != nil && *msg.To() == staking.Address {
+		failed = !applyStakingCall(config.Staking, statedb, header, msg)
+	}
+	if !failed && config.Permissions != nil && msg.To() != nil && *msg.To() == permissions.Address {
+		failed = !applyPermissionsCall(statedb, header, msg)
+	}
+	// base fee is burned by the EVM, unless the treasury receives it
+	if header.BaseFee != nil {
+		fee := new(big.Int).Mul(header.BaseFee, new(big.Int).SetUint64(result.UsedGas))
+		if treasury := config.FeeTreasury(); treasury != nil {
+			statedb.AddBalance(*treasury, fee)
+		} else if config.Rewards != nil {
+			rewards.AddBurned(statedb, fee)
+		}
+	}
+
+	var root []byte
+	if ethConfig.IsByzantium(header.Number) {
+		statedb.Finalise(true)
+	} else {
+		root = statedb.IntermediateRoot(ethConfig.IsEIP158(header.Number)).Bytes()
+	}
+	*usedGas += result.UsedGas
+
+	receipt := &types.Receipt{
+		Type:              tx.Type(),
+		PostState:         root,
+		CumulativeGasUsed: *usedGas,
+		TxHash:            tx.Hash(),
+		GasUsed:           result.UsedGas,
+		BlockHash:         header.Hash(),
+		BlockNumber:       header.Number,
+		TransactionIndex:  uint(statedb.TxIndex()),
+	}
+	if failed {
+		receipt.Status = types.ReceiptStatusFailed
+	} else {
+		receipt.Status = types.ReceiptStatusSuccessful
+	}
+	if msg.To() == nil {
+		receipt.ContractAddress = crypto.CreateAddress(msg.From(), tx.Nonce())
+	}
+	receipt.Logs = statedb.GetLogs(tx.Hash(), receipt.BlockHash)
+	receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+
+	return receipt, nil
+}
+
+// applyStakingCall executes the staking operation of the message, which value is transferred to the staking account.
+// Failed operation keeps the fees paid, but reverts its changes and returns the value to the sender
+func applyStakingCall(config *params.StakingConfig, statedb *state.StateDB, header *types.Header, msg types.Message) bool {
+	snapshot := statedb.Snapshot()
+	if err := staking.ApplyCall(config, statedb, header.Number.Uint64(), msg.From(), msg.Value(), msg.Data()); err != nil {
+		statedb.RevertToSnapshot(snapshot)
+		statedb.SubBalance(staking.Address, msg.Value())
+		statedb.AddBalance(msg.From(), msg.Value())
+		return false
+	}
+	return true
+}
+
+// applyPermissionsCall executes the allowlist operation of the admin message.
+// Failed operation keeps the fees paid, but reverts its changes and returns the value to the sender
+func applyPermissionsCall(statedb *state.StateDB, header *types.Header, msg types.Message) bool {
+	snapshot := statedb.Snapshot()
+	if err := permissions.ApplyCall(statedb, header.Number.Uint64(), msg.From(), msg.Value(), msg.Data()); err != nil {
+		statedb.RevertToSnapshot(snapshot)
+		statedb.SubBalance(permissions.Address, msg.Value())
+		statedb.AddBalance(msg.From(), msg.Value())
+		return false
+	}
+	return true
+}
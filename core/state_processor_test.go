@@ -0,0 +1,271 @@
+package core
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/consensus/instant"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/staking"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+// storeAndLogCode deploys contract which stores first calldata word into slot 0 and emits LOG1 with 0xaa topic
+var storeAndLogCode = hexutil.MustDecode("0x600e600c600039600e6000f3" + "600035600055" + "60aa60006000a100")
+
+// factoryCode is the contract code which creates an empty contract on every call
+var factoryCode = hexutil.MustDecode("0x600060006000f05000")
+
+type fakeChain struct{}
+
+func (fakeChain) Engine() consensus.Engine {
+	return instant.New()
+}
+
+func (fakeChain) GetHeader(common.Hash, uint64) *types.Header {
+	return nil
+}
+
+func newTestState(t *testing.T) *state.StateDB {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	statedb, err := state.NewDatabase(badgerdb.NewDatabase(db, nil)).Open(types.EmptyRootHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.SetBalance(tests.Account0, big.NewInt(1e18))
+	return statedb
+}
+
+func signTestTx(t *testing.T, config *params.ChainConfig, tx *types.Transaction) *types.Transaction {
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := types.SignTx(tx, types.LatestSigner(config.EthConfig()), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestStateProcessorContract(t *testing.T) {
+	config := params.DevChainConfig
+	statedb := newTestState(t)
+
+	gasPrice := big.NewInt(1)
+	deploy := signTestTx(t, config, types.NewContractCreation(0, nil, 100_000, gasPrice, storeAndLogCode))
+	contract := crypto.CreateAddress(tests.Account0, 0)
+	call := signTestTx(t, config, types.NewTransaction(1, contract, big.NewInt(0), 100_000, gasPrice, common.HexToHash("0x2a").Bytes()))
+	// not enough gas to store the value, so execution fails, but tx is still included
+	failed := signTestTx(t, config, types.NewTransaction(2, contract, big.NewInt(0), 21_500, gasPrice, common.HexToHash("0x2b").Bytes()))
+	transfer := signTestTx(t, config, types.NewTransaction(3, tests.Account1, big.NewInt(1000), 21_000, gasPrice, nil))
+
+	header := &types.Header{
+		Number:     big.NewInt(1),
+		GasLimit:   10_000_000,
+		Difficulty: common.Big1,
+		Coinbase:   tests.Account10,
+	}
+	block := types.NewBlockWithHeader(header).WithBody(types.Transactions{deploy, call, failed, transfer}, nil)
+
+	receipts, logs, usedGas, err := NewStateProcessor(config, fakeChain{}).Process(block, statedb, vm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if receipts[0].ContractAddress != contract || receipts[0].Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("unexpected deploy receipt: %+v", receipts[0])
+	}
+	if receipts[1].Status != types.ReceiptStatusSuccessful || len(logs) != 1 {
+		t.Fatalf("unexpected call receipt: %+v", receipts[1])
+	}
+	if logs[0].Address != contract || logs[0].Topics[0] != common.HexToHash("0xaa") || logs[0].TxHash != call.Hash() {
+		t.Fatalf("unexpected log: %+v", logs[0])
+	}
+	if receipts[2].Status != types.ReceiptStatusFailed || receipts[2].GasUsed != 21_500 {
+		t.Fatalf("unexpected failed call receipt: %+v", receipts[2])
+	}
+	if receipts[3].CumulativeGasUsed != usedGas {
+		t.Fatalf("cumulative gas %d does not match used gas %d", receipts[3].CumulativeGasUsed, usedGas)
+	}
+
+	if value := statedb.GetState(contract, common.Hash{}); value != common.HexToHash("0x2a") {
+		t.Fatalf("unexpected stored value: %s", value)
+	}
+	if balance := statedb.GetBalance(tests.Account1); balance.Int64() != 1000 {
+		t.Fatalf("unexpected recipient balance: %s", balance)
+	}
+	fees := new(big.Int).Mul(new(big.Int).SetUint64(usedGas), gasPrice)
+	if balance := statedb.GetBalance(tests.Account10); balance.Cmp(fees) != 0 {
+		t.Fatalf("unexpected coinbase fees: have %s, want %s", balance, fees)
+	}
+	if nonce := statedb.GetNonce(tests.Account0); nonce != 4 {
+		t.Fatalf("unexpected sender nonce: %d", nonce)
+	}
+}
+
+func TestStateProcessorInvalidTx(t *testing.T) {
+	config := params.DevChainConfig
+	statedb := newTestState(t)
+
+	// nonce is too high
+	tx := signTestTx(t, config, types.NewTransaction(5, tests.Account1, big.NewInt(1), 21_000, big.NewInt(1), nil))
+	header := &types.Header{Number: big.NewInt(1), GasLimit: 10_000_000, Difficulty: common.Big1}
+	block := types.NewBlockWithHeader(header).WithBody(types.Transactions{tx}, nil)
+
+	if _, _, _, err := NewStateProcessor(config, fakeChain{}).Process(block, statedb, vm.Config{}); err == nil {
+		t.Fatal("expected invalid nonce error")
+	}
+}
+
+func TestStateProcessorStaking(t *testing.T) {
+	config := *params.DevChainConfig
+	config.Staking = &params.StakingConfig{MinDelegation: big.NewInt(100), UnbondingPeriod: 10}
+	statedb := newTestState(t)
+
+	bond, err := staking.EncodeCall(&staking.Call{Op: staking.OpBond, Commission: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// delegation to unknown validator fails, the value is returned
+	delegate, err := staking.EncodeCall(&staking.Call{Op: staking.OpDelegate, Validator: tests.Account1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasPrice := big.NewInt(1)
+	txs := types.Transactions{
+		signTestTx(t, &config, types.NewTransaction(0, staking.Address, big.NewInt(1000), 50_000, gasPrice, bond)),
+		signTestTx(t, &config, types.NewTransaction(1, staking.Address, big.NewInt(500), 50_000, gasPrice, delegate)),
+	}
+
+	header := &types.Header{
+		Number:     big.NewInt(1),
+		GasLimit:   10_000_000,
+		Difficulty: common.Big1,
+		Coinbase:   tests.Account10,
+	}
+	block := types.NewBlockWithHeader(header).WithBody(txs, nil)
+
+	receipts, _, _, err := NewStateProcessor(&config, fakeChain{}).Process(block, statedb, vm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipts[0].Status != types.ReceiptStatusSuccessful || receipts[1].Status != types.ReceiptStatusFailed {
+		t.Fatalf("unexpected receipt statuses: %d, %d", receipts[0].Status, receipts[1].Status)
+	}
+	if balance := statedb.GetBalance(staking.Address); balance.Int64() != 1000 {
+		t.Fatalf("unexpected bonded balance: %s", balance)
+	}
+
+	registry, err := staking.Load(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := registry.Validator(tests.Account0); v == nil || v.Stake().Int64() != 1000 || v.Commission != 5 {
+		t.Fatalf("unexpected registered validator: %+v", v)
+	}
+}
+
+func TestStateProcessorPermissions(t *testing.T) {
+	config := *params.DevChainConfig
+	config.Permissions = &params.PermissionsConfig{
+		Admins:            []common.Address{tests.Account0},
+		RestrictSenders:   true,
+		RestrictDeployers: true,
+	}
+	statedb := newTestState(t)
+	statedb.SetBalance(tests.Account1, big.NewInt(1e18))
+	if err := permissions.Setup(config.Permissions, statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	addSender, err := permissions.EncodeCall(&permissions.Call{Op: permissions.OpAddSender, Account: tests.Account1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gasPrice := big.NewInt(1)
+	header := &types.Header{
+		Number:     big.NewInt(1),
+		GasLimit:   10_000_000,
+		Difficulty: common.Big1,
+		Coinbase:   tests.Account10,
+	}
+	block := types.NewBlockWithHeader(header).WithBody(types.Transactions{
+		signTestTx(t, &config, types.NewTransaction(0, permissions.Address, new(big.Int), 50_000, gasPrice, addSender)),
+	}, nil)
+
+	receipts, _, _, err := NewStateProcessor(&config, fakeChain{}).Process(block, statedb, vm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipts[0].Status != types.ReceiptStatusSuccessful || len(receipts[0].Logs) != 1 {
+		t.Fatalf("unexpected admin call receipt: status %d, %d logs", receipts[0].Status, len(receipts[0].Logs))
+	}
+	if topics := receipts[0].Logs[0].Topics; topics[0] != permissions.EventID(permissions.OpAddSender) || common.BytesToAddress(topics[1].Bytes()) != tests.Account1 {
+		t.Fatalf("unexpected allowlist change event: %v", topics)
+	}
+
+	if err := permissions.Check(config.Permissions, statedb, tests.Account1, &tests.Account2); err != nil {
+		t.Fatalf("allowed sender is refused: %v", err)
+	}
+	if err := permissions.Check(config.Permissions, statedb, tests.Account2, &tests.Account1); !errors.Is(err, permissions.ErrSenderNotAllowed) {
+		t.Fatalf("unexpected sender not allowed error: %v", err)
+	}
+
+	// allowed sender may not deploy contracts, so the block is invalid
+	key, err := crypto.HexToECDSA(tests.PrivateKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy := types.MustSignNewTx(key, types.LatestSigner(config.EthConfig()), &types.LegacyTx{
+		Gas:      100_000,
+		GasPrice: gasPrice,
+		Data:     storeAndLogCode,
+	})
+	header.Number = big.NewInt(2)
+	block = types.NewBlockWithHeader(header).WithBody(types.Transactions{deploy}, nil)
+	if _, _, _, err := NewStateProcessor(&config, fakeChain{}).Process(block, statedb, vm.Config{}); !errors.Is(err, permissions.ErrDeployerNotAllowed) {
+		t.Fatalf("unexpected deployer not allowed error: %v", err)
+	}
+
+	// nor with a factory contract, while admin may
+	factory := common.HexToAddress("0xfac7")
+	statedb.SetCode(factory, factoryCode)
+	call := types.MustSignNewTx(key, types.LatestSigner(config.EthConfig()), &types.LegacyTx{
+		To:       &factory,
+		Gas:      100_000,
+		GasPrice: gasPrice,
+	})
+	block = types.NewBlockWithHeader(header).WithBody(types.Transactions{call}, nil)
+	if _, _, _, err := NewStateProcessor(&config, fakeChain{}).Process(block, statedb, vm.Config{}); !errors.Is(err, permissions.ErrDeployerNotAllowed) {
+		t.Fatalf("unexpected factory deployer not allowed error: %v", err)
+	}
+
+	statedb.SetBalance(tests.Account0, big.NewInt(1e18))
+	block = types.NewBlockWithHeader(header).WithBody(types.Transactions{
+		signTestTx(t, &config, types.NewTransaction(1, factory, new(big.Int), 100_000, gasPrice, nil)),
+	}, nil)
+	if _, _, _, err := NewStateProcessor(&config, fakeChain{}).Process(block, statedb, vm.Config{}); err != nil {
+		t.Fatalf("admin factory call is refused: %v", err)
+	}
+	if !statedb.Exist(crypto.CreateAddress(factory, 0)) {
+		t.Fatal("contract is not created by the factory")
+	}
+}
@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/spf13/viper"
+	"path/filepath"
+)
+
+const (
+	// NodeKeyFile is the node key file name under the node directory
+	NodeKeyFile = "nodekey"
+	// NodeDBDir is the known nodes database directory name under the node directory
+	NodeDBDir = "nodes"
+)
+
+// Config are the discovery service settings
+type Config struct {
+	Addr        string   `json:"addr" yaml:"addr"`                 // UDP listen address
+	Port        int      `json:"port" yaml:"port"`                 // UDP listen port, 0 picks any free port
+	V5          bool     `json:"v5" yaml:"v5"`                     // run discv5 on the same port besides discv4
+	NodeKey     string   `json:"node_key" yaml:"node_key"`         // node key file, generated if missing
+	NodeDB      string   `json:"node_db" yaml:"node_db"`           // known nodes database directory, kept in memory if empty
+	Bootnodes   []string `json:"bootnodes" yaml:"bootnodes"`       // enode URLs of bootstrap nodes
+	NetRestrict string   `json:"net_restrict" yaml:"net_restrict"` // comma separated CIDR masks of allowed peer IPs
+	DNS         []string `json:"dns" yaml:"dns"`                   // EIP-1459 enrtree:// URLs of node lists
+
+	NAT      nat.Interface    `json:"-" yaml:"-"` // port mapping and external IP of the node record, none if nil
+	Resolver dnsdisc.Resolver `json:"-" yaml:"-"` // DNS resolver of node lists, system resolver is used if nil
+}
+
+var DefaultConfig = Config{
+	Addr: "0.0.0.0",
+	Port: 9410,
+}
+
+// NewConfig reads 'discovery' config section of the bootnode, its key and nodes database are kept under 'data_dir/discovery'
+func NewConfig(cfg *viper.Viper) Config {
+	dir := filepath.Join(cfg.GetString("data_dir"), "discovery")
+	conf := Config{
+		Addr:        cfg.GetString("discovery.addr"),
+		Port:        cfg.GetInt("discovery.port"),
+		V5:          cfg.GetBool("discovery.v5"),
+		NodeKey:     filepath.Join(dir, NodeKeyFile),
+		NodeDB:      filepath.Join(dir, NodeDBDir),
+		Bootnodes:   cfg.GetStringSlice("discovery.bootnodes"),
+		NetRestrict: cfg.GetString("discovery.net_restrict"),
+	}
+	return conf.sanitize()
+}
+
+func (c Config) sanitize() Config {
+	if len(c.Addr) == 0 {
+		c.Addr = DefaultConfig.Addr
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		c.Port = DefaultConfig.Port
+	}
+	return c
+}
@@ -0,0 +1,367 @@
+package discovery
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/ethereum/go-ethereum/p2p/netutil"
+	"github.com/rovergulf/chain/core"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrInvalidBootnode = errors.New("invalid bootnode")
+	ErrInvalidDNSTree  = errors.New("invalid DNS tree url")
+	ErrNotStarted      = errors.New("discovery is not started")
+)
+
+// Service is the discv4 and optional discv5 UDP discovery, which helps peer nodes to find each other.
+// Bootnodes run it standalone, regular nodes use it as the source of dial candidates
+type Service struct {
+	config Config
+	logger *zap.SugaredLogger
+
+	key       *ecdsa.PrivateKey
+	db        *enode.DB
+	localNode *enode.LocalNode
+	bootnodes []*enode.Node
+	restrict  *netutil.Netlist
+	dns       *dnsdisc.Client
+
+	mu     sync.RWMutex // protects fields below
+	conn   *net.UDPConn
+	v4     *discover.UDPv4
+	v5     *discover.UDPv5
+	filter func(n *enode.Node) bool
+	quit   chan struct{}
+	closed chan struct{}
+}
+
+// New loads the node key and opens known nodes database, use Start to begin discovery
+func New(config Config, logger *zap.SugaredLogger) (*Service, error) {
+	config = config.sanitize()
+
+	key, err := LoadNodeKey(config.NodeKey)
+	if err != nil {
+		return nil, fmt.Errorf("node key: %w", err)
+	}
+	return NewWithKey(config, key, logger)
+}
+
+// NewWithKey returns the discovery service of the node identified by the key
+func NewWithKey(config Config, key *ecdsa.PrivateKey, logger *zap.SugaredLogger) (*Service, error) {
+	config = config.sanitize()
+
+	bootnodes := make([]*enode.Node, 0, len(config.Bootnodes))
+	for _, url := range config.Bootnodes {
+		n, err := enode.Parse(enode.ValidSchemes, url)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidBootnode, url, err)
+		}
+		bootnodes = append(bootnodes, n)
+	}
+
+	var dns *dnsdisc.Client
+	if len(config.DNS) > 0 {
+		for _, url := range config.DNS {
+			if _, _, err := dnsdisc.ParseURL(url); err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", ErrInvalidDNSTree, url, err)
+			}
+		}
+		dns = dnsdisc.NewClient(dnsdisc.Config{Resolver: config.Resolver})
+	}
+
+	var restrict *netutil.Netlist
+	if len(config.NetRestrict) > 0 {
+		list, err := netutil.ParseNetlist(config.NetRestrict)
+		if err != nil {
+			return nil, fmt.Errorf("net restrict: %w", err)
+		}
+		restrict = list
+	}
+
+	db, err := enode.OpenDB(config.NodeDB)
+	if err != nil {
+		return nil, fmt.Errorf("node database: %w", err)
+	}
+
+	return &Service{
+		config:    config,
+		logger:    logger,
+		key:       key,
+		db:        db,
+		localNode: enode.NewLocalNode(db, key),
+		bootnodes: bootnodes,
+		restrict:  restrict,
+		dns:       dns,
+	}, nil
+}
+
+// LocalNode returns the local node record, its entries are advertised to other nodes
+func (s *Service) LocalNode() *enode.LocalNode {
+	return s.localNode
+}
+
+// Self returns the local node, its URL is used to connect to it
+func (s *Service) Self() *enode.Node {
+	return s.localNode.Node()
+}
+
+// Start listens for discovery packets on the configured UDP address and joins the network through bootnodes
+func (s *Service) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.v4 != nil {
+		return nil
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.config.Addr, strconv.Itoa(s.config.Port)))
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	laddr := conn.LocalAddr().(*net.UDPAddr)
+	s.localNode.SetFallbackIP(net.IP{127, 0, 0, 1})
+	s.localNode.SetFallbackUDP(laddr.Port)
+	if !laddr.IP.IsUnspecified() {
+		s.localNode.SetStaticIP(laddr.IP)
+	}
+	s.closed = make(chan struct{})
+	s.setupNAT(laddr.Port)
+
+	// discv5 shares the socket, it reads packets discv4 is unable to decode
+	var unhandled chan discover.ReadPacket
+	if s.config.V5 {
+		unhandled = make(chan discover.ReadPacket, 100)
+	}
+	v4, err := discover.ListenV4(conn, s.localNode, discover.Config{
+		PrivateKey:  s.key,
+		NetRestrict: s.restrict,
+		Bootnodes:   s.bootnodes,
+		Unhandled:   unhandled,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn, s.v4 = conn, v4
+
+	if s.config.V5 {
+		v5, err := discover.ListenV5(&sharedUDPConn{UDPConn: conn, unhandled: unhandled}, s.localNode, discover.Config{
+			PrivateKey:  s.key,
+			NetRestrict: s.restrict,
+			Bootnodes:   s.bootnodes,
+		})
+		if err != nil {
+			v4.Close()
+			s.conn, s.v4 = nil, nil
+			return err
+		}
+		s.v5 = v5
+	}
+
+	s.logger.Infow("Started discovery", "addr", laddr, "v5", s.config.V5, "self", s.Self().URLv4())
+	return nil
+}
+
+// setupNAT maps the UDP port and sets the external IP of the node record
+func (s *Service) setupNAT(port int) {
+	if s.config.NAT == nil {
+		return
+	}
+	if ext, ok := s.config.NAT.(nat.ExtIP); ok {
+		s.localNode.SetStaticIP(net.IP(ext))
+		return
+	}
+
+	go nat.Map(s.config.NAT, s.closed, "udp", port, port, "carrack discovery")
+	go func() {
+		// UPnP and PMP devices are looked up in the background
+		ip, err := s.config.NAT.ExternalIP()
+		if err != nil {
+			s.logger.Warnw("Unable to get external IP", "nat", s.config.NAT, "err", err)
+			return
+		}
+		s.localNode.SetStaticIP(ip)
+	}()
+}
+
+// Advertise sets the 'carrack' entry of the local node record and keeps its fork ID up to date with the chain head.
+// Found nodes are filtered by the entry then, so nodes of other networks sharing bootnodes are not dialed
+func (s *Service) Advertise(chain Chain, networkID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quit != nil {
+		close(s.quit)
+	}
+	s.quit = make(chan struct{})
+	s.filter = NewNodeFilter(chain, networkID)
+	s.localNode.Set(NewEntry(chain, networkID))
+
+	heads := make(chan core.ChainHeadEvent, 10)
+	sub := chain.SubscribeChainHeadEvent(heads)
+	go func(quit chan struct{}) {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case <-heads:
+				if entry := NewEntry(chain, networkID); !s.hasEntry(entry) {
+					s.localNode.Set(entry)
+				}
+			case <-sub.Err():
+				return
+			case <-quit:
+				return
+			}
+		}
+	}(s.quit)
+}
+
+// hasEntry reports whether the local node record carries the entry, record sequence is increased on every change
+func (s *Service) hasEntry(entry *Entry) bool {
+	var current Entry
+	if err := s.Self().Load(&current); err != nil {
+		return false
+	}
+	return current.NetworkID == entry.NetworkID && current.Genesis == entry.Genesis && current.ForkID == entry.ForkID
+}
+
+// RandomNodes returns the iterator of nodes found by random lookups and DNS trees, it is a source of peers to dial.
+// Nodes of other networks are skipped once the local network is advertised
+func (s *Service) RandomNodes() (enode.Iterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.v4 == nil {
+		return nil, ErrNotStarted
+	}
+
+	self, network := s.localNode.ID(), s.filter
+	filter := func(n *enode.Node) bool {
+		return n.ID() != self && (network == nil || network(n))
+	}
+
+	v4 := s.v4.RandomNodes()
+	if network != nil {
+		v4 = &resolveIterator{Iterator: v4, v4: s.v4, filter: filter}
+	}
+	if s.v5 == nil && s.dns == nil {
+		return v4, nil
+	}
+
+	mix := enode.NewFairMix(0)
+	mix.AddSource(v4)
+	if s.v5 != nil {
+		// discv5 lookups may return the local node
+		mix.AddSource(enode.Filter(s.v5.RandomNodes(), filter))
+	}
+	if s.dns != nil {
+		it, err := s.dns.NewIterator(s.config.DNS...)
+		if err != nil {
+			mix.Close()
+			return nil, err
+		}
+		mix.AddSource(enode.Filter(it, filter))
+	}
+	return mix, nil
+}
+
+// resolveIterator yields records of discv4 nodes accepted by the filter, records are requested from nodes
+// missing the 'carrack' entry, as neighbors are sent without them
+type resolveIterator struct {
+	enode.Iterator
+	v4     *discover.UDPv4
+	filter func(n *enode.Node) bool
+	node   *enode.Node
+}
+
+// Next implements enode.Iterator
+func (it *resolveIterator) Next() bool {
+	for it.Iterator.Next() {
+		if n := resolveNode(it.v4, it.Iterator.Node()); n != nil && it.filter(n) {
+			it.node = n
+			return true
+		}
+	}
+	return false
+}
+
+// Node implements enode.Iterator
+func (it *resolveIterator) Node() *enode.Node {
+	return it.node
+}
+
+// resolveNode returns the node record with the 'carrack' entry, nil if the node is not reachable
+func resolveNode(v4 *discover.UDPv4, n *enode.Node) *enode.Node {
+	var entry Entry
+	if n.Load(&entry) == nil {
+		return n
+	}
+	resolved, err := v4.RequestENR(n)
+	if err != nil {
+		return nil
+	}
+	return resolved
+}
+
+// Stop closes the discovery listener and known nodes database
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quit != nil {
+		close(s.quit)
+		s.quit = nil
+	}
+	if s.closed != nil {
+		close(s.closed)
+		s.closed = nil
+	}
+	// discv4 closes the shared socket and unhandled packets channel, which stops discv5 reading
+	if s.v4 != nil {
+		s.v4.Close()
+		s.v4, s.conn = nil, nil
+	}
+	if s.v5 != nil {
+		s.v5.Close()
+		s.v5 = nil
+	}
+	if s.db != nil {
+		s.db.Close()
+		s.db = nil
+	}
+}
+
+// sharedUDPConn passes discv5 the packets discv4 has not handled, writes go to the socket directly
+type sharedUDPConn struct {
+	*net.UDPConn
+	unhandled chan discover.ReadPacket
+}
+
+// ReadFromUDP implements discover.UDPConn
+func (c *sharedUDPConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	packet, ok := <-c.unhandled
+	if !ok {
+		return 0, nil, net.ErrClosed
+	}
+	n := copy(b, packet.Data)
+	return n, packet.Addr, nil
+}
+
+// Close implements discover.UDPConn, the socket is closed by discv4
+func (c *sharedUDPConn) Close() error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testChain is the chain at its genesis block
+type testChain struct {
+	genesis *types.Block
+	feed    event.Feed
+}
+
+func newTestChain(networkId uint64) *testChain {
+	return &testChain{genesis: types.NewBlockWithHeader(&types.Header{Number: new(big.Int), Nonce: types.EncodeNonce(networkId)})}
+}
+
+func (c *testChain) Config() *params.ChainConfig  { return params.DevChainConfig }
+func (c *testChain) Genesis() *types.Block        { return c.genesis }
+func (c *testChain) CurrentHeader() *types.Header { return c.genesis.Header() }
+func (c *testChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+func newTestService(t *testing.T, bootnodes ...*enode.Node) *Service {
+	return newTestServiceV5(t, false, bootnodes...)
+}
+
+func newTestServiceV5(t *testing.T, v5 bool, bootnodes ...*enode.Node) *Service {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+
+	config := Config{Addr: "127.0.0.1", V5: v5}
+	for _, n := range bootnodes {
+		config.Bootnodes = append(config.Bootnodes, n.URLv4())
+	}
+	s, err := NewWithKey(config, key, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// nextNode waits for the next dial candidate of the iterator
+func nextNode(t *testing.T, it enode.Iterator) *enode.Node {
+	next := make(chan *enode.Node, 1)
+	go func() {
+		if it.Next() {
+			next <- it.Node()
+		}
+	}()
+	select {
+	case n := <-next:
+		return n
+	case <-time.After(10 * time.Second):
+		t.Fatal("no nodes discovered")
+		return nil
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	bootnode := newTestService(t)
+	local := newTestService(t, bootnode.Self())
+
+	it, err := local.RandomNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	if n := nextNode(t, it); n.ID() != bootnode.Self().ID() {
+		t.Fatalf("unexpected node %s discovered", n.ID())
+	}
+}
+
+func TestDiscoveryNetworkFilter(t *testing.T) {
+	mainnet := newTestServiceV5(t, true)
+	mainnet.Advertise(newTestChain(params.MainNetworkId), params.MainNetworkId)
+	testnet := newTestServiceV5(t, true)
+	testnet.Advertise(newTestChain(params.TestNetworkId), params.TestNetworkId)
+
+	local := newTestServiceV5(t, true, mainnet.Self(), testnet.Self())
+	local.Advertise(newTestChain(params.MainNetworkId), params.MainNetworkId)
+
+	it, err := local.RandomNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	for i := 0; i < 3; i++ {
+		if n := nextNode(t, it); n.ID() != mainnet.Self().ID() {
+			t.Fatalf("dial candidate %s is not of the local network", n.ID())
+		}
+	}
+}
+
+func TestLoadNodeKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discovery", NodeKeyFile)
+	key, err := LoadNodeKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadNodeKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(loaded) {
+		t.Fatal("node key is not persisted")
+	}
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"sort"
+	"strings"
+)
+
+// maxTXTString is the length limit of a single character string in the TXT record
+const maxTXTString = 255
+
+// Crawl collects nodes accepted by the filter until the context is done, node records are requested from discv4 nodes
+func (s *Service) Crawl(ctx context.Context, filter func(n *enode.Node) bool) ([]*enode.Node, error) {
+	s.mu.RLock()
+	v4, v5, self := s.v4, s.v5, s.localNode.ID()
+	s.mu.RUnlock()
+
+	if v4 == nil {
+		return nil, ErrNotStarted
+	}
+
+	it := enode.NewFairMix(0)
+	it.AddSource(v4.RandomNodes())
+	if v5 != nil {
+		it.AddSource(v5.RandomNodes())
+	}
+	go func() {
+		<-ctx.Done()
+		it.Close()
+	}()
+
+	found := make(map[enode.ID]*enode.Node)
+	for it.Next() {
+		n := resolveNode(v4, it.Node())
+		if n == nil || n.ID() == self || !filter(n) {
+			continue
+		}
+		if known, ok := found[n.ID()]; !ok || known.Seq() < n.Seq() {
+			found[n.ID()] = n
+			s.logger.Debugw("Crawled node", "id", n.ID(), "seq", n.Seq(), "ip", n.IP())
+		}
+	}
+
+	nodes := make([]*enode.Node, 0, len(found))
+	for _, n := range found {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID().String() < nodes[j].ID().String()
+	})
+	return nodes, nil
+}
+
+// ZoneFile returns the TXT records of the signed EIP-1459 tree in the zone file format, root record goes first
+func ZoneFile(tree *dnsdisc.Tree, domain string, ttl uint) string {
+	records := tree.ToTXT(domain)
+	names := make([]string, 0, len(records))
+	for name := range records {
+		if name != domain {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{domain}, names...)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s.\t%d\tIN\tTXT\t%s\n", name, ttl, quoteTXT(records[name]))
+	}
+	return b.String()
+}
+
+// quoteTXT splits the record value into quoted character strings of allowed length
+func quoteTXT(value string) string {
+	var parts []string
+	for len(value) > maxTXTString {
+		parts = append(parts, `"`+value[:maxTXTString]+`"`)
+		value = value[maxTXTString:]
+	}
+	parts = append(parts, `"`+value+`"`)
+	return strings.Join(parts, " ")
+}
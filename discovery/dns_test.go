@@ -0,0 +1,142 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/dnsdisc"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"net"
+	"strings"
+	"testing"
+)
+
+// mapResolver is the in-process DNS resolver of TXT records
+type mapResolver map[string]string
+
+func (r mapResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if record, ok := r[name]; ok {
+		return []string{record}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// newTestNodes returns records of nodes advertising the network
+func newTestNodes(t *testing.T, count int, networkId uint64) []*enode.Node {
+	chain := newTestChain(networkId)
+	nodes := make([]*enode.Node, count)
+	for i := range nodes {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r enr.Record
+		r.Set(enr.IP(net.IP{127, 0, 0, 1}))
+		r.Set(enr.UDP(30000 + i))
+		r.Set(NewEntry(chain, networkId))
+		if err := enode.SignV4(&r, key); err != nil {
+			t.Fatal(err)
+		}
+		if nodes[i], err = enode.New(enode.ValidSchemes, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nodes
+}
+
+func TestZoneFile(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := dnsdisc.MakeTree(1, newTestNodes(t, 3, params.MainNetworkId), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, "nodes.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := tree.ToTXT("nodes.example.org")
+	lines := strings.Split(strings.TrimSpace(ZoneFile(tree, "nodes.example.org", 300)), "\n")
+	if len(lines) != len(records) {
+		t.Fatalf("zone file has %d records, want %d", len(lines), len(records))
+	}
+	if want := fmt.Sprintf("nodes.example.org.\t300\tIN\tTXT\t%q", records["nodes.example.org"]); lines[0] != want {
+		t.Fatalf("root record %q, want %q", lines[0], want)
+	}
+	for _, line := range lines {
+		fields := strings.SplitN(line, "\t", 5)
+		value := strings.ReplaceAll(strings.Trim(fields[4], `"`), `" "`, "")
+		if records[strings.TrimSuffix(fields[0], ".")] != value {
+			t.Fatalf("record %s does not match the tree", fields[0])
+		}
+	}
+	if !strings.HasPrefix(url, "enrtree://") {
+		t.Fatalf("unexpected tree url %s", url)
+	}
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mainnet := newTestNodes(t, 2, params.MainNetworkId)
+	testnet := newTestNodes(t, 2, params.TestNetworkId)
+	tree, err := dnsdisc.MakeTree(1, append(mainnet, testnet...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := tree.Sign(key, "nodes.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	s, err := NewWithKey(Config{
+		Addr:     "127.0.0.1",
+		DNS:      []string{url},
+		Resolver: mapResolver(tree.ToTXT("nodes.example.org")),
+	}, nodeKey, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	s.Advertise(newTestChain(params.MainNetworkId), params.MainNetworkId)
+
+	it, err := s.RandomNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	for i := 0; i < 4; i++ {
+		n := nextNode(t, it)
+		if n.ID() != mainnet[0].ID() && n.ID() != mainnet[1].ID() {
+			t.Fatalf("dial candidate %s is not of the local network", n.ID())
+		}
+	}
+}
+
+func TestInvalidDNSTree(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	if _, err := NewWithKey(Config{DNS: []string{"enode://nodes.example.org"}}, key, logger); err == nil {
+		t.Fatal("invalid tree url is accepted")
+	}
+}
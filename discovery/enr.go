@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/core/forkid"
+)
+
+// Chain is the local chain advertised in the node record
+type Chain interface {
+	forkid.Blockchain
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// Entry is the 'carrack' node record entry, which tells peers the network the node belongs to
+type Entry struct {
+	NetworkID uint64
+	Genesis   common.Hash
+	ForkID    forkid.ID
+
+	// Rest keeps fields added by later versions
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry
+func (e Entry) ENRKey() string {
+	return "carrack"
+}
+
+// NewEntry returns the node record entry of the chain current head
+func NewEntry(chain forkid.Blockchain, networkID uint64) *Entry {
+	return &Entry{
+		NetworkID: networkID,
+		Genesis:   chain.Genesis().Hash(),
+		ForkID:    forkid.NewIDWithChain(chain),
+	}
+}
+
+// NewNodeFilter returns the filter of nodes advertising the same network, genesis and compatible fork ID,
+// nodes without the 'carrack' entry are filtered out
+func NewNodeFilter(chain forkid.Blockchain, networkID uint64) func(n *enode.Node) bool {
+	genesis := chain.Genesis().Hash()
+	filter := forkid.NewFilter(chain)
+	return func(n *enode.Node) bool {
+		var entry Entry
+		if err := n.Load(&entry); err != nil {
+			return false
+		}
+		if entry.NetworkID != networkID || entry.Genesis != genesis {
+			return false
+		}
+		return filter(entry.ForkID) == nil
+	}
+}
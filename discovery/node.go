@@ -1,3 +0,0 @@
-package discovery
-
-type node struct{}
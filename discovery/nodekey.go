@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/crypto"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LoadNodeKey reads the node key file, generating and saving a new key if the file does not exist
+func LoadNodeKey(path string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.LoadECDSA(path)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if key, err = crypto.GenerateKey(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := crypto.SaveECDSA(path, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,47 @@
+package downloader
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mode is the chain synchronisation strategy
+type Mode uint32
+
+const (
+	// FullSync downloads blocks from the common ancestor and executes them all
+	FullSync Mode = iota
+
+	// SnapSync downloads the state of the pivot block near the head and blocks before it with receipts,
+	// then blocks from the pivot are executed as by full sync. The node should start with the empty chain
+	SnapSync
+)
+
+func (m Mode) String() string {
+	switch m {
+	case FullSync:
+		return "full"
+	case SnapSync:
+		return "snap"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(m))
+	}
+}
+
+// Config are the chain synchronisation settings
+type Config struct {
+	Mode     Mode          `json:"mode" yaml:"mode"`
+	Interval time.Duration `json:"interval" yaml:"interval"` // time between checks of peers having heavier chains
+}
+
+var DefaultConfig = Config{
+	Mode:     FullSync,
+	Interval: 5 * time.Second,
+}
+
+func (c Config) sanitize() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultConfig.Interval
+	}
+	return c
+}
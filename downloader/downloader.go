@@ -0,0 +1,358 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/carrack"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/state"
+	"go.uber.org/zap"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MaxHeaderFetch  = 192 // headers requested at once, the skeleton gap size
+	MaxSkeletonSize = 128 // skeleton headers requested in a round
+	MaxBodyFetch    = 128 // block bodies requested at once
+	MaxReceiptFetch = 256 // block receipts requested at once
+
+	maxImportBatch = 256 // blocks inserted into the chain at once
+
+	// PivotDistance is the number of blocks above the snap sync pivot, recent blocks are executed
+	PivotDistance = 64
+)
+
+var (
+	ErrUnknownMode     = errors.New("unknown sync mode")
+	ErrNoPeers         = errors.New("no peers to sync with")
+	ErrNoAncestor      = errors.New("common ancestor not found")
+	ErrInvalidChain    = errors.New("retrieved chain is invalid")
+	ErrInvalidSkeleton = errors.New("skeleton does not link to the chain")
+	ErrBadResponse     = errors.New("invalid response")
+	ErrNotAvailable    = errors.New("data is not available at the peer")
+)
+
+// Chain is the local chain extended by the downloaded blocks
+type Chain interface {
+	CurrentBlock() *types.Block
+	GetTd(hash common.Hash, number uint64) *big.Int
+	GetHeaderByNumber(number uint64) *types.Header
+	HasBlock(hash common.Hash, number uint64) bool
+	InsertChain(blocks types.Blocks) (int, error)
+	InsertReceiptChain(blocks types.Blocks, receipts []types.Receipts) (int, error)
+	StateDatabase() *state.Database
+}
+
+// Peers are the connected peers blocks are downloaded from
+type Peers interface {
+	BestPeer() *carrack.Peer
+	Peers() []*carrack.Peer
+	DropPeer(id string)
+}
+
+// Progress is the state of the chain synchronisation, the node is synced once the current block reaches the highest one
+type Progress struct {
+	StartingBlock uint64 `json:"starting_block" yaml:"starting_block"` // block the latest sync started at
+	CurrentBlock  uint64 `json:"current_block" yaml:"current_block"`   // local chain head
+	HighestBlock  uint64 `json:"highest_block" yaml:"highest_block"`   // head of the peer synced with
+
+	// snap sync state download
+	SyncedAccounts uint64 `json:"synced_accounts" yaml:"synced_accounts"`
+	SyncedSlots    uint64 `json:"synced_slots" yaml:"synced_slots"`
+	SyncedCodes    uint64 `json:"synced_codes" yaml:"synced_codes"`
+	HealedNodes    uint64 `json:"healed_nodes" yaml:"healed_nodes"`
+}
+
+// Downloader synchronises the local chain with the heaviest chain of peers
+type Downloader struct {
+	// state download counters, accessed atomically
+	syncedAccounts uint64
+	syncedSlots    uint64
+	syncedCodes    uint64
+	healedNodes    uint64
+
+	config  Config
+	chain   Chain
+	peers   Peers
+	logger  *zap.SugaredLogger
+	metrics *syncMetrics
+
+	lock     sync.RWMutex // protects starting and highest
+	starting uint64
+	highest  uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns the downloader of the chain blocks, Start runs the synchronisation loop
+func New(config Config, chain Chain, peers Peers, logger *zap.SugaredLogger) (*Downloader, error) {
+	config = config.sanitize()
+	if config.Mode != FullSync && config.Mode != SnapSync {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMode, config.Mode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Downloader{
+		config:  config,
+		chain:   chain,
+		peers:   peers,
+		logger:  logger,
+		metrics: newSyncMetrics(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Start checks peers for heavier chains every sync interval and downloads them
+func (d *Downloader) Start() {
+	d.wg.Add(1)
+	go d.loop()
+}
+
+// Stop cancels the running synchronisation and waits for it
+func (d *Downloader) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Progress returns the synchronisation state
+func (d *Downloader) Progress() Progress {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	current := d.chain.CurrentBlock().NumberU64()
+	highest := d.highest
+	if current > highest {
+		highest = current
+	}
+	return Progress{
+		StartingBlock:  d.starting,
+		CurrentBlock:   current,
+		HighestBlock:   highest,
+		SyncedAccounts: atomic.LoadUint64(&d.syncedAccounts),
+		SyncedSlots:    atomic.LoadUint64(&d.syncedSlots),
+		SyncedCodes:    atomic.LoadUint64(&d.syncedCodes),
+		HealedNodes:    atomic.LoadUint64(&d.healedNodes),
+	}
+}
+
+func (d *Downloader) loop() {
+	defer d.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if err := d.Synchronise(d.ctx); err != nil && !errors.Is(err, context.Canceled) {
+				d.logger.Warnw("Chain synchronisation failed", "err", err)
+			}
+			timer.Reset(d.config.Interval)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// Synchronise downloads the chain of the best peer if it is heavier than the local one
+func (d *Downloader) Synchronise(ctx context.Context) error {
+	peer := d.peers.BestPeer()
+	if peer == nil {
+		return nil
+	}
+	head := d.chain.CurrentBlock()
+	hash, number, td := peer.Head()
+	if td.Cmp(d.chain.GetTd(head.Hash(), head.NumberU64())) <= 0 {
+		return nil
+	}
+
+	start := time.Now()
+	d.logger.Infow("Synchronising with peer", "peer", peer.ID(), "head", hash, "number", number, "td", td,
+		"local", head.NumberU64(), "mode", d.config.Mode)
+
+	ancestor, err := d.findAncestor(ctx, peer, head.NumberU64(), number)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.dropPeer(peer, err)
+		}
+		return err
+	}
+	d.setProgress(ancestor.Number.Uint64(), number)
+
+	// the state is downloaded by the node starting with the empty chain, peers keep states of all blocks
+	if d.config.Mode == SnapSync && head.NumberU64() == 0 && number > PivotDistance && peer.Version() >= carrack.Carrack2 {
+		err = d.syncSnap(ctx, peer, ancestor, number-PivotDistance)
+	} else {
+		err = d.syncFullBlocks(ctx, peer, ancestor)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidChain) || errors.Is(err, ErrInvalidSkeleton) || errors.Is(err, ErrBadResponse) {
+			d.dropPeer(peer, err)
+		}
+		return err
+	}
+
+	head = d.chain.CurrentBlock()
+	d.logger.Infow("Chain synchronisation completed", "peer", peer.ID(), "number", head.NumberU64(), "hash", head.Hash(),
+		"elapsed", time.Since(start))
+	return nil
+}
+
+// syncFullBlocks downloads headers after the ancestor round by round, fetches their bodies and imports the blocks
+func (d *Downloader) syncFullBlocks(ctx context.Context, master *carrack.Peer, parent *types.Header) error {
+	for {
+		headers, err := d.fetchHeaders(ctx, master, parent)
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			return nil
+		}
+		if err := d.fetchBodies(ctx, headers); err != nil {
+			return err
+		}
+		if err := d.importBlocks(headers); err != nil {
+			return err
+		}
+		parent = headers[len(headers)-1].Header
+	}
+}
+
+// syncSnap downloads the pivot block state, then blocks with receipts up to the pivot, which are not executed,
+// and following blocks, which are
+func (d *Downloader) syncSnap(ctx context.Context, master *carrack.Peer, parent *types.Header, number uint64) error {
+	headers, err := master.RequestHeadersByNumber(ctx, number, 1, 0, false)
+	if err != nil {
+		return err
+	}
+	if len(headers) != 1 || headers[0].Number.Uint64() != number {
+		return fmt.Errorf("%w: pivot header #%d", ErrBadResponse, number)
+	}
+	pivot := headers[0]
+	d.logger.Infow("Synchronising pivot block state", "number", number, "hash", pivot.Hash(), "root", pivot.Root)
+
+	if err := d.syncState(ctx, pivot.Root); err != nil {
+		return err
+	}
+
+	for {
+		results, err := d.fetchHeaders(ctx, master, parent)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			if parent.Number.Uint64() < number {
+				return fmt.Errorf("%w: chain ends at #%d below the pivot", ErrInvalidChain, parent.Number)
+			}
+			return nil
+		}
+
+		pre := 0
+		for pre < len(results) && results[pre].Header.Number.Uint64() <= number {
+			pre++
+		}
+		if pre > 0 {
+			if last := results[pre-1].Header; last.Number.Uint64() == number && last.Hash() != pivot.Hash() {
+				return fmt.Errorf("%w: pivot block %s, header %s", ErrInvalidChain, pivot.Hash(), last.Hash())
+			}
+		}
+		if err := d.fetchBodies(ctx, results); err != nil {
+			return err
+		}
+		if err := d.fetchReceipts(ctx, results[:pre]); err != nil {
+			return err
+		}
+		if err := d.importReceiptBlocks(results[:pre]); err != nil {
+			return err
+		}
+		if err := d.importBlocks(results[pre:]); err != nil {
+			return err
+		}
+		parent = results[len(results)-1].Header
+	}
+}
+
+// importReceiptBlocks writes the downloaded blocks with receipts in batches
+func (d *Downloader) importReceiptBlocks(results []*fetchResult) error {
+	for len(results) > 0 {
+		batch := results
+		if len(batch) > maxImportBatch {
+			batch = batch[:maxImportBatch]
+		}
+		results = results[len(batch):]
+
+		blocks := make(types.Blocks, len(batch))
+		receipts := make([]types.Receipts, len(batch))
+		for i, res := range batch {
+			blocks[i] = types.NewBlockWithHeader(res.Header).WithBody(res.Transactions, res.Uncles)
+			receipts[i] = res.Receipts
+		}
+		if n, err := d.chain.InsertReceiptChain(blocks, receipts); err != nil {
+			if errors.Is(err, core.ErrChainStopped) {
+				return err
+			}
+			return fmt.Errorf("%w: block #%d [%s]: %s", ErrInvalidChain, blocks[n].NumberU64(), blocks[n].Hash(), err)
+		}
+		d.metrics.imported.Mark(int64(len(blocks)))
+
+		last := blocks[len(blocks)-1]
+		d.metrics.current.Update(int64(last.NumberU64()))
+		d.logger.Infow("Imported downloaded blocks with receipts", "count", len(blocks), "number", last.NumberU64(),
+			"hash", last.Hash(), "highest", d.Progress().HighestBlock)
+	}
+	return nil
+}
+
+// importBlocks inserts the downloaded blocks in batches
+func (d *Downloader) importBlocks(results []*fetchResult) error {
+	for len(results) > 0 {
+		batch := results
+		if len(batch) > maxImportBatch {
+			batch = batch[:maxImportBatch]
+		}
+		results = results[len(batch):]
+
+		blocks := make(types.Blocks, len(batch))
+		for i, res := range batch {
+			blocks[i] = types.NewBlockWithHeader(res.Header).WithBody(res.Transactions, res.Uncles)
+		}
+		if n, err := d.chain.InsertChain(blocks); err != nil {
+			if errors.Is(err, core.ErrChainStopped) {
+				return err
+			}
+			return fmt.Errorf("%w: block #%d [%s]: %s", ErrInvalidChain, blocks[n].NumberU64(), blocks[n].Hash(), err)
+		}
+		d.metrics.imported.Mark(int64(len(blocks)))
+
+		last := blocks[len(blocks)-1]
+		d.metrics.current.Update(int64(last.NumberU64()))
+		d.logger.Infow("Imported downloaded blocks", "count", len(blocks), "number", last.NumberU64(), "hash", last.Hash(),
+			"highest", d.Progress().HighestBlock)
+	}
+	return nil
+}
+
+func (d *Downloader) setProgress(starting, highest uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.starting, d.highest = starting, highest
+	d.metrics.starting.Update(int64(starting))
+	d.metrics.highest.Update(int64(highest))
+}
+
+// dropPeer disconnects the peer, which failed to serve the sync
+func (d *Downloader) dropPeer(peer *carrack.Peer, err error) {
+	d.logger.Debugw("Dropping sync peer", "peer", peer.ID(), "err", err)
+	d.metrics.drops.Mark(1)
+	d.peers.DropPeer(peer.ID())
+}
@@ -0,0 +1,302 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/rovergulf/chain/carrack"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"github.com/rovergulf/chain/tests/testchain"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testTxPool struct{}
+
+func (testTxPool) AddRemotes(txs []*types.Transaction) []error {
+	return make([]error, len(txs))
+}
+
+func (testTxPool) Get(common.Hash) *types.Transaction {
+	return nil
+}
+
+func (testTxPool) Has(common.Hash) bool {
+	return false
+}
+
+func (testTxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return new(event.Feed).Subscribe(ch)
+}
+
+// testPeers records dropped peers instead of disconnecting them
+type testPeers struct {
+	*carrack.Handler
+
+	lock    sync.Mutex
+	dropped []string
+}
+
+func (ps *testPeers) DropPeer(id string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.dropped = append(ps.dropped, id)
+}
+
+// newTestBlockChain returns the chain of the genesis with the blocks inserted
+func newTestBlockChain(t *testing.T, genesis *core.Genesis, blocks []*types.Block) *core.BlockChain {
+	bc := testchain.New(t, genesis)
+	if n, err := bc.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %s", n, err)
+	}
+	return bc
+}
+
+// newTestBlocks generates blocks on top of the genesis, every other one has a transfer
+func newTestBlocks(t *testing.T, genesis *core.Genesis, n int) []*types.Block {
+	return newTestBlocksWith(t, genesis, n, nil)
+}
+
+// newTestBlocksWith generates test blocks, calling gen for the blocks without a transfer
+func newTestBlocksWith(t *testing.T, genesis *core.Genesis, n int, gen func(int, *core.BlockGen)) []*types.Block {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	sdb := state.NewDatabase(badgerdb.NewDatabase(db, nil))
+	genesisBlock, err := genesis.ToBlock(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSigner(genesis.Config.EthConfig())
+	blocks, _, err := core.GenerateChain(genesis.Config, genesisBlock, sdb, n, func(i int, b *core.BlockGen) {
+		if i%2 == 1 {
+			if gen != nil {
+				gen(i, b)
+			}
+			return
+		}
+		tx, err := types.SignTx(types.NewTransaction(b.TxNonce(tests.Account0), tests.Account1, big.NewInt(1000), 21_000, big.NewInt(1), nil), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddTx(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func newTestHandler(t *testing.T, bc *core.BlockChain) *carrack.Handler {
+	logger, _ := logutils.NewLogger()
+	h := carrack.NewHandler(params.DevNetworkId, bc, testTxPool{}, logger)
+	t.Cleanup(h.Stop)
+	return h
+}
+
+// connect runs the protocol between handlers over the message pipe
+func connect(t *testing.T, local, remote *carrack.Handler, id byte) {
+	localRw, remoteRw := p2p.MsgPipe()
+	t.Cleanup(func() {
+		localRw.Close()
+		remoteRw.Close()
+	})
+	run := func(h *carrack.Handler, p *p2p.Peer, rw *p2p.MsgPipeRW) {
+		h.Protocols(nil)[0].Run(p, rw)
+		rw.Close()
+	}
+	go run(local, p2p.NewPeer(enode.ID{id}, "remote", nil), localRw)
+	go run(remote, p2p.NewPeer(enode.ID{}, "local", nil), remoteRw)
+
+	for i := 0; i < 100 && len(local.Peers()) < int(id); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(local.Peers()) < int(id) {
+		t.Fatal("peer is not registered")
+	}
+}
+
+func newTestDownloader(t *testing.T, bc *core.BlockChain, remotes ...*core.BlockChain) (*Downloader, *testPeers) {
+	return newTestDownloaderWithConfig(t, DefaultConfig, bc, remotes...)
+}
+
+func newTestDownloaderWithConfig(t *testing.T, config Config, bc *core.BlockChain, remotes ...*core.BlockChain) (*Downloader, *testPeers) {
+	local := newTestHandler(t, bc)
+	for i, remote := range remotes {
+		connect(t, local, newTestHandler(t, remote), byte(i+1))
+	}
+	peers := &testPeers{Handler: local}
+	logger, _ := logutils.NewLogger()
+	d, err := New(config, bc, peers, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, peers
+}
+
+func TestSynchronise(t *testing.T) {
+	genesis := testchain.Genesis()
+	blocks := newTestBlocks(t, genesis, 2*MaxHeaderFetch+50)
+
+	for _, tt := range []struct {
+		name  string
+		local int
+		peers int
+	}{
+		{name: "genesis", local: 0, peers: 3},
+		{name: "ancestor", local: MaxHeaderFetch + 10, peers: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			remotes := make([]*core.BlockChain, tt.peers)
+			for i := range remotes {
+				remotes[i] = newTestBlockChain(t, genesis, blocks)
+			}
+			bc := newTestBlockChain(t, genesis, blocks[:tt.local])
+			d, peers := newTestDownloader(t, bc, remotes...)
+
+			if err := d.Synchronise(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if head := bc.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+				t.Fatalf("unexpected head after sync #%d %s", head.NumberU64(), head.Hash())
+			}
+			if len(peers.dropped) > 0 {
+				t.Fatalf("peers dropped: %v", peers.dropped)
+			}
+			progress := d.Progress()
+			if progress.StartingBlock != uint64(tt.local) || progress.HighestBlock != uint64(len(blocks)) {
+				t.Fatalf("unexpected progress %+v", progress)
+			}
+		})
+	}
+}
+
+func TestFetchReceipts(t *testing.T) {
+	genesis := testchain.Genesis()
+	blocks := newTestBlocks(t, genesis, 10)
+	d, _ := newTestDownloader(t, newTestBlockChain(t, genesis, nil), newTestBlockChain(t, genesis, blocks))
+
+	results := make([]*fetchResult, len(blocks))
+	for i, block := range blocks {
+		results[i] = newFetchResult(block.Header())
+	}
+	if err := d.fetchReceipts(context.Background(), results); err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		if want := len(blocks[i].Transactions()); !res.receiptDone || len(res.Receipts) != want {
+			t.Fatalf("block %d has %d receipts, want %d", i, len(res.Receipts), want)
+		}
+	}
+}
+
+func TestNoPeers(t *testing.T) {
+	genesis := testchain.Genesis()
+	d, _ := newTestDownloader(t, newTestBlockChain(t, genesis, nil))
+
+	results := []*fetchResult{newFetchResult(newTestBlocks(t, genesis, 1)[0].Header())}
+	if err := d.fetchBodies(context.Background(), results); !errors.Is(err, ErrNoPeers) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSnapSync(t *testing.T) {
+	genesis := testchain.Genesis()
+	genesis.Alloc[tests.Account1] = core.GenesisAccount{Balance: big.NewInt(1e18)}
+	// accounts spread over all the state ranges
+	for i := 0; i < 300; i++ {
+		genesis.Alloc[common.BigToAddress(big.NewInt(int64(i+1)))] = core.GenesisAccount{Balance: big.NewInt(int64(i + 1))}
+	}
+
+	// contracts deployed after the genesis, so their code and storage are missing locally
+	key, err := crypto.HexToECDSA(tests.PrivateKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const contracts = 10
+	signer := types.LatestSigner(genesis.Config.EthConfig())
+	blocks := newTestBlocksWith(t, genesis, MaxHeaderFetch+PivotDistance+10, func(i int, b *core.BlockGen) {
+		if i/2 >= contracts {
+			return
+		}
+		var code []byte
+		for j := 0; j <= i; j++ {
+			code = append(code, 0x60, byte(j+1), 0x60, byte(j), 0x55) // SSTORE(j, j+1)
+		}
+		// return the runtime code PUSH1 i STOP
+		code = append(code, 0x62, 0x60, byte(i), 0x00, 0x60, 0x00, 0x52, 0x60, 0x03, 0x60, 0x1d, 0xf3)
+		tx, err := types.SignTx(types.NewContractCreation(b.TxNonce(tests.Account1), nil, 1_000_000, big.NewInt(1), code), signer, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.AddTx(tx)
+	})
+	pivot := blocks[len(blocks)-PivotDistance-1]
+
+	bc := newTestBlockChain(t, genesis, nil)
+	remotes := []*core.BlockChain{newTestBlockChain(t, genesis, blocks), newTestBlockChain(t, genesis, blocks)}
+	d, peers := newTestDownloaderWithConfig(t, Config{Mode: SnapSync}, bc, remotes...)
+
+	if err := d.Synchronise(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if head := bc.CurrentBlock(); head.Hash() != blocks[len(blocks)-1].Hash() {
+		t.Fatalf("unexpected head after sync #%d %s", head.NumberU64(), head.Hash())
+	}
+	if len(peers.dropped) > 0 {
+		t.Fatalf("peers dropped: %v", peers.dropped)
+	}
+
+	statedb, err := bc.StateAt(pivot.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, account := range genesis.Alloc {
+		if balance := statedb.GetBalance(addr); addr != tests.Account0 && addr != tests.Account1 && balance.Cmp(account.Balance) != 0 {
+			t.Fatalf("account %s balance %d, want %d", addr, balance, account.Balance)
+		}
+	}
+	for n := 0; n < contracts; n++ {
+		addr := crypto.CreateAddress(tests.Account1, uint64(n))
+		if code := statedb.GetCode(addr); len(code) != 3 || code[1] != byte(2*n+1) {
+			t.Fatalf("contract %s has code %x", addr, code)
+		}
+		for j := 0; j <= 2*n+1; j++ {
+			if got := statedb.GetState(addr, common.BigToHash(big.NewInt(int64(j)))); got != common.BigToHash(big.NewInt(int64(j+1))) {
+				t.Fatalf("contract %s slot %d is %s", addr, j, got)
+			}
+		}
+	}
+	if bc.StateDatabase().HasState(blocks[0].Root()) {
+		t.Fatal("state below the pivot is downloaded")
+	}
+	if receipts := bc.GetReceiptsByHash(blocks[0].Hash()); len(receipts) != 1 {
+		t.Fatalf("unexpected receipts below the pivot: %d", len(receipts))
+	}
+	if progress := d.Progress(); progress.SyncedAccounts == 0 || progress.SyncedCodes != contracts || progress.SyncedSlots == 0 {
+		t.Fatalf("unexpected state progress %+v", progress)
+	}
+}
@@ -0,0 +1,351 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/carrack"
+)
+
+// fetchResult is the downloaded block data
+type fetchResult struct {
+	Header       *types.Header
+	Transactions types.Transactions
+	Uncles       []*types.Header
+	Receipts     types.Receipts
+
+	bodyDone    bool
+	receiptDone bool
+}
+
+func newFetchResult(header *types.Header) *fetchResult {
+	return &fetchResult{
+		Header:      header,
+		bodyDone:    header.TxHash == types.EmptyRootHash && header.UncleHash == types.EmptyUncleHash,
+		receiptDone: header.ReceiptHash == types.EmptyRootHash,
+	}
+}
+
+// findAncestor returns the latest block of the peer chain known locally
+func (d *Downloader) findAncestor(ctx context.Context, peer *carrack.Peer, local, remote uint64) (*types.Header, error) {
+	// recent blocks are checked at once, a fork is usually short
+	head := local
+	if remote < head {
+		head = remote
+	}
+	var from uint64
+	if head >= MaxHeaderFetch {
+		from = head - MaxHeaderFetch + 1
+	}
+	headers, err := peer.RequestHeadersByNumber(ctx, from, head-from+1, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	for i, header := range headers {
+		if header.Number.Uint64() != from+uint64(i) {
+			return nil, fmt.Errorf("%w: ancestor header #%d at position %d", ErrBadResponse, header.Number, i)
+		}
+	}
+	for i := len(headers) - 1; i >= 0; i-- {
+		if d.chain.HasBlock(headers[i].Hash(), headers[i].Number.Uint64()) {
+			return headers[i], nil
+		}
+	}
+
+	// genesis is the same for peers passed the handshake, so the ancestor is searched down to it
+	ancestor := d.chain.GetHeaderByNumber(0)
+	start, end := uint64(0), from
+	for start+1 < end {
+		check := (start + end) / 2
+		headers, err := peer.RequestHeadersByNumber(ctx, check, 1, 0, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(headers) != 1 || headers[0].Number.Uint64() != check {
+			return nil, fmt.Errorf("%w: ancestor search header #%d", ErrBadResponse, check)
+		}
+		if d.chain.HasBlock(headers[0].Hash(), check) {
+			start, ancestor = check, headers[0]
+		} else {
+			end = check
+		}
+	}
+	if ancestor == nil {
+		return nil, ErrNoAncestor
+	}
+	return ancestor, nil
+}
+
+// fetchHeaders downloads headers following the parent. The master peer provides the skeleton of every
+// MaxHeaderFetch-th header, gaps are filled by any peers walking back from skeleton headers
+func (d *Downloader) fetchHeaders(ctx context.Context, master *carrack.Peer, parent *types.Header) ([]*fetchResult, error) {
+	from := parent.Number.Uint64() + 1
+	skeleton, err := master.RequestHeadersByNumber(ctx, from+MaxHeaderFetch-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+	if err != nil {
+		return nil, err
+	}
+	for i, header := range skeleton {
+		if header.Number.Uint64() != from+uint64(i+1)*MaxHeaderFetch-1 {
+			return nil, fmt.Errorf("%w: skeleton header #%d at position %d", ErrBadResponse, header.Number, i)
+		}
+	}
+
+	// the chain tail is shorter than a gap, it is fetched from the master
+	if len(skeleton) == 0 {
+		headers, err := master.RequestHeadersByNumber(ctx, from, MaxHeaderFetch, 0, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyHeaderChain(parent, headers); err != nil {
+			return nil, err
+		}
+		d.metrics.headersIn.Mark(int64(len(headers)))
+		return d.newResults(headers), nil
+	}
+
+	headers := make([]*types.Header, len(skeleton)*MaxHeaderFetch)
+	err = d.fetch(ctx, len(skeleton), func(ctx context.Context, peer *carrack.Peer, task int) error {
+		point := skeleton[task]
+		gap, err := peer.RequestHeadersByHash(ctx, point.Hash(), MaxHeaderFetch, 0, true)
+		if err != nil {
+			return err
+		}
+		if len(gap) == 0 {
+			return ErrNotAvailable
+		}
+		if len(gap) != MaxHeaderFetch || gap[0].Hash() != point.Hash() {
+			return fmt.Errorf("%w: %d headers of the gap at #%d", ErrBadResponse, len(gap), point.Number)
+		}
+		for i := 1; i < len(gap); i++ {
+			if gap[i-1].ParentHash != gap[i].Hash() || gap[i-1].Number.Uint64() != gap[i].Number.Uint64()+1 {
+				return fmt.Errorf("%w: non contiguous gap header #%d", ErrBadResponse, gap[i].Number)
+			}
+		}
+
+		// gap headers are linked by hashes to the skeleton, so only the master could break the chain
+		prev := parent
+		if task > 0 {
+			prev = skeleton[task-1]
+		}
+		if gap[len(gap)-1].ParentHash != prev.Hash() {
+			return fmt.Errorf("%w: gap at #%d", ErrInvalidSkeleton, point.Number)
+		}
+		for i, header := range gap {
+			headers[(task+1)*MaxHeaderFetch-1-i] = header
+		}
+		d.metrics.headersIn.Mark(int64(len(gap)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.newResults(headers), nil
+}
+
+// newResults returns results of the downloaded headers, updating the highest block known
+func (d *Downloader) newResults(headers []*types.Header) []*fetchResult {
+	results := make([]*fetchResult, len(headers))
+	for i, header := range headers {
+		results[i] = newFetchResult(header)
+	}
+	if len(headers) > 0 {
+		d.lock.Lock()
+		if number := headers[len(headers)-1].Number.Uint64(); number > d.highest {
+			d.highest = number
+			d.metrics.highest.Update(int64(number))
+		}
+		d.lock.Unlock()
+	}
+	return results
+}
+
+// verifyHeaderChain checks the headers follow the parent
+func verifyHeaderChain(parent *types.Header, headers []*types.Header) error {
+	for _, header := range headers {
+		if header.ParentHash != parent.Hash() || header.Number.Uint64() != parent.Number.Uint64()+1 {
+			return fmt.Errorf("%w: header #%d does not follow #%d", ErrBadResponse, header.Number, parent.Number)
+		}
+		parent = header
+	}
+	return nil
+}
+
+// fetchBodies downloads bodies of non-empty blocks concurrently from peers
+func (d *Downloader) fetchBodies(ctx context.Context, results []*fetchResult) error {
+	tasks := splitTasks(results, MaxBodyFetch, func(res *fetchResult) bool { return res.bodyDone })
+	return d.fetch(ctx, len(tasks), func(ctx context.Context, peer *carrack.Peer, task int) error {
+		for {
+			var (
+				pending []*fetchResult
+				hashes  []common.Hash
+			)
+			for _, res := range tasks[task] {
+				if !res.bodyDone {
+					pending = append(pending, res)
+					hashes = append(hashes, res.Header.Hash())
+				}
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+
+			bodies, err := peer.RequestBodies(ctx, hashes)
+			if err != nil {
+				return err
+			}
+			if len(bodies) == 0 {
+				return ErrNotAvailable
+			}
+			// peers respond in the request order, larger responses are truncated
+			for i, body := range bodies {
+				header := pending[i].Header
+				if types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil)) != header.TxHash ||
+					types.CalcUncleHash(body.Uncles) != header.UncleHash {
+					return fmt.Errorf("%w: body of block #%d does not match the header", ErrBadResponse, header.Number)
+				}
+				pending[i].Transactions, pending[i].Uncles, pending[i].bodyDone = body.Transactions, body.Uncles, true
+			}
+			d.metrics.bodiesIn.Mark(int64(len(bodies)))
+		}
+	})
+}
+
+// fetchReceipts downloads receipts of blocks with transactions concurrently from peers
+func (d *Downloader) fetchReceipts(ctx context.Context, results []*fetchResult) error {
+	tasks := splitTasks(results, MaxReceiptFetch, func(res *fetchResult) bool { return res.receiptDone })
+	return d.fetch(ctx, len(tasks), func(ctx context.Context, peer *carrack.Peer, task int) error {
+		for {
+			var (
+				pending []*fetchResult
+				hashes  []common.Hash
+			)
+			for _, res := range tasks[task] {
+				if !res.receiptDone {
+					pending = append(pending, res)
+					hashes = append(hashes, res.Header.Hash())
+				}
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+
+			receipts, err := peer.RequestReceipts(ctx, hashes)
+			if err != nil {
+				return err
+			}
+			if len(receipts) == 0 {
+				return ErrNotAvailable
+			}
+			for i, list := range receipts {
+				header := pending[i].Header
+				if types.DeriveSha(types.Receipts(list), trie.NewStackTrie(nil)) != header.ReceiptHash {
+					return fmt.Errorf("%w: receipts of block #%d do not match the header", ErrBadResponse, header.Number)
+				}
+				pending[i].Receipts, pending[i].receiptDone = list, true
+			}
+			d.metrics.receiptsIn.Mark(int64(len(receipts)))
+		}
+	})
+}
+
+// splitTasks groups results, which are not done yet, by size
+func splitTasks(results []*fetchResult, size int, done func(*fetchResult) bool) [][]*fetchResult {
+	var tasks [][]*fetchResult
+	for _, res := range results {
+		if done(res) {
+			continue
+		}
+		if len(tasks) == 0 || len(tasks[len(tasks)-1]) == size {
+			tasks = append(tasks, make([]*fetchResult, 0, size))
+		}
+		tasks[len(tasks)-1] = append(tasks[len(tasks)-1], res)
+	}
+	return tasks
+}
+
+// taskResult is the outcome of the task run by the peer
+type taskResult struct {
+	peer *carrack.Peer
+	task int
+	err  error
+}
+
+// fetch runs tasks concurrently, one at a time per peer. Tasks the peer has no data for are retried by
+// other peers, peers failing tasks otherwise are dropped. The invalid skeleton aborts fetching
+func (d *Downloader) fetch(ctx context.Context, count int, run func(ctx context.Context, peer *carrack.Peer, task int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		queue   = make([]int, count)
+		tried   = make([]map[string]bool, count) // peers having no data for tasks
+		busy    = make(map[string]bool)
+		failed  = make(map[string]bool)
+		done    int
+		results = make(chan taskResult)
+	)
+	for i := range queue {
+		queue[i], tried[i] = i, make(map[string]bool)
+	}
+
+	for done < count {
+		for _, peer := range d.peers.Peers() {
+			id := peer.ID()
+			if busy[id] || failed[id] {
+				continue
+			}
+			next := -1
+			for i, task := range queue {
+				if !tried[task][id] {
+					next = i
+					break
+				}
+			}
+			if next < 0 {
+				continue
+			}
+			task := queue[next]
+			queue = append(queue[:next], queue[next+1:]...)
+
+			busy[id] = true
+			go func(peer *carrack.Peer, task int) {
+				res := taskResult{peer: peer, task: task, err: run(ctx, peer, task)}
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+			}(peer, task)
+		}
+		if len(busy) == 0 {
+			return fmt.Errorf("%w: %d of %d tasks are not served", ErrNoPeers, count-done, count)
+		}
+
+		select {
+		case res := <-results:
+			id := res.peer.ID()
+			delete(busy, id)
+			switch {
+			case res.err == nil:
+				done++
+			case errors.Is(res.err, ErrInvalidSkeleton):
+				return res.err
+			case errors.Is(res.err, ErrNotAvailable):
+				tried[res.task][id] = true
+				queue = append(queue, res.task)
+			default:
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failed[id] = true
+				d.dropPeer(res.peer, res.err)
+				queue = append(queue, res.task)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package downloader
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// syncMetrics are created by the downloader, after the node enables metrics collection
+type syncMetrics struct {
+	headersIn  metrics.Meter
+	bodiesIn   metrics.Meter
+	receiptsIn metrics.Meter
+	imported   metrics.Meter
+	drops      metrics.Meter
+
+	accountsIn metrics.Meter
+	slotsIn    metrics.Meter
+	codesIn    metrics.Meter
+	nodesIn    metrics.Meter
+
+	starting metrics.Gauge
+	current  metrics.Gauge
+	highest  metrics.Gauge
+}
+
+func newSyncMetrics() *syncMetrics {
+	return &syncMetrics{
+		headersIn:  metrics.GetOrRegisterMeter("chain/sync/headers/in", nil),
+		bodiesIn:   metrics.GetOrRegisterMeter("chain/sync/bodies/in", nil),
+		receiptsIn: metrics.GetOrRegisterMeter("chain/sync/receipts/in", nil),
+		imported:   metrics.GetOrRegisterMeter("chain/sync/blocks/imported", nil),
+		drops:      metrics.GetOrRegisterMeter("chain/sync/peers/dropped", nil),
+		accountsIn: metrics.GetOrRegisterMeter("chain/sync/state/accounts/in", nil),
+		slotsIn:    metrics.GetOrRegisterMeter("chain/sync/state/slots/in", nil),
+		codesIn:    metrics.GetOrRegisterMeter("chain/sync/state/codes/in", nil),
+		nodesIn:    metrics.GetOrRegisterMeter("chain/sync/state/nodes/in", nil),
+		starting:   metrics.GetOrRegisterGauge("chain/sync/starting", nil),
+		current:    metrics.GetOrRegisterGauge("chain/sync/current", nil),
+		highest:    metrics.GetOrRegisterGauge("chain/sync/highest", nil),
+	}
+}
@@ -0,0 +1,351 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	gethstate "github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/rovergulf/chain/carrack"
+	"math/big"
+	"sync/atomic"
+)
+
+const (
+	accountRanges     = 16         // state trie ranges downloaded concurrently, one per the first key nibble
+	stateRequestBytes = 512 * 1024 // soft limit of state ranges responses
+	MaxCodeFetch      = 64         // contract codes requested at once
+	MaxTrieNodeFetch  = 384        // trie nodes requested at once
+)
+
+var (
+	emptyCode = crypto.Keccak256Hash(nil)
+	maxHash   = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+)
+
+// accountRange is the part of the state trie downloaded by one peer at a time, the progress is kept
+// between peers. Nodes of the range subtree are written by the stack trie, the state root is healed
+type accountRange struct {
+	next  common.Hash // origin of the next request
+	last  common.Hash
+	trie  *trie.StackTrie
+	batch ethdb.Batch
+}
+
+func newAccountRanges(db ethdb.KeyValueStore) []*accountRange {
+	ranges := make([]*accountRange, accountRanges)
+	step := new(big.Int).Div(new(big.Int).Lsh(common.Big1, 256), big.NewInt(accountRanges))
+	for i := range ranges {
+		next := new(big.Int).Mul(step, big.NewInt(int64(i)))
+		last := new(big.Int).Sub(new(big.Int).Add(next, step), common.Big1)
+		batch := db.NewBatch()
+		ranges[i] = &accountRange{
+			next:  common.BigToHash(next),
+			last:  common.BigToHash(last),
+			trie:  trie.NewStackTrie(batch),
+			batch: batch,
+		}
+	}
+	return ranges
+}
+
+// syncState downloads the state trie of the root in account ranges with their storage and codes, then heals it
+func (d *Downloader) syncState(ctx context.Context, root common.Hash) error {
+	db := d.chain.StateDatabase().Underlying().TrieDB().DiskDB()
+	if root == types.EmptyRootHash || rawdb.HasTrieNode(db, root) {
+		return nil
+	}
+
+	ranges := newAccountRanges(db)
+	err := d.fetch(ctx, len(ranges), func(ctx context.Context, peer *carrack.Peer, task int) error {
+		if peer.Version() < carrack.Carrack2 {
+			return ErrNotAvailable
+		}
+		return d.syncAccountRange(ctx, peer, root, ranges[task])
+	})
+	if err != nil {
+		return err
+	}
+	d.logger.Infow("Downloaded state ranges", "root", root, "accounts", atomic.LoadUint64(&d.syncedAccounts),
+		"slots", atomic.LoadUint64(&d.syncedSlots), "codes", atomic.LoadUint64(&d.syncedCodes))
+
+	return d.healState(ctx, root)
+}
+
+// syncAccountRange downloads accounts of the range from the peer, every account is added to the trie
+// once its storage and code are written, so the range is continued from the next one by other peers
+func (d *Downloader) syncAccountRange(ctx context.Context, peer *carrack.Peer, root common.Hash, r *accountRange) error {
+	db := d.chain.StateDatabase().Underlying().TrieDB().DiskDB()
+	for {
+		accounts, proof, err := peer.RequestAccountRange(ctx, root, r.next, r.last, stateRequestBytes)
+		if err != nil {
+			return err
+		}
+		if len(accounts) == 0 && len(proof) == 0 {
+			return ErrNotAvailable
+		}
+		keys, values := make([][]byte, len(accounts)), make([][]byte, len(accounts))
+		for i, account := range accounts {
+			keys[i], values[i] = account.Hash[:], account.Body
+		}
+		more, err := verifyRange(root, r.next, keys, values, proof)
+		if err != nil {
+			return fmt.Errorf("%w: account range: %s", ErrBadResponse, err)
+		}
+
+		var (
+			decoded = make([]types.StateAccount, 0, len(accounts))
+			codes   []common.Hash
+		)
+		for _, account := range accounts {
+			if bytes.Compare(account.Hash[:], r.last[:]) > 0 {
+				more = false
+				break
+			}
+			var acc types.StateAccount
+			if err := rlp.DecodeBytes(account.Body, &acc); err != nil {
+				return fmt.Errorf("%w: account %s: %s", ErrBadResponse, account.Hash, err)
+			}
+			if hash := common.BytesToHash(acc.CodeHash); hash != emptyCode && !rawdb.HasCode(db, hash) {
+				codes = append(codes, hash)
+			}
+			decoded = append(decoded, acc)
+		}
+		if err := d.syncCodes(ctx, peer, codes, r.batch); err != nil {
+			return err
+		}
+
+		for i, acc := range decoded {
+			if acc.Root != types.EmptyRootHash && !rawdb.HasTrieNode(db, acc.Root) {
+				if err := d.syncStorage(ctx, peer, acc.Root, r.batch); err != nil {
+					return err
+				}
+			}
+			if err := r.trie.TryUpdate(accounts[i].Hash[:], accounts[i].Body); err != nil {
+				return err
+			}
+			r.next = incHash(accounts[i].Hash)
+		}
+		d.metrics.accountsIn.Mark(int64(len(decoded)))
+		atomic.AddUint64(&d.syncedAccounts, uint64(len(decoded)))
+
+		if !more || r.next == (common.Hash{}) {
+			if _, err := r.trie.Commit(); err != nil {
+				return err
+			}
+			return r.batch.Write()
+		}
+		if r.batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := r.batch.Write(); err != nil {
+				return err
+			}
+			r.batch.Reset()
+		}
+	}
+}
+
+// syncStorage downloads the whole storage trie from the peer
+func (d *Downloader) syncStorage(ctx context.Context, peer *carrack.Peer, root common.Hash, batch ethdb.KeyValueWriter) error {
+	var (
+		st    = trie.NewStackTrie(batch)
+		next  common.Hash
+		slots uint64
+	)
+	for {
+		res, proof, err := peer.RequestStorageRange(ctx, root, next, maxHash, stateRequestBytes)
+		if err != nil {
+			return err
+		}
+		if len(res) == 0 && len(proof) == 0 {
+			return ErrNotAvailable
+		}
+		keys, values := make([][]byte, len(res)), make([][]byte, len(res))
+		for i, slot := range res {
+			keys[i], values[i] = slot.Hash[:], slot.Body
+		}
+		more, err := verifyRange(root, next, keys, values, proof)
+		if err != nil {
+			return fmt.Errorf("%w: storage range of %s: %s", ErrBadResponse, root, err)
+		}
+		for i := range keys {
+			if err := st.TryUpdate(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+		slots += uint64(len(keys))
+		if !more || len(keys) == 0 {
+			break
+		}
+		next = incHash(res[len(res)-1].Hash)
+	}
+
+	if hash, err := st.Commit(); err != nil {
+		return err
+	} else if hash != root {
+		return fmt.Errorf("%w: storage trie root %s, want %s", ErrBadResponse, hash, root)
+	}
+	d.metrics.slotsIn.Mark(int64(slots))
+	atomic.AddUint64(&d.syncedSlots, slots)
+	return nil
+}
+
+// syncCodes downloads contract codes from the peer
+func (d *Downloader) syncCodes(ctx context.Context, peer *carrack.Peer, hashes []common.Hash, batch ethdb.KeyValueWriter) error {
+	for len(hashes) > 0 {
+		chunk := hashes
+		if len(chunk) > MaxCodeFetch {
+			chunk = chunk[:MaxCodeFetch]
+		}
+		hashes = hashes[len(chunk):]
+
+		codes := make([][]byte, len(chunk))
+		if err := requestHashed(ctx, peer, chunk, codes, peer.RequestByteCodes); err != nil {
+			return err
+		}
+		for i, code := range codes {
+			rawdb.WriteCode(batch, chunk[i], code)
+		}
+		d.metrics.codesIn.Mark(int64(len(codes)))
+		atomic.AddUint64(&d.syncedCodes, uint64(len(codes)))
+	}
+	return nil
+}
+
+// healState downloads trie nodes and codes missing after ranges are written, e.g. nodes above the ranges subtrees
+func (d *Downloader) healState(ctx context.Context, root common.Hash) error {
+	db := d.chain.StateDatabase().Underlying().TrieDB().DiskDB()
+	sched := gethstate.NewStateSync(root, db, nil)
+	for sched.Pending() > 0 {
+		paths, nodes, codes := sched.Missing(MaxTrieNodeFetch)
+		if len(nodes)+len(codes) == 0 {
+			return fmt.Errorf("state heal stalled with %d pending items", sched.Pending())
+		}
+
+		nodeData, err := d.fetchHashed(ctx, nodes, MaxTrieNodeFetch/4, func(peer *carrack.Peer) hashedRequest {
+			return peer.RequestTrieNodes
+		})
+		if err != nil {
+			return err
+		}
+		codeData, err := d.fetchHashed(ctx, codes, MaxCodeFetch, func(peer *carrack.Peer) hashedRequest {
+			return peer.RequestByteCodes
+		})
+		if err != nil {
+			return err
+		}
+
+		for i, data := range nodeData {
+			if err := sched.ProcessNode(trie.NodeSyncResult{Path: paths[i], Data: data}); err != nil {
+				return err
+			}
+		}
+		for i, data := range codeData {
+			if err := sched.ProcessCode(trie.CodeSyncResult{Hash: codes[i], Data: data}); err != nil {
+				return err
+			}
+		}
+		batch := db.NewBatch()
+		if err := sched.Commit(batch); err != nil {
+			return err
+		}
+		if err := batch.Write(); err != nil {
+			return err
+		}
+
+		d.metrics.nodesIn.Mark(int64(len(nodeData)))
+		atomic.AddUint64(&d.healedNodes, uint64(len(nodeData)))
+		d.logger.Infow("Healed state", "root", root, "nodes", len(nodeData), "codes", len(codeData), "pending", sched.Pending())
+	}
+	return nil
+}
+
+// hashedRequest fetches blobs by their hashes, unknown ones are empty
+type hashedRequest func(ctx context.Context, hashes []common.Hash) ([][]byte, error)
+
+// fetchHashed downloads blobs by their hashes concurrently from peers
+func (d *Downloader) fetchHashed(ctx context.Context, hashes []common.Hash, size int, request func(peer *carrack.Peer) hashedRequest) ([][]byte, error) {
+	blobs := make([][]byte, len(hashes))
+	tasks := (len(hashes) + size - 1) / size
+	err := d.fetch(ctx, tasks, func(ctx context.Context, peer *carrack.Peer, task int) error {
+		if peer.Version() < carrack.Carrack2 {
+			return ErrNotAvailable
+		}
+		end := (task + 1) * size
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		return requestHashed(ctx, peer, hashes[task*size:end], blobs[task*size:end], request(peer))
+	})
+	return blobs, err
+}
+
+// requestHashed fills blobs of the hashes requesting missing ones from the peer until it has no more of them
+func requestHashed(ctx context.Context, peer *carrack.Peer, hashes []common.Hash, blobs [][]byte, request hashedRequest) error {
+	for {
+		var (
+			pending []int
+			missing []common.Hash
+		)
+		for i, blob := range blobs {
+			if blob == nil {
+				pending = append(pending, i)
+				missing = append(missing, hashes[i])
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		res, err := request(ctx, missing)
+		if err != nil {
+			return err
+		}
+		delivered := 0
+		for i, blob := range res {
+			if len(blob) == 0 {
+				continue
+			}
+			if crypto.Keccak256Hash(blob) != missing[i] {
+				return fmt.Errorf("%w: blob of %s has hash %s", ErrBadResponse, missing[i], crypto.Keccak256Hash(blob))
+			}
+			blobs[pending[i]] = blob
+			delivered++
+		}
+		if delivered == 0 {
+			return ErrNotAvailable
+		}
+	}
+}
+
+// verifyRange checks the range proof of trie leaves starting at the origin, it reports whether there are more leaves
+func verifyRange(root, origin common.Hash, keys, values [][]byte, proof [][]byte) (bool, error) {
+	proofDb := memorydb.New()
+	for _, node := range proof {
+		if err := proofDb.Put(crypto.Keccak256(node), node); err != nil {
+			return false, err
+		}
+	}
+	var last []byte
+	if len(keys) > 0 {
+		last = keys[len(keys)-1]
+	}
+	return trie.VerifyRangeProof(root, origin[:], last, keys, values, proofDb)
+}
+
+// incHash returns the next hash, zero hash follows the max one
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
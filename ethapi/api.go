@@ -0,0 +1,278 @@
+package ethapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// EthAPI provides chain, state and transaction methods of the 'eth' namespace
+type EthAPI struct {
+	b Backend
+}
+
+func NewEthAPI(b Backend) *EthAPI {
+	return &EthAPI{b: b}
+}
+
+// ChainId returns EIP-155 chain id
+func (api *EthAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(api.b.ChainConfig().ChainID)
+}
+
+// BlockNumber returns the number of the canonical head
+func (api *EthAPI) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(api.b.CurrentBlock().NumberU64())
+}
+
+// Syncing returns false once the node is synced, otherwise the synchronisation progress
+func (api *EthAPI) Syncing() (interface{}, error) {
+	progress := api.b.SyncProgress()
+	if progress.CurrentBlock >= progress.HighestBlock {
+		return false, nil
+	}
+	return map[string]interface{}{
+		"startingBlock": hexutil.Uint64(progress.StartingBlock),
+		"currentBlock":  hexutil.Uint64(progress.CurrentBlock),
+		"highestBlock":  hexutil.Uint64(progress.HighestBlock),
+	}, nil
+}
+
+func (api *EthAPI) GetBalance(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	statedb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(statedb.GetBalance(address)), statedb.Error()
+}
+
+// GetTransactionCount returns account nonce, 'pending' includes transactions in the pool
+func (api *EthAPI) GetTransactionCount(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		nonce, err := api.b.GetPoolNonce(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		return (*hexutil.Uint64)(&nonce), nil
+	}
+
+	statedb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	nonce := statedb.GetNonce(address)
+	return (*hexutil.Uint64)(&nonce), statedb.Error()
+}
+
+func (api *EthAPI) GetCode(ctx context.Context, address common.Address, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	statedb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	return statedb.GetCode(address), statedb.Error()
+}
+
+func (api *EthAPI) GetStorageAt(ctx context.Context, address common.Address, key string, blockNrOrHash rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	statedb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+	value := statedb.GetState(address, common.HexToHash(key))
+	return value[:], statedb.Error()
+}
+
+// GetProof returns Merkle proofs of the account and its storage slots
+func (api *EthAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNrOrHash rpc.BlockNumberOrHash) (*state.AccountResult, error) {
+	statedb, _, err := api.b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if statedb == nil || err != nil {
+		return nil, err
+	}
+
+	keys := make([]common.Hash, len(storageKeys))
+	for i, key := range storageKeys {
+		keys[i] = common.HexToHash(key)
+	}
+	return statedb.AccountProof(address, keys)
+}
+
+// GetBlockByNumber returns block by number, 'pending' returns the block being built by the node
+func (api *EthAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	block, err := api.b.BlockByNumber(ctx, number)
+	if block == nil || err != nil {
+		return nil, err
+	}
+
+	fields := RPCMarshalBlock(block, fullTx, api.b.ChainConfig())
+	if number == rpc.PendingBlockNumber {
+		// pending block fields, which are not known until it is sealed
+		for _, field := range []string{"hash", "nonce", "miner"} {
+			fields[field] = nil
+		}
+	}
+	return fields, nil
+}
+
+func (api *EthAPI) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	block, err := api.b.BlockByHash(ctx, hash)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return RPCMarshalBlock(block, fullTx, api.b.ChainConfig()), nil
+}
+
+func (api *EthAPI) GetBlockTransactionCountByNumber(ctx context.Context, number rpc.BlockNumber) (*hexutil.Uint, error) {
+	block, err := api.b.BlockByNumber(ctx, number)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	count := hexutil.Uint(len(block.Transactions()))
+	return &count, nil
+}
+
+// GetTransactionByHash returns mined or pool transaction
+func (api *EthAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (*RPCTransaction, error) {
+	tx, blockHash, blockNumber, index, err := api.b.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		block, err := api.b.BlockByHash(ctx, blockHash)
+		if block == nil || err != nil {
+			return nil, err
+		}
+		return newRPCTransaction(tx, blockHash, blockNumber, index, block.BaseFee(), api.b.ChainConfig()), nil
+	}
+
+	if tx := api.b.GetPoolTransaction(hash); tx != nil {
+		return newRPCTransaction(tx, common.Hash{}, 0, 0, nil, api.b.ChainConfig()), nil
+	}
+	return nil, nil
+}
+
+// GetTransactionReceipt returns receipt of the mined transaction
+func (api *EthAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	tx, blockHash, blockNumber, index, err := api.b.GetTransaction(ctx, hash)
+	if tx == nil || err != nil {
+		return nil, err
+	}
+	receipts, err := api.b.GetReceipts(ctx, blockHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(receipts) <= int(index) {
+		return nil, nil
+	}
+	receipt := receipts[index]
+
+	block, err := api.b.BlockByHash(ctx, blockHash)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	from, _ := types.Sender(types.LatestSigner(api.b.ChainConfig().EthConfig()), tx)
+
+	fields := map[string]interface{}{
+		"blockHash":         blockHash,
+		"blockNumber":       hexutil.Uint64(blockNumber),
+		"transactionHash":   hash,
+		"transactionIndex":  hexutil.Uint64(index),
+		"from":              from,
+		"to":                tx.To(),
+		"gasUsed":           hexutil.Uint64(receipt.GasUsed),
+		"cumulativeGasUsed": hexutil.Uint64(receipt.CumulativeGasUsed),
+		"contractAddress":   nil,
+		"logs":              receipt.Logs,
+		"logsBloom":         receipt.Bloom,
+		"type":              hexutil.Uint(tx.Type()),
+		"status":            hexutil.Uint(receipt.Status),
+	}
+	if baseFee := block.BaseFee(); baseFee != nil {
+		fields["effectiveGasPrice"] = (*hexutil.Big)(new(big.Int).Add(baseFee, tx.EffectiveGasTipValue(baseFee)))
+	} else {
+		fields["effectiveGasPrice"] = (*hexutil.Big)(tx.GasPrice())
+	}
+	if receipt.Logs == nil {
+		fields["logs"] = []*types.Log{}
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		fields["contractAddress"] = receipt.ContractAddress
+	}
+	return fields, nil
+}
+
+// GasPrice returns suggested legacy transaction gas price, the tip plus base fee of the head
+func (api *EthAPI) GasPrice(ctx context.Context) (*hexutil.Big, error) {
+	tip, err := api.b.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if head := api.b.CurrentBlock(); head.BaseFee() != nil {
+		tip.Add(tip, head.BaseFee())
+	}
+	return (*hexutil.Big)(tip), nil
+}
+
+// MaxPriorityFeePerGas returns suggested tip of dynamic fee transactions
+func (api *EthAPI) MaxPriorityFeePerGas(ctx context.Context) (*hexutil.Big, error) {
+	tip, err := api.b.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(tip), nil
+}
+
+type feeHistoryResult struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas,omitempty"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+// FeeHistory returns base fees, gas usage ratios and tip percentiles of the range of blocks ending with the last block
+func (api *EthAPI) FeeHistory(ctx context.Context, blockCount rpc.DecimalOrHex, lastBlock rpc.BlockNumber, rewardPercentiles []float64) (*feeHistoryResult, error) {
+	history, err := api.b.FeeHistory(ctx, int(blockCount), lastBlock, rewardPercentiles)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &feeHistoryResult{
+		OldestBlock:  (*hexutil.Big)(history.OldestBlock),
+		GasUsedRatio: history.GasUsedRatio,
+	}
+	if history.Reward != nil {
+		result.Reward = make([][]*hexutil.Big, len(history.Reward))
+		for i, rewards := range history.Reward {
+			result.Reward[i] = make([]*hexutil.Big, len(rewards))
+			for j, reward := range rewards {
+				result.Reward[i][j] = (*hexutil.Big)(reward)
+			}
+		}
+	}
+	if history.BaseFee != nil {
+		result.BaseFee = make([]*hexutil.Big, len(history.BaseFee))
+		for i, fee := range history.BaseFee {
+			result.BaseFee[i] = (*hexutil.Big)(fee)
+		}
+	}
+	return result, nil
+}
+
+// SendRawTransaction submits signed rlp encoded transaction to the pool
+func (api *EthAPI) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if !tx.Protected() {
+		return common.Hash{}, errors.New("only replay-protected (EIP-155) transactions allowed over RPC")
+	}
+	if err := api.b.SendTx(ctx, tx); err != nil {
+		return common.Hash{}, fmt.Errorf("transaction rejected: %w", err)
+	}
+	return tx.Hash(), nil
+}
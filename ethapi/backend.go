@@ -0,0 +1,45 @@
+package ethapi
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/downloader"
+	"github.com/rovergulf/chain/gasprice"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// Backend is the node functionality used by RPC APIs
+type Backend interface {
+	ChainConfig() *params.ChainConfig
+	CurrentBlock() *types.Block
+	SyncProgress() downloader.Progress
+
+	// HeaderByNumber, BlockByNumber and StateAndHeaderByNumberOrHash resolve 'pending' to the pending block
+	HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error)
+	BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error)
+	StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Header, error)
+	GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error)
+	GetTransaction(ctx context.Context, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error)
+
+	SendTx(ctx context.Context, tx *types.Transaction) error
+	GetPoolTransaction(hash common.Hash) *types.Transaction
+	GetPoolNonce(ctx context.Context, addr common.Address) (uint64, error)
+
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*gasprice.FeeHistory, error)
+}
+
+// APIs returns RPC services provided by the backend
+func APIs(b Backend) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "eth",
+			Service:   NewEthAPI(b),
+		},
+	}
+}
@@ -0,0 +1,153 @@
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/core"
+	"math/big"
+	"sort"
+)
+
+// maxPercentiles bounds the number of requested reward percentiles
+const maxPercentiles = 100
+
+var (
+	ErrInvalidPercentile = errors.New("invalid reward percentile")
+	ErrRequestBeyondHead = errors.New("request beyond head block")
+)
+
+// FeeHistory is the fee market data of the range of blocks
+type FeeHistory struct {
+	OldestBlock  *big.Int     // number of the first block of the range
+	Reward       [][]*big.Int // tips at the requested percentiles of block gas used, per block
+	BaseFee      []*big.Int   // base fees per block, including the block following the range
+	GasUsedRatio []float64    // gas used to gas limit ratio per block
+}
+
+// FeeHistory returns base fees, gas usage and tip percentiles of up to blocks blocks ending with the last block.
+// Tip percentiles are weighted by gas used by transactions
+func (o *Oracle) FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*FeeHistory, error) {
+	if len(percentiles) > maxPercentiles {
+		return nil, fmt.Errorf("%w: too many percentiles %d", ErrInvalidPercentile, len(percentiles))
+	}
+	for i, p := range percentiles {
+		if p < 0 || p > 100 || (i > 0 && p < percentiles[i-1]) {
+			return nil, fmt.Errorf("%w: #%d %f", ErrInvalidPercentile, i, p)
+		}
+	}
+	if blocks > o.config.MaxHeaderHistory {
+		blocks = o.config.MaxHeaderHistory
+	}
+	if len(percentiles) > 0 && blocks > o.config.MaxBlockHistory {
+		blocks = o.config.MaxBlockHistory
+	}
+	if blocks < 1 {
+		return &FeeHistory{OldestBlock: new(big.Int)}, nil
+	}
+
+	head, err := o.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	last := head.Number.Uint64()
+	if lastBlock >= 0 {
+		if uint64(lastBlock) > last {
+			return nil, fmt.Errorf("%w: requested %d, head %d", ErrRequestBeyondHead, lastBlock, last)
+		}
+		last = uint64(lastBlock)
+	} else if lastBlock == rpc.EarliestBlockNumber {
+		last = 0
+	}
+	if uint64(blocks) > last+1 {
+		blocks = int(last + 1)
+	}
+	oldest := last + 1 - uint64(blocks)
+
+	history := &FeeHistory{
+		OldestBlock:  new(big.Int).SetUint64(oldest),
+		BaseFee:      make([]*big.Int, blocks+1),
+		GasUsedRatio: make([]float64, blocks),
+	}
+	if len(percentiles) > 0 {
+		history.Reward = make([][]*big.Int, blocks)
+	}
+
+	config := o.backend.ChainConfig()
+	var header *types.Header
+	for i := 0; i < blocks; i++ {
+		number := rpc.BlockNumber(oldest + uint64(i))
+		if len(percentiles) > 0 {
+			block, err := o.backend.BlockByNumber(ctx, number)
+			if err != nil {
+				return nil, err
+			}
+			if block == nil {
+				return nil, fmt.Errorf("%w: missing block %d", ErrRequestBeyondHead, number)
+			}
+			receipts, err := o.backend.GetReceipts(ctx, block.Hash())
+			if err != nil {
+				return nil, err
+			}
+			header = block.Header()
+			history.Reward[i] = rewardPercentiles(block, receipts, percentiles)
+		} else if header, err = o.backend.HeaderByNumber(ctx, number); err != nil {
+			return nil, err
+		} else if header == nil {
+			return nil, fmt.Errorf("%w: missing block %d", ErrRequestBeyondHead, number)
+		}
+
+		history.BaseFee[i] = new(big.Int)
+		if header.BaseFee != nil {
+			history.BaseFee[i].Set(header.BaseFee)
+		}
+		if header.GasLimit > 0 {
+			history.GasUsedRatio[i] = float64(header.GasUsed) / float64(header.GasLimit)
+		}
+	}
+
+	history.BaseFee[blocks] = new(big.Int)
+	if config.IsLondon(new(big.Int).Add(header.Number, big.NewInt(1)), header.Time) {
+		history.BaseFee[blocks] = core.CalcBaseFee(config, header)
+	}
+	return history, nil
+}
+
+// rewardPercentiles returns effective tips at the percentiles of the block gas used
+func rewardPercentiles(block *types.Block, receipts types.Receipts, percentiles []float64) []*big.Int {
+	rewards := make([]*big.Int, len(percentiles))
+	txs := block.Transactions()
+	if len(txs) == 0 || len(receipts) != len(txs) {
+		for i := range rewards {
+			rewards[i] = new(big.Int)
+		}
+		return rewards
+	}
+
+	type txGasAndReward struct {
+		gasUsed uint64
+		reward  *big.Int
+	}
+	sorted := make([]txGasAndReward, len(txs))
+	for i, tx := range txs {
+		reward, _ := tx.EffectiveGasTip(block.BaseFee())
+		sorted[i] = txGasAndReward{gasUsed: receipts[i].GasUsed, reward: reward}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].reward.Cmp(sorted[j].reward) < 0
+	})
+
+	var txIndex int
+	sumGasUsed := sorted[0].gasUsed
+	for i, p := range percentiles {
+		threshold := uint64(float64(block.GasUsed()) * p / 100)
+		for sumGasUsed < threshold && txIndex < len(txs)-1 {
+			txIndex++
+			sumGasUsed += sorted[txIndex].gasUsed
+		}
+		rewards[i] = new(big.Int).Set(sorted[txIndex].reward)
+	}
+	return rewards
+}
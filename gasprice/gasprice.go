@@ -0,0 +1,174 @@
+package gasprice
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	chainparams "github.com/rovergulf/chain/params"
+	"github.com/spf13/viper"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// sampleNumber is the number of the lowest transaction tips sampled per block
+const sampleNumber = 3
+
+// Config are the gas price oracle settings
+type Config struct {
+	Blocks           int      `json:"blocks" yaml:"blocks"`                         // number of recent blocks sampled
+	Percentile       int      `json:"percentile" yaml:"percentile"`                 // percentile of sampled tips suggested
+	MaxHeaderHistory int      `json:"max_header_history" yaml:"max_header_history"` // max number of blocks of fee history
+	MaxBlockHistory  int      `json:"max_block_history" yaml:"max_block_history"`   // max number of blocks fee history rewards are calculated for
+	Default          *big.Int `json:"default" yaml:"default"`                       // tip suggested if there are no samples
+	MaxPrice         *big.Int `json:"max_price" yaml:"max_price"`                   // upper bound of the suggested tip
+	IgnorePrice      *big.Int `json:"ignore_price" yaml:"ignore_price"`             // lower tips are not sampled
+}
+
+var DefaultConfig = Config{
+	Blocks:           20,
+	Percentile:       60,
+	MaxHeaderHistory: 1024,
+	MaxBlockHistory:  1024,
+	Default:          big.NewInt(params.GWei),
+	MaxPrice:         big.NewInt(500 * params.GWei),
+	IgnorePrice:      big.NewInt(2 * params.Wei),
+}
+
+// NewConfig reads 'gpo' config section, using defaults for unset values
+func NewConfig(cfg *viper.Viper) Config {
+	conf := Config{
+		Blocks:           cfg.GetInt("gpo.blocks"),
+		Percentile:       cfg.GetInt("gpo.percentile"),
+		MaxHeaderHistory: cfg.GetInt("gpo.max_header_history"),
+		MaxBlockHistory:  cfg.GetInt("gpo.max_block_history"),
+		Default:          new(big.Int).SetUint64(cfg.GetUint64("gpo.default")),
+		MaxPrice:         new(big.Int).SetUint64(cfg.GetUint64("gpo.max_price")),
+		IgnorePrice:      new(big.Int).SetUint64(cfg.GetUint64("gpo.ignore_price")),
+	}
+	return conf.sanitize()
+}
+
+func (c Config) sanitize() Config {
+	if c.Blocks < 1 {
+		c.Blocks = DefaultConfig.Blocks
+	}
+	if c.Percentile < 0 || c.Percentile > 100 {
+		c.Percentile = DefaultConfig.Percentile
+	}
+	if c.MaxHeaderHistory < 1 {
+		c.MaxHeaderHistory = DefaultConfig.MaxHeaderHistory
+	}
+	if c.MaxBlockHistory < 1 {
+		c.MaxBlockHistory = DefaultConfig.MaxBlockHistory
+	}
+	if c.Default == nil || c.Default.Sign() <= 0 {
+		c.Default = DefaultConfig.Default
+	}
+	if c.MaxPrice == nil || c.MaxPrice.Sign() <= 0 {
+		c.MaxPrice = DefaultConfig.MaxPrice
+	}
+	if c.IgnorePrice == nil || c.IgnorePrice.Sign() <= 0 {
+		c.IgnorePrice = DefaultConfig.IgnorePrice
+	}
+	return c
+}
+
+// Backend is the chain the oracle samples blocks of
+type Backend interface {
+	ChainConfig() *chainparams.ChainConfig
+	HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error)
+	BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error)
+	GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error)
+}
+
+// Oracle suggests transaction tips based on the tips paid in recent blocks
+type Oracle struct {
+	backend Backend
+	config  Config
+
+	mu        sync.Mutex // protects cache
+	cacheHead common.Hash
+	cacheTip  *big.Int
+}
+
+func NewOracle(backend Backend, config Config) *Oracle {
+	return &Oracle{
+		backend: backend,
+		config:  config.sanitize(),
+	}
+}
+
+// SuggestTipCap returns the tip, which is enough for transaction to be included in a timely manner.
+// Lowest tips of recent blocks are sampled and the configured percentile is returned
+func (o *Oracle) SuggestTipCap(ctx context.Context) (*big.Int, error) {
+	head, err := o.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if err != nil {
+		return nil, err
+	}
+	headHash := head.Hash()
+
+	o.mu.Lock()
+	if o.cacheHead == headHash && o.cacheTip != nil {
+		tip := new(big.Int).Set(o.cacheTip)
+		o.mu.Unlock()
+		return tip, nil
+	}
+	o.mu.Unlock()
+
+	var samples []*big.Int
+	number := head.Number.Uint64()
+	for i := 0; i < o.config.Blocks && number > 0; i++ {
+		block, err := o.backend.BlockByNumber(ctx, rpc.BlockNumber(number))
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			break
+		}
+		samples = append(samples, o.lowestTips(block)...)
+		number--
+	}
+
+	tip := new(big.Int).Set(o.config.Default)
+	if len(samples) > 0 {
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i].Cmp(samples[j]) < 0
+		})
+		tip.Set(samples[(len(samples)-1)*o.config.Percentile/100])
+	}
+	if tip.Cmp(o.config.MaxPrice) > 0 {
+		tip.Set(o.config.MaxPrice)
+	}
+
+	o.mu.Lock()
+	o.cacheHead, o.cacheTip = headHash, tip
+	o.mu.Unlock()
+	return new(big.Int).Set(tip), nil
+}
+
+// lowestTips returns the lowest tips of block transactions, ignoring those paid by the block author to itself
+func (o *Oracle) lowestTips(block *types.Block) []*big.Int {
+	signer := types.MakeSigner(o.backend.ChainConfig().EthConfigAt(block.Number(), block.Time()), block.Number())
+
+	var tips []*big.Int
+	for _, tx := range block.Transactions() {
+		tip, err := tx.EffectiveGasTip(block.BaseFee())
+		if err != nil || tip.Cmp(o.config.IgnorePrice) < 0 {
+			continue
+		}
+		if sender, err := types.Sender(signer, tx); err == nil && sender == block.Coinbase() {
+			continue
+		}
+		tips = append(tips, tip)
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		return tips[i].Cmp(tips[j]) < 0
+	})
+	if len(tips) > sampleNumber {
+		tips = tips[:sampleNumber]
+	}
+	return tips
+}
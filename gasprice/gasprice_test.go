@@ -0,0 +1,152 @@
+package gasprice
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+type testBackend struct {
+	config   *params.ChainConfig
+	blocks   []*types.Block
+	receipts []types.Receipts
+}
+
+func (b *testBackend) ChainConfig() *params.ChainConfig {
+	return b.config
+}
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	block, err := b.BlockByNumber(ctx, number)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return block.Header(), nil
+}
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	if number < 0 {
+		return b.blocks[len(b.blocks)-1], nil
+	}
+	if int(number) >= len(b.blocks) {
+		return nil, nil
+	}
+	return b.blocks[number], nil
+}
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	for i, block := range b.blocks {
+		if block.Hash() == hash {
+			return b.receipts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// newTestBackend generates London chain, block i contains transactions with tips i+1 and 10*(i+1)
+func newTestBackend(t *testing.T, blocks int) *testBackend {
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := *params.DevChainConfig
+	config.LondonBlock = big.NewInt(0)
+	genesis := &core.Genesis{
+		Config:   &config,
+		GasLimit: core.DefaultGenesisGasLimit,
+		Alloc: core.GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+
+	sdb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	genesisBlock, err := genesis.ToBlock(sdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := types.LatestSigner(config.EthConfig())
+	chain, receipts, err := core.GenerateChain(&config, genesisBlock, sdb, blocks, func(i int, b *core.BlockGen) {
+		for _, tip := range []int64{int64(i + 1), int64(10 * (i + 1))} {
+			tx := types.MustSignNewTx(key, signer, &types.DynamicFeeTx{
+				ChainID:   config.ChainID,
+				Nonce:     b.TxNonce(tests.Account0),
+				GasTipCap: big.NewInt(tip),
+				GasFeeCap: new(big.Int).Add(b.BaseFee(), big.NewInt(tip)),
+				Gas:       21_000,
+				To:        &tests.Account1,
+			})
+			b.AddTx(tx)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testBackend{
+		config:   &config,
+		blocks:   append([]*types.Block{genesisBlock}, chain...),
+		receipts: append([]types.Receipts{nil}, receipts...),
+	}
+}
+
+func TestSuggestTipCap(t *testing.T) {
+	backend := newTestBackend(t, 5)
+
+	config := DefaultConfig
+	config.Blocks = 3
+	config.Percentile = 50
+	config.IgnorePrice = big.NewInt(4)
+	oracle := NewOracle(backend, config)
+
+	// samples of the last three blocks are 30, 4, 40, 5, 50, tips of 3 are ignored
+	tip, err := oracle.SuggestTipCap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip.Int64() != 30 {
+		t.Fatalf("unexpected suggested tip: %s", tip)
+	}
+
+	config.MaxPrice = big.NewInt(20)
+	if tip, err = NewOracle(backend, config).SuggestTipCap(context.Background()); err != nil || tip.Int64() != 20 {
+		t.Fatalf("suggested tip is not capped: %s, %v", tip, err)
+	}
+}
+
+func TestFeeHistory(t *testing.T) {
+	backend := newTestBackend(t, 5)
+	oracle := NewOracle(backend, DefaultConfig)
+
+	history, err := oracle.FeeHistory(context.Background(), 3, rpc.LatestBlockNumber, []float64{0, 50, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.OldestBlock.Uint64() != 3 || len(history.BaseFee) != 4 || len(history.GasUsedRatio) != 3 {
+		t.Fatalf("unexpected fee history range: %d, %d base fees", history.OldestBlock, len(history.BaseFee))
+	}
+	for i, rewards := range history.Reward {
+		low, high := int64(i+3), int64(10*(i+3))
+		if rewards[0].Int64() != low || rewards[1].Int64() != low || rewards[2].Int64() != high {
+			t.Errorf("unexpected rewards of block #%d: %v", i+3, rewards)
+		}
+	}
+	if next := core.CalcBaseFee(backend.config, backend.blocks[5].Header()); history.BaseFee[3].Cmp(next) != 0 {
+		t.Fatalf("unexpected next base fee: have %s, want %s", history.BaseFee[3], next)
+	}
+
+	if _, err := oracle.FeeHistory(context.Background(), 1, 10, nil); err == nil {
+		t.Fatal("expected request beyond head error")
+	}
+	if _, err := oracle.FeeHistory(context.Background(), 1, rpc.LatestBlockNumber, []float64{50, 10}); err == nil {
+		t.Fatal("expected invalid percentile error")
+	}
+}
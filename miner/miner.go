@@ -0,0 +1,286 @@
+package miner
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/txpool"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoEtherbase = errors.New("etherbase is not set")
+
+// Config are the block production settings
+type Config struct {
+	Etherbase common.Address `json:"etherbase" yaml:"etherbase"` // block rewards and fees receiver, clique pays the signer instead
+	GasCeil   uint64         `json:"gas_ceil" yaml:"gas_ceil"`   // target block gas limit
+	Interval  time.Duration  `json:"interval" yaml:"interval"`   // time interval between produced blocks
+	ExtraData []byte         `json:"extra_data" yaml:"extra_data"`
+}
+
+var DefaultConfig = Config{
+	GasCeil:  core.DefaultGenesisGasLimit,
+	Interval: 5 * time.Second,
+}
+
+// NewConfig reads 'miner' config section, using defaults for unset values
+func NewConfig(cfg *viper.Viper) Config {
+	conf := Config{
+		Etherbase: common.HexToAddress(cfg.GetString("miner.etherbase")),
+		GasCeil:   cfg.GetUint64("miner.gas_ceil"),
+		Interval:  cfg.GetDuration("miner.interval"),
+		ExtraData: []byte(cfg.GetString("miner.extra_data")),
+	}
+	if conf.GasCeil == 0 {
+		conf.GasCeil = DefaultConfig.GasCeil
+	}
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultConfig.Interval
+	}
+	return conf
+}
+
+// Miner produces blocks of pending pool transactions on top of the canonical head
+// and keeps the pending block view up to date
+type Miner struct {
+	config Config
+	engine consensus.Engine
+	chain  *core.BlockChain
+	txPool *txpool.TxPool
+	logger *zap.SugaredLogger
+
+	mu      sync.RWMutex // protects etherbase and pending
+	pending *environment
+
+	mining int32
+
+	startCh chan struct{}
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New returns miner, which keeps pending block updated, but does not produce blocks until started
+func New(config Config, chain *core.BlockChain, txPool *txpool.TxPool, engine consensus.Engine, logger *zap.SugaredLogger) *Miner {
+	m := &Miner{
+		config:  config,
+		engine:  engine,
+		chain:   chain,
+		txPool:  txPool,
+		logger:  logger,
+		startCh: make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.loop()
+
+	return m
+}
+
+// Start begins block production
+func (m *Miner) Start() error {
+	if m.Etherbase() == (common.Address{}) {
+		return ErrNoEtherbase
+	}
+	if atomic.CompareAndSwapInt32(&m.mining, 0, 1) {
+		m.logger.Infow("Starting block production", "etherbase", m.Etherbase(), "interval", m.config.Interval)
+		select {
+		case m.startCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Stop pauses block production, pending block is still updated
+func (m *Miner) Stop() {
+	if atomic.CompareAndSwapInt32(&m.mining, 1, 0) {
+		m.logger.Info("Block production stopped")
+	}
+}
+
+func (m *Miner) Mining() bool {
+	return atomic.LoadInt32(&m.mining) == 1
+}
+
+// Close terminates the miner
+func (m *Miner) Close() {
+	m.Stop()
+	close(m.quit)
+	m.wg.Wait()
+}
+
+func (m *Miner) Etherbase() common.Address {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.config.Etherbase
+}
+
+func (m *Miner) SetEtherbase(addr common.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config.Etherbase = addr
+}
+
+// Pending returns pending block and a copy of its state
+func (m *Miner) Pending() (*types.Block, *state.StateDB) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.pending == nil {
+		return nil, nil
+	}
+	return m.pending.block, m.pending.state.Copy()
+}
+
+// PendingBlock returns block with pending transactions, which would be produced on top of the current head
+func (m *Miner) PendingBlock() *types.Block {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.pending == nil {
+		return nil
+	}
+	return m.pending.block
+}
+
+func (m *Miner) PendingBlockAndReceipts() (*types.Block, types.Receipts) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.pending == nil {
+		return nil, nil
+	}
+	return m.pending.block, m.pending.receipts
+}
+
+func (m *Miner) loop() {
+	defer m.wg.Done()
+
+	chainHeadCh := make(chan core.ChainHeadEvent, 16)
+	chainHeadSub := m.chain.SubscribeChainHeadEvent(chainHeadCh)
+	defer chainHeadSub.Unsubscribe()
+
+	txsCh := make(chan core.NewTxsEvent, 256)
+	txsSub := m.txPool.SubscribeNewTxsEvent(txsCh)
+	defer txsSub.Unsubscribe()
+
+	timer := time.NewTimer(m.config.Interval)
+	defer timer.Stop()
+
+	m.updatePending()
+
+	for {
+		select {
+		case <-m.startCh:
+			timer.Reset(m.config.Interval)
+
+		case <-timer.C:
+			if m.Mining() {
+				m.commit(chainHeadCh)
+				timer.Reset(m.config.Interval)
+			}
+
+		case <-chainHeadCh:
+			m.updatePending()
+
+		case <-txsCh:
+			m.updatePending()
+
+		case <-chainHeadSub.Err():
+			return
+		case <-txsSub.Err():
+			return
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// updatePending rebuilds pending block on top of the current head
+func (m *Miner) updatePending() {
+	env, err := m.buildWork(time.Now())
+	if err != nil {
+		m.logger.Warnw("Unable to build pending block", "err", err)
+		return
+	}
+
+	m.mu.Lock()
+	m.pending = env
+	m.mu.Unlock()
+}
+
+// commit builds a new block, passes it to the engine for sealing and writes the sealed block into the chain.
+// Sealing is aborted if the chain head changes meanwhile
+func (m *Miner) commit(chainHeadCh <-chan core.ChainHeadEvent) {
+	env, err := m.buildWork(time.Now())
+	if err != nil {
+		m.logger.Errorw("Unable to build block", "err", err)
+		return
+	}
+
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if err := m.engine.Seal(m.chain, env.block, results, stop); err != nil {
+		m.logger.Warnw("Block sealing failed", "number", env.block.NumberU64(), "err", err)
+		return
+	}
+
+	select {
+	case block := <-results:
+		if block == nil {
+			return
+		}
+		if err := m.writeBlock(env, block); err != nil {
+			m.logger.Errorw("Unable to write sealed block", "number", block.NumberU64(), "hash", block.Hash(), "err", err)
+		}
+
+	case <-chainHeadCh:
+		m.logger.Debugw("Chain head changed, sealing aborted", "number", env.block.NumberU64())
+		m.updatePending()
+
+	case <-m.quit:
+	}
+}
+
+// writeBlock sets sealed block hash to receipts and logs and writes the block with its state
+func (m *Miner) writeBlock(env *environment, block *types.Block) error {
+	var (
+		hash     = block.Hash()
+		receipts = make(types.Receipts, len(env.receipts))
+		logs     []*types.Log
+	)
+	for i, r := range env.receipts {
+		receipt := new(types.Receipt)
+		*receipt = *r
+		receipt.BlockHash = hash
+		receipt.Logs = make([]*types.Log, len(r.Logs))
+		for j, l := range r.Logs {
+			log := new(types.Log)
+			*log = *l
+			log.BlockHash = hash
+			receipt.Logs[j] = log
+		}
+		receipts[i] = receipt
+		logs = append(logs, receipt.Logs...)
+	}
+
+	if err := m.chain.WriteBlockWithState(block, receipts, logs, env.state); err != nil {
+		return err
+	}
+
+	m.logger.Infow("Produced new block", "number", block.NumberU64(), "hash", hash,
+		"txs", len(block.Transactions()), "gas", block.GasUsed(), "elapsed", time.Since(env.started))
+	return nil
+}
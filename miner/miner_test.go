@@ -0,0 +1,211 @@
+package miner
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/consensus/clique"
+	"github.com/rovergulf/chain/consensus/instant"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/tests"
+	"github.com/rovergulf/chain/tests/testchain"
+	"github.com/rovergulf/chain/txpool"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestMiner(t *testing.T, config Config) (*Miner, *core.BlockChain, *txpool.TxPool) {
+	genesis := &core.Genesis{
+		Config:   params.DevChainConfig,
+		GasLimit: core.DefaultGenesisGasLimit,
+		Alloc: core.GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+	return newTestMinerWithEngine(t, config, genesis, instant.New())
+}
+
+func newTestMinerWithEngine(t *testing.T, config Config, genesis *core.Genesis, engine consensus.Engine) (*Miner, *core.BlockChain, *txpool.TxPool) {
+	logger, _ := logutils.NewLogger()
+	bc := testchain.NewWithEngine(t, genesis, engine)
+
+	pool, err := txpool.NewTxPool(txpool.DefaultConfig, bc.Config(), bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Stop)
+
+	m := New(config, bc, pool, engine, logger)
+	t.Cleanup(m.Close)
+
+	return m, bc, pool
+}
+
+func signedTransfer(t *testing.T, nonce uint64, gasPrice int64) *types.Transaction {
+	key, err := crypto.HexToECDSA(tests.PrivateKey0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := types.NewTransaction(nonce, tests.Account1, big.NewInt(1000), 21_000, big.NewInt(gasPrice), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(params.DevChainConfig.ChainID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMinerPendingBlock(t *testing.T) {
+	m, bc, pool := newTestMiner(t, DefaultConfig)
+
+	if err := m.Start(); err != ErrNoEtherbase {
+		t.Fatalf("expected missing etherbase error, got %v", err)
+	}
+
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		if err := pool.AddLocal(signedTransfer(t, nonce, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool {
+		block := m.PendingBlock()
+		return block != nil && len(block.Transactions()) == 2
+	})
+
+	block, statedb := m.Pending()
+	if block.NumberU64() != bc.CurrentBlock().NumberU64()+1 {
+		t.Fatalf("unexpected pending block number: %d", block.NumberU64())
+	}
+	if balance := statedb.GetBalance(tests.Account1); balance.Int64() != 2000 {
+		t.Fatalf("unexpected pending balance: %s", balance)
+	}
+}
+
+func TestMinerProduceBlocks(t *testing.T) {
+	config := DefaultConfig
+	config.Etherbase = tests.Account10
+	config.Interval = 50 * time.Millisecond
+	// block gas limit moves towards the lower ceiling
+	config.GasCeil = 10_000_000
+	m, bc, pool := newTestMiner(t, config)
+
+	cheap, expensive := signedTransfer(t, 0, 1), signedTransfer(t, 1, 5)
+	if errs := pool.AddLocals(types.Transactions{cheap, expensive}); errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		tx, _, _, _ := bc.GetTransaction(expensive.Hash())
+		return tx != nil
+	})
+	m.Stop()
+
+	var included int
+	for number := uint64(1); number <= bc.CurrentBlock().NumberU64(); number++ {
+		block := bc.GetBlockByNumber(number)
+		if block.GasLimit() >= bc.GetBlockByNumber(number-1).GasLimit() {
+			t.Fatalf("gas limit of block %d does not follow the ceiling", number)
+		}
+		if block.Coinbase() != tests.Account10 {
+			t.Fatalf("unexpected coinbase: %s", block.Coinbase())
+		}
+		included += len(block.Transactions())
+
+		receipts := bc.GetReceiptsByHash(block.Hash())
+		if len(receipts) != len(block.Transactions()) {
+			t.Fatalf("missing receipts of block %d", number)
+		}
+	}
+	if included != 2 {
+		t.Fatalf("unexpected number of included transactions: %d", included)
+	}
+
+	statedb, err := bc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := statedb.GetBalance(tests.Account10); balance.Sign() <= 0 {
+		t.Fatal("etherbase has not received fees")
+	}
+}
+
+// TestMinerCliqueEtherbase checks blocks produced with the etherbase other than the signer are valid for other nodes
+func TestMinerCliqueEtherbase(t *testing.T) {
+	key, err := crypto.HexToECDSA(tests.PrivateKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainConfig := *params.DevChainConfig
+	chainConfig.Clique = &params.CliqueConfig{Period: 1, Epoch: 30000}
+	extra := append(make([]byte, 32), tests.Account1.Bytes()...)
+	genesis := &core.Genesis{
+		Config:     &chainConfig,
+		Timestamp:  uint64(time.Now().Add(-time.Hour).Unix()),
+		ExtraData:  append(extra, make([]byte, crypto.SignatureLength)...),
+		GasLimit:   core.DefaultGenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Alloc: core.GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+
+	engine := clique.New(chainConfig.Clique, rawdb.NewMemoryDatabase())
+	engine.Authorize(&keystore.Key{Address: tests.Account1, PrivateKey: key})
+	config := DefaultConfig
+	config.Etherbase = tests.Account10
+	config.Interval = 50 * time.Millisecond
+	m, bc, pool := newTestMinerWithEngine(t, config, genesis, engine)
+
+	tx := signedTransfer(t, 0, 1)
+	if err := pool.AddLocal(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		tx, _, _, _ := bc.GetTransaction(tx.Hash())
+		return tx != nil
+	})
+	m.Stop()
+
+	var blocks types.Blocks
+	for number := uint64(1); number <= bc.CurrentBlock().NumberU64(); number++ {
+		blocks = append(blocks, bc.GetBlockByNumber(number))
+	}
+	other := testchain.NewWithEngine(t, genesis, clique.New(chainConfig.Clique, rawdb.NewMemoryDatabase()))
+	if _, err := other.InsertChain(blocks); err != nil {
+		t.Fatalf("produced blocks are rejected: %v", err)
+	}
+	if other.CurrentBlock().Hash() != bc.CurrentBlock().Hash() {
+		t.Fatal("produced blocks are not imported")
+	}
+
+	statedb, err := other.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance := statedb.GetBalance(tests.Account10); balance.Sign() != 0 {
+		t.Fatalf("etherbase has received fees of the clique block: %s", balance)
+	}
+	if balance := statedb.GetBalance(tests.Account1); balance.Cmp(big.NewInt(1000)) <= 0 {
+		t.Fatalf("signer has not received fees: %s", balance)
+	}
+}
@@ -0,0 +1,127 @@
+package miner
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+	"time"
+)
+
+// environment is the block being built with its execution results
+type environment struct {
+	coinbase common.Address // fees receiver, the block author which may differ from the header coinbase
+	header   *types.Header
+	state    *state.StateDB
+	gasPool  *gethcore.GasPool
+	signer   types.Signer
+	txs      []*types.Transaction
+	receipts []*types.Receipt
+	block    *types.Block
+	started  time.Time
+}
+
+// buildWork assembles unsealed block of pending transactions on top of the current head
+func (m *Miner) buildWork(now time.Time) (*environment, error) {
+	parent := m.chain.CurrentBlock()
+	config := m.chain.Config()
+
+	timestamp := uint64(now.Unix())
+	if timestamp <= parent.Time() {
+		timestamp = parent.Time() + 1
+	}
+
+	m.mu.RLock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   gethcore.CalcGasLimit(parent.GasLimit(), m.config.GasCeil),
+		Time:       timestamp,
+		Extra:      m.config.ExtraData,
+		Coinbase:   m.config.Etherbase,
+	}
+	m.mu.RUnlock()
+
+	if config.IsLondon(header.Number, header.Time) {
+		header.BaseFee = core.CalcBaseFee(config, parent.Header())
+		if !config.IsLondon(parent.Number(), parent.Time()) {
+			header.GasLimit = gethcore.CalcGasLimit(parent.GasLimit()*config.ElasticityMultiplier(), m.config.GasCeil)
+		}
+	}
+	if err := m.engine.Prepare(m.chain, header); err != nil {
+		return nil, err
+	}
+	// fees are paid the same way block import does, e.g. clique pays the signer, not the etherbase
+	coinbase := header.Coinbase
+	if sealer, ok := m.engine.(consensus.Sealer); ok {
+		coinbase = sealer.SealerAddress()
+	}
+
+	statedb, err := m.chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
+
+	env := &environment{
+		coinbase: coinbase,
+		header:   header,
+		state:    statedb,
+		gasPool:  new(gethcore.GasPool).AddGas(header.GasLimit),
+		signer:   types.MakeSigner(config.EthConfigAt(header.Number, header.Time), header.Number),
+		started:  now,
+	}
+
+	txs := types.NewTransactionsByPriceAndNonce(env.signer, m.txPool.Pending(), header.BaseFee)
+	m.commitTransactions(env, txs)
+
+	if env.block, err = m.engine.Finalize(m.chain, header, env.state, env.txs, env.receipts); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// commitTransactions applies transactions ordered by effective tip until block gas limit is reached
+func (m *Miner) commitTransactions(env *environment, txs *types.TransactionsByPriceAndNonce) {
+	config := m.chain.Config()
+	for {
+		if env.gasPool.Gas() < params.TxGas {
+			break
+		}
+		tx := txs.Peek()
+		if tx == nil {
+			break
+		}
+		from, _ := types.Sender(env.signer, tx)
+
+		snap := env.state.Snapshot()
+		env.state.Prepare(tx.Hash(), len(env.txs))
+		receipt, err := core.ApplyTransaction(config, m.chain, env.coinbase, env.gasPool, env.state, env.header, tx, &env.header.GasUsed, vm.Config{})
+		switch {
+		case err == nil:
+			env.txs = append(env.txs, tx)
+			env.receipts = append(env.receipts, receipt)
+			txs.Shift()
+
+		case errors.Is(err, gethcore.ErrGasLimitReached):
+			// next transaction of the sender would not fit either
+			env.state.RevertToSnapshot(snap)
+			txs.Pop()
+
+		case errors.Is(err, gethcore.ErrNonceTooLow):
+			// transaction is already mined, try the next one of the sender
+			env.state.RevertToSnapshot(snap)
+			txs.Shift()
+
+		default:
+			m.logger.Debugw("Skipping transaction", "hash", tx.Hash(), "from", from, "err", err)
+			env.state.RevertToSnapshot(snap)
+			txs.Pop()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/downloader"
+	"github.com/rovergulf/chain/gasprice"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+var errUnknownBlock = errors.New("unknown block")
+
+// apiBackend implements ethapi.Backend on top of the node services
+type apiBackend struct {
+	n *Node
+}
+
+func (b *apiBackend) ChainConfig() *params.ChainConfig {
+	return b.n.chain.Config()
+}
+
+func (b *apiBackend) CurrentBlock() *types.Block {
+	return b.n.chain.CurrentBlock()
+}
+
+func (b *apiBackend) SyncProgress() downloader.Progress {
+	return b.n.downloader.Progress()
+}
+
+func (b *apiBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	block, err := b.BlockByNumber(ctx, number)
+	if block == nil || err != nil {
+		return nil, err
+	}
+	return block.Header(), nil
+}
+
+func (b *apiBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	switch number {
+	case rpc.PendingBlockNumber:
+		if block := b.n.miner.PendingBlock(); block != nil {
+			return block, nil
+		}
+		return b.n.chain.CurrentBlock(), nil
+	case rpc.LatestBlockNumber:
+		return b.n.chain.CurrentBlock(), nil
+	case rpc.EarliestBlockNumber:
+		return b.n.chain.Genesis(), nil
+	}
+	if number < 0 {
+		// safe and finalized blocks are not tracked separately yet
+		return b.n.chain.CurrentBlock(), nil
+	}
+	return b.n.chain.GetBlockByNumber(uint64(number)), nil
+}
+
+func (b *apiBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	return b.n.chain.GetBlockByHash(hash), nil
+}
+
+func (b *apiBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Header, error) {
+	if number, ok := blockNrOrHash.Number(); ok && number == rpc.PendingBlockNumber {
+		if block, statedb := b.n.miner.Pending(); block != nil {
+			return statedb, block.Header(), nil
+		}
+	}
+
+	var block *types.Block
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		block = b.n.chain.GetBlockByHash(hash)
+		if block != nil && blockNrOrHash.RequireCanonical && b.n.chain.GetCanonicalHash(block.NumberU64()) != hash {
+			return nil, nil, errors.New("hash is not currently canonical")
+		}
+	} else if number, ok := blockNrOrHash.Number(); ok {
+		var err error
+		if block, err = b.BlockByNumber(ctx, number); err != nil {
+			return nil, nil, err
+		}
+	}
+	if block == nil {
+		return nil, nil, errUnknownBlock
+	}
+
+	statedb, err := b.n.chain.StateAt(block.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+	return statedb, block.Header(), nil
+}
+
+func (b *apiBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.n.chain.GetReceiptsByHash(hash), nil
+}
+
+func (b *apiBackend) GetTransaction(ctx context.Context, hash common.Hash) (*types.Transaction, common.Hash, uint64, uint64, error) {
+	tx, blockHash, blockNumber, index := b.n.chain.GetTransaction(hash)
+	return tx, blockHash, blockNumber, index, nil
+}
+
+func (b *apiBackend) SendTx(ctx context.Context, tx *types.Transaction) error {
+	return b.n.SendTransaction(ctx, tx)
+}
+
+func (b *apiBackend) GetPoolTransaction(hash common.Hash) *types.Transaction {
+	return b.n.txPool.Get(hash)
+}
+
+func (b *apiBackend) GetPoolNonce(ctx context.Context, addr common.Address) (uint64, error) {
+	return b.n.txPool.Nonce(addr), nil
+}
+
+func (b *apiBackend) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return b.n.gpo.SuggestTipCap(ctx)
+}
+
+func (b *apiBackend) FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*gasprice.FeeHistory, error) {
+	return b.n.gpo.FeeHistory(ctx, blocks, lastBlock, percentiles)
+}
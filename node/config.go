@@ -1,3 +1,61 @@
 package node
 
-const SyncModeDefault = "default"
+import (
+	"fmt"
+	"github.com/rovergulf/chain/allowlist"
+	"github.com/rovergulf/chain/carrack"
+	"github.com/rovergulf/chain/downloader"
+	"github.com/spf13/viper"
+	"path/filepath"
+	"time"
+)
+
+// BanListFile is the file of banned peers under 'data_dir'
+const BanListFile = "banned_nodes.json"
+
+// 'node.sync_mode' values
+const (
+	SyncModeDefault = "default" // full sync
+	SyncModeFull    = "full"
+	SyncModeSnap    = "snap"
+)
+
+// newDownloaderConfig returns chain synchronisation settings of the node, sync interval is in seconds
+func newDownloaderConfig(cfg *viper.Viper) (downloader.Config, error) {
+	conf := downloader.Config{
+		Interval: time.Duration(cfg.GetInt("node.sync_interval")) * time.Second,
+	}
+	switch mode := cfg.GetString("node.sync_mode"); mode {
+	case SyncModeDefault, SyncModeFull:
+		conf.Mode = downloader.FullSync
+	case SyncModeSnap:
+		conf.Mode = downloader.SnapSync
+	default:
+		return conf, fmt.Errorf("%w: %s", downloader.ErrUnknownMode, mode)
+	}
+	return conf, nil
+}
+
+// newReputationConfig returns peers scoring and banning settings of the node, the ban list is kept under 'data_dir'
+func newReputationConfig(cfg *viper.Viper) carrack.ReputationConfig {
+	return carrack.ReputationConfig{
+		BanThreshold:   cfg.GetInt("node.ban_threshold"),
+		BanDuration:    cfg.GetDuration("node.ban_duration"),
+		RequestRate:    cfg.GetFloat64("node.request_rate"),
+		RequestBurst:   cfg.GetInt("node.request_burst"),
+		BroadcastRate:  cfg.GetFloat64("node.broadcast_rate"),
+		BroadcastBurst: cfg.GetInt("node.broadcast_burst"),
+		ConsensusRate:  cfg.GetFloat64("node.consensus_rate"),
+		ConsensusBurst: cfg.GetInt("node.consensus_burst"),
+		BanList:        filepath.Join(cfg.GetString("data_dir"), BanListFile),
+	}
+}
+
+// newAllowlistConfig returns the node allowlist settings, relative allowlist file path is resolved against 'data_dir'
+func newAllowlistConfig(cfg *viper.Viper) allowlist.Config {
+	conf := allowlist.Config{File: cfg.GetString("node.allowlist")}
+	if len(conf.File) > 0 && !filepath.IsAbs(conf.File) {
+		conf.File = filepath.Join(cfg.GetString("data_dir"), conf.File)
+	}
+	return conf
+}
@@ -0,0 +1,47 @@
+package node
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/metrics/prometheus"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+)
+
+// metricsServer exposes collected metrics in Prometheus format on 'metrics_addr'
+type metricsServer struct {
+	logger *zap.SugaredLogger
+	server *http.Server
+}
+
+func newMetricsServer(addr string, logger *zap.SugaredLogger) *metricsServer {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler(metrics.DefaultRegistry))
+	return &metricsServer{
+		logger: logger,
+		server: &http.Server{Addr: addr, Handler: mux},
+	}
+}
+
+func (m *metricsServer) start() error {
+	listener, err := net.Listen("tcp", m.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			m.logger.Errorw("Metrics server failed", "err", err)
+		}
+	}()
+
+	m.logger.Infow("Metrics server started", "endpoint", listener.Addr())
+	return nil
+}
+
+func (m *metricsServer) stop(ctx context.Context) {
+	if err := m.server.Shutdown(ctx); err != nil {
+		m.logger.Errorw("Unable to stop metrics server", "err", err)
+	}
+}
@@ -2,15 +2,43 @@ package node
 
 import (
 	"context"
+	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/rovergulf/chain/allowlist"
+	"github.com/rovergulf/chain/carrack"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/consensus/bft"
+	"github.com/rovergulf/chain/consensus/clique"
+	"github.com/rovergulf/chain/consensus/instant"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/discovery"
+	"github.com/rovergulf/chain/downloader"
+	"github.com/rovergulf/chain/ethapi"
+	"github.com/rovergulf/chain/gasprice"
+	"github.com/rovergulf/chain/miner"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
 	"github.com/rovergulf/chain/pkg/logutils"
 	"github.com/rovergulf/chain/pkg/traceutils"
+	"github.com/rovergulf/chain/rewards"
+	"github.com/rovergulf/chain/staking"
+	"github.com/rovergulf/chain/storage"
+	_ "github.com/rovergulf/chain/storage/badgerdb"
+	_ "github.com/rovergulf/chain/storage/dgraphdb"
+	"github.com/rovergulf/chain/txpool"
 	"github.com/rovergulf/chain/wallets"
 	"github.com/spf13/viper"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type Node struct {
@@ -18,13 +46,27 @@ type Node struct {
 	tracer trace.Tracer
 
 	walletsManager *wallets.Manager
+	storage        storage.Storage
+	chain          *core.BlockChain
+	txPool         *txpool.TxPool
+	engine         consensus.Engine
+	miner          *miner.Miner
+	gpo            *gasprice.Oracle
+	http           *httpServer
+	metrics        *metricsServer
+	discovery      *discovery.Service
+	server         *p2p.Server
+	handler        *carrack.Handler
+	allowlist      *allowlist.Allowlist
+	downloader     *downloader.Downloader
 
-	key *keystore.Key
+	staticNodes  []*enode.Node // configured static nodes, the server dials the ones present in the allowlist
+	trustedNodes []*enode.Node // configured trusted nodes, the server trusts the ones present in the allowlist
 
-	peer *p2p.Peer
+	key *keystore.Key
 }
 
-func New() (*Node, error) {
+func New() (_ *Node, err error) {
 	zapLogger, err := logutils.NewLogger()
 	if err != nil {
 		return nil, err
@@ -41,6 +83,18 @@ func New() (*Node, error) {
 	n := &Node{
 		logger: zapLogger,
 	}
+	// services created before the failure are stopped
+	defer func() {
+		if err != nil {
+			n.close()
+		}
+	}()
+
+	// meters are created by services, which are enabled once the setting is read
+	metrics.Enabled = viper.GetBool("metrics")
+	if metrics.Enabled {
+		n.metrics = newMetricsServer(viper.GetString("metrics_addr"), zapLogger)
+	}
 
 	if traceProvider != nil {
 		n.tracer = traceProvider.Tracer("node")
@@ -53,15 +107,338 @@ func New() (*Node, error) {
 	}
 	n.walletsManager = wm
 
-	//n.peer = p2p.NewPeer(enode.PubkeyToIDV4())
+	if err := n.unlockAccount(viper.GetViper()); err != nil {
+		zapLogger.Errorw("Unable to unlock node account", "account", viper.GetString("node.account"), "err", err)
+		return nil, err
+	}
+
+	db, err := storage.Open(context.Background(), viper.GetViper())
+	if err != nil {
+		zapLogger.Errorw("Unable to open chain storage", "db", viper.GetString("db"), "err", err)
+		return nil, err
+	}
+	n.storage = db
+
+	genesis := core.DefaultGenesisBlock(viper.GetUint64("network.id"))
+	if genesisPath := viper.GetString("network.genesis"); len(genesisPath) > 0 {
+		if genesis, err = core.ReadGenesis(genesisPath); err != nil {
+			zapLogger.Errorw("Unable to read genesis", "path", genesisPath, "err", err)
+			return nil, err
+		}
+	}
+
+	engine, err := newEngine(genesis.Config, db, n.key, zapLogger)
+	if err != nil {
+		zapLogger.Errorw("Unable to init consensus engine", "err", err)
+		return nil, err
+	}
+	n.engine = engine
+
+	bc, err := core.NewBlockChain(db, genesis, engine, zapLogger)
+	if err != nil {
+		zapLogger.Errorw("Unable to init blockchain", "err", err)
+		return nil, err
+	}
+	n.chain = bc
+
+	pool, err := txpool.NewTxPool(txpool.NewConfig(viper.GetViper()), bc.Config(), bc, zapLogger)
+	if err != nil {
+		zapLogger.Errorw("Unable to init transaction pool", "err", err)
+		return nil, err
+	}
+	n.txPool = pool
+
+	minerConfig := miner.NewConfig(viper.GetViper())
+	if minerConfig.Etherbase == (common.Address{}) && n.key != nil {
+		minerConfig.Etherbase = n.key.Address
+	}
+	n.miner = miner.New(minerConfig, bc, pool, n.engine, zapLogger)
+
+	backend := &apiBackend{n: n}
+	n.gpo = gasprice.NewOracle(backend, gasprice.NewConfig(viper.GetViper()))
+
+	apis := append(ethapi.APIs(backend), n.engine.APIs(bc)...)
+	if bc.Config().Staking != nil {
+		apis = append(apis, staking.APIs(bc)...)
+	}
+	if bc.Config().Rewards != nil {
+		apis = append(apis, rewards.APIs(bc)...)
+	}
+	if bc.Config().Permissions != nil {
+		apis = append(apis, permissions.APIs(bc)...)
+	}
+	n.http = newHTTPServer(viper.GetViper(), zapLogger)
+	if err := n.http.register(apis); err != nil {
+		return nil, err
+	}
+
+	discoveryConfig := newDiscoveryConfig(viper.GetViper())
+	nodeKey, err := discovery.LoadNodeKey(discoveryConfig.NodeKey)
+	if err != nil {
+		zapLogger.Errorw("Unable to load node key", "err", err)
+		return nil, err
+	}
+	p2pConfig, err := newP2PConfig(viper.GetViper(), nodeKey)
+	if err != nil {
+		zapLogger.Errorw("Unable to init p2p server", "err", err)
+		return nil, err
+	}
+	n.server = &p2p.Server{Config: p2pConfig}
+
+	n.handler = carrack.NewHandler(viper.GetUint64("network.id"), bc, pool, zapLogger)
+	reputation, err := carrack.NewReputation(newReputationConfig(viper.GetViper()), zapLogger)
+	if err != nil {
+		zapLogger.Errorw("Unable to load peers ban list", "err", err)
+		return nil, err
+	}
+	n.handler.SetReputation(reputation)
+	if allowlistConfig := newAllowlistConfig(viper.GetViper()); allowlist.Restricted(allowlistConfig, bc.Config()) {
+		if n.allowlist, err = allowlist.New(allowlistConfig, bc, zapLogger); err != nil {
+			zapLogger.Errorw("Unable to load node allowlist", "err", err)
+			return nil, err
+		}
+		n.handler.SetAllowlist(n.allowlist)
+	}
+	n.staticNodes, n.trustedNodes = p2pConfig.StaticNodes, p2pConfig.TrustedNodes
+	n.server.StaticNodes = n.handler.ListedNodes(n.staticNodes)
+	n.server.TrustedNodes = n.handler.ListedNodes(n.trustedNodes)
+	// admin methods change peers of the node, so they are served only if enabled
+	if viper.GetBool("http.admin") {
+		if err := n.http.register(carrack.APIs(n.handler)); err != nil {
+			return nil, err
+		}
+	}
+	if engine, ok := n.engine.(*bft.Engine); ok {
+		n.handler.SetConsensus(engine)
+		engine.SetBroadcaster(n.handler)
+	}
+
+	downloaderConfig, err := newDownloaderConfig(viper.GetViper())
+	if err != nil {
+		zapLogger.Errorw("Unable to init chain synchronisation", "err", err)
+		return nil, err
+	}
+	if n.downloader, err = downloader.New(downloaderConfig, bc, n.handler, zapLogger); err != nil {
+		zapLogger.Errorw("Unable to init chain synchronisation", "err", err)
+		return nil, err
+	}
+
+	if !viper.GetBool("node.no_discovery") {
+		discoveryConfig.NAT = p2pConfig.NAT
+		if n.discovery, err = discovery.NewWithKey(discoveryConfig, nodeKey, zapLogger); err != nil {
+			zapLogger.Errorw("Unable to init discovery", "err", err)
+			return nil, err
+		}
+		n.discovery.Advertise(bc, viper.GetUint64("network.id"))
+	}
 
 	return n, nil
 }
 
+// Run starts node services and blocks until the context is done
+func (n *Node) Run(ctx context.Context) error {
+	if !viper.GetBool("http.disabled") {
+		if err := n.http.start(); err != nil {
+			n.logger.Errorw("Unable to start HTTP RPC server", "err", err)
+			return err
+		}
+	}
+
+	if n.metrics != nil {
+		if err := n.metrics.start(); err != nil {
+			n.logger.Errorw("Unable to start metrics server", "err", err)
+			return err
+		}
+	}
+
+	var candidates enode.Iterator
+	if n.discovery != nil {
+		if err := n.discovery.Start(); err != nil {
+			n.logger.Errorw("Unable to start discovery", "err", err)
+			return err
+		}
+		it, err := n.discovery.RandomNodes()
+		if err != nil {
+			return err
+		}
+		candidates = it
+	}
+
+	if n.allowlist != nil {
+		n.allowlist.Start()
+	}
+	n.server.Protocols = n.handler.Protocols(candidates)
+	if err := n.server.Start(); err != nil {
+		n.logger.Errorw("Unable to start p2p server", "err", err)
+		return err
+	}
+	n.logger.Infow("Started p2p server", "addr", n.server.ListenAddr, "self", n.server.Self().URLv4())
+	n.handler.SetStaticNodes(n.server, n.staticNodes, n.trustedNodes)
+	if n.discovery != nil {
+		// peers found by discovery connect to the p2p server listener
+		n.discovery.LocalNode().Set(enr.TCP(n.server.Self().TCP()))
+	}
+	n.downloader.Start()
+
+	// validators take part in consensus rounds, while block production provides proposals
+	if engine, ok := n.engine.(*bft.Engine); ok && n.key != nil {
+		if err := engine.Start(n.chain); err != nil {
+			n.logger.Errorw("Unable to start consensus engine", "err", err)
+			return err
+		}
+	}
+
+	if viper.GetBool("miner.enabled") {
+		if err := n.miner.Start(); err != nil {
+			n.logger.Errorw("Unable to start block production", "err", err)
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 func (n *Node) GracefulShutdown(ctx context.Context, sig string) {
 	defer ctx.Done()
 
 	n.logger.Warnw("Graceful shutdown signal received", "sig", sig)
 
+	n.http.stop(ctx)
+	if n.metrics != nil {
+		n.metrics.stop(ctx)
+	}
+	n.close()
+
 	os.Exit(0)
 }
+
+// close stops the node services and closes storages, services not created yet are skipped
+func (n *Node) close() {
+	if n.downloader != nil {
+		n.downloader.Stop()
+	}
+	if n.server != nil {
+		n.server.Stop()
+	}
+	if n.handler != nil {
+		n.handler.Stop()
+	}
+	if n.allowlist != nil {
+		n.allowlist.Stop()
+	}
+	if n.discovery != nil {
+		n.discovery.Stop()
+	}
+	if n.miner != nil {
+		n.miner.Close()
+	}
+	if n.engine != nil {
+		if err := n.engine.Close(); err != nil {
+			n.logger.Errorw("Unable to stop consensus engine", "err", err)
+		}
+	}
+	if n.txPool != nil {
+		n.txPool.Stop()
+	}
+	if n.chain != nil {
+		n.chain.Stop()
+	}
+	if n.storage != nil {
+		if err := n.storage.Close(); err != nil {
+			n.logger.Errorw("Unable to close chain storage", "err", err)
+		}
+	}
+	if n.walletsManager != nil {
+		n.walletsManager.Shutdown()
+	}
+}
+
+// unlockAccount decrypts the 'node.account' key used to seal blocks, node runs without account if it is not set
+func (n *Node) unlockAccount(cfg *viper.Viper) error {
+	account := cfg.GetString("node.account")
+	if len(account) == 0 {
+		return nil
+	}
+	if !common.IsHexAddress(account) {
+		return fmt.Errorf("invalid account address: %s", account)
+	}
+
+	var auth string
+	if passwordFile := cfg.GetString("node.password_file"); len(passwordFile) > 0 {
+		data, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return err
+		}
+		auth = strings.TrimRight(string(data), "\r\n")
+	}
+
+	wallet, err := n.walletsManager.GetWallet(common.HexToAddress(account), auth)
+	if err != nil {
+		return err
+	}
+	n.key = wallet.GetKey()
+
+	n.logger.Infow("Unlocked node account", "address", n.key.Address)
+	return nil
+}
+
+// newDiscoveryConfig returns discovery settings of the node, which listens on the node port.
+// Node key and known nodes database are kept under 'data_dir'
+func newDiscoveryConfig(cfg *viper.Viper) discovery.Config {
+	return discovery.Config{
+		Addr:      cfg.GetString("node.addr"),
+		Port:      cfg.GetInt("node.port"),
+		V5:        cfg.GetBool("node.discovery_v5"),
+		NodeKey:   filepath.Join(cfg.GetString("data_dir"), discovery.NodeKeyFile),
+		NodeDB:    filepath.Join(cfg.GetString("data_dir"), discovery.NodeDBDir),
+		Bootnodes: cfg.GetStringSlice("node.bootnodes"),
+		DNS:       cfg.GetStringSlice("node.dns_discovery"),
+	}
+}
+
+// newEngine returns consensus engine selected by the chain config
+func newEngine(config *params.ChainConfig, db storage.Storage, key *keystore.Key, logger *zap.SugaredLogger) (consensus.Engine, error) {
+	if config.Clique == nil && config.BFT == nil {
+		return instant.New(), nil
+	}
+
+	if config.BFT != nil {
+		engine := bft.New(config.BFT, logger)
+		if key != nil {
+			engine.Authorize(key)
+		}
+		return engine, nil
+	}
+
+	kv, err := storage.KeyValueStore(db)
+	if err != nil {
+		return nil, err
+	}
+	engine := clique.New(config.Clique, kv)
+	if key != nil {
+		engine.Authorize(key)
+	}
+	return engine, nil
+}
+
+// SendTransaction submits signed transaction to the pool as local
+func (n *Node) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := n.txPool.AddLocal(tx); err != nil {
+		n.logger.Debugw("Transaction rejected", "hash", tx.Hash(), "err", err)
+		return err
+	}
+
+	n.logger.Infow("Submitted transaction", "hash", tx.Hash(), "nonce", tx.Nonce(), "to", tx.To())
+	return nil
+}
+
+// TxPool returns the node transaction pool
+func (n *Node) TxPool() *txpool.TxPool {
+	return n.txPool
+}
+
+// Miner returns the node block producer
+func (n *Node) Miner() *miner.Miner {
+	return n.miner
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/nat"
+	"github.com/spf13/viper"
+	"net"
+)
+
+// p2pName is the client name advertised in the devp2p handshake
+const p2pName = "rovergulf-chain"
+
+var ErrInvalidPeer = errors.New("invalid peer url")
+
+// newP2PConfig returns devp2p server settings of the 'node' config section.
+// Peers are found by the node discovery service, so the server discovery is disabled
+func newP2PConfig(cfg *viper.Viper, key *ecdsa.PrivateKey) (p2p.Config, error) {
+	static, err := parsePeers(cfg.GetStringSlice("node.static_peers"))
+	if err != nil {
+		return p2p.Config{}, err
+	}
+	trusted, err := parsePeers(cfg.GetStringSlice("node.trusted_peers"))
+	if err != nil {
+		return p2p.Config{}, err
+	}
+	natm, err := parseNAT(cfg.GetString("node.nat"))
+	if err != nil {
+		return p2p.Config{}, err
+	}
+
+	return p2p.Config{
+		PrivateKey:      key,
+		Name:            p2pName,
+		ListenAddr:      net.JoinHostPort(cfg.GetString("node.addr"), cfg.GetString("node.port")),
+		MaxPeers:        cfg.GetInt("node.max_peers"),
+		MaxPendingPeers: cfg.GetInt("node.max_pending_peers"),
+		NAT:             natm,
+		StaticNodes:     static,
+		TrustedNodes:    trusted,
+		NoDiscovery:     true,
+	}, nil
+}
+
+// parsePeers parses enode URLs of peers
+func parsePeers(urls []string) ([]*enode.Node, error) {
+	nodes := make([]*enode.Node, 0, len(urls))
+	for _, url := range urls {
+		n, err := enode.Parse(enode.ValidSchemes, url)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidPeer, url, err)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// parseNAT parses 'node.nat' option: any, none, upnp, pmp, pmp:<gateway IP> or extip:<IP>, none if empty
+func parseNAT(spec string) (nat.Interface, error) {
+	if len(spec) == 0 {
+		return nil, nil
+	}
+	natm, err := nat.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nat option: %w", err)
+	}
+	return natm, nil
+}
@@ -1 +1,82 @@
 package node
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpServer serves JSON-RPC APIs over HTTP using 'http' config section
+type httpServer struct {
+	logger *zap.SugaredLogger
+
+	rpc      *rpc.Server
+	server   *http.Server
+	endpoint string
+
+	sslEnabled bool
+	sslCert    string
+	sslKey     string
+}
+
+func newHTTPServer(cfg *viper.Viper, logger *zap.SugaredLogger) *httpServer {
+	h := &httpServer{
+		logger:     logger,
+		rpc:        rpc.NewServer(),
+		endpoint:   net.JoinHostPort(cfg.GetString("http.addr"), cfg.GetString("http.port")),
+		sslEnabled: cfg.GetBool("http.ssl.enabled"),
+		sslCert:    cfg.GetString("http.ssl.cert"),
+		sslKey:     cfg.GetString("http.ssl.key"),
+	}
+	h.server = &http.Server{
+		Handler:      h.rpc,
+		ReadTimeout:  time.Duration(cfg.GetInt("http.read_timeout")) * time.Second,
+		WriteTimeout: time.Duration(cfg.GetInt("http.write_timeout")) * time.Second,
+		IdleTimeout:  time.Duration(cfg.GetInt("http.dial_timeout")) * time.Second,
+	}
+	return h
+}
+
+// register exposes APIs methods under their namespaces
+func (h *httpServer) register(apis []rpc.API) error {
+	for _, api := range apis {
+		if err := h.rpc.RegisterName(api.Namespace, api.Service); err != nil {
+			return fmt.Errorf("unable to register '%s' API: %w", api.Namespace, err)
+		}
+	}
+	return nil
+}
+
+func (h *httpServer) start() error {
+	listener, err := net.Listen("tcp", h.endpoint)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+		if h.sslEnabled {
+			err = h.server.ServeTLS(listener, h.sslCert, h.sslKey)
+		} else {
+			err = h.server.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			h.logger.Errorw("HTTP RPC server failed", "err", err)
+		}
+	}()
+
+	h.logger.Infow("HTTP RPC server started", "endpoint", listener.Addr(), "ssl", h.sslEnabled)
+	return nil
+}
+
+func (h *httpServer) stop(ctx context.Context) {
+	if err := h.server.Shutdown(ctx); err != nil {
+		h.logger.Errorw("Unable to stop HTTP RPC server", "err", err)
+	}
+	h.rpc.Stop()
+}
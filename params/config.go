@@ -0,0 +1,332 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	gethparams "github.com/ethereum/go-ethereum/params"
+	"math/big"
+)
+
+// ErrInvalidRewards is returned for the rewards schedule, which issues negative amounts
+var ErrInvalidRewards = errors.New("invalid rewards config")
+
+var (
+	// MainnetChainConfig is the chain parameters to run a node on the main network
+	MainnetChainConfig = newChainConfig(MainNetworkId)
+	// TestnetChainConfig is the chain parameters to run a node on the test network
+	TestnetChainConfig = newChainConfig(TestNetworkId)
+	// DevChainConfig is the chain parameters used by local networks and tests
+	DevChainConfig = newChainConfig(DevNetworkId)
+)
+
+// ChainConfig is the core config which determines the blockchain settings.
+// Ethereum protocol upgrades are enabled at specified block numbers or, if time is set instead, block timestamps,
+// nil value means the upgrade is disabled
+type ChainConfig struct {
+	ChainID *big.Int `json:"chain_id" yaml:"chain_id"`
+
+	HomesteadBlock      *big.Int `json:"homestead_block,omitempty" yaml:"homestead_block,omitempty"`
+	EIP150Block         *big.Int `json:"eip150_block,omitempty" yaml:"eip150_block,omitempty"`
+	EIP155Block         *big.Int `json:"eip155_block,omitempty" yaml:"eip155_block,omitempty"`
+	EIP158Block         *big.Int `json:"eip158_block,omitempty" yaml:"eip158_block,omitempty"`
+	ByzantiumBlock      *big.Int `json:"byzantium_block,omitempty" yaml:"byzantium_block,omitempty"`
+	ConstantinopleBlock *big.Int `json:"constantinople_block,omitempty" yaml:"constantinople_block,omitempty"`
+	PetersburgBlock     *big.Int `json:"petersburg_block,omitempty" yaml:"petersburg_block,omitempty"`
+	IstanbulBlock       *big.Int `json:"istanbul_block,omitempty" yaml:"istanbul_block,omitempty"`
+	BerlinBlock         *big.Int `json:"berlin_block,omitempty" yaml:"berlin_block,omitempty"`
+	LondonBlock         *big.Int `json:"london_block,omitempty" yaml:"london_block,omitempty"`
+
+	// Upgrades activated by the block timestamp, time is used only if the block of the upgrade is not set
+	HomesteadTime      *uint64 `json:"homestead_time,omitempty" yaml:"homestead_time,omitempty"`
+	EIP150Time         *uint64 `json:"eip150_time,omitempty" yaml:"eip150_time,omitempty"`
+	EIP155Time         *uint64 `json:"eip155_time,omitempty" yaml:"eip155_time,omitempty"`
+	EIP158Time         *uint64 `json:"eip158_time,omitempty" yaml:"eip158_time,omitempty"`
+	ByzantiumTime      *uint64 `json:"byzantium_time,omitempty" yaml:"byzantium_time,omitempty"`
+	ConstantinopleTime *uint64 `json:"constantinople_time,omitempty" yaml:"constantinople_time,omitempty"`
+	PetersburgTime     *uint64 `json:"petersburg_time,omitempty" yaml:"petersburg_time,omitempty"`
+	IstanbulTime       *uint64 `json:"istanbul_time,omitempty" yaml:"istanbul_time,omitempty"`
+	BerlinTime         *uint64 `json:"berlin_time,omitempty" yaml:"berlin_time,omitempty"`
+	LondonTime         *uint64 `json:"london_time,omitempty" yaml:"london_time,omitempty"`
+
+	// FeeMarket is the EIP-1559 settings used since London, Ethereum values are used if not set
+	FeeMarket *FeeMarketConfig `json:"fee_market,omitempty" yaml:"fee_market,omitempty"`
+
+	// Clique and BFT select the consensus engine, development engine is used if no engine is set
+	Clique *CliqueConfig `json:"clique,omitempty" yaml:"clique,omitempty"`
+	BFT    *BFTConfig    `json:"bft,omitempty" yaml:"bft,omitempty"`
+
+	// Rewards is the block issuance schedule, no new tokens are issued if not set
+	Rewards *RewardsConfig `json:"rewards,omitempty" yaml:"rewards,omitempty"`
+
+	// Staking enables native proof-of-stake, BFT validators are elected by stake at epoch boundaries
+	Staking *StakingConfig `json:"staking,omitempty" yaml:"staking,omitempty"`
+
+	// Permissions enables account allowlists of the permissioned network
+	Permissions *PermissionsConfig `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+}
+
+// PermissionsConfig is the initial allowlists of the permissioned network, written into the system account at genesis.
+// Lists are managed by admins with transactions to the system account later, admins may always send transactions
+type PermissionsConfig struct {
+	Admins            []common.Address `json:"admins" yaml:"admins"`
+	RestrictSenders   bool             `json:"restrict_senders" yaml:"restrict_senders"` // only listed senders may send transactions
+	Senders           []common.Address `json:"senders,omitempty" yaml:"senders,omitempty"`
+	RestrictDeployers bool             `json:"restrict_deployers" yaml:"restrict_deployers"` // only listed deployers may create contracts, directly or with factories
+	Deployers         []common.Address `json:"deployers,omitempty" yaml:"deployers,omitempty"`
+	RestrictNodes     bool             `json:"restrict_nodes" yaml:"restrict_nodes"` // only listed nodes may be peers
+	Nodes             []enode.ID       `json:"nodes,omitempty" yaml:"nodes,omitempty"`
+}
+
+// FeeMarketConfig is the EIP-1559 base fee settings
+type FeeMarketConfig struct {
+	ElasticityMultiplier     uint64          `json:"elasticity_multiplier" yaml:"elasticity_multiplier"`             // gas limit to gas target ratio
+	BaseFeeChangeDenominator uint64          `json:"base_fee_change_denominator" yaml:"base_fee_change_denominator"` // bounds the base fee change per block
+	InitialBaseFee           *big.Int        `json:"initial_base_fee" yaml:"initial_base_fee"`                       // base fee of the London block
+	Treasury                 *common.Address `json:"treasury,omitempty" yaml:"treasury,omitempty"`                   // base fee receiver, base fee is burned if not set
+}
+
+// RewardsConfig is the block issuance schedule and its distribution.
+// Treasury and community pool receive their percents of each block reward, the producer receives the rest
+type RewardsConfig struct {
+	BlockReward       *big.Int       `json:"block_reward" yaml:"block_reward"`                 // issuance of the first block
+	ReductionInterval uint64         `json:"reduction_interval" yaml:"reduction_interval"`     // number of blocks between reward reductions, 0 keeps it constant
+	ReductionPercent  uint64         `json:"reduction_percent" yaml:"reduction_percent"`       // part of the reward cut every interval, 50 is halving
+	MinReward         *big.Int       `json:"min_reward,omitempty" yaml:"min_reward,omitempty"` // tail issuance, reward is not reduced below it
+	TreasuryPercent   uint64         `json:"treasury_percent" yaml:"treasury_percent"`
+	Treasury          common.Address `json:"treasury" yaml:"treasury"`
+	CommunityPercent  uint64         `json:"community_percent" yaml:"community_percent"`
+	CommunityPool     common.Address `json:"community_pool" yaml:"community_pool"`
+}
+
+// Validate checks the distribution percents do not exceed the block reward
+func (c *RewardsConfig) Validate() error {
+	if c.TreasuryPercent+c.CommunityPercent > 100 || c.TreasuryPercent > 100 || c.CommunityPercent > 100 {
+		return fmt.Errorf("%w: treasury %d%% and community %d%% exceed the reward", ErrInvalidRewards, c.TreasuryPercent, c.CommunityPercent)
+	}
+	if c.ReductionPercent > 100 {
+		return fmt.Errorf("%w: reduction %d%% exceeds the reward", ErrInvalidRewards, c.ReductionPercent)
+	}
+	if (c.BlockReward != nil && c.BlockReward.Sign() < 0) || (c.MinReward != nil && c.MinReward.Sign() < 0) {
+		return fmt.Errorf("%w: negative reward", ErrInvalidRewards)
+	}
+	return nil
+}
+
+// Reward returns issuance of the block according to the reduction schedule
+func (c *RewardsConfig) Reward(number *big.Int) *big.Int {
+	reward := new(big.Int)
+	if c.BlockReward == nil || number == nil || number.Sign() <= 0 {
+		return reward
+	}
+	reward.Set(c.BlockReward)
+
+	if c.ReductionInterval > 0 && c.ReductionPercent > 0 {
+		keep := new(big.Int)
+		if c.ReductionPercent < 100 {
+			keep.SetUint64(100 - c.ReductionPercent)
+		}
+		for i := number.Uint64() / c.ReductionInterval; i > 0 && reward.Sign() > 0; i-- {
+			reward.Mul(reward, keep)
+			reward.Div(reward, big.NewInt(100))
+			if c.MinReward != nil && reward.Cmp(c.MinReward) <= 0 {
+				break
+			}
+		}
+	}
+	if c.MinReward != nil && reward.Cmp(c.MinReward) < 0 {
+		reward.Set(c.MinReward)
+	}
+	return reward
+}
+
+// CliqueConfig is the proof-of-authority engine settings
+type CliqueConfig struct {
+	Period uint64 `json:"period" yaml:"period"` // number of seconds between blocks
+	Epoch  uint64 `json:"epoch" yaml:"epoch"`   // number of blocks after which votes are reset and signers checkpoint is written
+}
+
+// BFTConfig is the byzantine fault tolerant engine settings
+type BFTConfig struct {
+	Period  uint64 `json:"period" yaml:"period"`   // minimal number of seconds between blocks
+	Epoch   uint64 `json:"epoch" yaml:"epoch"`     // number of blocks after which validator set can be changed
+	Timeout uint64 `json:"timeout" yaml:"timeout"` // base round step timeout in milliseconds, growing with each round
+}
+
+// StakingConfig is the native proof-of-stake settings
+type StakingConfig struct {
+	MinValidatorStake *big.Int `json:"min_validator_stake" yaml:"min_validator_stake"`           // minimal self bond of the elected validator
+	MinDelegation     *big.Int `json:"min_delegation,omitempty" yaml:"min_delegation,omitempty"` // minimal bonded amount and stake left after undelegation, 1 ether if not set
+	MaxValidators     uint64   `json:"max_validators" yaml:"max_validators"`                     // size of the elected validator set
+	BlockReward       *big.Int `json:"block_reward" yaml:"block_reward"`                         // issued every block to validators and delegators by stake
+	SlashPercent      uint64   `json:"slash_percent" yaml:"slash_percent"`                       // part of the stake burned for double signing
+	JailPeriod        uint64   `json:"jail_period" yaml:"jail_period"`                           // number of blocks slashed validator can not be elected
+	UnbondingPeriod   uint64   `json:"unbonding_period" yaml:"unbonding_period"`                 // number of blocks undelegated stake stays locked
+}
+
+// DelegationMinimum returns the minimal bonded amount, which bounds the number of delegations
+func (c *StakingConfig) DelegationMinimum() *big.Int {
+	if c.MinDelegation == nil {
+		return big.NewInt(gethparams.Ether)
+	}
+	return c.MinDelegation
+}
+
+// newChainConfig returns config with all supported Ethereum upgrades enabled since genesis
+func newChainConfig(networkId uint64) *ChainConfig {
+	return &ChainConfig{
+		ChainID:             new(big.Int).SetUint64(networkId),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(0),
+		ConstantinopleBlock: big.NewInt(0),
+		PetersburgBlock:     big.NewInt(0),
+		IstanbulBlock:       big.NewInt(0),
+		BerlinBlock:         big.NewInt(0),
+	}
+}
+
+// EthConfig returns go-ethereum chain config used to sign transactions and derive receipts.
+// Timestamp forks are reported as active since genesis, EthConfigAt must be used to select block execution rules
+func (c *ChainConfig) EthConfig() *gethparams.ChainConfig {
+	return c.ethConfig(func(*uint64) *big.Int {
+		return new(big.Int)
+	})
+}
+
+// EthConfigAt returns go-ethereum chain config used by EVM to select fork rules of the block,
+// timestamp forks passed by the block are activated at its number
+func (c *ChainConfig) EthConfigAt(number *big.Int, time uint64) *gethparams.ChainConfig {
+	return c.ethConfig(func(fork *uint64) *big.Int {
+		if !isTimestampForked(fork, time) {
+			return nil
+		}
+		return new(big.Int).Set(number)
+	})
+}
+
+// ethConfig converts the config, go-ethereum schedules forks by block numbers only,
+// so block of the timestamp fork is chosen by timeFork
+func (c *ChainConfig) ethConfig(timeFork func(time *uint64) *big.Int) *gethparams.ChainConfig {
+	block := func(number *big.Int, time *uint64) *big.Int {
+		if number != nil || time == nil {
+			return number
+		}
+		return timeFork(time)
+	}
+	return &gethparams.ChainConfig{
+		ChainID:             c.ChainID,
+		HomesteadBlock:      block(c.HomesteadBlock, c.HomesteadTime),
+		EIP150Block:         block(c.EIP150Block, c.EIP150Time),
+		EIP155Block:         block(c.EIP155Block, c.EIP155Time),
+		EIP158Block:         block(c.EIP158Block, c.EIP158Time),
+		ByzantiumBlock:      block(c.ByzantiumBlock, c.ByzantiumTime),
+		ConstantinopleBlock: block(c.ConstantinopleBlock, c.ConstantinopleTime),
+		PetersburgBlock:     block(c.PetersburgBlock, c.PetersburgTime),
+		IstanbulBlock:       block(c.IstanbulBlock, c.IstanbulTime),
+		BerlinBlock:         block(c.BerlinBlock, c.BerlinTime),
+		LondonBlock:         block(c.LondonBlock, c.LondonTime),
+	}
+}
+
+// Rules returns EVM rules active at the specified block
+func (c *ChainConfig) Rules(number *big.Int, time uint64) gethparams.Rules {
+	return c.EthConfigAt(number, time).Rules(number, false)
+}
+
+func (c *ChainConfig) IsHomestead(number *big.Int, time uint64) bool {
+	return isActive(c.HomesteadBlock, c.HomesteadTime, number, time)
+}
+
+func (c *ChainConfig) IsEIP150(number *big.Int, time uint64) bool {
+	return isActive(c.EIP150Block, c.EIP150Time, number, time)
+}
+
+func (c *ChainConfig) IsEIP155(number *big.Int, time uint64) bool {
+	return isActive(c.EIP155Block, c.EIP155Time, number, time)
+}
+
+func (c *ChainConfig) IsEIP158(number *big.Int, time uint64) bool {
+	return isActive(c.EIP158Block, c.EIP158Time, number, time)
+}
+
+func (c *ChainConfig) IsByzantium(number *big.Int, time uint64) bool {
+	return isActive(c.ByzantiumBlock, c.ByzantiumTime, number, time)
+}
+
+func (c *ChainConfig) IsConstantinople(number *big.Int, time uint64) bool {
+	return isActive(c.ConstantinopleBlock, c.ConstantinopleTime, number, time)
+}
+
+func (c *ChainConfig) IsPetersburg(number *big.Int, time uint64) bool {
+	return isActive(c.PetersburgBlock, c.PetersburgTime, number, time)
+}
+
+func (c *ChainConfig) IsIstanbul(number *big.Int, time uint64) bool {
+	return isActive(c.IstanbulBlock, c.IstanbulTime, number, time)
+}
+
+func (c *ChainConfig) IsBerlin(number *big.Int, time uint64) bool {
+	return isActive(c.BerlinBlock, c.BerlinTime, number, time)
+}
+
+func (c *ChainConfig) IsLondon(number *big.Int, time uint64) bool {
+	return isActive(c.LondonBlock, c.LondonTime, number, time)
+}
+
+// ElasticityMultiplier returns the ratio of the block gas limit to the gas target
+func (c *ChainConfig) ElasticityMultiplier() uint64 {
+	if c.FeeMarket == nil || c.FeeMarket.ElasticityMultiplier == 0 {
+		return gethparams.ElasticityMultiplier
+	}
+	return c.FeeMarket.ElasticityMultiplier
+}
+
+// BaseFeeChangeDenominator returns the bound of base fee change between blocks
+func (c *ChainConfig) BaseFeeChangeDenominator() uint64 {
+	if c.FeeMarket == nil || c.FeeMarket.BaseFeeChangeDenominator == 0 {
+		return gethparams.BaseFeeChangeDenominator
+	}
+	return c.FeeMarket.BaseFeeChangeDenominator
+}
+
+// InitialBaseFee returns base fee of the London block
+func (c *ChainConfig) InitialBaseFee() *big.Int {
+	if c.FeeMarket == nil || c.FeeMarket.InitialBaseFee == nil {
+		return new(big.Int).SetUint64(gethparams.InitialBaseFee)
+	}
+	return new(big.Int).Set(c.FeeMarket.InitialBaseFee)
+}
+
+// FeeTreasury returns the base fee receiver, nil means base fee is burned
+func (c *ChainConfig) FeeTreasury() *common.Address {
+	if c.FeeMarket == nil {
+		return nil
+	}
+	return c.FeeMarket.Treasury
+}
+
+func isForked(fork, head *big.Int) bool {
+	if fork == nil || head == nil {
+		return false
+	}
+	return fork.Cmp(head) <= 0
+}
+
+// isActive returns whether the upgrade is passed by the block, block number takes precedence over timestamp
+func isActive(block *big.Int, fork *uint64, number *big.Int, time uint64) bool {
+	if block != nil {
+		return isForked(block, number)
+	}
+	return isTimestampForked(fork, time)
+}
+
+func isTimestampForked(fork *uint64, time uint64) bool {
+	if fork == nil {
+		return false
+	}
+	return *fork <= time
+}
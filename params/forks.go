@@ -0,0 +1,92 @@
+package params
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrIncompatibleFork = errors.New("incompatible fork schedule")
+
+// Fork is the named protocol upgrade, activated at the block number or, if time is set, at the block timestamp
+type Fork struct {
+	Name  string   `json:"name" yaml:"name"`
+	Block *big.Int `json:"block,omitempty" yaml:"block,omitempty"`
+	Time  *uint64  `json:"time,omitempty" yaml:"time,omitempty"`
+}
+
+// Active returns whether the fork is passed by the block
+func (f Fork) Active(number *big.Int, time uint64) bool {
+	return isActive(f.Block, f.Time, number, time)
+}
+
+func (f Fork) String() string {
+	switch {
+	case f.Block != nil:
+		return fmt.Sprintf("%s@block:%s", f.Name, f.Block)
+	case f.Time != nil:
+		return fmt.Sprintf("%s@time:%d", f.Name, *f.Time)
+	default:
+		return f.Name + "@disabled"
+	}
+}
+
+// Forks returns all known upgrades in the activation order, disabled forks are included with no block and time
+func (c *ChainConfig) Forks() []Fork {
+	return []Fork{
+		newFork("homestead", c.HomesteadBlock, c.HomesteadTime),
+		newFork("eip150", c.EIP150Block, c.EIP150Time),
+		newFork("eip155", c.EIP155Block, c.EIP155Time),
+		newFork("eip158", c.EIP158Block, c.EIP158Time),
+		newFork("byzantium", c.ByzantiumBlock, c.ByzantiumTime),
+		newFork("constantinople", c.ConstantinopleBlock, c.ConstantinopleTime),
+		newFork("petersburg", c.PetersburgBlock, c.PetersburgTime),
+		newFork("istanbul", c.IstanbulBlock, c.IstanbulTime),
+		newFork("berlin", c.BerlinBlock, c.BerlinTime),
+		newFork("london", c.LondonBlock, c.LondonTime),
+	}
+}
+
+// newFork returns the upgrade scheduled by block number, or by time if the block is not set
+func newFork(name string, block *big.Int, time *uint64) Fork {
+	if block != nil {
+		return Fork{Name: name, Block: block}
+	}
+	return Fork{Name: name, Time: time}
+}
+
+// LatestFork returns the last upgrade passed by the block, empty name means the chain is on the frontier rules
+func (c *ChainConfig) LatestFork(number *big.Int, time uint64) Fork {
+	var latest Fork
+	for _, f := range c.Forks() {
+		if f.Active(number, time) {
+			latest = f
+		}
+	}
+	return latest
+}
+
+// CheckCompatible checks the new config does not reschedule forks already passed by the chain head,
+// as that would change the rules blocks were executed with
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, number *big.Int, time uint64) error {
+	stored, updated := c.Forks(), newcfg.Forks()
+	for i := range stored {
+		if sameFork(stored[i], updated[i]) {
+			continue
+		}
+		if stored[i].Active(number, time) || updated[i].Active(number, time) {
+			return fmt.Errorf("%w: %s is rescheduled to %s, head %d", ErrIncompatibleFork, stored[i], updated[i], number)
+		}
+	}
+	return nil
+}
+
+func sameFork(a, b Fork) bool {
+	if (a.Block == nil) != (b.Block == nil) || (a.Time == nil) != (b.Time == nil) {
+		return false
+	}
+	if a.Block != nil && a.Block.Cmp(b.Block) != 0 {
+		return false
+	}
+	return a.Time == nil || *a.Time == *b.Time
+}
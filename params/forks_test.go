@@ -0,0 +1,47 @@
+package params
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestTimestampFork(t *testing.T) {
+	config := *DevChainConfig
+	berlinTime, londonTime := uint64(1000), uint64(2000)
+	config.BerlinBlock, config.BerlinTime = nil, &berlinTime
+	config.LondonTime = &londonTime
+
+	if config.IsBerlin(big.NewInt(100), 999) || !config.IsBerlin(big.NewInt(100), 1000) {
+		t.Fatal("berlin is not activated by timestamp")
+	}
+	if rules := config.Rules(big.NewInt(100), 1500); !rules.IsBerlin || rules.IsLondon {
+		t.Fatalf("unexpected rules between timestamp forks: %+v", rules)
+	}
+	if fork := config.LatestFork(big.NewInt(100), 1500); fork.String() != "berlin@time:1000" {
+		t.Fatalf("unexpected latest fork: %s", fork)
+	}
+
+	if config.IsLondon(big.NewInt(100), 1999) || !config.IsLondon(big.NewInt(100), 2000) {
+		t.Fatal("london is not activated by timestamp")
+	}
+	if config.EthConfigAt(big.NewInt(100), 1999).IsLondon(big.NewInt(100)) {
+		t.Fatal("london rules are active before the fork timestamp")
+	}
+	if !config.EthConfigAt(big.NewInt(100), 2000).IsLondon(big.NewInt(100)) {
+		t.Fatal("london rules are not active after the fork timestamp")
+	}
+	if fork := config.LatestFork(big.NewInt(100), 2000); fork.Name != "london" {
+		t.Fatalf("unexpected latest fork: %s", fork)
+	}
+
+	rescheduled := config
+	laterTime := uint64(3000)
+	rescheduled.LondonTime = &laterTime
+	if err := config.CheckCompatible(&rescheduled, big.NewInt(100), 1500); err != nil {
+		t.Fatalf("future fork can not be rescheduled: %v", err)
+	}
+	if err := config.CheckCompatible(&rescheduled, big.NewInt(100), 2500); !errors.Is(err, ErrIncompatibleFork) {
+		t.Fatalf("unexpected incompatible fork error: %v", err)
+	}
+}
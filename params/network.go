@@ -0,0 +1,10 @@
+package params
+
+const (
+	// MainNetworkId is the Carrack main network id
+	MainNetworkId uint64 = 9420
+	// TestNetworkId is the Carrack public test network id
+	TestNetworkId uint64 = 9421
+	// DevNetworkId is used by local development networks
+	DevNetworkId uint64 = 1337
+)
@@ -0,0 +1,46 @@
+package permissions
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/state"
+)
+
+// Backend is the chain permissions API queries the allowlists of
+type Backend interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+	CurrentHeader() *types.Header
+	GetHeaderByNumber(number uint64) *types.Header
+}
+
+func APIs(b Backend) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "permissions",
+			Service:   &API{b: b},
+		},
+	}
+}
+
+// API exposes the account allowlists under the 'permissions' namespace
+type API struct {
+	b Backend
+}
+
+// GetLists returns the allowlists at the block, latest if number is not set
+func (api *API) GetLists(number *rpc.BlockNumber) (*Lists, error) {
+	header := api.b.CurrentHeader()
+	if number != nil && *number >= 0 {
+		header = api.b.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+
+	statedb, err := api.b.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	return Load(statedb)
+}
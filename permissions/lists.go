@@ -0,0 +1,130 @@
+package permissions
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+)
+
+// Lists are the account and node allowlists, stored in the system account storage
+type Lists struct {
+	Admins    []common.Address `json:"admins" yaml:"admins"`
+	Senders   []common.Address `json:"senders" yaml:"senders"`
+	Deployers []common.Address `json:"deployers" yaml:"deployers"`
+	Nodes     []enode.ID       `json:"nodes" yaml:"nodes" rlp:"optional"` // lists stored before nodes are decoded without them
+}
+
+// Setup writes the genesis allowlists of the config
+func Setup(config *params.PermissionsConfig, statedb *state.StateDB) error {
+	lists := &Lists{
+		Admins:    append([]common.Address{}, config.Admins...),
+		Senders:   append([]common.Address{}, config.Senders...),
+		Deployers: append([]common.Address{}, config.Deployers...),
+	}
+	// empty node list is not encoded, so lists of networks without it keep their genesis
+	if len(config.Nodes) > 0 {
+		lists.Nodes = append([]enode.ID{}, config.Nodes...)
+	}
+	return lists.Store(statedb)
+}
+
+// Load reads the allowlists from the system account storage
+func Load(statedb *state.StateDB) (*Lists, error) {
+	lists := new(Lists)
+	data := statedb.GetStorageBytes(Address)
+	if len(data) == 0 {
+		return lists, nil
+	}
+	if err := rlp.DecodeBytes(data, lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// Store writes the allowlists to the system account storage
+func (l *Lists) Store(statedb *state.StateDB) error {
+	data, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		return err
+	}
+	// system account has no balance, so the nonce keeps it from being deleted as empty with its storage
+	if statedb.GetNonce(Address) == 0 {
+		statedb.SetNonce(Address, 1)
+	}
+	statedb.SetStorageBytes(Address, data)
+	return nil
+}
+
+func (l *Lists) IsAdmin(addr common.Address) bool {
+	return contains(l.Admins, addr)
+}
+
+func (l *Lists) IsSender(addr common.Address) bool {
+	return contains(l.Senders, addr)
+}
+
+func (l *Lists) IsDeployer(addr common.Address) bool {
+	return contains(l.Deployers, addr)
+}
+
+func (l *Lists) IsNode(id enode.ID) bool {
+	for _, n := range l.Nodes {
+		if n == id {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []common.Address, addr common.Address) bool {
+	for _, a := range list {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends the address, reporting whether the list is changed
+func add(list *[]common.Address, addr common.Address) bool {
+	if contains(*list, addr) {
+		return false
+	}
+	*list = append(*list, addr)
+	return true
+}
+
+// remove deletes the address, reporting whether the list is changed
+func remove(list *[]common.Address, addr common.Address) bool {
+	for i, a := range *list {
+		if a == addr {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// addNode appends the node id, reporting whether the list is changed
+func addNode(list *[]enode.ID, id enode.ID) bool {
+	for _, n := range *list {
+		if n == id {
+			return false
+		}
+	}
+	*list = append(*list, id)
+	return true
+}
+
+// removeNode deletes the node id, reporting whether the list is changed
+func removeNode(list *[]enode.ID, id enode.ID) bool {
+	for i, n := range *list {
+		if n == id {
+			*list = append((*list)[:i], (*list)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// Address is the admin system account, which keeps the allowlists in its storage
+var Address = common.HexToAddress("0x0000000000000000000000000000000000001002")
+
+const (
+	// OpAddAdmin grants the call account the right to manage allowlists
+	OpAddAdmin uint64 = iota
+	// OpRemoveAdmin revokes admin rights of the call account, the last admin can not be removed
+	OpRemoveAdmin
+	// OpAddSender allows the call account to send transactions
+	OpAddSender
+	// OpRemoveSender forbids the call account to send transactions
+	OpRemoveSender
+	// OpAddDeployer allows the call account to create contracts
+	OpAddDeployer
+	// OpRemoveDeployer forbids the call account to create contracts
+	OpRemoveDeployer
+	// OpAddNode allows the call node to be a peer
+	OpAddNode
+	// OpRemoveNode disconnects the call node from peers
+	OpRemoveNode
+)
+
+var (
+	ErrUnknownBlock       = errors.New("unknown block")
+	ErrUnknownOp          = errors.New("unknown permissions operation")
+	ErrNotAdmin           = errors.New("sender is not an admin")
+	ErrLastAdmin          = errors.New("last admin can not be removed")
+	ErrUnexpectedValue    = errors.New("operation does not accept value")
+	ErrSenderNotAllowed   = errors.New("sender is not allowed")
+	ErrDeployerNotAllowed = errors.New("contract deployer is not allowed")
+)
+
+// events are signatures of logs emitted by the system account on allowlist changes, the account or node is the first topic
+var events = map[uint64]common.Hash{
+	OpAddAdmin:       crypto.Keccak256Hash([]byte("AdminAdded(address)")),
+	OpRemoveAdmin:    crypto.Keccak256Hash([]byte("AdminRemoved(address)")),
+	OpAddSender:      crypto.Keccak256Hash([]byte("SenderAdded(address)")),
+	OpRemoveSender:   crypto.Keccak256Hash([]byte("SenderRemoved(address)")),
+	OpAddDeployer:    crypto.Keccak256Hash([]byte("DeployerAdded(address)")),
+	OpRemoveDeployer: crypto.Keccak256Hash([]byte("DeployerRemoved(address)")),
+	OpAddNode:        crypto.Keccak256Hash([]byte("NodeAdded(bytes32)")),
+	OpRemoveNode:     crypto.Keccak256Hash([]byte("NodeRemoved(bytes32)")),
+}
+
+// EventID returns the topic of the log emitted by the operation
+func EventID(op uint64) common.Hash {
+	return events[op]
+}
+
+// Call is the allowlist operation, rlp encoded into the data of transaction sent to the system account
+type Call struct {
+	Op      uint64         `json:"op" yaml:"op"`
+	Account common.Address `json:"account" yaml:"account"`
+	Node    enode.ID       `json:"node,omitempty" yaml:"node,omitempty" rlp:"optional"` // node id of the node operations
+}
+
+// EncodeCall returns transaction data of the allowlist operation
+func EncodeCall(call *Call) ([]byte, error) {
+	return rlp.EncodeToBytes(call)
+}
+
+// Check returns an error if the sender may not send the transaction, to is nil for contract creation
+func Check(config *params.PermissionsConfig, statedb *state.StateDB, from common.Address, to *common.Address) error {
+	if !config.RestrictSenders && !(config.RestrictDeployers && to == nil) {
+		return nil
+	}
+
+	lists, err := Load(statedb)
+	if err != nil {
+		return err
+	}
+	if lists.IsAdmin(from) {
+		return nil
+	}
+	if config.RestrictSenders && !lists.IsSender(from) {
+		return fmt.Errorf("%w: %s", ErrSenderNotAllowed, from)
+	}
+	if config.RestrictDeployers && to == nil && !lists.IsDeployer(from) {
+		return fmt.Errorf("%w: %s", ErrDeployerNotAllowed, from)
+	}
+	return nil
+}
+
+// CanDeploy reports whether the account may create contracts, either directly or by calling a factory contract
+func CanDeploy(config *params.PermissionsConfig, statedb *state.StateDB, from common.Address) (bool, error) {
+	if !config.RestrictDeployers {
+		return true, nil
+	}
+
+	lists, err := Load(statedb)
+	if err != nil {
+		return false, err
+	}
+	return lists.IsAdmin(from) || lists.IsDeployer(from), nil
+}
+
+// ApplyCall executes the allowlist operation of the admin transaction and emits the change log
+func ApplyCall(statedb *state.StateDB, number uint64, from common.Address, value *big.Int, data []byte) error {
+	call := new(Call)
+	if err := rlp.DecodeBytes(data, call); err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownOp, err)
+	}
+	if value.Sign() != 0 {
+		return ErrUnexpectedValue
+	}
+
+	lists, err := Load(statedb)
+	if err != nil {
+		return err
+	}
+	if !lists.IsAdmin(from) {
+		return fmt.Errorf("%w: %s", ErrNotAdmin, from)
+	}
+
+	var (
+		changed bool
+		subject = common.BytesToHash(call.Account.Bytes())
+	)
+	switch call.Op {
+	case OpAddAdmin:
+		changed = add(&lists.Admins, call.Account)
+	case OpRemoveAdmin:
+		if len(lists.Admins) == 1 && lists.IsAdmin(call.Account) {
+			return ErrLastAdmin
+		}
+		changed = remove(&lists.Admins, call.Account)
+	case OpAddSender:
+		changed = add(&lists.Senders, call.Account)
+	case OpRemoveSender:
+		changed = remove(&lists.Senders, call.Account)
+	case OpAddDeployer:
+		changed = add(&lists.Deployers, call.Account)
+	case OpRemoveDeployer:
+		changed = remove(&lists.Deployers, call.Account)
+	case OpAddNode:
+		changed, subject = addNode(&lists.Nodes, call.Node), common.Hash(call.Node)
+	case OpRemoveNode:
+		changed, subject = removeNode(&lists.Nodes, call.Node), common.Hash(call.Node)
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownOp, call.Op)
+	}
+	if !changed {
+		return nil
+	}
+
+	statedb.AddLog(&types.Log{
+		Address:     Address,
+		Topics:      []common.Hash{events[call.Op], subject},
+		BlockNumber: number,
+	})
+	return lists.Store(statedb)
+}
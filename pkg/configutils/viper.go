@@ -23,11 +23,10 @@ func SetDefaultConfigValues() {
 	// process id
 	viper.SetDefault("pid_file", "/var/run/rbn/pidfile")
 
-	// TBD dgraphdb connection settings
-	// !!! Database interface needs to be implemented to use that
+	// dgraphdb connection settings, used as secondary analytics storage when enabled
 	viper.SetDefault("dgraphdb.enabled", false)
 	viper.SetDefault("dgraphdb.host", "127.0.0.1")
-	viper.SetDefault("dgraphdb.port", "9080")
+	viper.SetDefault("dgraphdb.port", "9080") // alpha gRPC API port
 	viper.SetDefault("dgraphdb.user", "")
 	viper.SetDefault("dgraphdb.password", "")
 	viper.SetDefault("dgraphdb.tls.enabled", false)
@@ -38,15 +37,68 @@ func SetDefaultConfigValues() {
 
 	// chain network setup
 	viper.SetDefault("network.id", params.MainNetworkId)
+	viper.SetDefault("network.genesis", "") // genesis json file path, network id default genesis is used if empty
+
+	// transaction pool
+	viper.SetDefault("txpool.price_limit", 1)
+	viper.SetDefault("txpool.price_bump", 10)
+	viper.SetDefault("txpool.account_slots", 16)
+	viper.SetDefault("txpool.global_slots", 4096)
+	viper.SetDefault("txpool.account_queue", 64)
+	viper.SetDefault("txpool.global_queue", 1024)
+	viper.SetDefault("txpool.lifetime", "3h")
+	viper.SetDefault("txpool.journal", "transactions.rlp") // relative to data_dir, empty value disables journal
+	viper.SetDefault("txpool.rejournal", "1h")
+
+	// block production
+	viper.SetDefault("miner.enabled", false)
+	viper.SetDefault("miner.etherbase", "")
+	viper.SetDefault("miner.gas_ceil", 30_000_000)
+	viper.SetDefault("miner.interval", "5s")
+	viper.SetDefault("miner.extra_data", "")
+
+	// gas price oracle, prices are in wei
+	viper.SetDefault("gpo.blocks", 20)
+	viper.SetDefault("gpo.percentile", 60)
+	viper.SetDefault("gpo.max_header_history", 1024)
+	viper.SetDefault("gpo.max_block_history", 1024)
+	viper.SetDefault("gpo.default", 1_000_000_000)
+	viper.SetDefault("gpo.max_price", 500_000_000_000)
+	viper.SetDefault("gpo.ignore_price", 2)
 
 	// p2p settings
 	viper.SetDefault("node.max_peers", 256)
+	viper.SetDefault("node.max_pending_peers", 0)      // inbound handshakes limit, p2p server default is used if 0
+	viper.SetDefault("node.nat", "")                   // any, none, upnp, pmp, pmp:<gateway IP> or extip:<IP>
+	viper.SetDefault("node.static_peers", []string{})  // enode URLs the node keeps connected to
+	viper.SetDefault("node.trusted_peers", []string{}) // enode URLs allowed to connect above peers limit
 	viper.SetDefault("node.addr", "127.0.0.1")
 	viper.SetDefault("node.port", 9420)
 	viper.SetDefault("node.sync_mode", node.SyncModeDefault)
 	viper.SetDefault("node.sync_interval", 5)
+	viper.SetDefault("node.ban_threshold", -100)  // peers score is lowered by misbehaviour, they are banned at the threshold
+	viper.SetDefault("node.ban_duration", "24h")  // banned nodes are kept in data_dir/banned_nodes.json
+	viper.SetDefault("node.request_rate", 100)    // requests per second served to a peer
+	viper.SetDefault("node.request_burst", 500)   // requests served to a peer at once
+	viper.SetDefault("node.broadcast_rate", 50)   // transactions and blocks messages per second accepted from a peer
+	viper.SetDefault("node.broadcast_burst", 200) // transactions and blocks messages accepted from a peer at once
+	viper.SetDefault("node.consensus_rate", 100)  // consensus messages per second accepted from a peer
+	viper.SetDefault("node.consensus_burst", 400) // consensus messages accepted from a peer at once
+	viper.SetDefault("node.allowlist", "")        // JSON list of enode URLs allowed to be peers, reloaded on changes, relative to data_dir
 	viper.SetDefault("node.cache_dir", "")
 	viper.SetDefault("node.no_discovery", false)
+	viper.SetDefault("node.discovery_v5", false)
+	viper.SetDefault("node.bootnodes", []string{})     // enode URLs discovery joins the network through
+	viper.SetDefault("node.dns_discovery", []string{}) // EIP-1459 enrtree:// URLs of node lists
+	viper.SetDefault("node.account", "")               // wallets account unlocked to seal blocks
+	viper.SetDefault("node.password_file", "")         // file containing the node account passphrase
+
+	// discovery bootnode, its node key and database are kept under data_dir/discovery
+	viper.SetDefault("discovery.addr", "0.0.0.0")
+	viper.SetDefault("discovery.port", 9410)
+	viper.SetDefault("discovery.v5", true) // bootnodes serve both discovery versions on one port
+	viper.SetDefault("discovery.bootnodes", []string{})
+	viper.SetDefault("discovery.net_restrict", "")
 
 	// http server
 	viper.SetDefault("http.disabled", false)
@@ -59,6 +111,7 @@ func SetDefaultConfigValues() {
 	viper.SetDefault("http.ssl.cert", "")
 	viper.SetDefault("http.ssl.key", "")
 	viper.SetDefault("http.ssl.verify", false)
+	viper.SetDefault("http.admin", false) // serve 'admin' namespace to inspect peer scores and ban nodes
 
 	// ws server
 
@@ -0,0 +1,72 @@
+package rewards
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+)
+
+var ErrUnknownBlock = errors.New("unknown block")
+
+// Backend is the chain rewards API queries the issuance of
+type Backend interface {
+	Config() *params.ChainConfig
+	StateAt(root common.Hash) (*state.StateDB, error)
+	CurrentHeader() *types.Header
+	GetHeaderByNumber(number uint64) *types.Header
+}
+
+func APIs(b Backend) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "rewards",
+			Service:   &API{b: b},
+		},
+	}
+}
+
+// API exposes block issuance and token supply under the 'rewards' namespace
+type API struct {
+	b Backend
+}
+
+func (api *API) headerAt(number *rpc.BlockNumber) (*types.Header, error) {
+	header := api.b.CurrentHeader()
+	if number != nil && *number >= 0 {
+		header = api.b.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+	return header, nil
+}
+
+// GetIssuance returns the block reward issued by the schedule at the block, latest if number is not set
+func (api *API) GetIssuance(number *rpc.BlockNumber) (*Issuance, error) {
+	header, err := api.headerAt(number)
+	if err != nil {
+		return nil, err
+	}
+
+	config := api.b.Config().Rewards
+	if config == nil {
+		config = new(params.RewardsConfig)
+	}
+	return split(config, header.Number.Uint64(), config.Reward(header.Number)), nil
+}
+
+// GetSupply returns the total token supply at the block, latest if number is not set
+func (api *API) GetSupply(number *rpc.BlockNumber) (*Supply, error) {
+	header, err := api.headerAt(number)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := api.b.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	return GetSupply(statedb), nil
+}
@@ -0,0 +1,108 @@
+package rewards
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// Address is the system account, which storage keeps the supply accounting
+var Address = common.HexToAddress("0x0000000000000000000000000000000000001001")
+
+var (
+	genesisSupplyKey = common.BigToHash(big.NewInt(0))
+	issuedKey        = common.BigToHash(big.NewInt(1))
+	burnedKey        = common.BigToHash(big.NewInt(2))
+)
+
+// Supply is the token supply accounting at the block
+type Supply struct {
+	Genesis *big.Int `json:"genesis" yaml:"genesis"` // allocated by the genesis
+	Issued  *big.Int `json:"issued" yaml:"issued"`   // issued by block rewards since genesis
+	Burned  *big.Int `json:"burned" yaml:"burned"`   // burned base fees and slashed stake since genesis
+	Total   *big.Int `json:"total" yaml:"total"`
+}
+
+// Issuance is the block reward distribution
+type Issuance struct {
+	Number    uint64   `json:"number" yaml:"number"`
+	Reward    *big.Int `json:"reward" yaml:"reward"`
+	Producer  *big.Int `json:"producer" yaml:"producer"`
+	Treasury  *big.Int `json:"treasury" yaml:"treasury"`
+	Community *big.Int `json:"community" yaml:"community"`
+}
+
+// Accumulate issues the block reward of the schedule and splits it between the producer, treasury and community pool
+func Accumulate(config *params.RewardsConfig, statedb *state.StateDB, header *types.Header, producer common.Address) *big.Int {
+	reward := config.Reward(header.Number)
+	if reward.Sign() == 0 {
+		return reward
+	}
+
+	issuance := split(config, header.Number.Uint64(), reward)
+	statedb.AddBalance(config.Treasury, issuance.Treasury)
+	statedb.AddBalance(config.CommunityPool, issuance.Community)
+	statedb.AddBalance(producer, issuance.Producer)
+
+	AddIssued(statedb, reward)
+	return reward
+}
+
+// split returns the distribution of the block reward
+func split(config *params.RewardsConfig, number uint64, reward *big.Int) *Issuance {
+	treasury := new(big.Int).Div(new(big.Int).Mul(reward, new(big.Int).SetUint64(config.TreasuryPercent)), big.NewInt(100))
+	community := new(big.Int).Div(new(big.Int).Mul(reward, new(big.Int).SetUint64(config.CommunityPercent)), big.NewInt(100))
+	return &Issuance{
+		Number:    number,
+		Reward:    reward,
+		Producer:  new(big.Int).Sub(new(big.Int).Sub(reward, treasury), community),
+		Treasury:  treasury,
+		Community: community,
+	}
+}
+
+// SetGenesisSupply records the genesis allocation
+func SetGenesisSupply(statedb *state.StateDB, amount *big.Int) {
+	keep(statedb)
+	statedb.SetState(Address, genesisSupplyKey, common.BigToHash(amount))
+}
+
+// AddIssued records new tokens
+func AddIssued(statedb *state.StateDB, amount *big.Int) {
+	add(statedb, issuedKey, amount)
+}
+
+// AddBurned records destroyed tokens
+func AddBurned(statedb *state.StateDB, amount *big.Int) {
+	add(statedb, burnedKey, amount)
+}
+
+func add(statedb *state.StateDB, key common.Hash, amount *big.Int) {
+	if amount == nil || amount.Sign() <= 0 {
+		return
+	}
+	keep(statedb)
+	value := statedb.GetState(Address, key).Big()
+	statedb.SetState(Address, key, common.BigToHash(value.Add(value, amount)))
+}
+
+// keep sets the nonce of the system account, otherwise it is empty and is deleted with its storage after EIP-158
+func keep(statedb *state.StateDB) {
+	if statedb.GetNonce(Address) == 0 {
+		statedb.SetNonce(Address, 1)
+	}
+}
+
+// GetSupply returns the supply accounting of the state
+func GetSupply(statedb *state.StateDB) *Supply {
+	supply := &Supply{
+		Genesis: statedb.GetState(Address, genesisSupplyKey).Big(),
+		Issued:  statedb.GetState(Address, issuedKey).Big(),
+		Burned:  statedb.GetState(Address, burnedKey).Big(),
+	}
+	supply.Total = new(big.Int).Add(supply.Genesis, supply.Issued)
+	supply.Total.Sub(supply.Total, supply.Burned)
+	return supply
+}
@@ -0,0 +1,81 @@
+package staking
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// StateReader provides states the registry is read from
+type StateReader interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// Backend is the chain staking API queries the registry of
+type Backend interface {
+	StateReader
+	CurrentHeader() *types.Header
+	GetHeaderByNumber(number uint64) *types.Header
+}
+
+func APIs(b Backend) []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "staking",
+			Service:   &API{b: b},
+		},
+	}
+}
+
+// API exposes the staking registry under the 'staking' namespace
+type API struct {
+	b Backend
+}
+
+func (api *API) registryAt(number *rpc.BlockNumber) (*Registry, error) {
+	header := api.b.CurrentHeader()
+	if number != nil && *number >= 0 {
+		header = api.b.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, ErrUnknownBlock
+	}
+
+	statedb, err := api.b.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	return Load(statedb)
+}
+
+// GetRegistry returns registered validators and pending unbondings at the block, latest if number is not set
+func (api *API) GetRegistry(number *rpc.BlockNumber) (*Registry, error) {
+	return api.registryAt(number)
+}
+
+// GetValidator returns the registered validator at the block, latest if number is not set
+func (api *API) GetValidator(address common.Address, number *rpc.BlockNumber) (*Validator, error) {
+	registry, err := api.registryAt(number)
+	if err != nil {
+		return nil, err
+	}
+	if v := registry.Validator(address); v != nil {
+		return v, nil
+	}
+	return nil, ErrUnknownValidator
+}
+
+// GetPendingReward returns rewards accrued by the delegation to the validator, which are not claimed yet,
+// at the block, latest if number is not set
+func (api *API) GetPendingReward(validator, delegator common.Address, number *rpc.BlockNumber) (*big.Int, error) {
+	registry, err := api.registryAt(number)
+	if err != nil {
+		return nil, err
+	}
+	if v := registry.Validator(validator); v != nil {
+		return v.PendingReward(delegator), nil
+	}
+	return nil, ErrUnknownValidator
+}
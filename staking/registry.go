@@ -0,0 +1,302 @@
+package staking
+
+import (
+	"bytes"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+	"sort"
+)
+
+// rewardPrecision scales the reward index, so rewards per stake unit are not rounded down to zero
+var rewardPrecision = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// Delegation is the stake bonded by the delegator to the validator, self bond is the delegation of the validator itself.
+// Rewards are accrued lazily, they are settled by the validator reward index on every stake change and claim
+type Delegation struct {
+	Delegator common.Address `json:"delegator" yaml:"delegator"`
+	Amount    *big.Int       `json:"amount" yaml:"amount"`
+	Index     *big.Int       `json:"index" yaml:"index" rlp:"optional"`   // validator reward index rewards are settled at
+	Reward    *big.Int       `json:"reward" yaml:"reward" rlp:"optional"` // settled rewards, which are not claimed yet
+}
+
+// Validator is the registered validator candidate with its delegations
+type Validator struct {
+	Address     common.Address `json:"address" yaml:"address"`
+	Commission  uint64         `json:"commission" yaml:"commission"`     // percent of rewards kept by the validator
+	Jailed      bool           `json:"jailed" yaml:"jailed"`             // jailed validators are not elected until unjailed
+	JailedUntil uint64         `json:"jailed_until" yaml:"jailed_until"` // first block the validator can be unjailed at
+	Delegations []*Delegation  `json:"delegations" yaml:"delegations"`
+	RewardIndex *big.Int       `json:"reward_index" yaml:"reward_index" rlp:"optional"` // delegators reward per stake unit scaled by 1e18, accumulated since registration
+}
+
+// Unbonding is the undelegated stake, which is returned to the owner once released.
+// It is still slashed for offences of the validator committed before it is unbonded
+type Unbonding struct {
+	Owner     common.Address `json:"owner" yaml:"owner"`
+	Amount    *big.Int       `json:"amount" yaml:"amount"`
+	Release   uint64         `json:"release" yaml:"release"`                    // number of the block the stake is returned at
+	Validator common.Address `json:"validator" yaml:"validator" rlp:"optional"` // validator the stake is unbonded from
+	Height    uint64         `json:"height" yaml:"height" rlp:"optional"`       // number of the block the stake is unbonded at
+}
+
+// Offence is the punished offence, it is remembered until its evidence expires
+type Offence struct {
+	Hash   common.Hash `json:"hash" yaml:"hash"`
+	Height uint64      `json:"height" yaml:"height"` // number of the block the offence is committed at
+}
+
+// Registry is the staking state, stored in the system account storage
+type Registry struct {
+	Validators []*Validator `json:"validators" yaml:"validators"`
+	Unbondings []*Unbonding `json:"unbondings" yaml:"unbondings"`
+	Slashed    []*Offence   `json:"slashed" yaml:"slashed"` // offences already punished
+}
+
+// Load reads the registry from the system account storage
+func Load(statedb *state.StateDB) (*Registry, error) {
+	registry := new(Registry)
+	data := statedb.GetStorageBytes(Address)
+	if len(data) == 0 {
+		return registry, nil
+	}
+	if err := rlp.DecodeBytes(data, registry); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Store writes the registry to the system account storage
+func (r *Registry) Store(statedb *state.StateDB) error {
+	data, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		return err
+	}
+	statedb.SetStorageBytes(Address, data)
+	return nil
+}
+
+// Validator returns the registered validator or nil
+func (r *Registry) Validator(addr common.Address) *Validator {
+	for _, v := range r.Validators {
+		if v.Address == addr {
+			return v
+		}
+	}
+	return nil
+}
+
+// Stake returns total stake delegated to the validator
+func (v *Validator) Stake() *big.Int {
+	stake := new(big.Int)
+	for _, d := range v.Delegations {
+		stake.Add(stake, d.Amount)
+	}
+	return stake
+}
+
+// SelfStake returns stake bonded by the validator itself
+func (v *Validator) SelfStake() *big.Int {
+	if d := v.delegation(v.Address); d != nil {
+		return new(big.Int).Set(d.Amount)
+	}
+	return new(big.Int)
+}
+
+func (v *Validator) delegation(delegator common.Address) *Delegation {
+	for _, d := range v.Delegations {
+		if d.Delegator == delegator {
+			return d
+		}
+	}
+	return nil
+}
+
+// PendingReward returns rewards of the delegator, which are not claimed yet
+func (v *Validator) PendingReward(delegator common.Address) *big.Int {
+	d := v.delegation(delegator)
+	if d == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Add(bigOrZero(d.Reward), v.accrued(d))
+}
+
+// accrued returns rewards of the delegation since it is settled last time
+func (v *Validator) accrued(d *Delegation) *big.Int {
+	delta := new(big.Int).Sub(bigOrZero(v.RewardIndex), bigOrZero(d.Index))
+	return delta.Div(delta.Mul(delta, d.Amount), rewardPrecision)
+}
+
+// settle moves rewards accrued by the delegation into its unclaimed rewards, it must precede every stake change
+func (v *Validator) settle(d *Delegation) {
+	d.Reward = new(big.Int).Add(bigOrZero(d.Reward), v.accrued(d))
+	d.Index = new(big.Int).Set(bigOrZero(v.RewardIndex))
+}
+
+// claim pays settled rewards of the delegation out of the system account
+func (v *Validator) claim(statedb *state.StateDB, d *Delegation) *big.Int {
+	v.settle(d)
+	reward := d.Reward
+	d.Reward = new(big.Int)
+	statedb.SubBalance(Address, reward)
+	statedb.AddBalance(d.Delegator, reward)
+	return reward
+}
+
+func bigOrZero(v *big.Int) *big.Int {
+	if v == nil {
+		return new(big.Int)
+	}
+	return v
+}
+
+// Elect returns up to max validators with the highest stake, which have enough self bond and are not jailed.
+// Validators are ordered by stake, which is the order they propose blocks in
+func (r *Registry) Elect(minStake *big.Int, max uint64) []common.Address {
+	candidates := make([]*Validator, 0, len(r.Validators))
+	stakes := make(map[common.Address]*big.Int)
+	for _, v := range r.Validators {
+		if v.Jailed || (minStake != nil && v.SelfStake().Cmp(minStake) < 0) {
+			continue
+		}
+		candidates = append(candidates, v)
+		stakes[v.Address] = v.Stake()
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if cmp := stakes[candidates[i].Address].Cmp(stakes[candidates[j].Address]); cmp != 0 {
+			return cmp > 0
+		}
+		return bytes.Compare(candidates[i].Address[:], candidates[j].Address[:]) < 0
+	})
+
+	if max > 0 && uint64(len(candidates)) > max {
+		candidates = candidates[:max]
+	}
+	elected := make([]common.Address, len(candidates))
+	for i, v := range candidates {
+		elected[i] = v.Address
+	}
+	return elected
+}
+
+// Reward issues the block reward to the validators by their stake, jailed validators are not rewarded. Each validator
+// receives its commission and shares the rest with delegators by their stake. Delegators rewards are kept
+// in the system account and accrued to the validator reward index, so they are claimed later. Returns the issued amount
+func (r *Registry) Reward(statedb *state.StateDB, validators []common.Address, reward *big.Int) *big.Int {
+	issued := new(big.Int)
+	if reward == nil || reward.Sign() <= 0 {
+		return issued
+	}
+
+	total := new(big.Int)
+	for _, addr := range validators {
+		if v := r.Validator(addr); v != nil && !v.Jailed {
+			total.Add(total, v.Stake())
+		}
+	}
+	if total.Sign() == 0 {
+		return issued
+	}
+
+	for _, addr := range validators {
+		v := r.Validator(addr)
+		if v == nil || v.Jailed {
+			continue
+		}
+		stake := v.Stake()
+		if stake.Sign() == 0 {
+			continue
+		}
+		share := new(big.Int).Div(new(big.Int).Mul(reward, stake), total)
+		commission := new(big.Int).Div(new(big.Int).Mul(share, new(big.Int).SetUint64(v.Commission)), big.NewInt(100))
+		statedb.AddBalance(v.Address, commission)
+		issued.Add(issued, commission)
+
+		rest := new(big.Int).Sub(share, commission)
+		index := new(big.Int).Div(new(big.Int).Mul(rest, rewardPrecision), stake)
+		v.RewardIndex = index.Add(index, bigOrZero(v.RewardIndex))
+		statedb.AddBalance(Address, rest)
+		issued.Add(issued, rest)
+	}
+	return issued
+}
+
+// Slash burns the part of the validator stake, including the stake unbonded since the offence height, and jails it.
+// Each offence is punished once
+func (r *Registry) Slash(statedb *state.StateDB, offence common.Hash, height uint64, offender common.Address, percent, jailUntil uint64) bool {
+	if r.IsSlashed(offence) {
+		return false
+	}
+	r.Slashed = append(r.Slashed, &Offence{Hash: offence, Height: height})
+
+	var slashed bool
+	for _, u := range r.Unbondings {
+		if u.Validator == offender && u.Height >= height {
+			r.burn(statedb, u.Amount, percent)
+			slashed = true
+		}
+	}
+
+	v := r.Validator(offender)
+	if v == nil {
+		return slashed
+	}
+	for _, d := range v.Delegations {
+		v.settle(d)
+		r.burn(statedb, d.Amount, percent)
+	}
+	v.Jailed = true
+	if v.JailedUntil < jailUntil {
+		v.JailedUntil = jailUntil
+	}
+	return true
+}
+
+// burn takes the percent off the stake amount and removes it from the system account
+func (r *Registry) burn(statedb *state.StateDB, stake *big.Int, percent uint64) {
+	amount := new(big.Int).Div(new(big.Int).Mul(stake, new(big.Int).SetUint64(percent)), big.NewInt(100))
+	stake.Sub(stake, amount)
+	statedb.SubBalance(Address, amount)
+}
+
+// IsSlashed returns whether the offence is already punished
+func (r *Registry) IsSlashed(offence common.Hash) bool {
+	for _, o := range r.Slashed {
+		if o.Hash == offence {
+			return true
+		}
+	}
+	return false
+}
+
+// PruneSlashed forgets punished offences, which evidence is expired at the block
+func (r *Registry) PruneSlashed(number, unbondingPeriod uint64) {
+	kept := r.Slashed[:0]
+	for _, o := range r.Slashed {
+		if !EvidenceExpired(o.Height, number, unbondingPeriod) {
+			kept = append(kept, o)
+		}
+	}
+	r.Slashed = kept
+}
+
+// EvidenceExpired tells if the offence committed at the height can not be punished at the block anymore.
+// Stake unbonded since the offence is released after the unbonding period, so evidence is accepted until then
+func EvidenceExpired(height, number, unbondingPeriod uint64) bool {
+	return height+unbondingPeriod < number
+}
+
+// Release returns unbonded stake, which release block is reached, to its owners
+func (r *Registry) Release(statedb *state.StateDB, number uint64) {
+	pending := r.Unbondings[:0]
+	for _, u := range r.Unbondings {
+		if u.Release > number {
+			pending = append(pending, u)
+			continue
+		}
+		statedb.SubBalance(Address, u.Amount)
+		statedb.AddBalance(u.Owner, u.Amount)
+	}
+	r.Unbondings = pending
+}
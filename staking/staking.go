@@ -0,0 +1,206 @@
+package staking
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"math/big"
+)
+
+// Address is the system account, which holds bonded stake and the registry in its storage
+var Address = common.HexToAddress("0x0000000000000000000000000000000000001000")
+
+const (
+	// OpBond bonds transferred value to the sender validator, registering it with the call commission
+	OpBond uint64 = iota
+	// OpDelegate bonds transferred value to the call validator
+	OpDelegate
+	// OpUndelegate unbonds the call amount from the validator, it is returned after the unbonding period
+	OpUndelegate
+	// OpUnjail makes the sender validator electable again after its jail period
+	OpUnjail
+	// OpClaim pays rewards accrued by the sender delegation to the call validator
+	OpClaim
+)
+
+var (
+	ErrUnknownBlock      = errors.New("unknown block")
+	ErrUnknownOp         = errors.New("unknown staking operation")
+	ErrUnknownValidator  = errors.New("unknown validator")
+	ErrZeroAmount        = errors.New("staking amount must be positive")
+	ErrInvalidCommission = errors.New("commission must not exceed 100 percent")
+	ErrInsufficientStake = errors.New("insufficient delegated stake")
+	ErrDelegationTooLow  = errors.New("delegation is below the minimum")
+	ErrUnknownDelegation = errors.New("unknown delegation")
+	ErrStillJailed       = errors.New("validator is still jailed")
+	ErrUnexpectedValue   = errors.New("operation does not accept value")
+)
+
+// Call is the staking operation, rlp encoded into the data of transaction sent to the system account
+type Call struct {
+	Op         uint64         `json:"op" yaml:"op"`
+	Validator  common.Address `json:"validator" yaml:"validator"`
+	Amount     *big.Int       `json:"amount" yaml:"amount"`
+	Commission uint64         `json:"commission" yaml:"commission"`
+}
+
+// EncodeCall returns transaction data of the staking operation
+func EncodeCall(call *Call) ([]byte, error) {
+	if call.Amount == nil {
+		call.Amount = new(big.Int)
+	}
+	return rlp.EncodeToBytes(call)
+}
+
+// ApplyCall executes the staking operation of the transaction, which value is already transferred to the system account
+func ApplyCall(config *params.StakingConfig, statedb *state.StateDB, number uint64, from common.Address, value *big.Int, data []byte) error {
+	call := new(Call)
+	if err := rlp.DecodeBytes(data, call); err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownOp, err)
+	}
+
+	registry, err := Load(statedb)
+	if err != nil {
+		return err
+	}
+
+	switch call.Op {
+	case OpBond:
+		if call.Commission > 100 {
+			return ErrInvalidCommission
+		}
+		v := registry.Validator(from)
+		if v == nil {
+			v = &Validator{Address: from}
+			registry.Validators = append(registry.Validators, v)
+		}
+		v.Commission = call.Commission
+		if err := v.delegate(from, value, config.DelegationMinimum()); err != nil {
+			return err
+		}
+
+	case OpDelegate:
+		v := registry.Validator(call.Validator)
+		if v == nil {
+			return ErrUnknownValidator
+		}
+		if err := v.delegate(from, value, config.DelegationMinimum()); err != nil {
+			return err
+		}
+
+	case OpUndelegate:
+		if value.Sign() > 0 {
+			return ErrUnexpectedValue
+		}
+		v := registry.Validator(call.Validator)
+		if v == nil {
+			return ErrUnknownValidator
+		}
+		if err := registry.undelegate(statedb, v, from, call.Amount, config.DelegationMinimum()); err != nil {
+			return err
+		}
+		registry.Unbondings = append(registry.Unbondings, &Unbonding{
+			Owner:     from,
+			Amount:    new(big.Int).Set(call.Amount),
+			Release:   number + config.UnbondingPeriod,
+			Validator: v.Address,
+			Height:    number,
+		})
+
+	case OpUnjail:
+		if value.Sign() > 0 {
+			return ErrUnexpectedValue
+		}
+		v := registry.Validator(from)
+		if v == nil {
+			return ErrUnknownValidator
+		}
+		if v.JailedUntil > number {
+			return ErrStillJailed
+		}
+		v.Jailed = false
+
+	case OpClaim:
+		if value.Sign() > 0 {
+			return ErrUnexpectedValue
+		}
+		v := registry.Validator(call.Validator)
+		if v == nil {
+			return ErrUnknownValidator
+		}
+		d := v.delegation(from)
+		if d == nil {
+			return ErrUnknownDelegation
+		}
+		v.claim(statedb, d)
+
+	default:
+		return ErrUnknownOp
+	}
+
+	return registry.Store(statedb)
+}
+
+// delegate bonds the amount, repeated delegations of the same delegator are merged into one
+func (v *Validator) delegate(delegator common.Address, amount, minimum *big.Int) error {
+	if amount == nil || amount.Sign() <= 0 {
+		return ErrZeroAmount
+	}
+	if amount.Cmp(minimum) < 0 {
+		return fmt.Errorf("%w: %s is less than %s", ErrDelegationTooLow, amount, minimum)
+	}
+	if d := v.delegation(delegator); d != nil {
+		v.settle(d)
+		d.Amount.Add(d.Amount, amount)
+		return nil
+	}
+	v.Delegations = append(v.Delegations, &Delegation{
+		Delegator: delegator,
+		Amount:    new(big.Int).Set(amount),
+		Index:     new(big.Int).Set(bigOrZero(v.RewardIndex)),
+	})
+	return nil
+}
+
+// undelegate removes the amount from the delegation, its rewards are paid once it is removed entirely.
+// Validators without stake are removed from the registry
+func (r *Registry) undelegate(statedb *state.StateDB, v *Validator, delegator common.Address, amount, minimum *big.Int) error {
+	if amount == nil || amount.Sign() <= 0 {
+		return ErrZeroAmount
+	}
+	d := v.delegation(delegator)
+	if d == nil || d.Amount.Cmp(amount) < 0 {
+		return ErrInsufficientStake
+	}
+	left := new(big.Int).Sub(d.Amount, amount)
+	if left.Sign() > 0 && left.Cmp(minimum) < 0 {
+		return fmt.Errorf("%w: %s is left", ErrDelegationTooLow, left)
+	}
+	v.settle(d)
+	d.Amount.Set(left)
+
+	if d.Amount.Sign() == 0 {
+		v.claim(statedb, d)
+		delegations := v.Delegations[:0]
+		for _, other := range v.Delegations {
+			if other != d {
+				delegations = append(delegations, other)
+			}
+		}
+		v.Delegations = delegations
+	}
+	// jailed validators are kept, so the jail can not be escaped by bonding again
+	if len(v.Delegations) == 0 && !v.Jailed {
+		validators := r.Validators[:0]
+		for _, other := range r.Validators {
+			if other != v {
+				validators = append(validators, other)
+			}
+		}
+		r.Validators = validators
+	}
+	return nil
+}
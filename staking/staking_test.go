@@ -0,0 +1,281 @@
+package staking
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+var testConfig = &params.StakingConfig{
+	MinValidatorStake: big.NewInt(100),
+	MinDelegation:     big.NewInt(50),
+	MaxValidators:     2,
+	BlockReward:       big.NewInt(1000),
+	SlashPercent:      10,
+	JailPeriod:        5,
+	UnbondingPeriod:   3,
+}
+
+func newTestState(t *testing.T) *state.StateDB {
+	statedb, err := state.NewDatabase(rawdb.NewMemoryDatabase()).Open(types.EmptyRootHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return statedb
+}
+
+// applyCall transfers the value to the staking account, as transaction execution does, and applies the call
+func applyCall(t *testing.T, statedb *state.StateDB, number uint64, from common.Address, value int64, call *Call) error {
+	data, err := EncodeCall(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb.AddBalance(Address, big.NewInt(value))
+	return ApplyCall(testConfig, statedb, number, from, big.NewInt(value), data)
+}
+
+func TestStakingElection(t *testing.T) {
+	statedb := newTestState(t)
+
+	if err := applyCall(t, statedb, 1, tests.Account0, 100, &Call{Op: OpBond, Commission: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account1, 200, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	// self bond is lower than required, so delegations do not make it elected
+	if err := applyCall(t, statedb, 1, tests.Account2, 50, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account5, 500, &Call{Op: OpDelegate, Validator: tests.Account2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account5, 300, &Call{Op: OpDelegate, Validator: tests.Account0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account5, 1, &Call{Op: OpDelegate, Validator: tests.Account3}); !errors.Is(err, ErrUnknownValidator) {
+		t.Fatalf("unexpected unknown validator error: %v", err)
+	}
+
+	registry, err := Load(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elected := registry.Elect(testConfig.MinValidatorStake, testConfig.MaxValidators)
+	if len(elected) != 2 || elected[0] != tests.Account0 || elected[1] != tests.Account1 {
+		t.Fatalf("unexpected elected validators: %v", elected)
+	}
+
+	// Account0 has 400 of 600 elected stake, receives 10% commission and shares the rest by 1:3 once claimed
+	registry.Reward(statedb, elected, testConfig.BlockReward)
+	if balance := statedb.GetBalance(tests.Account0); balance.Int64() != 66 {
+		t.Fatalf("unexpected commission: %s", balance)
+	}
+	if pending := registry.Validator(tests.Account0).PendingReward(tests.Account5); pending.Int64() != 450 {
+		t.Fatalf("unexpected pending reward: %s", pending)
+	}
+	if err := registry.Store(statedb); err != nil {
+		t.Fatal(err)
+	}
+	for _, claim := range []struct{ delegator, validator common.Address }{
+		{tests.Account0, tests.Account0},
+		{tests.Account1, tests.Account1},
+		{tests.Account5, tests.Account0},
+	} {
+		if err := applyCall(t, statedb, 2, claim.delegator, 0, &Call{Op: OpClaim, Validator: claim.validator}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for addr, expected := range map[common.Address]int64{
+		tests.Account0: 66 + 150,
+		tests.Account1: 333,
+		tests.Account5: 450,
+	} {
+		if balance := statedb.GetBalance(addr); balance.Int64() != expected {
+			t.Errorf("unexpected reward of %s: %s, expected %d", addr, balance, expected)
+		}
+	}
+}
+
+func TestStakingDelegations(t *testing.T) {
+	statedb := newTestState(t)
+
+	if err := applyCall(t, statedb, 1, tests.Account0, 100, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account5, 1, &Call{Op: OpDelegate, Validator: tests.Account0}); !errors.Is(err, ErrDelegationTooLow) {
+		t.Fatalf("unexpected delegation minimum error: %v", err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account5, 100, &Call{Op: OpDelegate, Validator: tests.Account0}); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := Load(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Reward(statedb, []common.Address{tests.Account0}, testConfig.BlockReward)
+	if err := registry.Store(statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	// repeated delegation is merged, rewards accrued before it are kept
+	if err := applyCall(t, statedb, 2, tests.Account5, 200, &Call{Op: OpDelegate, Validator: tests.Account0}); err != nil {
+		t.Fatal(err)
+	}
+	if registry, err = Load(statedb); err != nil {
+		t.Fatal(err)
+	}
+	v := registry.Validator(tests.Account0)
+	if len(v.Delegations) != 2 || v.Stake().Int64() != 400 {
+		t.Fatalf("repeated delegation is not merged: %d delegations, stake %s", len(v.Delegations), v.Stake())
+	}
+	registry.Reward(statedb, []common.Address{tests.Account0}, testConfig.BlockReward)
+	if pending := v.PendingReward(tests.Account5); pending.Int64() != 500+750 {
+		t.Fatalf("unexpected pending reward: %s", pending)
+	}
+	if err := registry.Store(statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyCall(t, statedb, 3, tests.Account5, 0, &Call{Op: OpUndelegate, Validator: tests.Account0, Amount: big.NewInt(280)}); !errors.Is(err, ErrDelegationTooLow) {
+		t.Fatalf("unexpected delegation minimum error: %v", err)
+	}
+	// rewards are paid out once the delegation is removed
+	if err := applyCall(t, statedb, 3, tests.Account5, 0, &Call{Op: OpUndelegate, Validator: tests.Account0, Amount: big.NewInt(300)}); err != nil {
+		t.Fatal(err)
+	}
+	if balance := statedb.GetBalance(tests.Account5); balance.Int64() != 1250 {
+		t.Fatalf("unexpected claimed reward: %s", balance)
+	}
+	if err := applyCall(t, statedb, 3, tests.Account5, 0, &Call{Op: OpClaim, Validator: tests.Account0}); !errors.Is(err, ErrUnknownDelegation) {
+		t.Fatalf("unexpected unknown delegation error: %v", err)
+	}
+}
+
+func TestStakingSlashAndUnbond(t *testing.T) {
+	statedb := newTestState(t)
+
+	if err := applyCall(t, statedb, 1, tests.Account0, 1000, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account0, 0, &Call{Op: OpUndelegate, Validator: tests.Account0, Amount: big.NewInt(400)}); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := Load(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offence := common.HexToHash("0x01")
+	// stake unbonded before the offence is not slashed
+	if !registry.Slash(statedb, offence, 2, tests.Account0, testConfig.SlashPercent, 2+testConfig.JailPeriod) {
+		t.Fatal("validator is not slashed")
+	}
+	if registry.Slash(statedb, offence, 2, tests.Account0, testConfig.SlashPercent, 2+testConfig.JailPeriod) {
+		t.Fatal("offence is punished twice")
+	}
+	if stake := registry.Validator(tests.Account0).Stake(); stake.Int64() != 540 {
+		t.Fatalf("unexpected stake after slashing: %s", stake)
+	}
+	if elected := registry.Elect(testConfig.MinValidatorStake, testConfig.MaxValidators); len(elected) != 0 {
+		t.Fatalf("jailed validator is elected: %v", elected)
+	}
+
+	registry.Release(statedb, 3)
+	if balance := statedb.GetBalance(tests.Account0); balance.Sign() != 0 {
+		t.Fatalf("stake released before unbonding period: %s", balance)
+	}
+	registry.Release(statedb, 4)
+	if balance := statedb.GetBalance(tests.Account0); balance.Int64() != 400 {
+		t.Fatalf("unexpected released stake: %s", balance)
+	}
+	if balance := statedb.GetBalance(Address); balance.Int64() != 540 {
+		t.Fatalf("unexpected staking account balance: %s", balance)
+	}
+	if err := registry.Store(statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyCall(t, statedb, 6, tests.Account0, 0, &Call{Op: OpUnjail}); !errors.Is(err, ErrStillJailed) {
+		t.Fatalf("unexpected still jailed error: %v", err)
+	}
+	if err := applyCall(t, statedb, 7, tests.Account0, 0, &Call{Op: OpUnjail}); err != nil {
+		t.Fatal(err)
+	}
+	if registry, err = Load(statedb); err != nil {
+		t.Fatal(err)
+	}
+	if elected := registry.Elect(testConfig.MinValidatorStake, testConfig.MaxValidators); len(elected) != 1 {
+		t.Fatalf("unjailed validator is not elected: %v", elected)
+	}
+}
+
+func TestStakingSlashUnbonding(t *testing.T) {
+	statedb := newTestState(t)
+
+	if err := applyCall(t, statedb, 1, tests.Account0, 1000, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 1, tests.Account1, 100, &Call{Op: OpBond}); err != nil {
+		t.Fatal(err)
+	}
+	// both validators double sign at block #2 and undelegate before the evidence is included,
+	// Account1 unbonds all its stake and leaves the registry
+	if err := applyCall(t, statedb, 3, tests.Account0, 0, &Call{Op: OpUndelegate, Validator: tests.Account0, Amount: big.NewInt(400)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyCall(t, statedb, 3, tests.Account1, 0, &Call{Op: OpUndelegate, Validator: tests.Account1, Amount: big.NewInt(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := Load(statedb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.Validator(tests.Account1) != nil {
+		t.Fatal("validator without stake is kept")
+	}
+	if !registry.Slash(statedb, common.HexToHash("0x01"), 2, tests.Account0, testConfig.SlashPercent, 4+testConfig.JailPeriod) {
+		t.Fatal("validator is not slashed")
+	}
+	if !registry.Slash(statedb, common.HexToHash("0x02"), 2, tests.Account1, testConfig.SlashPercent, 4+testConfig.JailPeriod) {
+		t.Fatal("unbonded validator is not slashed")
+	}
+	if stake := registry.Validator(tests.Account0).Stake(); stake.Int64() != 540 {
+		t.Fatalf("unexpected stake after slashing: %s", stake)
+	}
+
+	registry.Release(statedb, 6)
+	if balance := statedb.GetBalance(tests.Account0); balance.Int64() != 360 {
+		t.Fatalf("unexpected released stake of Account0: %s", balance)
+	}
+	if balance := statedb.GetBalance(tests.Account1); balance.Int64() != 90 {
+		t.Fatalf("unexpected released stake of Account1: %s", balance)
+	}
+	if balance := statedb.GetBalance(Address); balance.Int64() != 540 {
+		t.Fatalf("unexpected staking account balance: %s", balance)
+	}
+
+	// offences are forgotten once their evidence expires
+	registry.PruneSlashed(5, testConfig.UnbondingPeriod)
+	if len(registry.Slashed) != 2 {
+		t.Fatalf("offences are pruned before evidence expires: %d", len(registry.Slashed))
+	}
+	registry.PruneSlashed(6, testConfig.UnbondingPeriod)
+	if len(registry.Slashed) != 0 {
+		t.Fatalf("expired offences are kept: %d", len(registry.Slashed))
+	}
+	if err := registry.Store(statedb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(statedb); err != nil {
+		t.Fatal(err)
+	}
+}
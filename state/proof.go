@@ -0,0 +1,153 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"math/big"
+)
+
+// AccountResult is the eth_getProof response format
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []string        `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+}
+
+type StorageResult struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+	Proof []string     `json:"proof"`
+}
+
+// AccountProof returns Merkle proofs of account and its storage slots
+func (s *StateDB) AccountProof(addr common.Address, storageKeys []common.Hash) (*AccountResult, error) {
+	storageHash := types.EmptyRootHash
+	codeHash := s.GetCodeHash(addr)
+	storageTrie := s.StorageTrie(addr)
+	if storageTrie != nil {
+		storageHash = storageTrie.Hash()
+	} else {
+		// account does not exist, so code hash is the hash of empty code
+		codeHash = crypto.Keccak256Hash(nil)
+	}
+
+	storageProof := make([]StorageResult, len(storageKeys))
+	for i, key := range storageKeys {
+		if storageTrie == nil {
+			storageProof[i] = StorageResult{Key: key.Hex(), Value: &hexutil.Big{}, Proof: []string{}}
+			continue
+		}
+		proof, err := s.GetStorageProof(addr, key)
+		if err != nil {
+			return nil, err
+		}
+		storageProof[i] = StorageResult{
+			Key:   key.Hex(),
+			Value: (*hexutil.Big)(s.GetState(addr, key).Big()),
+			Proof: toHexSlice(proof),
+		}
+	}
+
+	accountProof, err := s.GetProof(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccountResult{
+		Address:      addr,
+		AccountProof: toHexSlice(accountProof),
+		Balance:      (*hexutil.Big)(s.GetBalance(addr)),
+		CodeHash:     codeHash,
+		Nonce:        hexutil.Uint64(s.GetNonce(addr)),
+		StorageHash:  storageHash,
+		StorageProof: storageProof,
+	}, s.Error()
+}
+
+// VerifyAccountProof checks account and storage proofs against the state root
+func VerifyAccountProof(root common.Hash, res *AccountResult) error {
+	value, err := verify(root, crypto.Keccak256(res.Address.Bytes()), res.AccountProof)
+	if err != nil {
+		return fmt.Errorf("invalid account proof: %w", err)
+	}
+
+	expected := types.StateAccount{
+		Nonce:    uint64(res.Nonce),
+		Balance:  res.Balance.ToInt(),
+		Root:     res.StorageHash,
+		CodeHash: res.CodeHash.Bytes(),
+	}
+	if len(value) == 0 {
+		// proof of absence is valid only for empty account
+		if expected.Nonce != 0 || expected.Balance.Sign() != 0 || expected.Root != types.EmptyRootHash {
+			return fmt.Errorf("account %s is not in state", res.Address)
+		}
+	} else {
+		enc, err := rlp.EncodeToBytes(&expected)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(enc, value) {
+			return fmt.Errorf("account %s does not match proof", res.Address)
+		}
+	}
+
+	for _, sp := range res.StorageProof {
+		value, err := verify(res.StorageHash, crypto.Keccak256(common.HexToHash(sp.Key).Bytes()), sp.Proof)
+		if err != nil {
+			return fmt.Errorf("invalid storage proof of %s: %w", sp.Key, err)
+		}
+
+		stored := new(big.Int)
+		if len(value) > 0 {
+			_, content, _, err := rlp.Split(value)
+			if err != nil {
+				return err
+			}
+			stored.SetBytes(content)
+		}
+		if stored.Cmp(sp.Value.ToInt()) != 0 {
+			return fmt.Errorf("storage slot %s does not match proof", sp.Key)
+		}
+	}
+
+	return nil
+}
+
+func verify(root common.Hash, key []byte, proof []string) ([]byte, error) {
+	if root == types.EmptyRootHash && len(proof) == 0 {
+		return nil, nil
+	}
+
+	db := memorydb.New()
+	for _, node := range proof {
+		data, err := hexutil.Decode(node)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Put(crypto.Keccak256(data), data); err != nil {
+			return nil, err
+		}
+	}
+
+	return trie.VerifyProof(root, key, db)
+}
+
+func toHexSlice(b [][]byte) []string {
+	r := make([]string, len(b))
+	for i := range b {
+		r[i] = hexutil.Encode(b[i])
+	}
+	return r
+}
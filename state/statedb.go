@@ -0,0 +1,114 @@
+package state
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	gethstate "github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"math/big"
+)
+
+// Database keeps account tries and contract code on top of chain key value storage.
+// Tries are Merkle Patricia Tries encoded the same way as Ethereum does,
+// so state roots and proofs can be verified by any Ethereum tooling
+type Database struct {
+	db gethstate.Database
+}
+
+// NewDatabase returns state database persisted through provided key value store
+func NewDatabase(kv ethdb.KeyValueStore) *Database {
+	return &Database{
+		db: gethstate.NewDatabase(rawdb.NewDatabase(kv)),
+	}
+}
+
+// Open returns state at specified root, types.EmptyRootHash or zero hash opens an empty state
+func (db *Database) Open(root common.Hash) (*StateDB, error) {
+	sdb, err := gethstate.New(root, db.db, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StateDB{
+		StateDB: sdb,
+		db:      db,
+	}, nil
+}
+
+// HasState reports whether state trie root node is persisted
+func (db *Database) HasState(root common.Hash) bool {
+	_, err := db.db.OpenTrie(root)
+	return err == nil
+}
+
+// Underlying returns go-ethereum state database used by EVM related packages
+func (db *Database) Underlying() gethstate.Database {
+	return db.db
+}
+
+// StateDB holds per-account balance, nonce, code and storage.
+// Every change is journaled, so it can be reverted to the snapshot taken with Snapshot,
+// e.g. when transaction execution fails
+type StateDB struct {
+	*gethstate.StateDB
+	db *Database
+}
+
+// Copy returns independent copy of the state, the journal and snapshots are not copied
+func (s *StateDB) Copy() *StateDB {
+	return &StateDB{
+		StateDB: s.StateDB.Copy(),
+		db:      s.db,
+	}
+}
+
+// CommitBlock writes state changes made by block execution to storage and returns new state root
+func (s *StateDB) CommitBlock(deleteEmptyObjects bool) (common.Hash, error) {
+	root, err := s.Commit(deleteEmptyObjects)
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	if err := s.db.db.TrieDB().Commit(root, false, nil); err != nil {
+		return common.Hash{}, err
+	}
+
+	return root, nil
+}
+
+// GetStorageBytes returns data kept in the account storage by SetStorageBytes
+func (s *StateDB) GetStorageBytes(addr common.Address) []byte {
+	size := s.GetState(addr, common.Hash{}).Big().Uint64()
+	if size == 0 {
+		return nil
+	}
+
+	data := make([]byte, 0, size+common.HashLength)
+	for i := uint64(0); uint64(len(data)) < size; i++ {
+		chunk := s.GetState(addr, chunkKey(i))
+		data = append(data, chunk[:]...)
+	}
+	return data[:size]
+}
+
+// SetStorageBytes writes data to the account storage as 32 byte chunks following its length,
+// it is used by system accounts to keep their encoded state
+func (s *StateDB) SetStorageBytes(addr common.Address, data []byte) {
+	prevSize := s.GetState(addr, common.Hash{}).Big().Uint64()
+	s.SetState(addr, common.Hash{}, common.BigToHash(new(big.Int).SetUint64(uint64(len(data)))))
+
+	var i uint64
+	for ; uint64(len(data)) > i*common.HashLength; i++ {
+		var chunk common.Hash
+		copy(chunk[:], data[i*common.HashLength:])
+		s.SetState(addr, chunkKey(i), chunk)
+	}
+	// chunks of the larger previous data are cleared
+	for ; prevSize > i*common.HashLength; i++ {
+		s.SetState(addr, chunkKey(i), common.Hash{})
+	}
+}
+
+func chunkKey(i uint64) common.Hash {
+	return common.BigToHash(new(big.Int).SetUint64(i + 1))
+}
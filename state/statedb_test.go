@@ -0,0 +1,108 @@
+package state
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	return NewDatabase(newTestKeyValueStore(t))
+}
+
+func newTestKeyValueStore(t *testing.T) *badgerdb.Database {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return badgerdb.NewDatabase(db, []byte("state:"))
+}
+
+func TestStateSnapshotRevert(t *testing.T) {
+	db := newTestDatabase(t)
+	s, err := db.Open(types.EmptyRootHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.AddBalance(tests.Account0, big.NewInt(100))
+	snap := s.Snapshot()
+	s.SubBalance(tests.Account0, big.NewInt(40))
+	s.SetNonce(tests.Account0, 1)
+	s.SetState(tests.Account0, common.HexToHash("0x01"), common.HexToHash("0x02"))
+
+	s.RevertToSnapshot(snap)
+	if balance := s.GetBalance(tests.Account0); balance.Int64() != 100 {
+		t.Fatalf("unexpected balance after revert: %s", balance)
+	}
+	if nonce := s.GetNonce(tests.Account0); nonce != 0 {
+		t.Fatalf("unexpected nonce after revert: %d", nonce)
+	}
+	if value := s.GetState(tests.Account0, common.HexToHash("0x01")); value != (common.Hash{}) {
+		t.Fatalf("unexpected storage value after revert: %s", value)
+	}
+}
+
+func TestStateCommitAndProof(t *testing.T) {
+	kv := newTestKeyValueStore(t)
+	db := NewDatabase(kv)
+	s, err := db.Open(types.EmptyRootHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slot := common.HexToHash("0x01")
+	s.SetBalance(tests.Account0, big.NewInt(1e18))
+	s.SetNonce(tests.Account0, 5)
+	s.SetBalance(tests.Account1, big.NewInt(42))
+	s.SetCode(tests.Account1, []byte{0x60, 0x00})
+	s.SetState(tests.Account1, slot, common.HexToHash("0xff"))
+
+	root, err := s.CommitBlock(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !db.HasState(root) {
+		t.Fatalf("state root %s is not persisted", root)
+	}
+
+	// reopen state from storage by root
+	reopened, err := NewDatabase(kv).Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce := reopened.GetNonce(tests.Account0); nonce != 5 {
+		t.Fatalf("unexpected nonce: %d", nonce)
+	}
+	if value := reopened.GetState(tests.Account1, slot); value != common.HexToHash("0xff") {
+		t.Fatalf("unexpected storage value: %s", value)
+	}
+
+	for _, addr := range []common.Address{tests.Account0, tests.Account1, tests.Account2} {
+		res, err := reopened.AccountProof(addr, []common.Hash{slot})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyAccountProof(root, res); err != nil {
+			t.Fatalf("account %s proof verification failed: %s", addr, err)
+		}
+	}
+
+	// tampered balance must not pass verification
+	res, err := reopened.AccountProof(tests.Account1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Balance.ToInt().SetInt64(43)
+	if err := VerifyAccountProof(root, res); err == nil {
+		t.Fatal("tampered proof passed verification")
+	}
+}
@@ -0,0 +1,260 @@
+package badgerdb
+
+import (
+	"fmt"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// kvPrefix separates raw key value pairs (state trie nodes, chain indexes) from Driver records
+var kvPrefix = []byte("kv:")
+
+// Database implements ethdb.KeyValueStore on top of badger
+type Database struct {
+	db     *badger.DB
+	prefix []byte
+}
+
+// NewDatabase returns key value store using keys namespaced with prefix
+func NewDatabase(db *badger.DB, prefix []byte) *Database {
+	return &Database{
+		db:     db,
+		prefix: prefix,
+	}
+}
+
+// KeyValueStore returns raw key value store sharing the Driver database
+func (d *Driver) KeyValueStore() ethdb.KeyValueStore {
+	return NewDatabase(d.db, kvPrefix)
+}
+
+func (kv *Database) key(key []byte) []byte {
+	return append(append(make([]byte, 0, len(kv.prefix)+len(key)), kv.prefix...), key...)
+}
+
+func (kv *Database) Has(key []byte) (bool, error) {
+	err := kv.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(kv.key(key))
+		return err
+	})
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (kv *Database) Get(key []byte) ([]byte, error) {
+	var data []byte
+	err := kv.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(kv.key(key))
+		if err != nil {
+			return err
+		}
+		data, err = item.ValueCopy(nil)
+		return err
+	})
+	return data, err
+}
+
+func (kv *Database) Put(key []byte, value []byte) error {
+	return kv.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(kv.key(key), value)
+	})
+}
+
+func (kv *Database) Delete(key []byte) error {
+	return kv.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(kv.key(key))
+	})
+}
+
+func (kv *Database) Stat(property string) (string, error) {
+	lsm, vlog := kv.db.Size()
+	return fmt.Sprintf("lsm: %d, vlog: %d", lsm, vlog), nil
+}
+
+// Compact runs value log garbage collection, as badger compacts LSM tree on its own
+func (kv *Database) Compact(start []byte, limit []byte) error {
+	if err := kv.db.RunValueLogGC(0.5); err != nil && err != badger.ErrNoRewrite {
+		return err
+	}
+	return nil
+}
+
+func (kv *Database) NewBatch() ethdb.Batch {
+	return &batch{kv: kv}
+}
+
+func (kv *Database) NewBatchWithSize(size int) ethdb.Batch {
+	return &batch{kv: kv, ops: make([]batchOp, 0, size)}
+}
+
+func (kv *Database) NewIterator(prefix []byte, start []byte) ethdb.Iterator {
+	txn := kv.db.NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = kv.key(prefix)
+
+	return &iterator{
+		txn:    txn,
+		it:     txn.NewIterator(opts),
+		prefix: opts.Prefix,
+		seek:   kv.key(append(append([]byte{}, prefix...), start...)),
+		trim:   len(kv.prefix),
+	}
+}
+
+func (kv *Database) NewSnapshot() (ethdb.Snapshot, error) {
+	return &snapshot{kv: kv, txn: kv.db.NewTransaction(false)}, nil
+}
+
+// Close does nothing, as badger database is owned and closed by Driver
+func (kv *Database) Close() error {
+	return nil
+}
+
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type batch struct {
+	kv   *Database
+	ops  []batchOp
+	size int
+}
+
+func (b *batch) Put(key []byte, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *batch) Delete(key []byte) error {
+	b.ops = append(b.ops, batchOp{key: append([]byte{}, key...), delete: true})
+	b.size += len(key)
+	return nil
+}
+
+func (b *batch) ValueSize() int {
+	return b.size
+}
+
+func (b *batch) Write() error {
+	wb := b.kv.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = wb.Delete(b.kv.key(op.key))
+		} else {
+			err = wb.Set(b.kv.key(op.key), op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return wb.Flush()
+}
+
+func (b *batch) Reset() {
+	b.ops = b.ops[:0]
+	b.size = 0
+}
+
+func (b *batch) Replay(w ethdb.KeyValueWriter) error {
+	for _, op := range b.ops {
+		var err error
+		if op.delete {
+			err = w.Delete(op.key)
+		} else {
+			err = w.Put(op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type iterator struct {
+	txn     *badger.Txn
+	it      *badger.Iterator
+	prefix  []byte
+	seek    []byte
+	trim    int
+	started bool
+
+	key, value []byte
+	err        error
+}
+
+func (i *iterator) Next() bool {
+	if i.err != nil || i.it == nil {
+		return false
+	}
+
+	if !i.started {
+		i.it.Seek(i.seek)
+		i.started = true
+	} else {
+		i.it.Next()
+	}
+
+	if !i.it.ValidForPrefix(i.prefix) {
+		i.key, i.value = nil, nil
+		return false
+	}
+
+	item := i.it.Item()
+	i.key = item.KeyCopy(nil)[i.trim:]
+	i.value, i.err = item.ValueCopy(nil)
+	return i.err == nil
+}
+
+func (i *iterator) Error() error {
+	return i.err
+}
+
+func (i *iterator) Key() []byte {
+	return i.key
+}
+
+func (i *iterator) Value() []byte {
+	return i.value
+}
+
+func (i *iterator) Release() {
+	if i.it != nil {
+		i.it.Close()
+		i.txn.Discard()
+		i.it = nil
+	}
+}
+
+type snapshot struct {
+	kv  *Database
+	txn *badger.Txn
+}
+
+func (s *snapshot) Has(key []byte) (bool, error) {
+	_, err := s.txn.Get(s.kv.key(key))
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *snapshot) Get(key []byte) ([]byte, error) {
+	item, err := s.txn.Get(s.kv.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (s *snapshot) Release() {
+	s.txn.Discard()
+}
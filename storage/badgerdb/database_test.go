@@ -0,0 +1,52 @@
+package badgerdb
+
+import (
+	"bytes"
+	"context"
+	"github.com/rovergulf/chain/storage"
+	"testing"
+)
+
+func TestDatabaseIteratorAndBatch(t *testing.T) {
+	d := newTestDriver(t)
+	kv := d.KeyValueStore()
+
+	batch := kv.NewBatch()
+	for _, key := range []string{"a1", "a2", "a3", "b1"} {
+		if err := batch.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Delete([]byte("a3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := kv.Has([]byte("a3")); ok {
+		t.Fatal("deleted key is present")
+	}
+	if value, err := kv.Get([]byte("b1")); err != nil || !bytes.Equal(value, []byte("vb1")) {
+		t.Fatalf("unexpected value: %s, err: %v", value, err)
+	}
+
+	it := kv.NewIterator([]byte("a"), []byte("2"))
+	defer it.Release()
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if it.Error() != nil {
+		t.Fatal(it.Error())
+	}
+	if len(keys) != 1 || keys[0] != "a2" {
+		t.Fatalf("unexpected iterated keys: %v", keys)
+	}
+
+	// raw key value pairs must not be visible to driver records
+	if block, err := d.SearchBlocks(context.Background(), storage.BlockFilter{}); err != nil || len(block) != 0 {
+		t.Fatalf("unexpected blocks: %d, err: %v", len(block), err)
+	}
+}
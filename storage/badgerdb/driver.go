@@ -1 +1,318 @@
 package badgerdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"path"
+)
+
+const DbChainDir = "chaindata"
+
+var (
+	blockPrefix       = []byte("b") // blockPrefix + hash -> rlp encoded block
+	blockNumberPrefix = []byte("n") // blockNumberPrefix + num (uint64 big endian) -> hash
+	txPrefix          = []byte("t") // txPrefix + hash -> binary encoded tx
+	eventPrefix       = []byte("e") // eventPrefix + tx hash + index (uint32 big endian) -> json encoded log
+)
+
+func init() {
+	storage.Register(storage.BadgerDriver, Open)
+}
+
+// Driver is the default chain Storage implementation
+type Driver struct {
+	db     *badger.DB
+	logger *zap.SugaredLogger
+}
+
+// Open opens badger database under 'data_dir' using 'cache.size' as block cache size
+func Open(ctx context.Context, cfg *viper.Viper) (storage.Storage, error) {
+	logger, err := logutils.NewLogger()
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := path.Join(cfg.GetString("data_dir"), DbChainDir)
+	opts := badger.DefaultOptions(dbPath)
+	if cacheSize := cfg.GetInt64("cache.size"); cacheSize > 0 {
+		opts = opts.WithBlockCacheSize(cacheSize)
+	}
+
+	db, err := OpenDB(dbPath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDriver(db, logger), nil
+}
+
+// NewDriver wraps already opened badger database
+func NewDriver(db *badger.DB, logger *zap.SugaredLogger) *Driver {
+	return &Driver{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (d *Driver) SaveTx(ctx context.Context, tx *types.Transaction) error {
+	data, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(txKey(tx.Hash()), data)
+	})
+}
+
+func (d *Driver) FindTx(ctx context.Context, hash common.Hash) (*types.Transaction, error) {
+	data, err := d.get(txKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func (d *Driver) SearchTxs(ctx context.Context, filter storage.TxFilter) ([]*types.Transaction, error) {
+	var result []*types.Transaction
+	err := d.iterate(txPrefix, nil, func(key, val []byte) (bool, error) {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(val); err != nil {
+			return false, err
+		}
+		if filter.Match(tx) {
+			result = append(result, tx)
+		}
+		return filter.Limit == 0 || len(result) < filter.Limit, nil
+	})
+
+	return result, err
+}
+
+func (d *Driver) SaveBlock(ctx context.Context, block *types.Block) error {
+	data, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(blockKey(block.Hash()), data); err != nil {
+			return err
+		}
+		return txn.Set(blockNumberKey(block.NumberU64()), block.Hash().Bytes())
+	})
+}
+
+func (d *Driver) FindBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	data, err := d.get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(data, block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+func (d *Driver) SearchBlocks(ctx context.Context, filter storage.BlockFilter) ([]*types.Block, error) {
+	var hashes []common.Hash
+	err := d.iterate(blockNumberPrefix, blockNumberKey(filter.FromNumber), func(key, val []byte) (bool, error) {
+		number := binary.BigEndian.Uint64(key[len(blockNumberPrefix):])
+		if !filter.Match(number) {
+			return false, nil
+		}
+		hashes = append(hashes, common.BytesToHash(val))
+		return filter.Limit == 0 || len(hashes) < filter.Limit, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*types.Block, 0, len(hashes))
+	for _, hash := range hashes {
+		block, err := d.FindBlock(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, block)
+	}
+
+	return result, nil
+}
+
+func (d *Driver) SaveEvent(ctx context.Context, event *types.Log) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(eventKey(event.TxHash, event.Index), data)
+	})
+}
+
+func (d *Driver) FindEvent(ctx context.Context, txHash common.Hash, index uint) (*types.Log, error) {
+	data, err := d.get(eventKey(txHash, index))
+	if err != nil {
+		return nil, err
+	}
+
+	event := new(types.Log)
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func (d *Driver) SearchEvents(ctx context.Context, filter storage.EventFilter) ([]*types.Log, error) {
+	var result []*types.Log
+	err := d.iterate(eventPrefix, nil, func(key, val []byte) (bool, error) {
+		event := new(types.Log)
+		if err := json.Unmarshal(val, event); err != nil {
+			return false, err
+		}
+		if filter.Match(event) {
+			result = append(result, event)
+		}
+		return filter.Limit == 0 || len(result) < filter.Limit, nil
+	})
+
+	return result, err
+}
+
+func (d *Driver) DeleteBlock(ctx context.Context, hash common.Hash) error {
+	block, err := d.FindBlock(ctx, hash)
+	if err == storage.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return d.db.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(blockKey(hash)); err != nil {
+			return err
+		}
+		// number index may already point to the block replacing this one
+		item, err := txn.Get(blockNumberKey(block.NumberU64()))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		indexed, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if common.BytesToHash(indexed) != hash {
+			return nil
+		}
+		return txn.Delete(blockNumberKey(block.NumberU64()))
+	})
+}
+
+func (d *Driver) DeleteTx(ctx context.Context, hash common.Hash) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(txKey(hash))
+	})
+}
+
+func (d *Driver) DeleteEvent(ctx context.Context, txHash common.Hash, index uint) error {
+	return d.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(eventKey(txHash, index))
+	})
+}
+
+func (d *Driver) Close() error {
+	return d.db.Close()
+}
+
+func (d *Driver) get(key []byte) ([]byte, error) {
+	var data []byte
+	if err := d.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			if err == badger.ErrKeyNotFound {
+				return storage.ErrNotFound
+			}
+			return err
+		}
+
+		data, err = item.ValueCopy(nil)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// iterate calls fn for every key with specified prefix starting from seek key, until fn returns false
+func (d *Driver) iterate(prefix, seek []byte, fn func(key, val []byte) (bool, error)) error {
+	if seek == nil {
+		seek = prefix
+	}
+
+	return d.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			next, err := fn(item.KeyCopy(nil), val)
+			if err != nil {
+				return err
+			}
+			if !next {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func blockKey(hash common.Hash) []byte {
+	return append(append([]byte{}, blockPrefix...), hash.Bytes()...)
+}
+
+func blockNumberKey(number uint64) []byte {
+	key := make([]byte, len(blockNumberPrefix)+8)
+	copy(key, blockNumberPrefix)
+	binary.BigEndian.PutUint64(key[len(blockNumberPrefix):], number)
+	return key
+}
+
+func txKey(hash common.Hash) []byte {
+	return append(append([]byte{}, txPrefix...), hash.Bytes()...)
+}
+
+func eventKey(txHash common.Hash, index uint) []byte {
+	key := make([]byte, len(eventPrefix)+common.HashLength+4)
+	copy(key, eventPrefix)
+	copy(key[len(eventPrefix):], txHash.Bytes())
+	binary.BigEndian.PutUint32(key[len(eventPrefix)+common.HashLength:], uint32(index))
+	return key
+}
@@ -0,0 +1,131 @@
+package badgerdb
+
+import (
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/rovergulf/chain/storage"
+	"github.com/rovergulf/chain/tests"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func newTestDriver(t *testing.T) *Driver {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDriver(db, zap.NewNop().Sugar())
+	t.Cleanup(func() {
+		d.Close()
+	})
+	return d
+}
+
+func TestDriverBlocksAndTxs(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDriver(t)
+
+	to := tests.Account1
+	tx := types.NewTransaction(0, to, big.NewInt(1), 21000, big.NewInt(1), nil)
+	for i := uint64(0); i < 3; i++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(i), Difficulty: common.Big1}
+		block := types.NewBlockWithHeader(header).WithBody([]*types.Transaction{tx}, nil)
+		if err := d.SaveBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SaveTx(ctx, tx); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := d.FindTx(ctx, tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Hash() != tx.Hash() {
+		t.Fatalf("tx hash mismatch: have %s, want %s", found.Hash(), tx.Hash())
+	}
+
+	txs, err := d.SearchTxs(ctx, storage.TxFilter{To: &tests.Account2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 0 {
+		t.Fatalf("unexpected txs found: %d", len(txs))
+	}
+
+	blocks, err := d.SearchBlocks(ctx, storage.BlockFilter{FromNumber: 1, ToNumber: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].NumberU64() != 1 || blocks[1].NumberU64() != 2 {
+		t.Fatalf("unexpected blocks range: %d", len(blocks))
+	}
+	if len(blocks[0].Transactions()) != 1 {
+		t.Fatalf("block body is not stored")
+	}
+
+	if _, err := d.FindBlock(ctx, common.Hash{}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+}
+
+func TestDriverEvents(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDriver(t)
+
+	topic := common.HexToHash("0x01")
+	for i := uint(0); i < 4; i++ {
+		event := &types.Log{
+			Address:     tests.Account0,
+			Topics:      []common.Hash{topic},
+			BlockNumber: uint64(i),
+			TxHash:      common.HexToHash("0xff"),
+			Index:       i,
+		}
+		if err := d.SaveEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	event, err := d.FindEvent(ctx, common.HexToHash("0xff"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.BlockNumber != 2 {
+		t.Fatalf("unexpected event block number: %d", event.BlockNumber)
+	}
+
+	events, err := d.SearchEvents(ctx, storage.EventFilter{
+		FromBlock: 1,
+		Addresses: []common.Address{tests.Account0},
+		Topics:    [][]common.Hash{{topic}},
+		Limit:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected events count: %d", len(events))
+	}
+}
+
+func TestOpenUnknownDriver(t *testing.T) {
+	cfg := viper.New()
+	cfg.Set("db", "leveldb")
+
+	_, err := storage.Open(context.Background(), cfg)
+	if !errors.Is(err, storage.ErrUnknownDriver) {
+		t.Fatalf("expected unknown driver error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), storage.BadgerDriver.String()) {
+		t.Fatalf("error does not list registered drivers: %s", err)
+	}
+}
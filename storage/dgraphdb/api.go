@@ -0,0 +1,322 @@
+package dgraphdb
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Dgraph gRPC API, subset of messages from the 'api' protobuf package used by the client.
+// Messages are encoded by hand as only a few fields of each are used
+const (
+	dgraphService       = "api.Dgraph"
+	loginMethod         = "/api.Dgraph/Login"
+	queryMethod         = "/api.Dgraph/Query"
+	alterMethod         = "/api.Dgraph/Alter"
+	accessTokenMetadata = "accessjwt"
+)
+
+var errInvalidMessage = errors.New("invalid message")
+
+type message interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+// codec encodes API messages for the gRPC transport
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported type %T", errInvalidMessage, v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("%w: unsupported type %T", errInvalidMessage, v)
+	}
+	return m.unmarshal(data)
+}
+
+// Name is the content subtype Dgraph Alpha expects
+func (codec) Name() string {
+	return "proto"
+}
+
+// request is api.Request
+type request struct {
+	Query     string            // 4
+	Vars      map[string]string // 5
+	ReadOnly  bool              // 6
+	Mutations []*mutation       // 12
+	CommitNow bool              // 13
+}
+
+func (r *request) marshal() []byte {
+	var b []byte
+	b = appendString(b, 4, r.Query)
+	for k, v := range r.Vars {
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		b = appendMessage(b, 5, entry)
+	}
+	b = appendBool(b, 6, r.ReadOnly)
+	for _, mu := range r.Mutations {
+		b = appendMessage(b, 12, mu.marshal())
+	}
+	return appendBool(b, 13, r.CommitNow)
+}
+
+func (r *request) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		switch {
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			r.Query = string(v)
+			return n, true
+		case num == 5 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, true
+			}
+			var key, value string
+			err := walkFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+				if typ != protowire.BytesType || (num != 1 && num != 2) {
+					return 0, false
+				}
+				s, n := protowire.ConsumeBytes(b)
+				if num == 1 {
+					key = string(s)
+				} else {
+					value = string(s)
+				}
+				return n, true
+			})
+			if err != nil {
+				return -1, true
+			}
+			if r.Vars == nil {
+				r.Vars = make(map[string]string)
+			}
+			r.Vars[key] = value
+			return n, true
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			r.ReadOnly = protowire.DecodeBool(v)
+			return n, true
+		case num == 12 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, true
+			}
+			mu := new(mutation)
+			if err := mu.unmarshal(v); err != nil {
+				return -1, true
+			}
+			r.Mutations = append(r.Mutations, mu)
+			return n, true
+		case num == 13 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			r.CommitNow = protowire.DecodeBool(v)
+			return n, true
+		}
+		return 0, false
+	})
+}
+
+// mutation is api.Mutation
+type mutation struct {
+	SetJson    []byte // 1
+	DeleteJson []byte // 2
+	Cond       string // 9
+}
+
+func (m *mutation) marshal() []byte {
+	b := appendBytes(nil, 1, m.SetJson)
+	b = appendBytes(b, 2, m.DeleteJson)
+	return appendString(b, 9, m.Cond)
+}
+
+func (m *mutation) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		if typ != protowire.BytesType || (num != 1 && num != 2 && num != 9) {
+			return 0, false
+		}
+		v, n := protowire.ConsumeBytes(b)
+		switch num {
+		case 1:
+			m.SetJson = append([]byte{}, v...)
+		case 2:
+			m.DeleteJson = append([]byte{}, v...)
+		default:
+			m.Cond = string(v)
+		}
+		return n, true
+	})
+}
+
+// response is api.Response
+type response struct {
+	Json []byte // 1
+}
+
+func (r *response) marshal() []byte {
+	return appendBytes(nil, 1, r.Json)
+}
+
+func (r *response) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		if num != 1 || typ != protowire.BytesType {
+			return 0, false
+		}
+		v, n := protowire.ConsumeBytes(b)
+		r.Json = append([]byte{}, v...)
+		return n, true
+	})
+}
+
+// operation is api.Operation
+type operation struct {
+	Schema string // 1
+}
+
+func (o *operation) marshal() []byte {
+	return appendString(nil, 1, o.Schema)
+}
+
+func (o *operation) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		if num != 1 || typ != protowire.BytesType {
+			return 0, false
+		}
+		v, n := protowire.ConsumeBytes(b)
+		o.Schema = string(v)
+		return n, true
+	})
+}
+
+// payload is api.Payload returned by Alter, its content is not used
+type payload struct{}
+
+func (p *payload) marshal() []byte {
+	return nil
+}
+
+func (p *payload) unmarshal(b []byte) error {
+	return walkFields(b, func(protowire.Number, protowire.Type, []byte) (int, bool) {
+		return 0, false
+	})
+}
+
+// loginRequest is api.LoginRequest, either credentials or refresh token are set
+type loginRequest struct {
+	UserId       string // 1
+	Password     string // 2
+	RefreshToken string // 3
+}
+
+func (r *loginRequest) marshal() []byte {
+	b := appendString(nil, 1, r.UserId)
+	b = appendString(b, 2, r.Password)
+	return appendString(b, 3, r.RefreshToken)
+}
+
+func (r *loginRequest) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		if typ != protowire.BytesType || num < 1 || num > 3 {
+			return 0, false
+		}
+		v, n := protowire.ConsumeBytes(b)
+		switch num {
+		case 1:
+			r.UserId = string(v)
+		case 2:
+			r.Password = string(v)
+		default:
+			r.RefreshToken = string(v)
+		}
+		return n, true
+	})
+}
+
+// jwt is api.Jwt, encoded into the login response 'json' field
+type jwt struct {
+	AccessJwt  string // 1
+	RefreshJwt string // 2
+}
+
+func (j *jwt) marshal() []byte {
+	b := appendString(nil, 1, j.AccessJwt)
+	return appendString(b, 2, j.RefreshJwt)
+}
+
+func (j *jwt) unmarshal(b []byte) error {
+	return walkFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, bool) {
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			return 0, false
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if num == 1 {
+			j.AccessJwt = string(v)
+		} else {
+			j.RefreshJwt = string(v)
+		}
+		return n, true
+	})
+}
+
+// walkFields calls fn for every field of the encoded message. fn returns the length of the consumed
+// field value, negative on error, or false if the field is unknown and has to be skipped
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, bool)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", errInvalidMessage, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		n, ok := fn(num, typ, b)
+		if !ok {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: field %d", errInvalidMessage, num)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
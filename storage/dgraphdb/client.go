@@ -0,0 +1,245 @@
+package dgraphdb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"os"
+	"sync"
+)
+
+var ErrDgraphResponse = errors.New("dgraph error response")
+
+// Client is the subset of Dgraph API used by Driver
+type Client interface {
+	// Alter applies schema to the database
+	Alter(ctx context.Context, schema string) error
+	// Mutate runs upsert block consisting of optional query and set objects, committing it immediately
+	Mutate(ctx context.Context, query string, set []map[string]interface{}) error
+	// Delete runs upsert block consisting of optional query and objects to delete, committing it immediately.
+	// Objects are deleted only if the optional '@if' condition holds
+	Delete(ctx context.Context, query, cond string, del []map[string]interface{}) error
+	// Query runs DQL query with variables and returns raw 'data' field of response
+	Query(ctx context.Context, query string, vars map[string]string) (json.RawMessage, error)
+	// Close releases the connection
+	Close() error
+}
+
+// ClientConfig describes Dgraph Alpha gRPC API endpoint
+type ClientConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+
+	TLSEnabled bool
+	TLSCert    string // client certificate path
+	TLSKey     string // client certificate key path
+	TLSVerify  bool   // verify server certificate
+	TLSAuth    string // CA certificate path used to verify server
+}
+
+// grpcClient talks to Dgraph Alpha using its gRPC API
+type grpcClient struct {
+	cfg  ClientConfig
+	conn *grpc.ClientConn
+
+	lock         sync.Mutex
+	token        string // access token attached to calls
+	refreshToken string // token renewing the expired access one
+}
+
+// NewClient returns Client working over Dgraph Alpha gRPC API, options are appended to the dial ones
+func NewClient(cfg ClientConfig, opts ...grpc.DialOption) (Client, error) {
+	creds := insecure.NewCredentials()
+	if cfg.TLSEnabled {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	dialOpts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(codec{})),
+	}, opts...)
+	conn, err := grpc.Dial(net.JoinHostPort(cfg.Host, cfg.Port), dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcClient{
+		cfg:  cfg,
+		conn: conn,
+	}, nil
+}
+
+func newTLSConfig(cfg ClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: !cfg.TLSVerify,
+	}
+
+	if len(cfg.TLSAuth) > 0 {
+		caCert, err := os.ReadFile(cfg.TLSAuth)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("unable to parse CA certificate: %s", cfg.TLSAuth)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(cfg.TLSCert) > 0 && len(cfg.TLSKey) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (c *grpcClient) Alter(ctx context.Context, schema string) error {
+	return c.invoke(ctx, alterMethod, &operation{Schema: schema}, new(payload))
+}
+
+func (c *grpcClient) Mutate(ctx context.Context, query string, set []map[string]interface{}) error {
+	setJson, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	req := &request{
+		Query:     query,
+		Mutations: []*mutation{{SetJson: setJson}},
+		CommitNow: true,
+	}
+	return c.invoke(ctx, queryMethod, req, new(response))
+}
+
+func (c *grpcClient) Delete(ctx context.Context, query, cond string, del []map[string]interface{}) error {
+	deleteJson, err := json.Marshal(del)
+	if err != nil {
+		return err
+	}
+
+	req := &request{
+		Query:     query,
+		Mutations: []*mutation{{DeleteJson: deleteJson, Cond: cond}},
+		CommitNow: true,
+	}
+	return c.invoke(ctx, queryMethod, req, new(response))
+}
+
+func (c *grpcClient) Query(ctx context.Context, query string, vars map[string]string) (json.RawMessage, error) {
+	req := &request{
+		Query:    query,
+		Vars:     vars,
+		ReadOnly: true,
+	}
+	res := new(response)
+	if err := c.invoke(ctx, queryMethod, req, res); err != nil {
+		return nil, err
+	}
+
+	return res.Json, nil
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// login returns the access token, logging in with configured credentials unless it is issued already
+func (c *grpcClient) login(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if len(c.token) > 0 || len(c.cfg.User) == 0 {
+		return c.token, nil
+	}
+
+	return c.authenticate(ctx, &loginRequest{UserId: c.cfg.User, Password: c.cfg.Password})
+}
+
+// relogin replaces the expired access token using the refresh token, or credentials if the refresh fails
+func (c *grpcClient) relogin(ctx context.Context, expired string) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// token could be renewed by a concurrent call already
+	if c.token != expired {
+		return c.token, nil
+	}
+
+	if len(c.refreshToken) > 0 {
+		if token, err := c.authenticate(ctx, &loginRequest{RefreshToken: c.refreshToken}); err == nil {
+			return token, nil
+		}
+	}
+	return c.authenticate(ctx, &loginRequest{UserId: c.cfg.User, Password: c.cfg.Password})
+}
+
+// authenticate calls login method and keeps issued tokens, lock must be held
+func (c *grpcClient) authenticate(ctx context.Context, req *loginRequest) (string, error) {
+	res := new(response)
+	if err := c.conn.Invoke(ctx, loginMethod, req, res); err != nil {
+		return "", responseError(err)
+	}
+
+	var token jwt
+	if err := token.unmarshal(res.Json); err != nil {
+		return "", err
+	}
+
+	c.token, c.refreshToken = token.AccessJwt, token.RefreshJwt
+	return c.token, nil
+}
+
+func (c *grpcClient) invoke(ctx context.Context, method string, req, res message) error {
+	token, err := c.login(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.call(ctx, method, token, req, res)
+	// access token expires, so it is renewed and the rejected call is retried once
+	if status.Code(err) == codes.Unauthenticated && len(c.cfg.User) > 0 {
+		if token, err = c.relogin(ctx, token); err != nil {
+			return err
+		}
+		err = c.call(ctx, method, token, req, res)
+	}
+	if err != nil {
+		return responseError(err)
+	}
+	return nil
+}
+
+func (c *grpcClient) call(ctx context.Context, method, token string, req, res message) error {
+	if len(token) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, accessTokenMetadata, token)
+	}
+	return c.conn.Invoke(ctx, method, req, res)
+}
+
+// responseError wraps errors returned by Dgraph, transport ones are returned as is
+func responseError(err error) error {
+	if s, ok := status.FromError(err); ok {
+		return fmt.Errorf("%w: %s: %s", ErrDgraphResponse, s.Code(), s.Message())
+	}
+	return err
+}
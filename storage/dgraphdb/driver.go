@@ -1 +1,438 @@
 package dgraphdb
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/storage"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// Schema describes the graph of accounts connected by transfers.
+// Account nodes have direct 'sent_to' edges to simplify path queries,
+// transfer nodes keep the value and time of every transaction
+const Schema = `
+address: string @index(exact) @upsert .
+sent_to: [uid] @reverse .
+
+tx_hash: string @index(exact) @upsert .
+from: uid @reverse .
+to: uid @reverse .
+value: string .
+nonce: int .
+block: uid @reverse .
+
+block_hash: string @index(exact) @upsert .
+block_number: int @index(int) .
+timestamp: int @index(int) .
+coinbase: uid @reverse .
+
+event_id: string @index(exact) @upsert .
+emitter: uid @reverse .
+tx: uid @reverse .
+topics: [string] @index(exact) .
+log_index: int .
+
+raw: string .
+
+type Account {
+	address
+	sent_to
+}
+
+type Transfer {
+	tx_hash
+	from
+	to
+	value
+	nonce
+	block
+	block_number
+	timestamp
+	raw
+}
+
+type Block {
+	block_hash
+	block_number
+	timestamp
+	coinbase
+	raw
+}
+
+type Event {
+	event_id
+	emitter
+	tx
+	topics
+	log_index
+	block_number
+	raw
+}
+`
+
+func init() {
+	storage.Register(storage.DgraphDriver, Open)
+}
+
+// Driver mirrors blocks, transactions and events to Dgraph
+// and provides graph queries over accounts and transfers
+type Driver struct {
+	client Client
+	logger *zap.SugaredLogger
+}
+
+// Open connects to Dgraph using 'dgraphdb.*' config values and applies Schema
+func Open(ctx context.Context, cfg *viper.Viper) (storage.Storage, error) {
+	logger, err := logutils.NewLogger()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(ClientConfig{
+		Host:       cfg.GetString("dgraphdb.host"),
+		Port:       cfg.GetString("dgraphdb.port"),
+		User:       cfg.GetString("dgraphdb.user"),
+		Password:   cfg.GetString("dgraphdb.password"),
+		TLSEnabled: cfg.GetBool("dgraphdb.tls.enabled"),
+		TLSCert:    cfg.GetString("dgraphdb.tls.cert"),
+		TLSKey:     cfg.GetString("dgraphdb.tls.key"),
+		TLSVerify:  cfg.GetBool("dgraphdb.tls.verify"),
+		TLSAuth:    cfg.GetString("dgraphdb.tls.auth"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := NewDriver(ctx, client, logger)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDriver applies Schema using provided client
+func NewDriver(ctx context.Context, client Client, logger *zap.SugaredLogger) (*Driver, error) {
+	if err := client.Alter(ctx, Schema); err != nil {
+		logger.Errorw("Unable to apply dgraph schema", "err", err)
+		return nil, err
+	}
+
+	return &Driver{
+		client: client,
+		logger: logger,
+	}, nil
+}
+
+func (d *Driver) SaveTx(ctx context.Context, tx *types.Transaction) error {
+	u := newUpsert()
+	if err := u.addTx(tx, nil); err != nil {
+		return err
+	}
+
+	return d.client.Mutate(ctx, u.query(), u.set)
+}
+
+func (d *Driver) FindTx(ctx context.Context, hash common.Hash) (*types.Transaction, error) {
+	raw, err := d.findRaw(ctx, "tx_hash", hash.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// SearchTxs is not supported, use graph queries instead
+func (d *Driver) SearchTxs(ctx context.Context, filter storage.TxFilter) ([]*types.Transaction, error) {
+	return nil, storage.ErrNotSupported
+}
+
+// SaveBlock stores block with all its transactions as transfers between accounts
+func (d *Driver) SaveBlock(ctx context.Context, block *types.Block) error {
+	u := newUpsert()
+	if err := u.addBlock(block); err != nil {
+		return err
+	}
+
+	return d.client.Mutate(ctx, u.query(), u.set)
+}
+
+func (d *Driver) FindBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	raw, err := d.findRaw(ctx, "block_hash", hash.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(data, block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// SearchBlocks is not supported, use graph queries instead
+func (d *Driver) SearchBlocks(ctx context.Context, filter storage.BlockFilter) ([]*types.Block, error) {
+	return nil, storage.ErrNotSupported
+}
+
+func (d *Driver) SaveEvent(ctx context.Context, event *types.Log) error {
+	u := newUpsert()
+	if err := u.addEvent(event); err != nil {
+		return err
+	}
+
+	return d.client.Mutate(ctx, u.query(), u.set)
+}
+
+func (d *Driver) FindEvent(ctx context.Context, txHash common.Hash, index uint) (*types.Log, error) {
+	raw, err := d.findRaw(ctx, "event_id", eventId(txHash, index))
+	if err != nil {
+		return nil, err
+	}
+
+	event := new(types.Log)
+	if err := json.Unmarshal([]byte(raw), event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// SearchEvents is not supported, use graph queries instead
+func (d *Driver) SearchEvents(ctx context.Context, filter storage.EventFilter) ([]*types.Log, error) {
+	return nil, storage.ErrNotSupported
+}
+
+// DeleteBlock removes the block node, transfers of its transactions are removed by DeleteTx
+func (d *Driver) DeleteBlock(ctx context.Context, hash common.Hash) error {
+	return d.deleteNode(ctx, "block_hash", hash.Hex())
+}
+
+// deleteSentToQuery finds accounts of the transfer and other transfers between them
+const deleteSentToQuery = `{
+	tx as var(func: eq(tx_hash, "%s")) {
+		sender as from
+		recipient as to
+	}
+	var(func: uid(sender)) {
+		others as ~from @filter(NOT uid(tx) AND uid_in(to, uid(recipient)))
+	}
+}`
+
+// DeleteTx removes the transfer node and the 'sent_to' edge of its accounts,
+// unless other transfers between the same accounts are left
+func (d *Driver) DeleteTx(ctx context.Context, hash common.Hash) error {
+	edge := []map[string]interface{}{{
+		"uid":     "uid(sender)",
+		"sent_to": map[string]interface{}{"uid": "uid(recipient)"},
+	}}
+	if err := d.client.Delete(ctx, fmt.Sprintf(deleteSentToQuery, hash.Hex()), "@if(eq(len(others), 0))", edge); err != nil {
+		return err
+	}
+
+	return d.deleteNode(ctx, "tx_hash", hash.Hex())
+}
+
+func (d *Driver) DeleteEvent(ctx context.Context, txHash common.Hash, index uint) error {
+	return d.deleteNode(ctx, "event_id", eventId(txHash, index))
+}
+
+// Close closes the Dgraph connection
+func (d *Driver) Close() error {
+	return d.client.Close()
+}
+
+func (d *Driver) findRaw(ctx context.Context, predicate, value string) (string, error) {
+	query := fmt.Sprintf(`query find($value: string) {
+	find(func: eq(%s, $value)) {
+		raw
+	}
+}`, predicate)
+
+	data, err := d.client.Query(ctx, query, map[string]string{"$value": value})
+	if err != nil {
+		return "", err
+	}
+
+	var res struct {
+		Find []struct {
+			Raw string `json:"raw"`
+		} `json:"find"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", err
+	}
+	if len(res.Find) == 0 || len(res.Find[0].Raw) == 0 {
+		return "", storage.ErrNotFound
+	}
+
+	return res.Find[0].Raw, nil
+}
+
+func (d *Driver) deleteNode(ctx context.Context, predicate, value string) error {
+	query := fmt.Sprintf(`{
+	n as var(func: eq(%s, "%s"))
+}`, predicate, value)
+
+	return d.client.Delete(ctx, query, "", []map[string]interface{}{{"uid": "uid(n)"}})
+}
+
+// upsert collects variables of unique nodes and objects to set into single upsert block
+type upsert struct {
+	vars map[string]string // unique key -> variable name
+	defs []string
+	set  []map[string]interface{}
+}
+
+func newUpsert() *upsert {
+	return &upsert{
+		vars: make(map[string]string),
+	}
+}
+
+func (u *upsert) query() string {
+	if len(u.defs) == 0 {
+		return ""
+	}
+
+	return "{\n\t" + strings.Join(u.defs, "\n\t") + "\n}"
+}
+
+// ref returns reference to the node with predicate value, creating it if it does not exist
+func (u *upsert) ref(predicate, value, dgraphType string) map[string]interface{} {
+	key := predicate + ":" + value
+	name, ok := u.vars[key]
+	if !ok {
+		name = fmt.Sprintf("v%d", len(u.vars))
+		u.vars[key] = name
+		u.defs = append(u.defs, fmt.Sprintf(`%s as var(func: eq(%s, "%s"))`, name, predicate, value))
+		u.set = append(u.set, map[string]interface{}{
+			"uid":         "uid(" + name + ")",
+			predicate:     value,
+			"dgraph.type": dgraphType,
+		})
+	}
+
+	return map[string]interface{}{"uid": "uid(" + name + ")"}
+}
+
+func (u *upsert) account(addr common.Address) map[string]interface{} {
+	return u.ref("address", strings.ToLower(addr.Hex()), "Account")
+}
+
+func (u *upsert) addBlock(block *types.Block) error {
+	data, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+
+	ref := u.ref("block_hash", block.Hash().Hex(), "Block")
+	u.set = append(u.set, map[string]interface{}{
+		"uid":          ref["uid"],
+		"block_number": block.NumberU64(),
+		"timestamp":    block.Time(),
+		"coinbase":     u.account(block.Coinbase()),
+		"raw":          hex.EncodeToString(data),
+	})
+
+	for _, tx := range block.Transactions() {
+		if err := u.addTx(tx, block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *upsert) addTx(tx *types.Transaction, block *types.Block) error {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return err
+	}
+
+	to := crypto.CreateAddress(from, tx.Nonce())
+	if tx.To() != nil {
+		to = *tx.To()
+	}
+
+	data, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	sender, recipient := u.account(from), u.account(to)
+	u.set = append(u.set, map[string]interface{}{
+		"uid":     sender["uid"],
+		"sent_to": []interface{}{recipient},
+	})
+
+	transfer := map[string]interface{}{
+		"uid":   u.ref("tx_hash", tx.Hash().Hex(), "Transfer")["uid"],
+		"from":  sender,
+		"to":    recipient,
+		"value": tx.Value().String(),
+		"nonce": tx.Nonce(),
+		"raw":   hex.EncodeToString(data),
+	}
+	if block != nil {
+		transfer["block"] = u.ref("block_hash", block.Hash().Hex(), "Block")
+		transfer["block_number"] = block.NumberU64()
+		transfer["timestamp"] = block.Time()
+	}
+	u.set = append(u.set, transfer)
+
+	return nil
+}
+
+func (u *upsert) addEvent(event *types.Log) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	topics := make([]string, len(event.Topics))
+	for i := range event.Topics {
+		topics[i] = event.Topics[i].Hex()
+	}
+
+	u.set = append(u.set, map[string]interface{}{
+		"uid":          u.ref("event_id", eventId(event.TxHash, event.Index), "Event")["uid"],
+		"emitter":      u.account(event.Address),
+		"tx":           u.ref("tx_hash", event.TxHash.Hex(), "Transfer"),
+		"topics":       topics,
+		"log_index":    event.Index,
+		"block_number": event.BlockNumber,
+		"raw":          string(data),
+	})
+
+	return nil
+}
+
+func eventId(txHash common.Hash, index uint) string {
+	return fmt.Sprintf("%s:%d", txHash.Hex(), index)
+}
@@ -0,0 +1,278 @@
+package dgraphdb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/rovergulf/chain/storage"
+	"github.com/rovergulf/chain/tests"
+	"go.uber.org/zap"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var testChainId = big.NewInt(1337)
+
+func newTestDriver(t *testing.T) (*fakeDgraph, *Driver) {
+	fake, client := newFakeDgraph(t)
+	d, err := NewDriver(context.Background(), client, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.schema != Schema {
+		t.Fatal("schema is not applied")
+	}
+	return fake, d
+}
+
+func signTestTx(t *testing.T, hexKey string, nonce uint64, to common.Address, value int64) *types.Transaction {
+	key, err := crypto.HexToECDSA(hexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signTx(t, key, nonce, to, value)
+}
+
+func signTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, to common.Address, value int64) *types.Transaction {
+	tx := types.NewTransaction(nonce, to, big.NewInt(value), 21000, big.NewInt(1), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(testChainId), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestBlock(number, timestamp uint64, txs ...*types.Transaction) *types.Block {
+	header := &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		Time:       timestamp,
+		Difficulty: common.Big1,
+		Coinbase:   tests.Account10,
+	}
+	return types.NewBlockWithHeader(header).WithBody(txs, nil)
+}
+
+// saveTestGraph stores transfers 0 -> 1 -> 2 -> 3, 0 -> 2 and 1 -> 0 in three blocks an hour apart
+func saveTestGraph(t *testing.T, d *Driver, start time.Time) []*types.Block {
+	ctx := context.Background()
+	ts := uint64(start.Unix())
+
+	blocks := []*types.Block{
+		newTestBlock(1, ts,
+			signTestTx(t, tests.PrivateKey0, 0, tests.Account1, 100),
+			signTestTx(t, tests.PrivateKey0, 1, tests.Account2, 50),
+		),
+		newTestBlock(2, ts+3600,
+			signTestTx(t, tests.PrivateKey1, 0, tests.Account2, 70),
+			signTestTx(t, tests.PrivateKey1, 1, tests.Account0, 30),
+		),
+		newTestBlock(3, ts+7200,
+			signTestTx(t, tests.PrivateKey2, 0, tests.Account3, 10),
+		),
+	}
+
+	for _, block := range blocks {
+		if err := d.SaveBlock(ctx, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return blocks
+}
+
+func TestDriverMirror(t *testing.T) {
+	ctx := context.Background()
+	fake, d := newTestDriver(t)
+	blocks := saveTestGraph(t, d, time.Unix(1_600_000_000, 0))
+
+	// accounts 0-3 and coinbase must not be duplicated across blocks
+	if count := fake.countType("Account"); count != 5 {
+		t.Fatalf("unexpected accounts count: %d", count)
+	}
+	if count := fake.countType("Transfer"); count != 5 {
+		t.Fatalf("unexpected transfers count: %d", count)
+	}
+
+	block, err := d.FindBlock(ctx, blocks[1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash() != blocks[1].Hash() || len(block.Transactions()) != 2 {
+		t.Fatalf("unexpected block found: %s", block.Hash())
+	}
+
+	tx := blocks[2].Transactions()[0]
+	found, err := d.FindTx(ctx, tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Hash() != tx.Hash() {
+		t.Fatalf("unexpected tx found: %s", found.Hash())
+	}
+
+	event := &types.Log{
+		Address:     tests.Account3,
+		Topics:      []common.Hash{common.HexToHash("0x01")},
+		BlockNumber: 3,
+		TxHash:      tx.Hash(),
+		Index:       0,
+	}
+	if err := d.SaveEvent(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	foundEvent, err := d.FindEvent(ctx, tx.Hash(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foundEvent.Address != tests.Account3 {
+		t.Fatalf("unexpected event emitter: %s", foundEvent.Address)
+	}
+	// event must be linked to already stored transfer
+	if count := fake.countType("Transfer"); count != 5 {
+		t.Fatalf("event created duplicated transfer: %d", count)
+	}
+
+	if _, err := d.FindTx(ctx, common.Hash{}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+
+	// reorged block data is removed
+	if err := d.DeleteEvent(ctx, tx.Hash(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteTx(ctx, tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteBlock(ctx, blocks[2].Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.FindEvent(ctx, tx.Hash(), 0); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deleted event is found: %v", err)
+	}
+	if _, err := d.FindTx(ctx, tx.Hash()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deleted tx is found: %v", err)
+	}
+	if _, err := d.FindBlock(ctx, blocks[2].Hash()); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deleted block is found: %v", err)
+	}
+	if err := d.DeleteTx(ctx, tx.Hash()); err != nil {
+		t.Fatalf("deleting missing tx failed: %v", err)
+	}
+}
+
+func TestClientTokenExpiry(t *testing.T) {
+	ctx := context.Background()
+	fake, d := newTestDriver(t)
+	block := newTestBlock(1, 1_600_000_000, signTestTx(t, tests.PrivateKey0, 0, tests.Account1, 100))
+
+	// expired access token is renewed with the refresh one
+	fake.expire(false)
+	if err := d.SaveBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	// client logs in again once refresh token is expired too
+	fake.expire(true)
+	if _, err := d.FindBlock(ctx, block.Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.logins != 3 {
+		t.Fatalf("unexpected logins count: %d", fake.logins)
+	}
+}
+
+func TestDriverCounterparties(t *testing.T) {
+	_, d := newTestDriver(t)
+	saveTestGraph(t, d, time.Unix(1_600_000_000, 0))
+
+	parties, err := d.Counterparties(context.Background(), tests.Account1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parties) != 2 {
+		t.Fatalf("unexpected counterparties count: %d", len(parties))
+	}
+
+	// Account0 both sent and received value
+	first := parties[0]
+	if first.Address != tests.Account0 || first.Transfers != 2 {
+		t.Fatalf("unexpected top counterparty: %s with %d transfers", first.Address, first.Transfers)
+	}
+	if first.Sent.Int64() != 30 || first.Received.Int64() != 100 {
+		t.Fatalf("unexpected amounts: sent %s, received %s", first.Sent, first.Received)
+	}
+}
+
+func TestDriverPaths(t *testing.T) {
+	_, d := newTestDriver(t)
+	blocks := saveTestGraph(t, d, time.Unix(1_600_000_000, 0))
+
+	paths, err := d.Paths(context.Background(), tests.Account0, tests.Account3, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("unexpected paths count: %d", len(paths))
+	}
+
+	shortest := []common.Address{tests.Account0, tests.Account2, tests.Account3}
+	if len(paths[0]) != len(shortest) {
+		t.Fatalf("unexpected shortest path length: %d", len(paths[0]))
+	}
+	for i := range shortest {
+		if paths[0][i] != shortest[i] {
+			t.Fatalf("unexpected path hop %d: %s", i, paths[0][i])
+		}
+	}
+
+	none, err := d.Paths(context.Background(), tests.Account3, tests.Account0, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("unexpected reverse paths: %d", len(none))
+	}
+
+	// edge of the dropped transfer is removed, the one of the repeated transfer is kept
+	repeated := newTestBlock(4, 1_600_010_000, signTestTx(t, tests.PrivateKey2, 1, tests.Account3, 20))
+	if err := d.SaveBlock(context.Background(), repeated); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteTx(context.Background(), repeated.Transactions()[0].Hash()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteTx(context.Background(), blocks[0].Transactions()[1].Hash()); err != nil {
+		t.Fatal(err)
+	}
+	paths, err = d.Paths(context.Background(), tests.Account0, tests.Account3, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || len(paths[0]) != 4 {
+		t.Fatalf("dropped transfer is still in paths: %v", paths)
+	}
+}
+
+func TestDriverFlows(t *testing.T) {
+	_, d := newTestDriver(t)
+	start := time.Unix(1_600_000_000, 0)
+	saveTestGraph(t, d, start)
+
+	buckets, err := d.Flows(context.Background(), tests.Account2, start, start.Add(3*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 3 {
+		t.Fatalf("unexpected buckets count: %d", len(buckets))
+	}
+
+	expected := []struct{ in, out int64 }{{50, 0}, {70, 0}, {0, 10}}
+	for i, b := range buckets {
+		if b.Inflow.Int64() != expected[i].in || b.Outflow.Int64() != expected[i].out {
+			t.Fatalf("unexpected bucket %d flow: in %s, out %s", i, b.Inflow, b.Outflow)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package dgraphdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	fakeVarRe       = regexp.MustCompile(`(\w+) as var\(func: eq\((\w+), "([^"]*)"\)\)`)
+	fakeQueryNameRe = regexp.MustCompile(`query (\w+)\(`)
+	fakeFindRe      = regexp.MustCompile(`eq\((\w+), \$value\)`)
+	fakeShortestRe  = regexp.MustCompile(`numpaths: (\d+), depth: (\d+)`)
+	fakeTxEdgesRe   = regexp.MustCompile(`(\w+) as var\(func: eq\(tx_hash, "[^"]*"\)\) \{\s*(\w+) as from\s*(\w+) as to`)
+	fakeOthersRe    = regexp.MustCompile(`(\w+) as ~from @filter`)
+	fakeCondRe      = regexp.MustCompile(`eq\(len\((\w+)\), 0\)`)
+	fakeListPreds   = map[string]bool{"sent_to": true}
+)
+
+const (
+	fakeUser     = "groot"
+	fakePassword = "password"
+)
+
+// fakeDgraph is an in-process stand-in of Dgraph Alpha gRPC API served over bufconn.
+// It applies upsert mutations to in-memory graph and answers the query shapes used by Driver
+type fakeDgraph struct {
+	lock    sync.Mutex
+	next    int
+	schema  string
+	logins  int                               // issued tokens pairs
+	token   string                            // valid access token
+	refresh string                            // valid refresh token
+	values  map[string]map[string]interface{} // uid -> predicate -> scalar value
+	edges   map[string]map[string][]string    // uid -> predicate -> uids
+}
+
+func newFakeDgraph(t *testing.T) (*fakeDgraph, Client) {
+	fake := &fakeDgraph{
+		values: make(map[string]map[string]interface{}),
+		edges:  make(map[string]map[string][]string),
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(grpc.ForceServerCodec(codec{}))
+	srv.RegisterService(&fakeServiceDesc, fake)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	client, err := NewClient(ClientConfig{Host: "bufconn", Port: "9080", User: fakeUser, Password: fakePassword},
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return fake, client
+}
+
+var fakeServiceDesc = grpc.ServiceDesc{
+	ServiceName: dgraphService,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Login", Handler: fakeHandler(func() message { return new(loginRequest) }, (*fakeDgraph).login)},
+		{MethodName: "Query", Handler: fakeHandler(func() message { return new(request) }, (*fakeDgraph).request)},
+		{MethodName: "Alter", Handler: fakeHandler(func() message { return new(operation) }, (*fakeDgraph).alter)},
+	},
+}
+
+type fakeMethod func(f *fakeDgraph, req message) (message, error)
+
+// fakeHandler decodes the request created by newReq and passes it to fn, authenticating all but login calls
+func fakeHandler(newReq func() message, fn fakeMethod) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := newReq()
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+
+		f := srv.(*fakeDgraph)
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		if _, ok := in.(*loginRequest); !ok {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if tokens := md.Get(accessTokenMetadata); len(tokens) != 1 || len(f.token) == 0 || tokens[0] != f.token {
+				return nil, status.Error(codes.Unauthenticated, "Token is expired")
+			}
+		}
+
+		res, err := fn(f, in)
+		if err != nil {
+			return nil, status.Error(codes.Unknown, err.Error())
+		}
+		return res, nil
+	}
+}
+
+func (f *fakeDgraph) login(m message) (message, error) {
+	req := m.(*loginRequest)
+	if len(req.RefreshToken) > 0 {
+		if len(f.refresh) == 0 || req.RefreshToken != f.refresh {
+			return nil, fmt.Errorf("invalid refresh token")
+		}
+	} else if req.UserId != fakeUser || req.Password != fakePassword {
+		return nil, fmt.Errorf("invalid username or password")
+	}
+
+	f.logins++
+	f.token, f.refresh = fmt.Sprintf("fake-access-jwt-%d", f.logins), fmt.Sprintf("fake-refresh-jwt-%d", f.logins)
+	return &response{Json: (&jwt{AccessJwt: f.token, RefreshJwt: f.refresh}).marshal()}, nil
+}
+
+// expire invalidates the access token and optionally the refresh one
+func (f *fakeDgraph) expire(refresh bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.token = ""
+	if refresh {
+		f.refresh = ""
+	}
+}
+
+func (f *fakeDgraph) alter(m message) (message, error) {
+	f.schema = m.(*operation).Schema
+	return new(payload), nil
+}
+
+func (f *fakeDgraph) request(m message) (message, error) {
+	req := m.(*request)
+	var data interface{}
+	var err error
+	if len(req.Mutations) > 0 {
+		err = f.mutate(req)
+		data = map[string]interface{}{}
+	} else {
+		data, err = f.query(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &response{Json: res}, nil
+}
+
+func (f *fakeDgraph) newUid() string {
+	f.next++
+	uid := fmt.Sprintf("0x%x", f.next)
+	f.values[uid] = make(map[string]interface{})
+	f.edges[uid] = make(map[string][]string)
+	return uid
+}
+
+func (f *fakeDgraph) lookup(predicate, value string) string {
+	for uid, values := range f.values {
+		if values[predicate] == value {
+			return uid
+		}
+	}
+	return ""
+}
+
+func (f *fakeDgraph) mutate(req *request) error {
+	if !req.CommitNow {
+		return fmt.Errorf("mutation is not committed")
+	}
+
+	vars := make(map[string]string)
+	for _, m := range fakeVarRe.FindAllStringSubmatch(req.Query, -1) {
+		vars[m[1]] = f.lookup(m[2], m[3])
+	}
+	// accounts of the transfer and another transfer between them, variables hold a single uid at most
+	if m := fakeTxEdgesRe.FindStringSubmatch(req.Query); m != nil {
+		tx := vars[m[1]]
+		vars[m[2]], vars[m[3]] = f.edge(tx, "from"), f.edge(tx, "to")
+		if others := fakeOthersRe.FindStringSubmatch(req.Query); others != nil {
+			vars[others[1]] = ""
+			for _, uid := range f.reverse(vars[m[2]], "from") {
+				if uid != tx && f.edge(uid, "to") == vars[m[3]] {
+					vars[others[1]] = uid
+				}
+			}
+		}
+	}
+
+	for _, mu := range req.Mutations {
+		if !fakeHolds(mu.Cond, vars) {
+			continue
+		}
+		if len(mu.DeleteJson) > 0 {
+			var del []map[string]interface{}
+			if err := json.Unmarshal(mu.DeleteJson, &del); err != nil {
+				return err
+			}
+			for _, obj := range del {
+				f.remove(obj, vars)
+			}
+		}
+		if len(mu.SetJson) > 0 {
+			var set []map[string]interface{}
+			if err := json.Unmarshal(mu.SetJson, &set); err != nil {
+				return err
+			}
+			for _, obj := range set {
+				f.apply(obj, vars)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeDgraph) apply(obj map[string]interface{}, vars map[string]string) string {
+	ref, _ := obj["uid"].(string)
+
+	var uid string
+	if strings.HasPrefix(ref, "uid(") {
+		name := strings.TrimSuffix(strings.TrimPrefix(ref, "uid("), ")")
+		if len(vars[name]) == 0 {
+			vars[name] = f.newUid()
+		}
+		uid = vars[name]
+	} else {
+		uid = f.newUid()
+	}
+
+	for predicate, value := range obj {
+		if predicate == "uid" {
+			continue
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			f.link(uid, predicate, f.apply(v, vars))
+		case []interface{}:
+			if len(v) > 0 {
+				if _, ok := v[0].(map[string]interface{}); ok {
+					for _, child := range v {
+						f.link(uid, predicate, f.apply(child.(map[string]interface{}), vars))
+					}
+					continue
+				}
+			}
+			f.values[uid][predicate] = v
+		default:
+			f.values[uid][predicate] = v
+		}
+	}
+
+	return uid
+}
+
+// fakeHolds evaluates '@if' condition consisting of empty variables checks
+func fakeHolds(cond string, vars map[string]string) bool {
+	for _, m := range fakeCondRe.FindAllStringSubmatch(cond, -1) {
+		if len(vars[m[1]]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func fakeVar(ref interface{}, vars map[string]string) string {
+	name, _ := ref.(string)
+	return vars[strings.TrimSuffix(strings.TrimPrefix(name, "uid("), ")")]
+}
+
+// remove deletes listed edges of the node referenced by the variable,
+// or all its predicates if none are listed, like Dgraph does for typed nodes
+func (f *fakeDgraph) remove(obj map[string]interface{}, vars map[string]string) {
+	uid := fakeVar(obj["uid"], vars)
+	if len(uid) == 0 {
+		return
+	}
+	if len(obj) == 1 {
+		delete(f.values, uid)
+		delete(f.edges, uid)
+		return
+	}
+
+	for predicate, value := range obj {
+		child, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		target := fakeVar(child["uid"], vars)
+		edges := f.edges[uid][predicate][:0]
+		for _, to := range f.edges[uid][predicate] {
+			if to != target {
+				edges = append(edges, to)
+			}
+		}
+		f.edges[uid][predicate] = edges
+	}
+}
+
+func (f *fakeDgraph) link(uid, predicate, child string) {
+	if !fakeListPreds[predicate] {
+		f.edges[uid][predicate] = []string{child}
+		return
+	}
+	for _, existing := range f.edges[uid][predicate] {
+		if existing == child {
+			return
+		}
+	}
+	f.edges[uid][predicate] = append(f.edges[uid][predicate], child)
+}
+
+// reverse returns nodes having predicate edge pointing to uid
+func (f *fakeDgraph) reverse(uid, predicate string) []string {
+	var result []string
+	for from, edges := range f.edges {
+		for _, to := range edges[predicate] {
+			if to == uid {
+				result = append(result, from)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeDgraph) edge(uid, predicate string) string {
+	if list := f.edges[uid][predicate]; len(list) > 0 {
+		return list[0]
+	}
+	return ""
+}
+
+func (f *fakeDgraph) countType(dgraphType string) int {
+	var count int
+	for _, values := range f.values {
+		if values["dgraph.type"] == dgraphType {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeDgraph) query(req *request) (interface{}, error) {
+	name := fakeQueryNameRe.FindStringSubmatch(req.Query)
+	if name == nil {
+		return nil, fmt.Errorf("unsupported query: %s", req.Query)
+	}
+
+	switch name[1] {
+	case "find":
+		return f.find(req.Query, req.Vars)
+	case "counterparties":
+		return f.counterparties(req.Vars)
+	case "paths":
+		return f.paths(req.Query, req.Vars)
+	case "flows":
+		return f.flows(req.Vars)
+	default:
+		return nil, fmt.Errorf("unsupported query: %s", name[1])
+	}
+}
+
+func (f *fakeDgraph) find(query string, vars map[string]string) (interface{}, error) {
+	predicate := fakeFindRe.FindStringSubmatch(query)
+	if predicate == nil {
+		return nil, fmt.Errorf("unsupported find query: %s", query)
+	}
+
+	result := make([]map[string]interface{}, 0)
+	if uid := f.lookup(predicate[1], vars["$value"]); len(uid) > 0 {
+		result = append(result, map[string]interface{}{"raw": f.values[uid]["raw"]})
+	}
+
+	return map[string]interface{}{"find": result}, nil
+}
+
+func (f *fakeDgraph) transfer(uid string, other string) map[string]interface{} {
+	return map[string]interface{}{
+		"value":     f.values[uid]["value"],
+		"timestamp": f.values[uid]["timestamp"],
+		other:       map[string]interface{}{"address": f.values[f.edge(uid, other)]["address"]},
+	}
+}
+
+func (f *fakeDgraph) counterparties(vars map[string]string) (interface{}, error) {
+	result := make([]map[string]interface{}, 0)
+	if account := f.lookup("address", vars["$addr"]); len(account) > 0 {
+		var sent, received []map[string]interface{}
+		for _, uid := range f.reverse(account, "from") {
+			sent = append(sent, f.transfer(uid, "to"))
+		}
+		for _, uid := range f.reverse(account, "to") {
+			received = append(received, f.transfer(uid, "from"))
+		}
+		result = append(result, map[string]interface{}{"sent": sent, "received": received})
+	}
+
+	return map[string]interface{}{"counterparties": result}, nil
+}
+
+func (f *fakeDgraph) paths(query string, vars map[string]string) (interface{}, error) {
+	limits := fakeShortestRe.FindStringSubmatch(query)
+	if limits == nil {
+		return nil, fmt.Errorf("unsupported shortest query: %s", query)
+	}
+	numPaths, _ := strconv.Atoi(limits[1])
+	depth, _ := strconv.Atoi(limits[2])
+
+	src, dst := f.lookup("address", vars["$from"]), f.lookup("address", vars["$to"])
+
+	var found [][]string
+	var walk func(path []string)
+	walk = func(path []string) {
+		last := path[len(path)-1]
+		if last == dst {
+			found = append(found, append([]string{}, path...))
+			return
+		}
+		if len(path) > depth {
+			return
+		}
+	next:
+		for _, to := range f.edges[last]["sent_to"] {
+			for _, visited := range path {
+				if visited == to {
+					continue next
+				}
+			}
+			walk(append(path, to))
+		}
+	}
+	if len(src) > 0 && len(dst) > 0 {
+		walk([]string{src})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return len(found[i]) < len(found[j])
+	})
+	if len(found) > numPaths {
+		found = found[:numPaths]
+	}
+
+	paths := make([]map[string]interface{}, 0)
+	nodes := make([]map[string]interface{}, 0)
+	seen := make(map[string]bool)
+	for _, path := range found {
+		var node map[string]interface{}
+		for i := len(path) - 1; i >= 0; i-- {
+			n := map[string]interface{}{"uid": path[i]}
+			if node != nil {
+				n["sent_to"] = node
+			}
+			node = n
+			if !seen[path[i]] {
+				seen[path[i]] = true
+				nodes = append(nodes, map[string]interface{}{"uid": path[i], "address": f.values[path[i]]["address"]})
+			}
+		}
+		node["_weight_"] = len(path) - 1
+		paths = append(paths, node)
+	}
+
+	return map[string]interface{}{"_path_": paths, "path": nodes}, nil
+}
+
+func (f *fakeDgraph) flows(vars map[string]string) (interface{}, error) {
+	from, _ := strconv.ParseFloat(vars["$from"], 64)
+	to, _ := strconv.ParseFloat(vars["$to"], 64)
+
+	inRange := func(uid string) bool {
+		ts, ok := f.values[uid]["timestamp"].(float64)
+		return ok && ts >= from && ts < to
+	}
+
+	result := make([]map[string]interface{}, 0)
+	if account := f.lookup("address", vars["$addr"]); len(account) > 0 {
+		var out, in []map[string]interface{}
+		for _, uid := range f.reverse(account, "from") {
+			if inRange(uid) {
+				out = append(out, f.transfer(uid, "to"))
+			}
+		}
+		for _, uid := range f.reverse(account, "to") {
+			if inRange(uid) {
+				in = append(in, f.transfer(uid, "from"))
+			}
+		}
+		result = append(result, map[string]interface{}{"out": out, "in": in})
+	}
+
+	return map[string]interface{}{"flows": result}, nil
+}
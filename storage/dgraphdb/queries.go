@@ -0,0 +1,260 @@
+package dgraphdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Counterparty describes value exchanged between queried account and another one
+type Counterparty struct {
+	Address   common.Address `json:"address" yaml:"address"`
+	Sent      *big.Int       `json:"sent" yaml:"sent"`
+	Received  *big.Int       `json:"received" yaml:"received"`
+	Transfers int            `json:"transfers" yaml:"transfers"`
+}
+
+// FlowBucket describes value moved in and out of account during time interval
+type FlowBucket struct {
+	Start     time.Time `json:"start" yaml:"start"`
+	Inflow    *big.Int  `json:"inflow" yaml:"inflow"`
+	Outflow   *big.Int  `json:"outflow" yaml:"outflow"`
+	Transfers int       `json:"transfers" yaml:"transfers"`
+}
+
+type transferResult struct {
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"`
+	From      *struct {
+		Address string `json:"address"`
+	} `json:"from"`
+	To *struct {
+		Address string `json:"address"`
+	} `json:"to"`
+}
+
+func (t transferResult) amount() *big.Int {
+	value, ok := new(big.Int).SetString(t.Value, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return value
+}
+
+const counterpartiesQuery = `query counterparties($addr: string) {
+	counterparties(func: eq(address, $addr)) {
+		sent: ~from {
+			value
+			to { address }
+		}
+		received: ~to {
+			value
+			from { address }
+		}
+	}
+}`
+
+// Counterparties returns accounts which sent value to or received from addr,
+// sorted by total transfers count
+func (d *Driver) Counterparties(ctx context.Context, addr common.Address) ([]*Counterparty, error) {
+	data, err := d.client.Query(ctx, counterpartiesQuery, map[string]string{
+		"$addr": strings.ToLower(addr.Hex()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Counterparties []struct {
+			Sent     []transferResult `json:"sent"`
+			Received []transferResult `json:"received"`
+		} `json:"counterparties"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	parties := make(map[common.Address]*Counterparty)
+	party := func(addr string) *Counterparty {
+		a := common.HexToAddress(addr)
+		if _, ok := parties[a]; !ok {
+			parties[a] = &Counterparty{Address: a, Sent: new(big.Int), Received: new(big.Int)}
+		}
+		return parties[a]
+	}
+
+	for _, account := range res.Counterparties {
+		for _, t := range account.Sent {
+			if t.To == nil {
+				continue
+			}
+			p := party(t.To.Address)
+			p.Sent.Add(p.Sent, t.amount())
+			p.Transfers++
+		}
+		for _, t := range account.Received {
+			if t.From == nil {
+				continue
+			}
+			p := party(t.From.Address)
+			p.Received.Add(p.Received, t.amount())
+			p.Transfers++
+		}
+	}
+
+	result := make([]*Counterparty, 0, len(parties))
+	for _, p := range parties {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Transfers != result[j].Transfers {
+			return result[i].Transfers > result[j].Transfers
+		}
+		return result[i].Address.Hex() < result[j].Address.Hex()
+	})
+
+	return result, nil
+}
+
+const pathsQuery = `query paths($from: string, $to: string) {
+	src as var(func: eq(address, $from))
+	dst as var(func: eq(address, $to))
+	path as shortest(from: uid(src), to: uid(dst), numpaths: %d, depth: %d) {
+		sent_to
+	}
+	path(func: uid(path)) {
+		uid
+		address
+	}
+}`
+
+// Paths returns up to maxPaths shortest chains of transfers leading from one account to another,
+// each path includes both ends and is at most depth hops long
+func (d *Driver) Paths(ctx context.Context, from, to common.Address, maxPaths, depth int) ([][]common.Address, error) {
+	data, err := d.client.Query(ctx, fmt.Sprintf(pathsQuery, maxPaths, depth), map[string]string{
+		"$from": strings.ToLower(from.Hex()),
+		"$to":   strings.ToLower(to.Hex()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Paths []map[string]interface{} `json:"_path_"`
+		Nodes []struct {
+			Uid     string `json:"uid"`
+			Address string `json:"address"`
+		} `json:"path"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	addresses := make(map[string]common.Address)
+	for _, node := range res.Nodes {
+		addresses[node.Uid] = common.HexToAddress(node.Address)
+	}
+
+	result := make([][]common.Address, 0, len(res.Paths))
+	for _, p := range res.Paths {
+		var path []common.Address
+		for node := p; node != nil; node = nextPathNode(node) {
+			uid, _ := node["uid"].(string)
+			addr, ok := addresses[uid]
+			if !ok {
+				return nil, fmt.Errorf("path node %s address is unknown", uid)
+			}
+			path = append(path, addr)
+		}
+		result = append(result, path)
+	}
+
+	return result, nil
+}
+
+func nextPathNode(node map[string]interface{}) map[string]interface{} {
+	switch next := node["sent_to"].(type) {
+	case map[string]interface{}:
+		return next
+	case []interface{}:
+		if len(next) > 0 {
+			n, _ := next[0].(map[string]interface{})
+			return n
+		}
+	}
+	return nil
+}
+
+const flowsQuery = `query flows($addr: string, $from: int, $to: int) {
+	flows(func: eq(address, $addr)) {
+		out: ~from @filter(ge(timestamp, $from) AND lt(timestamp, $to)) {
+			value
+			timestamp
+		}
+		in: ~to @filter(ge(timestamp, $from) AND lt(timestamp, $to)) {
+			value
+			timestamp
+		}
+	}
+}`
+
+// Flows returns value moved in and out of account within [from, to) time range
+// grouped by specified interval
+func (d *Driver) Flows(ctx context.Context, addr common.Address, from, to time.Time, interval time.Duration) ([]*FlowBucket, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid flows interval: %s", interval)
+	}
+
+	data, err := d.client.Query(ctx, flowsQuery, map[string]string{
+		"$addr": strings.ToLower(addr.Hex()),
+		"$from": fmt.Sprint(from.Unix()),
+		"$to":   fmt.Sprint(to.Unix()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var res struct {
+		Flows []struct {
+			Out []transferResult `json:"out"`
+			In  []transferResult `json:"in"`
+		} `json:"flows"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+
+	var buckets []*FlowBucket
+	for start := from; start.Before(to); start = start.Add(interval) {
+		buckets = append(buckets, &FlowBucket{Start: start, Inflow: new(big.Int), Outflow: new(big.Int)})
+	}
+	bucket := func(ts int64) *FlowBucket {
+		i := int(time.Unix(ts, 0).Sub(from) / interval)
+		if i < 0 || i >= len(buckets) {
+			return nil
+		}
+		return buckets[i]
+	}
+
+	for _, account := range res.Flows {
+		for _, t := range account.Out {
+			if b := bucket(t.Timestamp); b != nil {
+				b.Outflow.Add(b.Outflow, t.amount())
+				b.Transfers++
+			}
+		}
+		for _, t := range account.In {
+			if b := bucket(t.Timestamp); b != nil {
+				b.Inflow.Add(b.Inflow, t.amount())
+				b.Transfers++
+			}
+		}
+	}
+
+	return buckets, nil
+}
@@ -1,6 +1,12 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
 
 type DriverType string
 
@@ -11,18 +17,125 @@ func (dt DriverType) String() string {
 	return string(dt)
 }
 
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrNotSupported = errors.New("operation is not supported by storage driver")
+)
+
 type Driver struct {
 	Env DriverType `json:"env"`
 }
 
+// BlockFilter limits SearchBlocks results to the specified block numbers range
+type BlockFilter struct {
+	FromNumber uint64 `json:"from_number" yaml:"from_number"`
+	ToNumber   uint64 `json:"to_number" yaml:"to_number"` // zero means no upper bound
+	Limit      int    `json:"limit" yaml:"limit"`
+}
+
+// TxFilter limits SearchTxs results to the transactions sent to specified address
+type TxFilter struct {
+	To    *common.Address `json:"to" yaml:"to"`
+	Limit int             `json:"limit" yaml:"limit"`
+}
+
+// EventFilter limits SearchEvents results the same way as eth_getLogs does
+type EventFilter struct {
+	FromBlock uint64           `json:"from_block" yaml:"from_block"`
+	ToBlock   uint64           `json:"to_block" yaml:"to_block"` // zero means no upper bound
+	Addresses []common.Address `json:"addresses" yaml:"addresses"`
+	Topics    [][]common.Hash  `json:"topics" yaml:"topics"`
+	Limit     int              `json:"limit" yaml:"limit"`
+}
+
 type Storage interface {
-	SaveTx(ctx context.Context) error
-	FindTx(ctx context.Context)
-	SearchTxs(ctx context.Context)
-	SaveBlock(ctx context.Context)
-	FindBlock(ctx context.Context)
-	SearchBlocks(ctx context.Context)
-	SaveEvent(ctx context.Context)
-	FindEvent(ctx context.Context)
-	SearchEvents(ctx context.Context)
+	SaveTx(ctx context.Context, tx *types.Transaction) error
+	FindTx(ctx context.Context, hash common.Hash) (*types.Transaction, error)
+	SearchTxs(ctx context.Context, filter TxFilter) ([]*types.Transaction, error)
+	SaveBlock(ctx context.Context, block *types.Block) error
+	FindBlock(ctx context.Context, hash common.Hash) (*types.Block, error)
+	SearchBlocks(ctx context.Context, filter BlockFilter) ([]*types.Block, error)
+	SaveEvent(ctx context.Context, event *types.Log) error
+	FindEvent(ctx context.Context, txHash common.Hash, index uint) (*types.Log, error)
+	SearchEvents(ctx context.Context, filter EventFilter) ([]*types.Log, error)
+	// DeleteBlock, DeleteTx and DeleteEvent remove data of blocks which are no longer canonical,
+	// missing data is not an error
+	DeleteBlock(ctx context.Context, hash common.Hash) error
+	DeleteTx(ctx context.Context, hash common.Hash) error
+	DeleteEvent(ctx context.Context, txHash common.Hash, index uint) error
+	Close() error
+}
+
+// KeyValueStorage is implemented by drivers able to keep raw key value data, like state trie nodes
+type KeyValueStorage interface {
+	KeyValueStore() ethdb.KeyValueStore
+}
+
+// KeyValueStore returns raw key value store of the storage, if its driver supports that
+func KeyValueStore(s Storage) (ethdb.KeyValueStore, error) {
+	if m, ok := s.(*Mirror); ok {
+		s = m.primary
+	}
+
+	kvs, ok := s.(KeyValueStorage)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	return kvs.KeyValueStore(), nil
+}
+
+// Match reports whether block number fits the filter range
+func (f BlockFilter) Match(number uint64) bool {
+	return number >= f.FromNumber && (f.ToNumber == 0 || number <= f.ToNumber)
+}
+
+// Match reports whether transaction fits the filter
+func (f TxFilter) Match(tx *types.Transaction) bool {
+	if f.To == nil {
+		return true
+	}
+
+	return tx.To() != nil && *tx.To() == *f.To
+}
+
+// Match reports whether event fits the filter, topics are matched positionally
+func (f EventFilter) Match(event *types.Log) bool {
+	if event.BlockNumber < f.FromBlock || (f.ToBlock > 0 && event.BlockNumber > f.ToBlock) {
+		return false
+	}
+
+	if len(f.Addresses) > 0 {
+		var found bool
+		for _, addr := range f.Addresses {
+			if addr == event.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Topics) > len(event.Topics) {
+		return false
+	}
+	for i, sub := range f.Topics {
+		if len(sub) == 0 {
+			continue
+		}
+		var found bool
+		for _, topic := range sub {
+			if topic == event.Topics[i] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
 }
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Mirror writes data to both primary and secondary storages, while reading from primary only.
+// It is used to keep analytics databases like dgraph in sync with the chain
+type Mirror struct {
+	primary   Storage
+	secondary Storage
+}
+
+func NewMirror(primary, secondary Storage) *Mirror {
+	return &Mirror{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// Secondary returns mirrored storage, to access driver specific queries
+func (m *Mirror) Secondary() Storage {
+	return m.secondary
+}
+
+func (m *Mirror) write(fn func(s Storage) error) error {
+	if err := fn(m.primary); err != nil {
+		return err
+	}
+	if err := fn(m.secondary); err != nil {
+		return fmt.Errorf("mirror: %w", err)
+	}
+	return nil
+}
+
+func (m *Mirror) SaveTx(ctx context.Context, tx *types.Transaction) error {
+	return m.write(func(s Storage) error {
+		return s.SaveTx(ctx, tx)
+	})
+}
+
+func (m *Mirror) FindTx(ctx context.Context, hash common.Hash) (*types.Transaction, error) {
+	return m.primary.FindTx(ctx, hash)
+}
+
+func (m *Mirror) SearchTxs(ctx context.Context, filter TxFilter) ([]*types.Transaction, error) {
+	return m.primary.SearchTxs(ctx, filter)
+}
+
+func (m *Mirror) SaveBlock(ctx context.Context, block *types.Block) error {
+	return m.write(func(s Storage) error {
+		return s.SaveBlock(ctx, block)
+	})
+}
+
+func (m *Mirror) FindBlock(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	return m.primary.FindBlock(ctx, hash)
+}
+
+func (m *Mirror) SearchBlocks(ctx context.Context, filter BlockFilter) ([]*types.Block, error) {
+	return m.primary.SearchBlocks(ctx, filter)
+}
+
+func (m *Mirror) SaveEvent(ctx context.Context, event *types.Log) error {
+	return m.write(func(s Storage) error {
+		return s.SaveEvent(ctx, event)
+	})
+}
+
+func (m *Mirror) FindEvent(ctx context.Context, txHash common.Hash, index uint) (*types.Log, error) {
+	return m.primary.FindEvent(ctx, txHash, index)
+}
+
+func (m *Mirror) SearchEvents(ctx context.Context, filter EventFilter) ([]*types.Log, error) {
+	return m.primary.SearchEvents(ctx, filter)
+}
+
+func (m *Mirror) DeleteBlock(ctx context.Context, hash common.Hash) error {
+	return m.write(func(s Storage) error {
+		return s.DeleteBlock(ctx, hash)
+	})
+}
+
+func (m *Mirror) DeleteTx(ctx context.Context, hash common.Hash) error {
+	return m.write(func(s Storage) error {
+		return s.DeleteTx(ctx, hash)
+	})
+}
+
+func (m *Mirror) DeleteEvent(ctx context.Context, txHash common.Hash, index uint) error {
+	return m.write(func(s Storage) error {
+		return s.DeleteEvent(ctx, txHash, index)
+	})
+}
+
+func (m *Mirror) Close() error {
+	if err := m.secondary.Close(); err != nil {
+		m.primary.Close()
+		return err
+	}
+	return m.primary.Close()
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultDriver is used when 'db' config value is empty
+const DefaultDriver = BadgerDriver
+
+var ErrUnknownDriver = errors.New("unknown storage driver")
+
+// Factory creates ready to use Storage of a registered driver.
+// Drivers read their own settings like 'data_dir' or 'cache.size' from cfg
+type Factory func(ctx context.Context, cfg *viper.Viper) (Storage, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[DriverType]Factory)
+)
+
+// Register makes a storage driver available by the provided type.
+// It is meant to be called from driver package init function and panics
+// if factory is nil or driver is already registered
+func Register(dt DriverType, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := drivers[dt]; dup {
+		panic("storage: Register called twice for driver " + dt.String())
+	}
+
+	drivers[dt] = factory
+}
+
+// Drivers returns sorted list of registered drivers
+func Drivers() []DriverType {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	list := make([]DriverType, 0, len(drivers))
+	for dt := range drivers {
+		list = append(list, dt)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i] < list[j]
+	})
+
+	return list
+}
+
+// Open returns Storage of the driver set by 'db' config value.
+// If 'dgraphdb.enabled' is set, the result is mirrored to dgraph
+func Open(ctx context.Context, cfg *viper.Viper) (Storage, error) {
+	dt := DriverType(cfg.GetString("db"))
+	if len(dt) == 0 {
+		dt = DefaultDriver
+	}
+
+	primary, err := open(ctx, dt, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if dt == DgraphDriver || !cfg.GetBool("dgraphdb.enabled") {
+		return primary, nil
+	}
+
+	secondary, err := open(ctx, DgraphDriver, cfg)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+
+	return NewMirror(primary, secondary), nil
+}
+
+func open(ctx context.Context, dt DriverType, cfg *viper.Viper) (Storage, error) {
+	driversMu.RLock()
+	factory, ok := drivers[dt]
+	driversMu.RUnlock()
+	if !ok {
+		registered := make([]string, 0)
+		for _, d := range Drivers() {
+			registered = append(registered, d.String())
+		}
+		return nil, fmt.Errorf("%w '%s', registered: [%s]", ErrUnknownDriver, dt, strings.Join(registered, ", "))
+	}
+
+	return factory(ctx, cfg)
+}
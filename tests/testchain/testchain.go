@@ -0,0 +1,50 @@
+package testchain
+
+import (
+	"github.com/dgraph-io/badger/v3"
+	"github.com/rovergulf/chain/consensus"
+	"github.com/rovergulf/chain/consensus/instant"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"testing"
+)
+
+// Genesis returns the development network genesis, which funds tests.Account0
+func Genesis() *core.Genesis {
+	return &core.Genesis{
+		Config:   params.DevChainConfig,
+		GasLimit: core.DefaultGenesisGasLimit,
+		Alloc: core.GenesisAlloc{
+			tests.Account0: {Balance: big.NewInt(1e18)},
+		},
+	}
+}
+
+// New returns the chain of the genesis using the development engine, kept in memory until the test ends
+func New(t testing.TB, genesis *core.Genesis) *core.BlockChain {
+	return NewWithEngine(t, genesis, instant.New())
+}
+
+// NewWithEngine returns the chain of the genesis using the engine, kept in memory until the test ends
+func NewWithEngine(t testing.TB, genesis *core.Genesis, engine consensus.Engine) *core.BlockChain {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logutils.NewLogger()
+	store := badgerdb.NewDriver(db, logger)
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	bc, err := core.NewBlockChain(store, genesis, engine, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bc.Stop)
+	return bc
+}
@@ -0,0 +1,85 @@
+package txpool
+
+import (
+	"github.com/spf13/viper"
+	"path/filepath"
+	"time"
+)
+
+// Config are the limits and pricing rules of the transaction pool
+type Config struct {
+	PriceLimit uint64 `json:"price_limit" yaml:"price_limit"` // minimum gas tip of remote transactions
+	PriceBump  uint64 `json:"price_bump" yaml:"price_bump"`   // minimum price bump percentage to replace a transaction
+
+	AccountSlots uint64 `json:"account_slots" yaml:"account_slots"` // executable transaction slots guaranteed per account
+	GlobalSlots  uint64 `json:"global_slots" yaml:"global_slots"`   // maximum number of executable transactions
+	AccountQueue uint64 `json:"account_queue" yaml:"account_queue"` // maximum number of non-executable transactions per account
+	GlobalQueue  uint64 `json:"global_queue" yaml:"global_queue"`   // maximum number of non-executable transactions
+
+	Lifetime time.Duration `json:"lifetime" yaml:"lifetime"` // maximum time non-executable transactions are queued
+
+	Journal   string        `json:"journal" yaml:"journal"`     // path of local transactions journal, disabled if empty
+	Rejournal time.Duration `json:"rejournal" yaml:"rejournal"` // time interval to regenerate the journal
+}
+
+var DefaultConfig = Config{
+	PriceLimit: 1,
+	PriceBump:  10,
+
+	AccountSlots: 16,
+	GlobalSlots:  4096,
+	AccountQueue: 64,
+	GlobalQueue:  1024,
+
+	Lifetime: 3 * time.Hour,
+
+	Rejournal: time.Hour,
+}
+
+// NewConfig reads 'txpool' config section, using defaults for unset or invalid values.
+// Relative journal path is resolved against 'data_dir'
+func NewConfig(cfg *viper.Viper) Config {
+	conf := Config{
+		PriceLimit:   cfg.GetUint64("txpool.price_limit"),
+		PriceBump:    cfg.GetUint64("txpool.price_bump"),
+		AccountSlots: cfg.GetUint64("txpool.account_slots"),
+		GlobalSlots:  cfg.GetUint64("txpool.global_slots"),
+		AccountQueue: cfg.GetUint64("txpool.account_queue"),
+		GlobalQueue:  cfg.GetUint64("txpool.global_queue"),
+		Lifetime:     cfg.GetDuration("txpool.lifetime"),
+		Journal:      cfg.GetString("txpool.journal"),
+		Rejournal:    cfg.GetDuration("txpool.rejournal"),
+	}
+	if len(conf.Journal) > 0 && !filepath.IsAbs(conf.Journal) {
+		conf.Journal = filepath.Join(cfg.GetString("data_dir"), conf.Journal)
+	}
+	return conf.sanitize()
+}
+
+func (c Config) sanitize() Config {
+	if c.PriceLimit < 1 {
+		c.PriceLimit = DefaultConfig.PriceLimit
+	}
+	if c.PriceBump < 1 {
+		c.PriceBump = DefaultConfig.PriceBump
+	}
+	if c.AccountSlots < 1 {
+		c.AccountSlots = DefaultConfig.AccountSlots
+	}
+	if c.GlobalSlots < 1 {
+		c.GlobalSlots = DefaultConfig.GlobalSlots
+	}
+	if c.AccountQueue < 1 {
+		c.AccountQueue = DefaultConfig.AccountQueue
+	}
+	if c.GlobalQueue < 1 {
+		c.GlobalQueue = DefaultConfig.GlobalQueue
+	}
+	if c.Lifetime < 1 {
+		c.Lifetime = DefaultConfig.Lifetime
+	}
+	if c.Rejournal < time.Second {
+		c.Rejournal = DefaultConfig.Rejournal
+	}
+	return c
+}
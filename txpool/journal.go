@@ -0,0 +1,147 @@
+package txpool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var errNoActiveJournal = errors.New("no active journal")
+
+// journal is a rotating log of local transactions, so they survive node restarts
+type journal struct {
+	path   string
+	writer io.WriteCloser
+	logger *zap.SugaredLogger
+}
+
+func newJournal(path string, logger *zap.SugaredLogger) *journal {
+	return &journal{
+		path:   path,
+		logger: logger,
+	}
+}
+
+// load reads rlp encoded transactions from the journal and passes them to add in batches.
+// Transactions rejected by add, e.g. already mined ones, are just skipped
+func (journal *journal) load(add func([]*types.Transaction) []error) error {
+	input, err := os.Open(journal.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// transactions added while loading are already in the journal, so their inserts are discarded
+	journal.writer = new(devNull)
+	defer func() {
+		journal.writer = nil
+	}()
+
+	var (
+		stream  = rlp.NewStream(input, 0)
+		total   int
+		dropped int
+		batch   types.Transactions
+	)
+	flush := func() {
+		for _, err := range add(batch) {
+			if err != nil {
+				journal.logger.Debugw("Dropped journaled transaction", "err", err)
+				dropped++
+			}
+		}
+		batch = batch[:0]
+	}
+
+	var failure error
+	for {
+		tx := new(types.Transaction)
+		if err = stream.Decode(tx); err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			break
+		}
+		total++
+		if batch = append(batch, tx); len(batch) > 1024 {
+			flush()
+		}
+	}
+	flush()
+
+	journal.logger.Infow("Loaded local transaction journal", "path", journal.path, "transactions", total, "dropped", dropped)
+	return failure
+}
+
+// insert appends transaction to the journal
+func (journal *journal) insert(tx *types.Transaction) error {
+	if journal.writer == nil {
+		return errNoActiveJournal
+	}
+	return rlp.Encode(journal.writer, tx)
+}
+
+// rotate regenerates the journal with the current contents of the pool
+func (journal *journal) rotate(all map[common.Address]types.Transactions) error {
+	if journal.writer != nil {
+		if err := journal.writer.Close(); err != nil {
+			return err
+		}
+		journal.writer = nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(journal.path), 0755); err != nil {
+		return err
+	}
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	journaled := 0
+	for _, txs := range all {
+		for _, tx := range txs {
+			if err = rlp.Encode(replacement, tx); err != nil {
+				replacement.Close()
+				return err
+			}
+		}
+		journaled += len(txs)
+	}
+	replacement.Close()
+
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	sink, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to reopen journal: %w", err)
+	}
+	journal.writer = sink
+
+	journal.logger.Debugw("Regenerated local transaction journal", "transactions", journaled, "accounts", len(all))
+	return nil
+}
+
+func (journal *journal) close() error {
+	var err error
+	if journal.writer != nil {
+		err = journal.writer.Close()
+		journal.writer = nil
+	}
+	return err
+}
+
+// devNull accepts journal inserts done while loading the journal
+type devNull struct{}
+
+func (*devNull) Write(p []byte) (n int, err error) { return len(p), nil }
+func (*devNull) Close() error                      { return nil }
@@ -0,0 +1,160 @@
+package txpool
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"sort"
+)
+
+// txList is a nonce indexed list of transactions of a single account
+type txList struct {
+	txs    map[uint64]*types.Transaction
+	sorted types.Transactions // cache of nonce sorted txs, reset on every change
+}
+
+func newTxList() *txList {
+	return &txList{
+		txs: make(map[uint64]*types.Transaction),
+	}
+}
+
+func (l *txList) Len() int {
+	return len(l.txs)
+}
+
+func (l *txList) Empty() bool {
+	return len(l.txs) == 0
+}
+
+func (l *txList) Get(nonce uint64) *types.Transaction {
+	return l.txs[nonce]
+}
+
+// Add inserts transaction, replacing the one with the same nonce only if the new one is priced
+// at least priceBump percent higher. Returns whether tx is inserted and the replaced one
+func (l *txList) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Transaction) {
+	old := l.txs[tx.Nonce()]
+	if old != nil && !isReplacement(old, tx, priceBump) {
+		return false, nil
+	}
+
+	l.txs[tx.Nonce()] = tx
+	l.sorted = nil
+	return true, old
+}
+
+// Remove deletes transaction, returning whether it was found and txs with higher nonces,
+// which are not executable anymore because of the nonce gap
+func (l *txList) Remove(tx *types.Transaction) (bool, types.Transactions) {
+	nonce := tx.Nonce()
+	if _, ok := l.txs[nonce]; !ok {
+		return false, nil
+	}
+	delete(l.txs, nonce)
+	l.sorted = nil
+
+	return true, l.filter(func(tx *types.Transaction) bool {
+		return tx.Nonce() > nonce
+	})
+}
+
+// Forward removes all transactions with nonce lower than threshold, e.g. already mined ones
+func (l *txList) Forward(threshold uint64) types.Transactions {
+	return l.filter(func(tx *types.Transaction) bool {
+		return tx.Nonce() < threshold
+	})
+}
+
+// Filter removes transactions exceeding balance or gas limit.
+// Txs with higher nonces than the lowest removed one are returned as invalids
+func (l *txList) Filter(balance *big.Int, gasLimit uint64) (types.Transactions, types.Transactions) {
+	removed := l.filter(func(tx *types.Transaction) bool {
+		return tx.Gas() > gasLimit || tx.Cost().Cmp(balance) > 0
+	})
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	lowest := removed[0].Nonce()
+	invalids := l.filter(func(tx *types.Transaction) bool {
+		return tx.Nonce() > lowest
+	})
+	return removed, invalids
+}
+
+// Ready removes and returns sequential transactions starting with the provided nonce
+func (l *txList) Ready(start uint64) types.Transactions {
+	var ready types.Transactions
+	for next := start; ; next++ {
+		tx, ok := l.txs[next]
+		if !ok {
+			break
+		}
+		ready = append(ready, tx)
+		delete(l.txs, next)
+	}
+	if len(ready) > 0 {
+		l.sorted = nil
+	}
+	return ready
+}
+
+// Cap removes transactions with the highest nonces exceeding the limit
+func (l *txList) Cap(limit int) types.Transactions {
+	if len(l.txs) <= limit {
+		return nil
+	}
+	sorted := l.Flatten()
+	drops := sorted[limit:]
+	for _, tx := range drops {
+		delete(l.txs, tx.Nonce())
+	}
+	l.sorted = nil
+	return drops
+}
+
+// LastNonce returns the highest nonce in the list
+func (l *txList) LastNonce() uint64 {
+	sorted := l.Flatten()
+	return sorted[len(sorted)-1].Nonce()
+}
+
+// Flatten returns nonce sorted transactions, which must not be modified
+func (l *txList) Flatten() types.Transactions {
+	if l.sorted == nil {
+		l.sorted = make(types.Transactions, 0, len(l.txs))
+		for _, tx := range l.txs {
+			l.sorted = append(l.sorted, tx)
+		}
+		sort.Sort(types.TxByNonce(l.sorted))
+	}
+	return l.sorted
+}
+
+// filter removes and returns nonce sorted transactions matching fn
+func (l *txList) filter(fn func(*types.Transaction) bool) types.Transactions {
+	var removed types.Transactions
+	for _, tx := range l.Flatten() {
+		if fn(tx) {
+			removed = append(removed, tx)
+			delete(l.txs, tx.Nonce())
+		}
+	}
+	if len(removed) > 0 {
+		l.sorted = nil
+	}
+	return removed
+}
+
+// isReplacement reports whether replacement fee cap and tip are both at least priceBump percent higher
+func isReplacement(old, tx *types.Transaction, priceBump uint64) bool {
+	if old.GasFeeCapCmp(tx) >= 0 || old.GasTipCapCmp(tx) >= 0 {
+		return false
+	}
+
+	bump := func(price *big.Int) *big.Int {
+		threshold := new(big.Int).Mul(price, big.NewInt(int64(100+priceBump)))
+		return threshold.Div(threshold, big.NewInt(100))
+	}
+	return tx.GasFeeCap().Cmp(bump(old.GasFeeCap())) >= 0 && tx.GasTipCap().Cmp(bump(old.GasTipCap())) >= 0
+}
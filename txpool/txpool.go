@@ -0,0 +1,746 @@
+package txpool
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/permissions"
+	"github.com/rovergulf/chain/state"
+	"go.uber.org/zap"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// txMaxSize is the maximum size of a single transaction, protecting the pool from DoS
+	txMaxSize = 128 * 1024
+
+	// evictionInterval is how often queued transactions are checked for eviction
+	evictionInterval = time.Minute
+
+	// maxReorgDepth is the maximum reorg depth to reinject transactions of dropped blocks
+	maxReorgDepth = 64
+)
+
+var (
+	ErrAlreadyKnown       = errors.New("already known")
+	ErrInvalidSender      = errors.New("invalid sender")
+	ErrUnderpriced        = errors.New("transaction underpriced")
+	ErrReplaceUnderpriced = errors.New("replacement transaction underpriced")
+	ErrTxPoolOverflow     = errors.New("txpool is full")
+	ErrGasLimit           = errors.New("exceeds block gas limit")
+	ErrNegativeValue      = errors.New("negative value")
+	ErrOversizedData      = errors.New("oversized data")
+)
+
+// blockChain is the part of core.BlockChain used by the pool to track head state
+type blockChain interface {
+	CurrentBlock() *types.Block
+	GetBlock(hash common.Hash, number uint64) *types.Block
+	StateAt(root common.Hash) (*state.StateDB, error)
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// TxPool keeps transactions waiting to be included into blocks.
+// Executable transactions (sequential nonces starting with the account state nonce) are pending,
+// the rest are queued until nonce gap is filled. Local transactions are exempt from pricing rules and limits
+type TxPool struct {
+	config      Config
+	chainConfig *params.ChainConfig
+	chain       blockChain
+	signer      types.Signer
+	logger      *zap.SugaredLogger
+
+	mu sync.RWMutex
+
+	head          *types.Header
+	currentState  *state.StateDB
+	pendingNonces map[common.Address]uint64
+	currentMaxGas uint64
+
+	locals  map[common.Address]bool
+	pending map[common.Address]*txList
+	queue   map[common.Address]*txList
+	beats   map[common.Address]time.Time // last activity of accounts with queued txs
+	all     map[common.Hash]*types.Transaction
+
+	journal *journal // journal of local transactions to back up to disk
+
+	txFeed event.Feed
+	scope  event.SubscriptionScope
+
+	chainHeadCh  chan core.ChainHeadEvent
+	chainHeadSub event.Subscription
+	quit         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewTxPool returns transaction pool following the chain head
+func NewTxPool(config Config, chainConfig *params.ChainConfig, chain blockChain, logger *zap.SugaredLogger) (*TxPool, error) {
+	pool := &TxPool{
+		config:        config.sanitize(),
+		chainConfig:   chainConfig,
+		chain:         chain,
+		signer:        types.LatestSigner(chainConfig.EthConfig()),
+		logger:        logger,
+		pendingNonces: make(map[common.Address]uint64),
+		locals:        make(map[common.Address]bool),
+		pending:       make(map[common.Address]*txList),
+		queue:         make(map[common.Address]*txList),
+		beats:         make(map[common.Address]time.Time),
+		all:           make(map[common.Hash]*types.Transaction),
+		chainHeadCh:   make(chan core.ChainHeadEvent, 16),
+		quit:          make(chan struct{}),
+	}
+	if err := pool.reset(nil, chain.CurrentBlock().Header()); err != nil {
+		return nil, err
+	}
+
+	if len(pool.config.Journal) > 0 {
+		pool.journal = newJournal(pool.config.Journal, logger)
+		if err := pool.journal.load(pool.AddLocals); err != nil {
+			logger.Warnw("Failed to load transaction journal", "err", err)
+		}
+		if err := pool.journal.rotate(pool.local()); err != nil {
+			logger.Warnw("Failed to rotate transaction journal", "err", err)
+		}
+	}
+
+	pool.chainHeadSub = chain.SubscribeChainHeadEvent(pool.chainHeadCh)
+	pool.wg.Add(1)
+	go pool.loop()
+
+	return pool, nil
+}
+
+func (pool *TxPool) loop() {
+	defer pool.wg.Done()
+
+	evict := time.NewTicker(evictionInterval)
+	defer evict.Stop()
+
+	journal := time.NewTicker(pool.config.Rejournal)
+	defer journal.Stop()
+
+	for {
+		select {
+		case ev := <-pool.chainHeadCh:
+			pool.mu.Lock()
+			if err := pool.reset(pool.head, ev.Block.Header()); err != nil {
+				pool.logger.Errorw("Unable to reset txpool to the new head", "number", ev.Block.NumberU64(), "err", err)
+			}
+			pool.mu.Unlock()
+
+		case <-evict.C:
+			pool.mu.Lock()
+			for addr, list := range pool.queue {
+				if pool.locals[addr] || time.Since(pool.beats[addr]) < pool.config.Lifetime {
+					continue
+				}
+				for _, tx := range list.Flatten() {
+					pool.removeTx(tx.Hash())
+				}
+			}
+			pool.mu.Unlock()
+
+		case <-journal.C:
+			if pool.journal != nil {
+				pool.mu.Lock()
+				if err := pool.journal.rotate(pool.local()); err != nil {
+					pool.logger.Warnw("Failed to rotate transaction journal", "err", err)
+				}
+				pool.mu.Unlock()
+			}
+
+		case <-pool.chainHeadSub.Err():
+			return
+		case <-pool.quit:
+			return
+		}
+	}
+}
+
+// Stop terminates the pool and closes all subscriptions
+func (pool *TxPool) Stop() {
+	pool.scope.Close()
+	pool.chainHeadSub.Unsubscribe()
+	close(pool.quit)
+	pool.wg.Wait()
+
+	if pool.journal != nil {
+		pool.journal.close()
+	}
+
+	pool.logger.Info("Transaction pool stopped")
+}
+
+// SubscribeNewTxsEvent registers a subscription of NewTxsEvent, sent when transactions become pending
+func (pool *TxPool) SubscribeNewTxsEvent(ch chan<- core.NewTxsEvent) event.Subscription {
+	return pool.scope.Track(pool.txFeed.Subscribe(ch))
+}
+
+// AddLocal validates and adds transaction submitted through this node
+func (pool *TxPool) AddLocal(tx *types.Transaction) error {
+	return pool.AddLocals([]*types.Transaction{tx})[0]
+}
+
+func (pool *TxPool) AddLocals(txs []*types.Transaction) []error {
+	return pool.addTxs(txs, true)
+}
+
+// AddRemotes validates and adds transactions received from peers
+func (pool *TxPool) AddRemotes(txs []*types.Transaction) []error {
+	return pool.addTxs(txs, false)
+}
+
+func (pool *TxPool) addTxs(txs []*types.Transaction, local bool) []error {
+	errs := make([]error, len(txs))
+	dirty := make(map[common.Address]struct{})
+
+	pool.mu.Lock()
+	var promoted []*types.Transaction
+	for i, tx := range txs {
+		replaced, err := pool.add(tx, local)
+		errs[i] = err
+		if err != nil {
+			continue
+		}
+		if replaced {
+			promoted = append(promoted, tx)
+		} else {
+			from, _ := types.Sender(pool.signer, tx)
+			dirty[from] = struct{}{}
+		}
+	}
+	promoted = append(promoted, pool.promoteExecutables(dirty)...)
+	pool.mu.Unlock()
+
+	if len(promoted) > 0 {
+		pool.txFeed.Send(core.NewTxsEvent{Txs: promoted})
+	}
+	return errs
+}
+
+// add validates transaction and inserts it into the queue, or replaces pending one with the same nonce.
+// It reports whether pending transaction is replaced, so the new one is executable right away
+func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
+	hash := tx.Hash()
+	if pool.all[hash] != nil {
+		return false, ErrAlreadyKnown
+	}
+
+	from, err := types.Sender(pool.signer, tx)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", ErrInvalidSender, err)
+	}
+	local = local || pool.locals[from]
+	if err := pool.validateTx(tx, from, local); err != nil {
+		return false, err
+	}
+
+	if uint64(len(pool.all)) >= pool.config.GlobalSlots+pool.config.GlobalQueue {
+		cheapest := pool.cheapestRemote()
+		if cheapest == nil {
+			return false, ErrTxPoolOverflow
+		}
+		if !local && tx.EffectiveGasTipCmp(cheapest, pool.head.BaseFee) <= 0 {
+			return false, ErrUnderpriced
+		}
+		pool.logger.Debugw("Discarding underpriced transaction", "hash", cheapest.Hash(),
+			"tip", cheapest.GasTipCap(), "fee_cap", cheapest.GasFeeCap())
+		pool.removeTx(cheapest.Hash())
+	}
+
+	if local && !pool.locals[from] {
+		pool.logger.Debugw("Setting new local account", "address", from)
+		pool.locals[from] = true
+	}
+
+	if list := pool.pending[from]; list != nil && list.Get(tx.Nonce()) != nil {
+		inserted, old := list.Add(tx, pool.config.PriceBump)
+		if !inserted {
+			return false, ErrReplaceUnderpriced
+		}
+		delete(pool.all, old.Hash())
+		pool.all[hash] = tx
+		pool.journalTx(from, tx)
+		pool.logger.Debugw("Replaced pending transaction", "hash", hash, "old", old.Hash(), "from", from, "nonce", tx.Nonce())
+		return true, nil
+	}
+
+	if err := pool.enqueueTx(from, tx); err != nil {
+		return false, err
+	}
+	pool.journalTx(from, tx)
+	return false, nil
+}
+
+// journalTx appends transaction of the local account to the journal
+func (pool *TxPool) journalTx(from common.Address, tx *types.Transaction) {
+	if pool.journal == nil || !pool.locals[from] {
+		return
+	}
+	if err := pool.journal.insert(tx); err != nil {
+		pool.logger.Warnw("Failed to journal local transaction", "hash", tx.Hash(), "err", err)
+	}
+}
+
+// local returns pending and queued transactions of the local accounts
+func (pool *TxPool) local() map[common.Address]types.Transactions {
+	txs := make(map[common.Address]types.Transactions)
+	for addr := range pool.locals {
+		if list := pool.pending[addr]; list != nil {
+			txs[addr] = append(txs[addr], list.Flatten()...)
+		}
+		if list := pool.queue[addr]; list != nil {
+			txs[addr] = append(txs[addr], list.Flatten()...)
+		}
+	}
+	return txs
+}
+
+// validateTx checks transaction against consensus rules and current state
+func (pool *TxPool) validateTx(tx *types.Transaction, from common.Address, local bool) error {
+	if !pool.chainConfig.IsLondon(new(big.Int).Add(pool.head.Number, common.Big1), uint64(time.Now().Unix())) && tx.Type() == types.DynamicFeeTxType {
+		return gethcore.ErrTxTypeNotSupported
+	}
+	if tx.Size() > txMaxSize {
+		return ErrOversizedData
+	}
+	if tx.Value().Sign() < 0 {
+		return ErrNegativeValue
+	}
+	if pool.currentMaxGas < tx.Gas() {
+		return ErrGasLimit
+	}
+	if tx.GasFeeCap().BitLen() > 256 {
+		return gethcore.ErrFeeCapVeryHigh
+	}
+	if tx.GasTipCap().BitLen() > 256 {
+		return gethcore.ErrTipVeryHigh
+	}
+	if tx.GasFeeCapIntCmp(tx.GasTipCap()) < 0 {
+		return gethcore.ErrTipAboveFeeCap
+	}
+	if !local && tx.GasTipCapIntCmp(new(big.Int).SetUint64(pool.config.PriceLimit)) < 0 {
+		return ErrUnderpriced
+	}
+	if pool.chainConfig.Permissions != nil {
+		if err := permissions.Check(pool.chainConfig.Permissions, pool.currentState, from, tx.To()); err != nil {
+			return err
+		}
+	}
+	if pool.currentState.GetNonce(from) > tx.Nonce() {
+		return gethcore.ErrNonceTooLow
+	}
+	if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
+		return gethcore.ErrInsufficientFunds
+	}
+
+	rules := pool.chainConfig.Rules(pool.head.Number, pool.head.Time)
+	intrGas, err := gethcore.IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, rules.IsHomestead, rules.IsIstanbul)
+	if err != nil {
+		return err
+	}
+	if tx.Gas() < intrGas {
+		return gethcore.ErrIntrinsicGas
+	}
+
+	return nil
+}
+
+func (pool *TxPool) enqueueTx(from common.Address, tx *types.Transaction) error {
+	if pool.queue[from] == nil {
+		pool.queue[from] = newTxList()
+	}
+	inserted, old := pool.queue[from].Add(tx, pool.config.PriceBump)
+	if !inserted {
+		return ErrReplaceUnderpriced
+	}
+	if old != nil {
+		delete(pool.all, old.Hash())
+	}
+	pool.all[tx.Hash()] = tx
+	pool.beats[from] = time.Now()
+	return nil
+}
+
+// cheapestRemote returns remote transaction with the lowest effective tip
+func (pool *TxPool) cheapestRemote() *types.Transaction {
+	var cheapest *types.Transaction
+	for _, tx := range pool.all {
+		from, _ := types.Sender(pool.signer, tx)
+		if pool.locals[from] {
+			continue
+		}
+		if cheapest == nil || tx.EffectiveGasTipCmp(cheapest, pool.head.BaseFee) < 0 {
+			cheapest = tx
+		}
+	}
+	return cheapest
+}
+
+// removeTx drops transaction from the pool, moving pending txs with higher nonces back to the queue
+func (pool *TxPool) removeTx(hash common.Hash) {
+	tx := pool.all[hash]
+	if tx == nil {
+		return
+	}
+	from, _ := types.Sender(pool.signer, tx)
+	delete(pool.all, hash)
+
+	if list := pool.pending[from]; list != nil {
+		if removed, invalids := list.Remove(tx); removed {
+			if list.Empty() {
+				delete(pool.pending, from)
+			}
+			for _, invalid := range invalids {
+				pool.enqueueTx(from, invalid)
+			}
+			if nonce := tx.Nonce(); pool.pendingNonce(from) > nonce {
+				pool.pendingNonces[from] = nonce
+			}
+			return
+		}
+	}
+	if list := pool.queue[from]; list != nil {
+		list.Remove(tx)
+		if list.Empty() {
+			delete(pool.queue, from)
+			delete(pool.beats, from)
+		}
+	}
+}
+
+func (pool *TxPool) pendingNonce(addr common.Address) uint64 {
+	if nonce, ok := pool.pendingNonces[addr]; ok {
+		return nonce
+	}
+	return pool.currentState.GetNonce(addr)
+}
+
+// promoteExecutables moves queued transactions of the accounts, which became executable, to pending.
+// Stale, unaffordable and over limit transactions are dropped
+func (pool *TxPool) promoteExecutables(accounts map[common.Address]struct{}) []*types.Transaction {
+	var promoted []*types.Transaction
+	for addr := range accounts {
+		list := pool.queue[addr]
+		if list == nil {
+			continue
+		}
+
+		for _, tx := range list.Forward(pool.currentState.GetNonce(addr)) {
+			delete(pool.all, tx.Hash())
+		}
+		drops, _ := list.Filter(pool.currentState.GetBalance(addr), pool.currentMaxGas)
+		for _, tx := range drops {
+			delete(pool.all, tx.Hash())
+		}
+
+		ready := list.Ready(pool.pendingNonce(addr))
+		if len(ready) > 0 {
+			if pool.pending[addr] == nil {
+				pool.pending[addr] = newTxList()
+			}
+			for _, tx := range ready {
+				pool.pending[addr].Add(tx, pool.config.PriceBump)
+			}
+			pool.pendingNonces[addr] = ready[len(ready)-1].Nonce() + 1
+			promoted = append(promoted, ready...)
+		}
+
+		if !pool.locals[addr] {
+			for _, tx := range list.Cap(int(pool.config.AccountQueue)) {
+				delete(pool.all, tx.Hash())
+			}
+		}
+		if list.Empty() {
+			delete(pool.queue, addr)
+			delete(pool.beats, addr)
+		}
+	}
+
+	pool.truncatePending()
+	pool.truncateQueue()
+
+	// promoted txs might be dropped by limits
+	executable := promoted[:0]
+	for _, tx := range promoted {
+		if pool.all[tx.Hash()] != nil {
+			executable = append(executable, tx)
+		}
+	}
+	return executable
+}
+
+// truncatePending drops pending transactions with the highest nonces of the biggest remote accounts
+// until pending fits global slots, not going lower than account slots for each account
+func (pool *TxPool) truncatePending() {
+	var pending uint64
+	for _, list := range pool.pending {
+		pending += uint64(list.Len())
+	}
+	if pending <= pool.config.GlobalSlots {
+		return
+	}
+
+	var offenders []common.Address
+	for addr, list := range pool.pending {
+		if !pool.locals[addr] && uint64(list.Len()) > pool.config.AccountSlots {
+			offenders = append(offenders, addr)
+		}
+	}
+	sort.Slice(offenders, func(i, j int) bool {
+		return pool.pending[offenders[i]].Len() > pool.pending[offenders[j]].Len()
+	})
+
+	for _, addr := range offenders {
+		if pending <= pool.config.GlobalSlots {
+			break
+		}
+		list := pool.pending[addr]
+		excess := uint64(list.Len()) - pool.config.AccountSlots
+		if over := pending - pool.config.GlobalSlots; excess > over {
+			excess = over
+		}
+		for _, tx := range list.Cap(list.Len() - int(excess)) {
+			delete(pool.all, tx.Hash())
+			pending--
+		}
+		pool.pendingNonces[addr] = list.LastNonce() + 1
+	}
+}
+
+// truncateQueue drops queued transactions of the least recently active remote accounts
+// until queue fits global queue limit
+func (pool *TxPool) truncateQueue() {
+	var queued uint64
+	for _, list := range pool.queue {
+		queued += uint64(list.Len())
+	}
+	if queued <= pool.config.GlobalQueue {
+		return
+	}
+
+	var addrs []common.Address
+	for addr := range pool.queue {
+		if !pool.locals[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return pool.beats[addrs[i]].Before(pool.beats[addrs[j]])
+	})
+
+	for _, addr := range addrs {
+		if queued <= pool.config.GlobalQueue {
+			break
+		}
+		txs := pool.queue[addr].Flatten()
+		for i := len(txs) - 1; i >= 0 && queued > pool.config.GlobalQueue; i-- {
+			pool.removeTx(txs[i].Hash())
+			queued--
+		}
+	}
+}
+
+// demoteUnexecutables drops mined and invalid pending transactions,
+// moving transactions after nonce gaps back to the queue
+func (pool *TxPool) demoteUnexecutables() {
+	for addr, list := range pool.pending {
+		nonce := pool.currentState.GetNonce(addr)
+
+		for _, tx := range list.Forward(nonce) {
+			delete(pool.all, tx.Hash())
+		}
+		drops, invalids := list.Filter(pool.currentState.GetBalance(addr), pool.currentMaxGas)
+		for _, tx := range drops {
+			delete(pool.all, tx.Hash())
+		}
+		for _, tx := range invalids {
+			pool.enqueueTx(addr, tx)
+		}
+		// nonce gap appeared, e.g. after reorg, so all txs are not executable anymore
+		if !list.Empty() && list.Get(nonce) == nil {
+			for _, tx := range list.Ready(list.Flatten()[0].Nonce()) {
+				pool.enqueueTx(addr, tx)
+			}
+		}
+		if list.Empty() {
+			delete(pool.pending, addr)
+		}
+	}
+}
+
+// dropForbidden removes transactions, which senders are not allowed to send them anymore by the permissioned network
+func (pool *TxPool) dropForbidden() {
+	if pool.chainConfig.Permissions == nil {
+		return
+	}
+	for hash, tx := range pool.all {
+		from, _ := types.Sender(pool.signer, tx)
+		if err := permissions.Check(pool.chainConfig.Permissions, pool.currentState, from, tx.To()); err != nil {
+			pool.logger.Debugw("Dropped forbidden transaction", "hash", hash, "from", from, "err", err)
+			pool.removeTx(hash)
+		}
+	}
+}
+
+// reset moves pool state to the new chain head, reinjecting transactions of reorged blocks
+func (pool *TxPool) reset(oldHead, newHead *types.Header) error {
+	var reinject types.Transactions
+	if oldHead != nil && oldHead.Hash() != newHead.ParentHash {
+		reinject = pool.reorgedTxs(oldHead, newHead)
+	}
+
+	statedb, err := pool.chain.StateAt(newHead.Root)
+	if err != nil {
+		return err
+	}
+	pool.head = newHead
+	pool.currentState = statedb
+	pool.pendingNonces = make(map[common.Address]uint64)
+	pool.currentMaxGas = newHead.GasLimit
+
+	for _, tx := range reinject {
+		if _, err := pool.add(tx, false); err != nil && !errors.Is(err, ErrAlreadyKnown) {
+			pool.logger.Debugw("Unable to reinject transaction", "hash", tx.Hash(), "err", err)
+		}
+	}
+
+	pool.dropForbidden()
+	pool.demoteUnexecutables()
+	for addr, list := range pool.pending {
+		pool.pendingNonces[addr] = list.LastNonce() + 1
+	}
+
+	accounts := make(map[common.Address]struct{}, len(pool.queue))
+	for addr := range pool.queue {
+		accounts[addr] = struct{}{}
+	}
+	if promoted := pool.promoteExecutables(accounts); len(promoted) > 0 {
+		go pool.txFeed.Send(core.NewTxsEvent{Txs: promoted})
+	}
+
+	return nil
+}
+
+// reorgedTxs returns transactions included into the old chain, but missing in the new one
+func (pool *TxPool) reorgedTxs(oldHead, newHead *types.Header) types.Transactions {
+	var (
+		rem = pool.chain.GetBlock(oldHead.Hash(), oldHead.Number.Uint64())
+		add = pool.chain.GetBlock(newHead.Hash(), newHead.Number.Uint64())
+	)
+	if rem == nil || add == nil {
+		return nil
+	}
+	if rem.NumberU64() > add.NumberU64()+maxReorgDepth || add.NumberU64() > rem.NumberU64()+maxReorgDepth {
+		pool.logger.Warnw("Skipping deep transaction reorg", "old", rem.NumberU64(), "new", add.NumberU64())
+		return nil
+	}
+
+	var discarded, included types.Transactions
+	for rem.NumberU64() > add.NumberU64() {
+		discarded = append(discarded, rem.Transactions()...)
+		if rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1); rem == nil {
+			return nil
+		}
+	}
+	for add.NumberU64() > rem.NumberU64() {
+		included = append(included, add.Transactions()...)
+		if add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1); add == nil {
+			return nil
+		}
+	}
+	for rem.Hash() != add.Hash() {
+		discarded = append(discarded, rem.Transactions()...)
+		included = append(included, add.Transactions()...)
+		rem = pool.chain.GetBlock(rem.ParentHash(), rem.NumberU64()-1)
+		add = pool.chain.GetBlock(add.ParentHash(), add.NumberU64()-1)
+		if rem == nil || add == nil {
+			return nil
+		}
+	}
+
+	return types.TxDifference(discarded, included)
+}
+
+// Get returns transaction by hash if it is in the pool
+func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.all[hash]
+}
+
+func (pool *TxPool) Has(hash common.Hash) bool {
+	return pool.Get(hash) != nil
+}
+
+// Nonce returns the next nonce of the account, taking pending transactions into account
+func (pool *TxPool) Nonce(addr common.Address) uint64 {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.pendingNonce(addr)
+}
+
+// Stats returns number of pending and queued transactions
+func (pool *TxPool) Stats() (int, int) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var pending, queued int
+	for _, list := range pool.pending {
+		pending += list.Len()
+	}
+	for _, list := range pool.queue {
+		queued += list.Len()
+	}
+	return pending, queued
+}
+
+// Pending returns nonce sorted executable transactions grouped by account
+func (pool *TxPool) Pending() map[common.Address]types.Transactions {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	pending := make(map[common.Address]types.Transactions, len(pool.pending))
+	for addr, list := range pool.pending {
+		pending[addr] = append(types.Transactions{}, list.Flatten()...)
+	}
+	return pending
+}
+
+// Content returns pending and queued transactions grouped by account
+func (pool *TxPool) Content() (map[common.Address]types.Transactions, map[common.Address]types.Transactions) {
+	pending := pool.Pending()
+
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	queued := make(map[common.Address]types.Transactions, len(pool.queue))
+	for addr, list := range pool.queue {
+		queued[addr] = append(types.Transactions{}, list.Flatten()...)
+	}
+	return pending, queued
+}
+
+// Locals returns accounts, which transactions are treated as local
+func (pool *TxPool) Locals() []common.Address {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	locals := make([]common.Address, 0, len(pool.locals))
+	for addr := range pool.locals {
+		locals = append(locals, addr)
+	}
+	return locals
+}
@@ -0,0 +1,336 @@
+package txpool
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/ethereum/go-ethereum/common"
+	gethcore "github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/rovergulf/chain/core"
+	"github.com/rovergulf/chain/params"
+	"github.com/rovergulf/chain/pkg/logutils"
+	"github.com/rovergulf/chain/state"
+	"github.com/rovergulf/chain/storage/badgerdb"
+	"github.com/rovergulf/chain/tests"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testBlockChain struct {
+	sdb       *state.Database
+	block     *types.Block
+	headFeed  event.Feed
+	blocksMap map[common.Hash]*types.Block
+}
+
+func newTestBlockChain(t *testing.T, balances map[common.Address]*big.Int) *testBlockChain {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	bc := &testBlockChain{
+		sdb:       state.NewDatabase(badgerdb.NewDatabase(db, nil)),
+		blocksMap: make(map[common.Hash]*types.Block),
+	}
+	bc.commit(t, nil, func(statedb *state.StateDB) {
+		for addr, balance := range balances {
+			statedb.SetBalance(addr, balance)
+		}
+	})
+	return bc
+}
+
+// commit applies fn to the head state and makes the result the new head
+func (bc *testBlockChain) commit(t *testing.T, txs types.Transactions, fn func(statedb *state.StateDB)) {
+	header := &types.Header{Number: new(big.Int), GasLimit: 1_000_000, Difficulty: common.Big1}
+	root := types.EmptyRootHash
+	if bc.block != nil {
+		header.ParentHash = bc.block.Hash()
+		header.Number.Add(bc.block.Number(), common.Big1)
+		root = bc.block.Root()
+	}
+
+	statedb, err := bc.sdb.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(statedb)
+	if header.Root, err = statedb.CommitBlock(true); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.block = types.NewBlockWithHeader(header).WithBody(txs, nil)
+	bc.blocksMap[bc.block.Hash()] = bc.block
+}
+
+func (bc *testBlockChain) CurrentBlock() *types.Block {
+	return bc.block
+}
+
+func (bc *testBlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return bc.blocksMap[hash]
+}
+
+func (bc *testBlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
+	return bc.sdb.Open(root)
+}
+
+func (bc *testBlockChain) SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription {
+	return bc.headFeed.Subscribe(ch)
+}
+
+func testKey(t *testing.T, hex string) *ecdsa.PrivateKey {
+	key, err := crypto.HexToECDSA(hex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pricedTx(t *testing.T, nonce uint64, gasPrice int64, key *ecdsa.PrivateKey) *types.Transaction {
+	tx := types.NewTransaction(nonce, tests.Account9, big.NewInt(1), 21_000, big.NewInt(gasPrice), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(params.DevChainConfig.ChainID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func newTestPool(t *testing.T, config Config) (*TxPool, *testBlockChain) {
+	bc := newTestBlockChain(t, map[common.Address]*big.Int{
+		tests.Account0: big.NewInt(1e18),
+		tests.Account1: big.NewInt(1e18),
+		tests.Account2: big.NewInt(1e18),
+	})
+	logger, _ := logutils.NewLogger()
+	pool, err := NewTxPool(config, params.DevChainConfig, bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Stop)
+	return pool, bc
+}
+
+func TestTxPoolNonceOrdering(t *testing.T) {
+	pool, _ := newTestPool(t, DefaultConfig)
+	key := testKey(t, tests.PrivateKey0)
+
+	events := make(chan core.NewTxsEvent, 4)
+	sub := pool.SubscribeNewTxsEvent(events)
+	defer sub.Unsubscribe()
+
+	// nonce gap keeps transaction queued
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 1, 1, key)})[0]; err != nil {
+		t.Fatal(err)
+	}
+	if pending, queued := pool.Stats(); pending != 0 || queued != 1 {
+		t.Fatalf("unexpected stats: pending %d, queued %d", pending, queued)
+	}
+	if len(events) != 0 {
+		t.Fatal("unexpected event of queued transaction")
+	}
+
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 1, key)})[0]; err != nil {
+		t.Fatal(err)
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 0 {
+		t.Fatalf("unexpected stats: pending %d, queued %d", pending, queued)
+	}
+	if nonce := pool.Nonce(tests.Account0); nonce != 2 {
+		t.Fatalf("unexpected pending nonce: %d", nonce)
+	}
+	if ev := <-events; len(ev.Txs) != 2 || ev.Txs[0].Nonce() != 0 || ev.Txs[1].Nonce() != 1 {
+		t.Fatalf("unexpected new txs event: %v", ev.Txs)
+	}
+}
+
+func TestTxPoolValidation(t *testing.T) {
+	pool, _ := newTestPool(t, DefaultConfig)
+	key := testKey(t, tests.PrivateKey0)
+
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 0, key)})[0]; !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("expected underpriced error, got %v", err)
+	}
+	// locals are exempt from price limit
+	if err := pool.AddLocal(pricedTx(t, 0, 0, key)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddLocal(pricedTx(t, 0, 0, key)); !errors.Is(err, ErrAlreadyKnown) {
+		t.Fatalf("expected already known error, got %v", err)
+	}
+
+	poor := pricedTx(t, 0, 1, testKey(t, tests.PrivateKey5))
+	if err := pool.AddRemotes(types.Transactions{poor})[0]; !errors.Is(err, gethcore.ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds error, got %v", err)
+	}
+
+	tx := types.NewTransaction(0, tests.Account9, big.NewInt(1), 2_000_000, big.NewInt(1), nil)
+	tx, _ = types.SignTx(tx, types.NewEIP155Signer(params.DevChainConfig.ChainID), testKey(t, tests.PrivateKey1))
+	if err := pool.AddRemotes(types.Transactions{tx})[0]; !errors.Is(err, ErrGasLimit) {
+		t.Fatalf("expected gas limit error, got %v", err)
+	}
+
+	otherChain := types.NewTransaction(0, tests.Account9, big.NewInt(1), 21_000, big.NewInt(1), nil)
+	otherChain, _ = types.SignTx(otherChain, types.NewEIP155Signer(big.NewInt(1)), testKey(t, tests.PrivateKey1))
+	if err := pool.AddRemotes(types.Transactions{otherChain})[0]; !errors.Is(err, ErrInvalidSender) {
+		t.Fatalf("expected invalid sender error, got %v", err)
+	}
+}
+
+func TestTxPoolReplacement(t *testing.T) {
+	pool, _ := newTestPool(t, DefaultConfig)
+	key := testKey(t, tests.PrivateKey0)
+
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 100, key)})[0]; err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 109, key)})[0]; !errors.Is(err, ErrReplaceUnderpriced) {
+		t.Fatalf("expected replace underpriced error, got %v", err)
+	}
+	replacement := pricedTx(t, 0, 110, key)
+	if err := pool.AddRemotes(types.Transactions{replacement})[0]; err != nil {
+		t.Fatal(err)
+	}
+	if pending := pool.Pending()[tests.Account0]; len(pending) != 1 || pending[0].Hash() != replacement.Hash() {
+		t.Fatalf("unexpected pending transactions: %v", pending)
+	}
+}
+
+func TestTxPoolAccountQueue(t *testing.T) {
+	config := DefaultConfig
+	config.AccountQueue = 1
+	pool, _ := newTestPool(t, config)
+	key := testKey(t, tests.PrivateKey0)
+
+	// account queue limit drops the highest nonces
+	txs := types.Transactions{pricedTx(t, 2, 1, key), pricedTx(t, 3, 1, key)}
+	for _, err := range pool.AddRemotes(txs) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !pool.Has(txs[0].Hash()) || pool.Has(txs[1].Hash()) {
+		t.Fatal("transaction over account queue limit is kept")
+	}
+}
+
+func TestTxPoolLimits(t *testing.T) {
+	config := DefaultConfig
+	config.AccountSlots = 1
+	config.GlobalSlots = 2
+	config.GlobalQueue = 1
+	pool, _ := newTestPool(t, config)
+
+	key0, key1, key2 := testKey(t, tests.PrivateKey0), testKey(t, tests.PrivateKey1), testKey(t, tests.PrivateKey2)
+
+	// pending is truncated to global slots
+	pool.AddRemotes(types.Transactions{pricedTx(t, 0, 2, key1), pricedTx(t, 1, 2, key1), pricedTx(t, 2, 2, key1)})
+	if pending, _ := pool.Stats(); pending != 2 {
+		t.Fatalf("unexpected pending number: %d", pending)
+	}
+
+	queued := pricedTx(t, 5, 1, key0)
+	if err := pool.AddRemotes(types.Transactions{queued})[0]; err != nil {
+		t.Fatal(err)
+	}
+
+	// pool is full, so cheaper transactions are rejected, while more expensive evict the cheapest
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 1, key2)})[0]; !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("expected underpriced error, got %v", err)
+	}
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 3, key2)})[0]; err != nil {
+		t.Fatal(err)
+	}
+	if pool.Has(queued.Hash()) {
+		t.Fatal("the cheapest transaction is not evicted")
+	}
+	// the biggest account gives up its slots to the new one
+	if list := pool.Pending()[tests.Account1]; len(list) != 1 {
+		t.Fatalf("unexpected pending number of the biggest account: %d", len(list))
+	}
+	if pending, _ := pool.Stats(); pending != 2 {
+		t.Fatalf("unexpected pending number: %d", pending)
+	}
+}
+
+func TestTxPoolReset(t *testing.T) {
+	pool, bc := newTestPool(t, DefaultConfig)
+	key := testKey(t, tests.PrivateKey0)
+
+	txs := types.Transactions{pricedTx(t, 0, 1, key), pricedTx(t, 1, 1, key), pricedTx(t, 2, 1, key)}
+	pool.AddRemotes(txs)
+
+	// first transaction is mined
+	bc.commit(t, txs[:1], func(statedb *state.StateDB) {
+		statedb.SetNonce(tests.Account0, 1)
+	})
+	bc.headFeed.Send(core.ChainHeadEvent{Block: bc.block})
+
+	deadline := time.Now().Add(time.Second)
+	for pool.Has(txs[0].Hash()) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pool.Has(txs[0].Hash()) {
+		t.Fatal("mined transaction is still in the pool")
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 0 {
+		t.Fatalf("unexpected stats: pending %d, queued %d", pending, queued)
+	}
+}
+
+func TestTxPoolJournal(t *testing.T) {
+	config := DefaultConfig
+	config.Journal = filepath.Join(t.TempDir(), "transactions.rlp")
+
+	bc := newTestBlockChain(t, map[common.Address]*big.Int{
+		tests.Account0: big.NewInt(1e18),
+		tests.Account1: big.NewInt(1e18),
+	})
+	logger, _ := logutils.NewLogger()
+	pool, err := NewTxPool(config, params.DevChainConfig, bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := testKey(t, tests.PrivateKey0)
+	locals := types.Transactions{pricedTx(t, 0, 1, key), pricedTx(t, 1, 1, key), pricedTx(t, 3, 1, key)}
+	for _, tx := range locals {
+		if err := pool.AddLocal(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.AddRemotes(types.Transactions{pricedTx(t, 0, 1, testKey(t, tests.PrivateKey1))})[0]; err != nil {
+		t.Fatal(err)
+	}
+	pool.Stop()
+
+	// first local transaction is mined while the node is down
+	bc.commit(t, locals[:1], func(statedb *state.StateDB) {
+		statedb.SetNonce(tests.Account0, 1)
+	})
+
+	pool, err = NewTxPool(config, params.DevChainConfig, bc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Stop()
+
+	if pending, queued := pool.Stats(); pending != 1 || queued != 1 {
+		t.Fatalf("unexpected stats: pending %d, queued %d", pending, queued)
+	}
+	if pool.Has(locals[0].Hash()) || !pool.Has(locals[1].Hash()) || !pool.Has(locals[2].Hash()) {
+		t.Fatal("unexpected journaled transactions replayed")
+	}
+	if len(pool.Locals()) != 1 {
+		t.Fatalf("unexpected locals: %v", pool.Locals())
+	}
+}